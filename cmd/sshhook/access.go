@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+)
+
+// accessUsage access 子命令的用法
+const accessUsage = "usage: sshhook access request|approve|deny|list [flags] [username target|id]"
+
+// runAccess 实现 access 子命令：request 申请在一段时间内访问目标，approve 和 deny 由批准人处理申请，
+// list 列出申请。申请人和批准人是 -admin-token 的持有人，通过管理 API 执行，申请、批准、拒绝和过期都记录在审计日志中
+func runAccess(args []string) error {
+	if len(args) == 0 {
+		return errors.New(accessUsage)
+	}
+
+	fs := flag.NewFlagSet("access "+args[0], flag.ExitOnError)
+	adminURL := fs.String("admin-url", os.Getenv("SSHHOOK_ADMIN_URL"), "admin API URL (default $SSHHOOK_ADMIN_URL)")
+	adminToken := fs.String("admin-token", os.Getenv("SSHHOOK_ADMIN_TOKEN"), "admin API token (default $SSHHOOK_ADMIN_TOKEN)")
+	duration := fs.String("duration", "1h", "request: how long the access lasts after approval")
+	reason := fs.String("reason", "", "request, deny: reason recorded in the audit log")
+	user := fs.String("user", "", "list: only show requests of this user")
+	fs.Parse(args[1:])
+
+	if *adminURL == "" || *adminToken == "" {
+		return errors.New("-admin-url and -admin-token (or SSHHOOK_ADMIN_URL and SSHHOOK_ADMIN_TOKEN) are required")
+	}
+	client := webhook.NewAccessClient(*adminURL, *adminToken)
+
+	rest := fs.Args()
+	need := func(n int) error {
+		if len(rest) != n {
+			return errors.New(accessUsage)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "request":
+		if err := need(2); err != nil {
+			return err
+		}
+		grant, err := client.Request(webhook.AccessRequest{Username: rest[0], Target: rest[1], Duration: *duration, Reason: *reason})
+		if err != nil {
+			return err
+		}
+		fmt.Println(grant.ID)
+		fmt.Fprintf(os.Stderr, "Access request %s is waiting for approval\n", grant.ID)
+	case "approve":
+		if err := need(1); err != nil {
+			return err
+		}
+		grant, err := client.Approve(rest[0], webhook.AccessDecision{})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s may log in as %s+%s until %s\n", grant.Username, grant.Username, grant.Target,
+			grant.ExpiresAt.Format("2006-01-02 15:04:05"))
+	case "deny":
+		if err := need(1); err != nil {
+			return err
+		}
+		return client.Deny(rest[0], webhook.AccessDecision{Reason: *reason})
+	case "list":
+		if err := need(0); err != nil {
+			return err
+		}
+		return listAccess(client, *user)
+	default:
+		return fmt.Errorf("unknown access command: %s", args[0])
+	}
+	return nil
+}
+
+// listAccess 输出访问申请
+func listAccess(client *webhook.AccessClient, username string) error {
+	grants, err := client.List(username)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tTARGET\tDURATION\tSTATUS\tREQUESTER\tAPPROVER\tEXPIRES\tREASON")
+	for _, g := range grants {
+		expires := "-"
+		if g.ExpiresAt != nil {
+			expires = g.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", g.ID, g.Username, g.Target, g.Duration,
+			g.Status, dash(g.RequestedBy), dash(g.ApprovedBy), expires, dash(g.Reason))
+	}
+	return tw.Flush()
+}
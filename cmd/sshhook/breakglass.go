@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+)
+
+// breakGlassUsage breakglass 子命令的用法
+const breakGlassUsage = "usage: sshhook breakglass enable|disable|status [flags]"
+
+// runBreakGlass 实现 breakglass 子命令：enable 在配置的 window 内允许紧急访问用户登录，
+// disable 提前关闭，status 查看当前状态。通过管理 API 执行，操作人是 -admin-token 的持有人，
+// 启用和关闭都会记录审计事件并发送通知
+func runBreakGlass(args []string) error {
+	if len(args) == 0 {
+		return errors.New(breakGlassUsage)
+	}
+
+	fs := flag.NewFlagSet("breakglass "+args[0], flag.ExitOnError)
+	adminURL := fs.String("admin-url", os.Getenv("SSHHOOK_ADMIN_URL"), "admin API URL (default $SSHHOOK_ADMIN_URL)")
+	adminToken := fs.String("admin-token", os.Getenv("SSHHOOK_ADMIN_TOKEN"), "admin API token (default $SSHHOOK_ADMIN_TOKEN)")
+	reason := fs.String("reason", "", "enable (required), disable: reason recorded in the audit log and notifications")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 0 {
+		return errors.New(breakGlassUsage)
+	}
+	if *adminURL == "" || *adminToken == "" {
+		return errors.New("-admin-url and -admin-token (or SSHHOOK_ADMIN_URL and SSHHOOK_ADMIN_TOKEN) are required")
+	}
+	client := webhook.NewBreakGlassClient(*adminURL, *adminToken)
+
+	switch args[0] {
+	case "enable":
+		if *reason == "" {
+			return errors.New("-reason is required")
+		}
+		status, err := client.Enable(webhook.BreakGlassChange{Reason: *reason})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Break-glass access enabled for %s until %s\n", strings.Join(status.Users, ", "),
+			status.ExpiresAt.Format("2006-01-02 15:04:05"))
+	case "disable":
+		if err := client.Disable(webhook.BreakGlassChange{Reason: *reason}); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Break-glass access disabled")
+	case "status":
+		status, err := client.Status()
+		if err != nil {
+			return err
+		}
+		printBreakGlassStatus(status)
+	default:
+		return fmt.Errorf("unknown breakglass command: %s", args[0])
+	}
+	return nil
+}
+
+// printBreakGlassStatus 输出紧急访问状态
+func printBreakGlassStatus(status *webhook.BreakGlassStatus) {
+	fmt.Printf("users:   %s\n", dash(strings.Join(status.Users, ", ")))
+	fmt.Printf("window:  %s\n", status.Window)
+	if !status.Enabled {
+		fmt.Println("enabled: no")
+		return
+	}
+	fmt.Printf("enabled: yes, by %s at %s until %s\n", status.EnabledBy,
+		status.EnabledAt.Format("2006-01-02 15:04:05"), status.ExpiresAt.Format("2006-01-02 15:04:05"))
+	fmt.Printf("reason:  %s\n", status.Reason)
+}
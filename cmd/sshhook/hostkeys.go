@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xjdrew/sshproxy/pkg/hostkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+// runHostKeys 实现 hostkeys 子命令：generate、rotate、list、fingerprints
+func runHostKeys(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: sshhook hostkeys generate|rotate|list|fingerprints [flags]")
+	}
+
+	fs := flag.NewFlagSet("hostkeys "+args[0], flag.ExitOnError)
+	dir := fs.String("dir", ".", "host key directory")
+	types := fs.String("types", strings.Join(hostkeys.DefaultTypes, ","), "key types to generate: ed25519, ecdsa, rsa")
+	rsaBits := fs.Int("rsa-bits", hostkeys.DefaultRSABits, "RSA key size")
+	comment := fs.String("comment", "containerssh@sshproxy", "public key comment")
+	grace := fs.Duration("grace", 7*24*time.Hour, "how long rotated keys stay published")
+	host := fs.String("host", "", "SSH server host name used in fingerprint output")
+	port := fs.Int("port", 2222, "SSH server port used in known_hosts output")
+	fs.Parse(args[1:])
+
+	opts := hostkeys.Options{
+		Types:   strings.Split(*types, ","),
+		RSABits: *rsaBits,
+		Comment: *comment,
+	}
+
+	switch args[0] {
+	case "generate":
+		keys, err := hostkeys.Generate(*dir, opts, time.Now())
+		if err != nil {
+			return err
+		}
+		return printKeys(*dir, keys)
+	case "rotate":
+		keys, err := hostkeys.Rotate(*dir, opts, *grace, time.Now())
+		if err != nil {
+			return err
+		}
+		return printKeys(*dir, keys)
+	case "list":
+		m, err := hostkeys.LoadManifest(*dir)
+		if err != nil {
+			return err
+		}
+		// 第一列为文件路径，正在使用的 key 可以直接放入 config.yaml ssh.hostkeys；
+		// 保留期内和已过期的旧 key 一起列出，便于确认轮换状态
+		now := time.Now()
+		for _, k := range m.Keys {
+			fmt.Println(filepath.Join(*dir, k.File) + " " + keyState(k, now))
+		}
+		return nil
+	case "fingerprints":
+		if *host == "" {
+			return errors.New("-host is required")
+		}
+		return printFingerprints(*dir, *host, *port)
+	default:
+		return fmt.Errorf("unknown hostkeys command: %s", args[0])
+	}
+}
+
+// keyState 返回 key 的状态说明，保留期内的 key 带上到期时间
+func keyState(k hostkeys.Key, now time.Time) string {
+	state := k.State(now)
+	if state == hostkeys.StateRetiring {
+		state += " until " + k.RetireAt.Format(time.RFC3339)
+	}
+	return state
+}
+
+// printKeys 输出新生成的 key 及其指纹
+func printKeys(dir string, keys []hostkeys.Key) error {
+	for _, k := range keys {
+		pub, err := hostkeys.PublicKey(dir, k)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s %s\n", filepath.Join(dir, k.File), ssh.FingerprintSHA256(pub))
+	}
+	return nil
+}
+
+// printFingerprints 输出正在使用和保留期内的 key 的 SSHFP 记录和 known_hosts 记录
+func printFingerprints(dir, host string, port int) error {
+	m, err := hostkeys.LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var sshfp, knownHosts []string
+	for _, k := range m.Published(time.Now()) {
+		pub, err := hostkeys.PublicKey(dir, k)
+		if err != nil {
+			return err
+		}
+		record, err := hostkeys.SSHFP(host, pub)
+		if err != nil {
+			return err
+		}
+		sshfp = append(sshfp, record)
+		knownHosts = append(knownHosts, hostkeys.KnownHosts(host, port, pub))
+	}
+
+	fmt.Println("; SSHFP")
+	for _, line := range sshfp {
+		fmt.Println(line)
+	}
+	fmt.Println()
+	fmt.Println("# known_hosts")
+	for _, line := range knownHosts {
+		fmt.Println(line)
+	}
+	return nil
+}
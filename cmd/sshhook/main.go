@@ -10,7 +10,32 @@ import (
 	"github.com/xjdrew/sshproxy/pkg/webhook"
 )
 
+// commands 子命令列表，未匹配到子命令时启动 webhook 服务
+var commands = map[string]func(args []string) error{
+	"render-containerssh-config": runRenderContainerSSHConfig,
+	"hostkeys":                   runHostKeys,
+	"user":                       runUser,
+	"simulate":                   runSimulate,
+	"totp":                       runTOTP,
+	"access":                     runAccess,
+	"breakglass":                 runBreakGlass,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	runServer()
+}
+
+// runServer 启动 webhook 服务
+func runServer() {
 	configFile := flag.String("config", "webhook.yaml", "path to webhook config file")
 	flag.Parse()
 
@@ -32,11 +57,25 @@ func main() {
 	}
 
 	log.Printf("Webhook server started on %s", config.Listen)
+	if config.Admin.Listen != "" {
+		log.Printf("Admin API started on %s", config.Admin.Listen)
+	}
 
-	// 等待退出信号
+	// 等待退出信号，收到 SIGHUP 时重新加载配置
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		newConfig, err := webhook.LoadConfig(*configFile)
+		if err != nil {
+			log.Printf("Failed to reload config, keeping the current one: %v", err)
+			server.ReloadFailed(err)
+			continue
+		}
+		server.Reload(newConfig)
+	}
 
 	log.Println("Shutting down webhook server...")
 	if err := server.Stop(); err != nil {
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xjdrew/sshproxy/pkg/hostkeys"
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+)
+
+// stringList 可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runRenderContainerSSHConfig 根据 webhook.yaml 生成 ContainerSSH 的 config.yaml
+func runRenderContainerSSHConfig(args []string) error {
+	fs := flag.NewFlagSet("render-containerssh-config", flag.ExitOnError)
+	configFile := fs.String("config", "webhook.yaml", "path to webhook config file")
+	output := fs.String("output", "", "write containerssh config to this file instead of stdout")
+	webhookURL := fs.String("webhook-url", "", "URL containerssh uses to reach sshhook (default derived from listen)")
+	sshListen := fs.String("ssh-listen", "0.0.0.0:2222", "SSH listen address")
+	timeout := fs.Duration("timeout", 0, "timeout for auth and config webhook calls (optional)")
+	logLevel := fs.String("log-level", "info", "containerssh log level: debug, info, warning, error")
+	logFormat := fs.String("log-format", "text", "containerssh log format: text, json")
+	hostKeysDir := fs.String("hostkeys-dir", "", "use the active keys managed by 'sshhook hostkeys' in this directory")
+	var hostKeys stringList
+	fs.Var(&hostKeys, "hostkey", "SSH host key file (repeatable, default ssh_host_rsa_key)")
+	fs.Parse(args)
+
+	if *hostKeysDir != "" {
+		m, err := hostkeys.LoadManifest(*hostKeysDir)
+		if err != nil {
+			return err
+		}
+		// ContainerSSH 只使用正在使用的 key，旧 key 的状态输出到 stderr
+		now := time.Now()
+		for _, k := range m.Keys {
+			path := filepath.Join(*hostKeysDir, k.File)
+			if k.State(now) == hostkeys.StateActive {
+				hostKeys = append(hostKeys, path)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Skipping host key %s: %s\n", path, keyState(k, now))
+		}
+	}
+	if len(hostKeys) == 0 {
+		hostKeys = stringList{"ssh_host_rsa_key"}
+	}
+
+	// 生成 ContainerSSH 配置不需要密码明文，不解析 Secret 引用
+	config, err := webhook.LoadConfigWithoutSecrets(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	data, err := webhook.RenderContainerSSHConfig(config, webhook.ContainerSSHOptions{
+		WebhookURL: *webhookURL,
+		SSHListen:  *sshListen,
+		HostKeys:   hostKeys,
+		Timeout:    *timeout,
+		LogLevel:   *logLevel,
+		LogFormat:  *logFormat,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(*output, data, 0644)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+)
+
+// runSimulate 在进程内模拟一次登录：认证、计算登录目标并生成 ContainerSSH 配置，
+// 不会创建任何资源，默认不访问集群
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	configFile := fs.String("config", "webhook.yaml", "path to webhook config file")
+	username := fs.String("user", "", "SSH username")
+	password := fs.String("password", "", "password to authenticate with")
+	keyFile := fs.String("key", "", "public key file to authenticate with, e.g. id_ed25519.pub")
+	code := fs.String("code", "", "TOTP verification code for users with totpSecret")
+	remote := fs.String("remote", "", "client IP address")
+	connectionID := fs.String("connection-id", "simulate", "connection ID")
+	resolve := fs.Bool("resolve", false, "query the cluster to select the container and detect the shell")
+	jsonOutput := fs.Bool("json", false, "print the result as JSON")
+	verbose := fs.Bool("v", false, "print webhook logs to stderr")
+	fs.Parse(args)
+
+	if *username == "" {
+		return errors.New("-user is required")
+	}
+	opts := webhook.SimulateOptions{
+		Username:      *username,
+		Password:      *password,
+		Code:          *code,
+		RemoteAddress: *remote,
+		ConnectionID:  *connectionID,
+		Resolve:       *resolve,
+	}
+	if *keyFile != "" {
+		data, err := os.ReadFile(*keyFile)
+		if err != nil {
+			return err
+		}
+		opts.PublicKey = string(data)
+	}
+
+	config, err := webhook.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	result, err := webhook.Simulate(config, opts)
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else if err := printSimulateResult(result); err != nil {
+		return err
+	}
+	if result.Config == nil {
+		return errors.New("login denied")
+	}
+	return nil
+}
+
+// printSimulateResult 输出模拟结果
+func printSimulateResult(r *webhook.SimulateResult) error {
+	decision := "allow"
+	if r.Config == nil {
+		decision = "deny"
+	}
+	fmt.Printf("Decision: %s\n", decision)
+	fmt.Printf("Method:   %s (authenticated=%v)\n", r.Method, r.Authenticated)
+	if r.Authorized != nil {
+		fmt.Printf("Authz:    authorized=%v\n", *r.Authorized)
+	}
+	if r.Target != "" {
+		fmt.Printf("Target:   %s\n", r.Target)
+	}
+	if r.Reason != "" {
+		fmt.Printf("Reason:   %s\n", r.Reason)
+	}
+	printValues("Metadata", r.Metadata)
+	printValues("Environment", r.Environment)
+	if len(r.Files) > 0 {
+		fmt.Println("Files:")
+		for _, f := range r.Files {
+			fmt.Printf("  %s\n", f)
+		}
+	}
+	if r.Config == nil {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("ContainerSSH config:\n%s\n", data)
+	return nil
+}
+
+// printValues 按 key 排序输出
+func printValues(title string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("%s:\n", title)
+	for _, k := range keys {
+		fmt.Printf("  %s=%s\n", k, values[k])
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+	"golang.org/x/term"
+)
+
+// totpUsage totp 子命令的用法
+const totpUsage = "usage: sshhook totp enroll|remove [flags] username"
+
+// runTOTP 实现 totp 子命令：enroll 生成新的 TOTP 密钥并输出 otpauth:// 地址，remove 删除用户的 TOTP 密钥。
+// 密钥使用 -config 中的 mfa.encryptionKey 加密，默认写入该配置文件，指定 -admin-url 时通过管理 API 写入
+func runTOTP(args []string) error {
+	if len(args) == 0 {
+		return errors.New(totpUsage)
+	}
+
+	fs := flag.NewFlagSet("totp "+args[0], flag.ExitOnError)
+	configFile := fs.String("config", "webhook.yaml", "path to webhook config file")
+	adminURL := fs.String("admin-url", "", "use the admin API at this URL instead of editing the config file")
+	adminToken := fs.String("admin-token", os.Getenv("SSHHOOK_ADMIN_TOKEN"), "admin API token (default $SSHHOOK_ADMIN_TOKEN)")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return errors.New(totpUsage)
+	}
+	username := fs.Arg(0)
+
+	var store webhook.UserStore
+	if *adminURL != "" {
+		if *adminToken == "" {
+			return errors.New("-admin-token or SSHHOOK_ADMIN_TOKEN is required with -admin-url")
+		}
+		store = webhook.NewAdminUserStore(*adminURL, *adminToken)
+	} else {
+		store = webhook.NewFileUserStore(*configFile)
+	}
+
+	var err error
+	switch args[0] {
+	case "enroll":
+		err = enrollTOTP(store, *configFile, username)
+	case "remove":
+		err = store.SetTOTPSecret(username, "")
+	default:
+		return fmt.Errorf("unknown totp command: %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if *adminURL == "" {
+		fmt.Fprintf(os.Stderr, "%s updated, send SIGHUP to sshhook to apply\n", *configFile)
+	}
+	return nil
+}
+
+// enrollTOTP 生成 TOTP 密钥并写入用户配置。终端中要求输入一次验证码，确认验证器 App 已经导入
+func enrollTOTP(store webhook.UserStore, configFile, username string) error {
+	cfg, err := webhook.LoadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	enrollment, err := webhook.EnrollTOTP(cfg, username)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(enrollment.URI)
+	fmt.Fprintf(os.Stderr, "Add the URI above to an authenticator app, e.g. show it as a QR code with:\n  qrencode -t ansiutf8 '%s'\n", enrollment.URI)
+
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Verification code: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("failed to read verification code: %w", err)
+		}
+		if !enrollment.Verify(strings.TrimSpace(line)) {
+			return errors.New("invalid verification code, totpSecret not saved")
+		}
+	}
+	return store.SetTOTPSecret(username, enrollment.EncryptedSecret)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xjdrew/sshproxy/pkg/webhook"
+	"golang.org/x/term"
+)
+
+// userUsage user 子命令的用法
+const userUsage = "usage: sshhook user add|remove|list|set-password|add-key|remove-key [flags] [username] [key|fingerprint]"
+
+// runUser 实现 user 子命令：add、remove、list、set-password、add-key、remove-key。
+// 默认直接编辑配置文件（保留注释和顺序），指定 -admin-url 时调用管理 API
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return errors.New(userUsage)
+	}
+
+	fs := flag.NewFlagSet("user "+args[0], flag.ExitOnError)
+	configFile := fs.String("config", "webhook.yaml", "path to webhook config file")
+	adminURL := fs.String("admin-url", "", "use the admin API at this URL instead of editing the config file")
+	adminToken := fs.String("admin-token", os.Getenv("SSHHOOK_ADMIN_TOKEN"), "admin API token (default $SSHHOOK_ADMIN_TOKEN)")
+	target := fs.String("target", "", "add: login target")
+	password := fs.Bool("password", false, "add: prompt for a password")
+	var groups, keys stringList
+	fs.Var(&groups, "group", "add: group (repeatable)")
+	fs.Var(&keys, "key", "add: public key in authorized_keys format (repeatable)")
+	fs.Parse(args[1:])
+
+	var store webhook.UserStore
+	if *adminURL != "" {
+		if *adminToken == "" {
+			return errors.New("-admin-token or SSHHOOK_ADMIN_TOKEN is required with -admin-url")
+		}
+		store = webhook.NewAdminUserStore(*adminURL, *adminToken)
+	} else {
+		store = webhook.NewFileUserStore(*configFile)
+	}
+
+	rest := fs.Args()
+	need := func(n int) error {
+		if len(rest) != n {
+			return errors.New(userUsage)
+		}
+		return nil
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		if err := need(0); err != nil {
+			return err
+		}
+		return listUsers(store)
+	case "add":
+		if err := need(1); err != nil {
+			return err
+		}
+		user := webhook.NewUser{Username: rest[0], Target: *target, Groups: groups, PublicKeys: keys}
+		if *password {
+			if user.PasswordHash, err = promptPasswordHash(); err != nil {
+				return err
+			}
+		}
+		err = store.AddUser(user)
+	case "remove":
+		if err := need(1); err != nil {
+			return err
+		}
+		err = store.RemoveUser(rest[0])
+	case "set-password":
+		if err := need(1); err != nil {
+			return err
+		}
+		var hash string
+		if hash, err = promptPasswordHash(); err != nil {
+			return err
+		}
+		err = store.SetPasswordHash(rest[0], hash)
+	case "add-key":
+		if err := need(2); err != nil {
+			return err
+		}
+		var fingerprint string
+		if fingerprint, err = store.AddKey(rest[0], rest[1]); err == nil {
+			fmt.Println(fingerprint)
+		}
+	case "remove-key":
+		if err := need(2); err != nil {
+			return err
+		}
+		err = store.RemoveKey(rest[0], rest[1])
+	default:
+		return fmt.Errorf("unknown user command: %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	if *adminURL == "" {
+		fmt.Fprintf(os.Stderr, "%s updated, send SIGHUP to sshhook to apply\n", *configFile)
+	}
+	return nil
+}
+
+// listUsers 输出用户列表
+func listUsers(store webhook.UserStore) error {
+	users, err := store.ListUsers()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tTARGET\tGROUPS\tPASSWORD\tKEYS\tMFA\tSTATUS")
+	for _, u := range users {
+		status := "active"
+		if u.Locked {
+			status = "locked"
+		}
+		if !u.ExpiresAt.IsZero() {
+			status += ", expires " + u.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		mfa := "-"
+		if u.MFA {
+			mfa = "totp"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", u.Username, dash(u.Target),
+			dash(strings.Join(u.Groups, ",")), dash(u.Password), len(u.Keys), mfa, status)
+	}
+	return tw.Flush()
+}
+
+// dash 空字符串输出为 -
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// promptPasswordHash 读取密码并返回 bcrypt 哈希。终端中不回显并要求输入两次，
+// 否则从标准输入读取一行，便于脚本调用
+func promptPasswordHash() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		return webhook.HashPassword(strings.TrimRight(line, "\r\n"))
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Fprint(os.Stderr, "Retype password: ")
+	confirm, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	if string(password) != string(confirm) {
+		return "", errors.New("passwords do not match")
+	}
+	return webhook.HashPassword(string(password))
+}
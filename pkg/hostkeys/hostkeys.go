@@ -0,0 +1,460 @@
+// Package hostkeys 生成、轮换 SSH host key，并输出用于分发的指纹
+package hostkeys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestFile 记录 host key 状态的清单文件名，与 key 文件放在同一目录
+const ManifestFile = "hostkeys.yaml"
+
+// 支持的 key 类型
+const (
+	TypeEd25519 = "ed25519"
+	TypeECDSA   = "ecdsa"
+	TypeRSA     = "rsa"
+)
+
+// key 的状态
+const (
+	StateActive   = "active"
+	StateRetiring = "retiring"
+	StateExpired  = "expired"
+)
+
+// DefaultTypes 默认生成的 key 类型
+var DefaultTypes = []string{TypeEd25519, TypeECDSA, TypeRSA}
+
+// DefaultRSABits 默认 RSA key 长度
+const DefaultRSABits = 3072
+
+// Key 清单中的一个 host key
+type Key struct {
+	File     string     `yaml:"file"`               // 私钥文件名（相对于目录）
+	Type     string     `yaml:"type"`               // key 类型
+	Created  time.Time  `yaml:"created"`            // 生成时间
+	RetireAt *time.Time `yaml:"retireAt,omitempty"` // 轮换后保留到该时间，为空表示仍在使用
+}
+
+// Manifest host key 清单
+type Manifest struct {
+	Keys []Key `yaml:"keys"`
+}
+
+// Options 生成 key 的参数
+type Options struct {
+	Types   []string // key 类型，默认 DefaultTypes
+	RSABits int      // RSA key 长度，默认 DefaultRSABits
+	Comment string   // 公钥注释
+}
+
+// LoadManifest 读取目录中的清单，不存在时返回空清单
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// save 写回清单，先写入临时文件再改名，写入失败时原来的清单保持不变
+func (m *Manifest) save(dir string) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+ManifestFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, ManifestFile)); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// Active 返回正在使用的 key（未进入轮换保留期）
+func (m *Manifest) Active() []Key {
+	var keys []Key
+	for _, k := range m.Keys {
+		if k.RetireAt == nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// State 返回 key 的状态：active、retiring（保留期内，仍然公布指纹）或 expired（等待下次轮换时删除）
+func (k Key) State(now time.Time) string {
+	switch {
+	case k.RetireAt == nil:
+		return StateActive
+	case now.Before(*k.RetireAt):
+		return StateRetiring
+	default:
+		return StateExpired
+	}
+}
+
+// Published 返回需要对外公布指纹的 key：正在使用的 key 以及仍在保留期内的旧 key
+func (m *Manifest) Published(now time.Time) []Key {
+	var keys []Key
+	for _, k := range m.Keys {
+		if k.RetireAt == nil || now.Before(*k.RetireAt) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Generate 在目录中生成指定类型的 host key，已存在同类型的有效 key 时报错
+func Generate(dir string, opts Options, now time.Time) ([]Key, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	types := opts.Types
+	if len(types) == 0 {
+		types = DefaultTypes
+	}
+	for _, t := range types {
+		for _, k := range m.Active() {
+			if k.Type == t {
+				return nil, fmt.Errorf("%s host key already exists: %s (use rotate to replace it)", t, k.File)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	var keys []Key
+	for _, t := range types {
+		k, err := generateKey(dir, keyFile(t), t, opts, now)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		m.Keys = append(m.Keys, k)
+	}
+
+	if err := m.save(dir); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rotate 为每个正在使用的 key 生成替换 key，旧 key 改名后在 grace 时间内继续保留在清单中，
+// 同时删除已过保留期的旧 key。
+//
+// 新 key 先写入临时文件，全部生成成功后才替换旧 key 并写入清单；生成、改名或写入清单失败时
+// 撤销已经做的修改，目录和清单保持轮换前的状态。已过保留期的旧 key 在清单写入后才删除，
+// 删除失败时轮换仍然有效，返回新 key 和错误
+func Rotate(dir string, opts Options, grace time.Duration, now time.Time) ([]Key, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	active := m.Active()
+	if len(active) == 0 {
+		return nil, errors.New("no active host keys to rotate (use generate first)")
+	}
+
+	retireAt := now.Add(grace)
+	suffix := retiredSuffix(dir, m, active, now)
+
+	// 生成新 key
+	var keys []Key
+	cleanup := func() {
+		for _, k := range keys {
+			removeKey(dir, k.File+".new")
+		}
+	}
+	for _, old := range active {
+		k, err := generateKey(dir, old.File+".new", old.Type, opts, now)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		k.File = old.File
+		keys = append(keys, k)
+	}
+
+	// 旧 key 改名保留，新 key 改为正式文件名；失败时按相反顺序撤销
+	var undo []func()
+	rollback := func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		cleanup()
+	}
+	retired := make(map[string]string)
+	for _, k := range keys {
+		file := k.File + "." + suffix
+		if err := renameKey(dir, k.File, file); err != nil {
+			rollback()
+			return nil, err
+		}
+		from := k.File
+		undo = append(undo, func() { renameKey(dir, file, from) })
+		retired[k.File] = file
+
+		if err := renameKey(dir, k.File+".new", k.File); err != nil {
+			rollback()
+			return nil, err
+		}
+		undo = append(undo, func() { renameKey(dir, from, from+".new") })
+	}
+
+	var kept, expired []Key
+	for _, k := range m.Keys {
+		switch k.State(now) {
+		case StateExpired:
+			expired = append(expired, k)
+			continue
+		case StateActive:
+			k.File = retired[k.File]
+			k.RetireAt = &retireAt
+		}
+		kept = append(kept, k)
+	}
+	m.Keys = append(kept, keys...)
+	if err := m.save(dir); err != nil {
+		rollback()
+		return nil, err
+	}
+
+	// 清单已经不再引用过期的 key，最后删除文件
+	for _, k := range expired {
+		if err := removeKey(dir, k.File); err != nil {
+			return keys, err
+		}
+	}
+	return keys, nil
+}
+
+// keyFile 返回 key 类型对应的文件名
+func keyFile(keyType string) string {
+	return "ssh_host_" + keyType + "_key"
+}
+
+// generateSigner 生成私钥，测试中替换以模拟生成失败
+var generateSigner = func(keyType string, opts Options) (crypto.Signer, error) {
+	switch keyType {
+	case TypeEd25519:
+		_, signer, err := ed25519.GenerateKey(rand.Reader)
+		return signer, err
+	case TypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case TypeRSA:
+		bits := opts.RSABits
+		if bits == 0 {
+			bits = DefaultRSABits
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	default:
+		return nil, fmt.Errorf("unsupported host key type: %s", keyType)
+	}
+}
+
+// generateKey 生成一个 key 并写入 file 和 file.pub
+func generateKey(dir, file, keyType string, opts Options, now time.Time) (Key, error) {
+	signer, err := generateSigner(keyType, opts)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to generate %s host key: %w", keyType, err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(signer, opts.Comment)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to encode %s host key: %w", keyType, err)
+	}
+	pub, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to encode %s public key: %w", keyType, err)
+	}
+
+	path := filepath.Join(dir, file)
+	// 私钥只允许属主读写
+	if err := writeNewFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		return Key{}, err
+	}
+	if err := writeNewFile(path+".pub", authorizedKey(pub, opts.Comment), 0644); err != nil {
+		os.Remove(path)
+		return Key{}, err
+	}
+
+	return Key{File: file, Type: keyType, Created: now.UTC()}, nil
+}
+
+// writeNewFile 写入新文件，不覆盖已有文件（例如手工用 ssh-keygen 生成的 key）
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// authorizedKey 生成带注释的公钥行
+func authorizedKey(pub ssh.PublicKey, comment string) []byte {
+	line := ssh.MarshalAuthorizedKey(pub)
+	if comment == "" {
+		return line
+	}
+	return append(line[:len(line)-1], []byte(" "+comment+"\n")...)
+}
+
+// retiredSuffix 返回旧 key 改名使用的后缀：轮换时间精确到秒，同一秒内多次轮换时追加序号，
+// 保证改名后的文件名不与清单中和磁盘上已有的文件重复
+func retiredSuffix(dir string, m *Manifest, active []Key, now time.Time) string {
+	used := make(map[string]bool)
+	for _, k := range m.Keys {
+		used[k.File] = true
+	}
+	base := now.UTC().Format("20060102150405")
+	for n := 1; ; n++ {
+		suffix := base
+		if n > 1 {
+			suffix = fmt.Sprintf("%s-%d", base, n)
+		}
+		free := true
+		for _, k := range active {
+			file := k.File + "." + suffix
+			if used[file] || keyExists(dir, file) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return suffix
+		}
+	}
+}
+
+// keyExists 私钥或公钥文件是否已经存在
+func keyExists(dir, file string) bool {
+	for _, ext := range []string{"", ".pub"} {
+		if _, err := os.Lstat(filepath.Join(dir, file+ext)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// renameKey 同时重命名私钥和公钥文件，目标文件已经存在时返回错误，不会覆盖
+func renameKey(dir, from, to string) error {
+	if keyExists(dir, to) {
+		return fmt.Errorf("failed to rename host key: %s already exists", to)
+	}
+	for _, ext := range []string{"", ".pub"} {
+		if err := os.Rename(filepath.Join(dir, from+ext), filepath.Join(dir, to+ext)); err != nil {
+			return fmt.Errorf("failed to rename host key: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeKey 同时删除私钥和公钥文件
+func removeKey(dir, file string) error {
+	for _, ext := range []string{"", ".pub"} {
+		err := os.Remove(filepath.Join(dir, file+ext))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove host key: %w", err)
+		}
+	}
+	return nil
+}
+
+// PublicKey 读取 key 对应的公钥
+func PublicKey(dir string, k Key) (ssh.PublicKey, error) {
+	data, err := os.ReadFile(filepath.Join(dir, k.File+".pub"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s.pub: %w", k.File, err)
+	}
+	return pub, nil
+}
+
+// sshfpAlgorithms SSHFP 记录中的算法编号（RFC 4255、RFC 6594、RFC 7479）
+var sshfpAlgorithms = map[string]int{
+	ssh.KeyAlgoRSA:      1,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoED25519:  4,
+}
+
+// SSHFP 生成 SHA-256 指纹的 SSHFP DNS 记录
+func SSHFP(host string, pub ssh.PublicKey) (string, error) {
+	alg, ok := sshfpAlgorithms[pub.Type()]
+	if !ok {
+		return "", fmt.Errorf("no SSHFP algorithm for key type %s", pub.Type())
+	}
+	sum := sha256.Sum256(pub.Marshal())
+	return fmt.Sprintf("%s IN SSHFP %d 2 %s", dnsName(host), alg, hex.EncodeToString(sum[:])), nil
+}
+
+// KnownHosts 生成 known_hosts 格式的记录
+func KnownHosts(host string, port int, pub ssh.PublicKey) string {
+	if port != 0 && port != 22 {
+		host = "[" + host + "]:" + strconv.Itoa(port)
+	}
+	return host + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+}
+
+// dnsName 返回绝对域名形式
+func dnsName(host string) string {
+	if net.ParseIP(host) != nil || strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
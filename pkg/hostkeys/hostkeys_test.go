@@ -0,0 +1,276 @@
+package hostkeys
+
+import (
+	"crypto"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestGenerate 测试生成 host key
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	keys, err := Generate(dir, Options{RSABits: 2048}, now)
+	if err != nil {
+		t.Fatalf("Failed to generate host keys: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Expected 3 keys, got %d", len(keys))
+	}
+
+	for _, k := range keys {
+		path := filepath.Join(dir, k.File)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("Expected %s to have mode 0600, got %o", k.File, info.Mode().Perm())
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		pub, err := PublicKey(dir, k)
+		if err != nil {
+			t.Fatalf("Failed to read public key for %s: %v", k.File, err)
+		}
+		if string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+			t.Errorf("Public key does not match private key for %s", k.File)
+		}
+	}
+
+	// 已经存在的类型不能重复生成
+	if _, err := Generate(dir, Options{Types: []string{TypeEd25519}}, now); err == nil {
+		t.Error("Expected error when generating an existing key type, got nil")
+	}
+}
+
+// TestGenerate_ExistingFile 测试不会覆盖手工生成的 key
+func TestGenerate_ExistingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ssh_host_ed25519_key")
+	if err := os.WriteFile(path, []byte("existing"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if _, err := Generate(dir, Options{Types: []string{TypeEd25519}}, time.Now()); err == nil {
+		t.Error("Expected error when key file already exists, got nil")
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "existing" {
+		t.Error("Existing key file was overwritten")
+	}
+}
+
+// TestRotate 测试轮换 host key
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := Generate(dir, Options{Types: []string{TypeEd25519, TypeECDSA}}, now); err != nil {
+		t.Fatalf("Failed to generate host keys: %v", err)
+	}
+
+	rotatedAt := now.Add(time.Hour)
+	if _, err := Rotate(dir, Options{}, 24*time.Hour, rotatedAt); err != nil {
+		t.Fatalf("Failed to rotate host keys: %v", err)
+	}
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(m.Active()) != 2 {
+		t.Errorf("Expected 2 active keys, got %d", len(m.Active()))
+	}
+	if len(m.Published(rotatedAt)) != 4 {
+		t.Errorf("Expected 4 published keys during grace period, got %d", len(m.Published(rotatedAt)))
+	}
+	for _, k := range m.Keys {
+		if _, err := os.Stat(filepath.Join(dir, k.File)); err != nil {
+			t.Errorf("Expected key file %s to exist: %v", k.File, err)
+		}
+	}
+
+	// 保留期结束后再次轮换，旧 key 被删除
+	later := rotatedAt.Add(48 * time.Hour)
+	if len(m.Published(later)) != 2 {
+		t.Errorf("Expected 2 published keys after grace period, got %d", len(m.Published(later)))
+	}
+	if _, err := Rotate(dir, Options{}, 24*time.Hour, later); err != nil {
+		t.Fatalf("Failed to rotate host keys: %v", err)
+	}
+	m, err = LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(m.Keys) != 4 {
+		t.Errorf("Expected expired keys to be removed from manifest, got %d keys", len(m.Keys))
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "ssh_host_*_key*"))
+	if len(matches) != 8 {
+		t.Errorf("Expected 8 key files on disk, got %d", len(matches))
+	}
+}
+
+// TestRotate_SameSecond 测试同一秒内两次轮换时旧 key 使用不同的文件名，不会覆盖
+func TestRotate_SameSecond(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := Generate(dir, Options{Types: []string{TypeEd25519}}, now); err != nil {
+		t.Fatalf("Failed to generate host keys: %v", err)
+	}
+
+	rotatedAt := now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if _, err := Rotate(dir, Options{}, 24*time.Hour, rotatedAt); err != nil {
+			t.Fatalf("Failed to rotate host keys: %v", err)
+		}
+	}
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(m.Keys) != 3 {
+		t.Fatalf("Expected 3 keys in manifest, got %d", len(m.Keys))
+	}
+	files := make(map[string]bool)
+	pubs := make(map[string]bool)
+	for _, k := range m.Keys {
+		if files[k.File] {
+			t.Errorf("Expected unique key files, %s is listed twice", k.File)
+		}
+		files[k.File] = true
+		data, err := os.ReadFile(filepath.Join(dir, k.File+".pub"))
+		if err != nil {
+			t.Fatalf("Failed to read public key %s: %v", k.File, err)
+		}
+		pubs[string(data)] = true
+	}
+	if len(pubs) != 3 {
+		t.Errorf("Expected 3 different keys on disk, got %d", len(pubs))
+	}
+}
+
+// TestRotate_GenerateFailure 测试生成新 key 失败时不修改已有的 key 和清单
+func TestRotate_GenerateFailure(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := Generate(dir, Options{Types: []string{TypeEd25519, TypeECDSA}}, now); err != nil {
+		t.Fatalf("Failed to generate host keys: %v", err)
+	}
+	manifest, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	before, _ := filepath.Glob(filepath.Join(dir, "ssh_host_*"))
+
+	// ed25519 生成成功，ecdsa 生成失败
+	orig := generateSigner
+	t.Cleanup(func() { generateSigner = orig })
+	generateSigner = func(keyType string, opts Options) (crypto.Signer, error) {
+		if keyType == TypeECDSA {
+			return nil, errors.New("entropy exhausted")
+		}
+		return orig(keyType, opts)
+	}
+
+	if _, err := Rotate(dir, Options{}, time.Hour, now.Add(time.Hour)); err == nil || !strings.Contains(err.Error(), "entropy exhausted") {
+		t.Fatalf("Expected generation error, got %v", err)
+	}
+	after, _ := filepath.Glob(filepath.Join(dir, "ssh_host_*"))
+	if strings.Join(after, ",") != strings.Join(before, ",") {
+		t.Errorf("Expected key files %v, got %v", before, after)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil || string(data) != string(manifest) {
+		t.Errorf("Expected manifest to be unchanged, got %q", data)
+	}
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	for _, k := range m.Active() {
+		if _, err := PublicKey(dir, k); err != nil {
+			t.Errorf("Expected active key %s to be usable: %v", k.File, err)
+		}
+	}
+}
+
+// TestKeyState 测试 key 的状态
+func TestKeyState(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	tests := []struct {
+		key  Key
+		want string
+	}{
+		{Key{}, StateActive},
+		{Key{RetireAt: &later}, StateRetiring},
+		{Key{RetireAt: &now}, StateExpired},
+	}
+	for _, tt := range tests {
+		if got := tt.key.State(now); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
+}
+
+// TestRotate_NoKeys 测试没有 key 时轮换报错
+func TestRotate_NoKeys(t *testing.T) {
+	if _, err := Rotate(t.TempDir(), Options{}, time.Hour, time.Now()); err == nil {
+		t.Error("Expected error when rotating without keys, got nil")
+	}
+}
+
+// TestFingerprints 测试 SSHFP 和 known_hosts 格式
+func TestFingerprints(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := Generate(dir, Options{Types: []string{TypeEd25519}}, time.Now())
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	pub, err := PublicKey(dir, keys[0])
+	if err != nil {
+		t.Fatalf("Failed to read public key: %v", err)
+	}
+
+	record, err := SSHFP("ssh.example.com", pub)
+	if err != nil {
+		t.Fatalf("Failed to build SSHFP record: %v", err)
+	}
+	if !strings.HasPrefix(record, "ssh.example.com. IN SSHFP 4 2 ") {
+		t.Errorf("Unexpected SSHFP record: %s", record)
+	}
+	if len(strings.Fields(record)[5]) != 64 {
+		t.Errorf("Expected SHA-256 hex fingerprint, got %s", record)
+	}
+
+	line := KnownHosts("ssh.example.com", 2222, pub)
+	if !strings.HasPrefix(line, "[ssh.example.com]:2222 ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts line: %s", line)
+	}
+	if _, _, _, _, _, err := ssh.ParseKnownHosts([]byte(line)); err != nil {
+		t.Errorf("Failed to parse known_hosts line: %v", err)
+	}
+
+	line = KnownHosts("ssh.example.com", 22, pub)
+	if !strings.HasPrefix(line, "ssh.example.com ssh-ed25519 ") {
+		t.Errorf("Unexpected known_hosts line for default port: %s", line)
+	}
+}
@@ -0,0 +1,482 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultAccessMaxDuration 申请的默认最长时间
+	defaultAccessMaxDuration = 8 * time.Hour
+	// accessPendingTTL 没有被批准的申请保留的时间
+	accessPendingTTL = 24 * time.Hour
+	// grantSweepInterval 检查过期申请和紧急访问的间隔
+	grantSweepInterval = 30 * time.Second
+
+	grantPending = "pending"
+	grantActive  = "active"
+	grantExpired = "expired"
+)
+
+// AccessConfig 临时访问申请：用户通过管理 API 或 sshhook access 申请在一段时间内访问某个目标，
+// 批准后在有效期内可以用 <用户名>+<目标> 登录。申请、批准、拒绝和过期都写入审计日志
+type AccessConfig struct {
+	Approvers   []string      `yaml:"approvers,omitempty"`   // 可以批准申请的人，不能批准自己的申请；为空时不接受申请
+	MaxDuration time.Duration `yaml:"maxDuration,omitempty"` // 申请的最长时间，默认 8h
+	AuditLog    string        `yaml:"auditLog,omitempty"`    // 审计日志文件（可选），JSON Lines 格式追加写入
+}
+
+// enabled 是否接受访问申请
+func (a *AccessConfig) enabled() bool {
+	return len(a.Approvers) > 0
+}
+
+// maxDuration 返回申请的最长时间
+func (a *AccessConfig) maxDuration() time.Duration {
+	if a.MaxDuration == 0 {
+		return defaultAccessMaxDuration
+	}
+	return a.MaxDuration
+}
+
+// AccessGrant 访问申请，由管理 API 写入配置的 accessGrants，批准后在 expiresAt 之前有效，过期后被删除
+type AccessGrant struct {
+	ID          string        `yaml:"id"`
+	Username    string        `yaml:"username"`
+	Target      string        `yaml:"target"`
+	Duration    time.Duration `yaml:"duration"`         // 批准后的有效时间
+	Reason      string        `yaml:"reason,omitempty"` // 申请理由
+	RequestedAt time.Time     `yaml:"requestedAt"`
+	RequestedBy string        `yaml:"requestedBy,omitempty"` // 提交申请的管理 API token 的持有人
+	ApprovedBy  string        `yaml:"approvedBy,omitempty"`
+	ApprovedAt  time.Time     `yaml:"approvedAt,omitempty"`
+	ExpiresAt   time.Time     `yaml:"expiresAt,omitempty"`
+}
+
+// status 返回申请的状态：pending、active 或 expired
+func (g *AccessGrant) status(now time.Time) string {
+	switch {
+	case g.ApprovedAt.IsZero() && now.Sub(g.RequestedAt) < accessPendingTTL:
+		return grantPending
+	case g.ApprovedAt.IsZero() || !now.Before(g.ExpiresAt):
+		return grantExpired
+	default:
+		return grantActive
+	}
+}
+
+// validateAccess 检查访问申请的配置和已有的申请
+func (c *Config) validateAccess() error {
+	if c.Access.MaxDuration < 0 {
+		return errors.New("access: maxDuration must not be negative")
+	}
+	ids := make(map[string]bool)
+	for _, g := range c.AccessGrants {
+		if g.ID == "" {
+			return errors.New("access grant without id")
+		}
+		if ids[g.ID] {
+			return fmt.Errorf("duplicate access grant: %s", g.ID)
+		}
+		ids[g.ID] = true
+		if c.GetTarget(g.Target) == nil {
+			return fmt.Errorf("access grant %s: target not found: %s", g.ID, g.Target)
+		}
+		if g.Duration <= 0 {
+			return fmt.Errorf("access grant %s: duration must be positive", g.ID)
+		}
+	}
+	return nil
+}
+
+// activeGrant 返回用户对目标有效的访问申请
+func (c *Config) activeGrant(username, target string, now time.Time) *AccessGrant {
+	for i := range c.AccessGrants {
+		g := &c.AccessGrants[i]
+		if g.Username == username && g.Target == target && g.status(now) == grantActive {
+			return g
+		}
+	}
+	return nil
+}
+
+// AccessRequest 管理 API 创建访问申请的请求
+type AccessRequest struct {
+	Username string `json:"username"`
+	Target   string `json:"target"`
+	Duration string `json:"duration"` // 如 30m、2h
+	Reason   string `json:"reason,omitempty"`
+}
+
+// AccessDecision 批准或拒绝申请的请求，批准人是管理 API token 的持有人
+type AccessDecision struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// AdminAccessGrant 管理 API 返回的访问申请
+type AdminAccessGrant struct {
+	ID          string     `json:"id"`
+	Username    string     `json:"username"`
+	Target      string     `json:"target"`
+	Duration    string     `json:"duration"`
+	Reason      string     `json:"reason,omitempty"`
+	Status      string     `json:"status"` // pending、active 或 expired
+	RequestedAt time.Time  `json:"requestedAt"`
+	RequestedBy string     `json:"requestedBy,omitempty"`
+	ApprovedBy  string     `json:"approvedBy,omitempty"`
+	ApprovedAt  *time.Time `json:"approvedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+// newAdminAccessGrant 生成申请的输出
+func newAdminAccessGrant(g *AccessGrant, now time.Time) AdminAccessGrant {
+	item := AdminAccessGrant{
+		ID:          g.ID,
+		Username:    g.Username,
+		Target:      g.Target,
+		Duration:    g.Duration.String(),
+		Reason:      g.Reason,
+		Status:      g.status(now),
+		RequestedAt: g.RequestedAt,
+		RequestedBy: g.RequestedBy,
+		ApprovedBy:  g.ApprovedBy,
+	}
+	if !g.ApprovedAt.IsZero() {
+		item.ApprovedAt = &g.ApprovedAt
+		item.ExpiresAt = &g.ExpiresAt
+	}
+	return item
+}
+
+// newGrantID 生成申请的 ID
+func newGrantID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// auditAccess 把申请的变化写入审计日志
+func (s *Server) auditAccess(action, actor string, g *AccessGrant, reason string) {
+	ev := AuditEvent{
+		Time:     time.Now(),
+		Action:   action,
+		Actor:    actor,
+		Username: g.Username,
+		Target:   g.Target,
+		GrantID:  g.ID,
+		Duration: g.Duration.String(),
+		Reason:   reason,
+	}
+	if !g.ExpiresAt.IsZero() {
+		expires := g.ExpiresAt
+		ev.ExpiresAt = &expires
+	}
+	s.audit.add(ev, s.currentConfig().Access.AuditLog)
+}
+
+// handleAdminListAccess 列出访问申请，支持 user 参数
+func (s *Server) handleAdminListAccess(w http.ResponseWriter, r *http.Request) {
+	cfg := s.currentConfig()
+	now := time.Now()
+	username := r.URL.Query().Get("user")
+	items := []AdminAccessGrant{}
+	for i := range cfg.AccessGrants {
+		if username != "" && cfg.AccessGrants[i].Username != username {
+			continue
+		}
+		items = append(items, newAdminAccessGrant(&cfg.AccessGrants[i], now))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+}
+
+// handleAdminRequestAccess 创建访问申请，等待批准。申请人是管理 API token 的持有人，
+// username 是申请访问的用户，审计日志中分别记录
+func (s *Server) handleAdminRequestAccess(w http.ResponseWriter, r *http.Request) {
+	requester := adminPrincipal(r)
+	if requester == "" {
+		writeAdminError(w, errorf(http.StatusForbidden, "access requests require a named admin token"))
+		return
+	}
+	var body AccessRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAdminError(w, errorf(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+	duration, err := time.ParseDuration(body.Duration)
+	if err != nil || duration <= 0 {
+		writeAdminError(w, errorf(http.StatusBadRequest, "invalid duration: %q", body.Duration))
+		return
+	}
+
+	now := time.Now().Truncate(time.Second)
+	grant := AccessGrant{
+		Username:    body.Username,
+		Target:      body.Target,
+		Duration:    duration,
+		Reason:      body.Reason,
+		RequestedAt: now,
+		RequestedBy: requester,
+	}
+	if grant.ID, err = newGrantID(); err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	_, err = s.updateConfig(func(doc *configDocument) error {
+		cfg := s.currentConfig()
+		if !cfg.Access.enabled() {
+			return errorf(http.StatusForbidden, "access requests are disabled: no approvers configured")
+		}
+		if cfg.GetUser(grant.Username) == nil {
+			return errorf(http.StatusBadRequest, "user not found: %s", grant.Username)
+		}
+		if cfg.GetTarget(grant.Target) == nil {
+			return errorf(http.StatusBadRequest, "target not found: %s", grant.Target)
+		}
+		if max := cfg.Access.maxDuration(); duration > max {
+			return errorf(http.StatusBadRequest, "duration exceeds maxDuration %s", max)
+		}
+		for i := range cfg.AccessGrants {
+			g := &cfg.AccessGrants[i]
+			if g.Username == grant.Username && g.Target == grant.Target && g.status(now) != grantExpired {
+				return errorf(http.StatusConflict, "access request %s for %s is already %s", g.ID, g.Target, g.status(now))
+			}
+		}
+		node := &yaml.Node{}
+		if err := node.Encode(&grant); err != nil {
+			return err
+		}
+		list := doc.section("accessGrants", true)
+		list.Content = append(list.Content, node)
+		return nil
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	s.auditAccess(auditAccessRequested, requester, &grant, grant.Reason)
+	writeJSON(w, http.StatusCreated, newAdminAccessGrant(&grant, now))
+}
+
+// handleAdminDecideAccess 批准或拒绝申请，批准人是管理 API token 的持有人。批准后从现在开始计算有效期；
+// 拒绝已经批准的申请时立即收回，审计日志中记录为 access.revoked
+func (s *Server) handleAdminDecideAccess(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var body AccessDecision
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAdminError(w, errorf(http.StatusBadRequest, "invalid request body: %v", err))
+			return
+		}
+
+		approver := adminPrincipal(r)
+		if approver == "" {
+			writeAdminError(w, errorf(http.StatusForbidden, "deciding on access requests requires a named admin token"))
+			return
+		}
+
+		now := time.Now().Truncate(time.Second)
+		var grant AccessGrant
+		action := auditAccessDenied
+		_, err := s.updateConfig(func(doc *configDocument) error {
+			cfg := s.currentConfig()
+			if !contains(cfg.Access.Approvers, approver) {
+				return errorf(http.StatusForbidden, "%q is not an approver", approver)
+			}
+			node, i := doc.find("accessGrants", "id", id)
+			if node == nil {
+				return errorf(http.StatusNotFound, "Not found")
+			}
+			if err := node.Decode(&grant); err != nil {
+				return err
+			}
+			if grant.Username == approver || grant.RequestedBy == approver {
+				return errorf(http.StatusForbidden, "cannot decide on your own access request")
+			}
+			status := grant.status(now)
+			list := doc.section("accessGrants", false)
+			if !approve {
+				if status == grantExpired {
+					return errorf(http.StatusConflict, "access request %s is expired", id)
+				}
+				if status == grantActive {
+					action = auditAccessRevoked
+				}
+				list.Content = append(list.Content[:i], list.Content[i+1:]...)
+				return nil
+			}
+			if status != grantPending {
+				return errorf(http.StatusConflict, "access request %s is %s", id, status)
+			}
+			grant.ApprovedBy = approver
+			grant.ApprovedAt = now
+			grant.ExpiresAt = now.Add(grant.Duration)
+			updated := &yaml.Node{}
+			if err := updated.Encode(&grant); err != nil {
+				return err
+			}
+			keepLayout(updated, node)
+			list.Content[i] = updated
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		if approve {
+			action = auditAccessApproved
+		}
+		s.auditAccess(action, approver, &grant, body.Reason)
+		if !approve {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, newAdminAccessGrant(&grant, now))
+	}
+}
+
+// handleAdminAudit 查询最近的审计事件，支持 user 和 limit 参数
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	limit := defaultEventLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAdminError(w, errorf(http.StatusBadRequest, "invalid limit: %s", v))
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": s.audit.list(r.URL.Query().Get("user"), limit)})
+}
+
+// expireGrants 记录过期的申请并从配置中删除。每个申请只记录一次，配置不能写入时保留在配置中
+func (s *Server) expireGrants(now time.Time) {
+	cfg := s.currentConfig()
+	var expired []AccessGrant
+	for _, g := range cfg.AccessGrants {
+		if g.status(now) == grantExpired {
+			expired = append(expired, g)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	s.grantsMu.Lock()
+	for i := range expired {
+		g := &expired[i]
+		if s.expiredGrants[g.ID] {
+			continue
+		}
+		s.expiredGrants[g.ID] = true
+		reason := ""
+		if g.ApprovedAt.IsZero() {
+			reason = "not approved"
+		}
+		s.auditAccess(auditAccessExpired, "", g, reason)
+	}
+	s.grantsMu.Unlock()
+
+	if s.store == nil {
+		return
+	}
+	_, err := s.updateConfig(func(doc *configDocument) error {
+		list := doc.section("accessGrants", false)
+		if list == nil {
+			return nil
+		}
+		kept := list.Content[:0]
+		for _, node := range list.Content {
+			var g AccessGrant
+			if err := node.Decode(&g); err == nil && g.status(now) == grantExpired {
+				continue
+			}
+			kept = append(kept, node)
+		}
+		list.Content = kept
+		return nil
+	})
+	if err != nil {
+		log.Printf("[Access] Failed to remove expired access grants: %v", err)
+	}
+}
+
+// sweepExpired 定期检查过期的申请和紧急访问，直到 stop 关闭
+func (s *Server) sweepExpired(stop <-chan struct{}) {
+	ticker := time.NewTicker(grantSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.expireGrants(now)
+			s.expireBreakGlass(now)
+		}
+	}
+}
+
+// AccessClient 通过管理 API 申请和批准临时访问，供 sshhook access 使用
+type AccessClient struct {
+	api *adminUserStore
+}
+
+// NewAccessClient 创建 AccessClient，baseURL 如 http://127.0.0.1:8081
+func NewAccessClient(baseURL, token string) *AccessClient {
+	return &AccessClient{api: NewAdminUserStore(baseURL, token).(*adminUserStore)}
+}
+
+// accessPath 返回申请的 URL 路径
+func accessPath(id, action string) string {
+	return "/access-requests/" + url.PathEscape(id) + "/" + action
+}
+
+// Request 创建访问申请
+func (c *AccessClient) Request(req AccessRequest) (*AdminAccessGrant, error) {
+	var grant AdminAccessGrant
+	if _, err := c.api.do(http.MethodPost, "/access-requests", "", req, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// Approve 批准申请
+func (c *AccessClient) Approve(id string, decision AccessDecision) (*AdminAccessGrant, error) {
+	var grant AdminAccessGrant
+	if _, err := c.api.do(http.MethodPost, accessPath(id, "approve"), "", decision, &grant); err != nil {
+		return nil, err
+	}
+	return &grant, nil
+}
+
+// Deny 拒绝申请，已经批准的申请立即收回
+func (c *AccessClient) Deny(id string, decision AccessDecision) error {
+	_, err := c.api.do(http.MethodPost, accessPath(id, "deny"), "", decision, nil)
+	return err
+}
+
+// List 列出访问申请，username 不为空时只返回该用户的申请
+func (c *AccessClient) List(username string) ([]AdminAccessGrant, error) {
+	path := "/access-requests"
+	if username != "" {
+		path += "?user=" + url.QueryEscape(username)
+	}
+	var list struct {
+		Items []AdminAccessGrant `json:"items"`
+	}
+	if _, err := c.api.do(http.MethodGet, path, "", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
@@ -0,0 +1,313 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.containerssh.io/containerssh/config"
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// accessTestConfig 访问申请测试使用的配置文件：alice 默认登录 dev，prod 需要申请，bob 可以批准
+const accessTestConfig = `listen: ":8080"
+admin:
+  listen: "127.0.0.1:8081"
+  tokens:
+    - "admin-token"
+    - name: "alice"
+      token: "alice-token"
+    - name: "bob"
+      token: "bob-token"
+access:
+  approvers: ["bob"]
+  maxDuration: 2h
+  auditLog: "%s"
+clusters:
+  - name: "c1"
+    host: "https://127.0.0.1:6443"
+targets:
+  - name: "dev"
+    cluster: "c1"
+    namespace: "default"
+    pod: "dev-pod"
+    container: "app"
+  - name: "prod"
+    cluster: "c1"
+    namespace: "default"
+    pod: "prod-pod"
+    container: "app"
+users:
+  - username: "alice"
+    password: "alice-pass"
+    target: "dev"
+  - username: "bob"
+    password: "bob-pass"
+    target: "dev"
+`
+
+// newAccessTestServer 从临时配置文件创建服务器，extra 追加到配置末尾，返回服务器、管理 API 和审计日志路径
+func newAccessTestServer(t *testing.T, extra string) (*Server, http.Handler, string) {
+	t.Helper()
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	path := filepath.Join(dir, "webhook.yaml")
+	data := strings.Replace(accessTestConfig, "%s", auditPath, 1) + extra
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.offline = true
+	return server, server.adminHandler(), auditPath
+}
+
+// decodeGrant 解析管理 API 返回的访问申请
+func decodeGrant(t *testing.T, body []byte) AdminAccessGrant {
+	t.Helper()
+	var grant AdminAccessGrant
+	if err := json.Unmarshal(body, &grant); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", body, err)
+	}
+	return grant
+}
+
+// accessConfigRequest 以 alice+prod 登录、授权步骤已经写入目标时调用 config 接口
+func accessConfigRequest(t *testing.T, server *Server) int {
+	t.Helper()
+	var req config.Request
+	req.Username = "alice+prod"
+	req.AuthenticatedUsername = "alice"
+	req.Metadata = map[string]metadata.Value{metadataTarget: {Value: "prod"}}
+	return postJSON(t, server.handleConfig, req).Code
+}
+
+// auditActions 返回审计日志中的事件类型，从旧到新
+func auditActions(events []AuditEvent) []string {
+	var actions []string
+	for i := len(events) - 1; i >= 0; i-- {
+		actions = append(actions, events[i].Action)
+	}
+	return actions
+}
+
+// TestAccess_RequestAndApprove 测试申请批准后在有效期内可以访问目标，申请和批准写入审计日志
+func TestAccess_RequestAndApprove(t *testing.T) {
+	server, handler, auditPath := newAccessTestServer(t, "")
+
+	if resp := authzRequest(t, server, "alice+prod", "alice"); resp.Success {
+		t.Fatal("Expected prod to be denied before the request is approved")
+	}
+
+	rec := adminRequestAs(t, handler, "alice-token", http.MethodPost, "/api/v1/access-requests",
+		AccessRequest{Username: "alice", Target: "prod", Duration: "30m", Reason: "INC-42"}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	grant := decodeGrant(t, rec.Body.Bytes())
+	if grant.Status != grantPending || grant.ID == "" || grant.RequestedBy != "alice" {
+		t.Fatalf("Expected pending request by alice, got %+v", grant)
+	}
+	if resp := authzRequest(t, server, "alice+prod", "alice"); resp.Success {
+		t.Error("Expected pending request not to allow prod")
+	}
+
+	// 批准人是 token 的持有人：没有 name 的 token 不能批准，申请人不能批准自己的申请，批准人必须在 approvers 中
+	approve := "/api/v1/access-requests/" + grant.ID + "/approve"
+	for _, token := range []string{"admin-token", "alice-token"} {
+		if rec := adminRequestAs(t, handler, token, http.MethodPost, approve, AccessDecision{}, ""); rec.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403 for %s, got %d", token, rec.Code)
+		}
+	}
+	rec = adminRequestAs(t, handler, "bob-token", http.MethodPost, approve, AccessDecision{}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	grant = decodeGrant(t, rec.Body.Bytes())
+	if grant.Status != grantActive || grant.ApprovedBy != "bob" || grant.ExpiresAt == nil {
+		t.Fatalf("Expected active grant approved by bob, got %+v", grant)
+	}
+	if d := time.Until(*grant.ExpiresAt); d < 29*time.Minute || d > 30*time.Minute {
+		t.Errorf("Expected grant to expire in 30m, got %s", d)
+	}
+	if rec := adminRequestAs(t, handler, "bob-token", http.MethodPost, approve, AccessDecision{}, ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when approving twice, got %d", rec.Code)
+	}
+
+	if resp := authzRequest(t, server, "alice+prod", "alice"); !resp.Success {
+		t.Error("Expected prod to be allowed after approval")
+	}
+	if code := accessConfigRequest(t, server); code != http.StatusOK {
+		t.Errorf("Expected status 200 from config, got %d", code)
+	}
+
+	// 申请保存在配置文件中，重新加载后仍然有效
+	cfg, err := LoadConfig(server.store.Path())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.activeGrant("alice", "prod", time.Now()) == nil {
+		t.Errorf("Expected grant in config file, got %+v", cfg.AccessGrants)
+	}
+
+	if got := auditActions(server.audit.list("alice", 0)); strings.Join(got, ",") != "access.requested,access.approved" {
+		t.Errorf("Expected requested and approved audit events, got %v", got)
+	}
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var requested, approved AuditEvent
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &requested) != nil || json.Unmarshal([]byte(lines[1]), &approved) != nil {
+		t.Fatalf("Expected 2 audit log lines, got %q", data)
+	}
+	if requested.Actor != "alice" || requested.Username != "alice" {
+		t.Errorf("Expected request by alice, got %+v", requested)
+	}
+	if approved.Actor != "bob" || approved.GrantID != grant.ID || approved.ExpiresAt == nil {
+		t.Errorf("Expected approval by bob with expiry, got %+v", approved)
+	}
+}
+
+// TestAccess_RequestOnBehalf 测试代他人提交申请时记录申请人，申请人不能批准自己提交的申请
+func TestAccess_RequestOnBehalf(t *testing.T) {
+	server, handler, _ := newAccessTestServer(t, "")
+
+	rec := adminRequestAs(t, handler, "bob-token", http.MethodPost, "/api/v1/access-requests",
+		AccessRequest{Username: "alice", Target: "prod", Duration: "1h"}, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	grant := decodeGrant(t, rec.Body.Bytes())
+	if grant.Username != "alice" || grant.RequestedBy != "bob" {
+		t.Errorf("Expected request for alice by bob, got %+v", grant)
+	}
+	events := server.audit.list("alice", 0)
+	if len(events) != 1 || events[0].Actor != "bob" || events[0].Username != "alice" {
+		t.Errorf("Expected audit event by bob for alice, got %+v", events)
+	}
+	approve := "/api/v1/access-requests/" + grant.ID + "/approve"
+	if rec := adminRequestAs(t, handler, "bob-token", http.MethodPost, approve, AccessDecision{}, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 when approving own request, got %d", rec.Code)
+	}
+}
+
+// TestAccess_RequestErrors 测试申请的检查
+func TestAccess_RequestErrors(t *testing.T) {
+	_, handler, _ := newAccessTestServer(t, "")
+
+	tests := []struct {
+		req  AccessRequest
+		code int
+	}{
+		{AccessRequest{Username: "alice", Target: "prod", Duration: "3h"}, http.StatusBadRequest},
+		{AccessRequest{Username: "alice", Target: "prod", Duration: "soon"}, http.StatusBadRequest},
+		{AccessRequest{Username: "alice", Target: "missing", Duration: "1h"}, http.StatusBadRequest},
+		{AccessRequest{Username: "mallory", Target: "prod", Duration: "1h"}, http.StatusBadRequest},
+		{AccessRequest{Username: "alice", Target: "prod", Duration: "1h"}, http.StatusCreated},
+		{AccessRequest{Username: "alice", Target: "prod", Duration: "1h"}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		rec := adminRequestAs(t, handler, "alice-token", http.MethodPost, "/api/v1/access-requests", tt.req, "")
+		if rec.Code != tt.code {
+			t.Errorf("%+v: expected status %d, got %d: %s", tt.req, tt.code, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+// TestAccess_Deny 测试拒绝申请和收回已经批准的访问
+func TestAccess_Deny(t *testing.T) {
+	server, handler, _ := newAccessTestServer(t, "")
+
+	for _, approveFirst := range []bool{false, true} {
+		rec := adminRequestAs(t, handler, "alice-token", http.MethodPost, "/api/v1/access-requests",
+			AccessRequest{Username: "alice", Target: "prod", Duration: "1h"}, "")
+		grant := decodeGrant(t, rec.Body.Bytes())
+		if approveFirst {
+			adminRequestAs(t, handler, "bob-token", http.MethodPost, "/api/v1/access-requests/"+grant.ID+"/approve", AccessDecision{}, "")
+		}
+		rec = adminRequestAs(t, handler, "bob-token", http.MethodPost, "/api/v1/access-requests/"+grant.ID+"/deny",
+			AccessDecision{Reason: "not needed"}, "")
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if resp := authzRequest(t, server, "alice+prod", "alice"); resp.Success {
+			t.Error("Expected prod to be denied after deny")
+		}
+	}
+
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/access-requests", nil, "")
+	if !strings.Contains(rec.Body.String(), `"items":[]`) {
+		t.Errorf("Expected no access requests, got %s", rec.Body.String())
+	}
+	want := "access.requested,access.denied,access.requested,access.approved,access.revoked"
+	if got := auditActions(server.audit.list("", 0)); strings.Join(got, ",") != want {
+		t.Errorf("Expected audit events %s, got %v", want, got)
+	}
+}
+
+// TestAccess_Expire 测试过期的访问不再允许，config 接口再次检查，过期只记录一次并从配置中删除
+func TestAccess_Expire(t *testing.T) {
+	approvedAt := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	expiresAt := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	server, handler, _ := newAccessTestServer(t, `accessGrants:
+  - id: "g1"
+    username: "alice"
+    target: "prod"
+    duration: 1h
+    requestedAt: "`+approvedAt+`"
+    approvedBy: "bob"
+    approvedAt: "`+approvedAt+`"
+    expiresAt: "`+expiresAt+`"
+`)
+
+	if resp := authzRequest(t, server, "alice+prod", "alice"); !resp.Success {
+		t.Fatal("Expected prod to be allowed before expiry")
+	}
+
+	// 授权之后、获取配置之前过期
+	server.expireGrants(time.Now())
+	if got := server.audit.list("", 0); len(got) != 0 {
+		t.Fatalf("Expected no expiry before expiresAt, got %+v", got)
+	}
+	cfg := server.currentConfig()
+	cfg.AccessGrants[0].ExpiresAt = time.Now().Add(-time.Second)
+	if code := accessConfigRequest(t, server); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 from config after expiry, got %d", code)
+	}
+
+	server.expireGrants(time.Now().Add(2 * time.Minute))
+	server.expireGrants(time.Now().Add(2 * time.Minute))
+	events := server.audit.list("alice", 0)
+	if len(events) != 1 || events[0].Action != auditAccessExpired || events[0].GrantID != "g1" {
+		t.Errorf("Expected one expiry audit event, got %+v", events)
+	}
+	if len(server.currentConfig().AccessGrants) != 0 {
+		t.Errorf("Expected expired grant to be removed, got %+v", server.currentConfig().AccessGrants)
+	}
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/audit?user=alice", nil, "")
+	if !strings.Contains(rec.Body.String(), `"action":"access.expired"`) {
+		t.Errorf("Expected expiry in audit API, got %s", rec.Body.String())
+	}
+}
+
+// TestAccess_Disabled 测试没有配置批准人时不接受申请
+func TestAccess_Disabled(t *testing.T) {
+	_, handler, _ := newAdminTestServer(t)
+	rec := adminRequestAs(t, handler, "alice-token", http.MethodPost, "/api/v1/access-requests",
+		AccessRequest{Username: "alice", Target: "prod", Duration: "1h"}, "")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "disabled") {
+		t.Errorf("Expected status 403 for disabled access requests, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
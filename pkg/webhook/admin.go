@@ -0,0 +1,726 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultEventLimit 查询认证事件时默认返回的数量
+const defaultEventLimit = 100
+
+// AdminConfig 管理 API 配置，监听地址与 webhook 分开，避免暴露给 ContainerSSH 所在的网络
+type AdminConfig struct {
+	Listen      string       `yaml:"listen,omitempty"`      // 监听地址，留空时不启动管理 API
+	Tokens      []AdminToken `yaml:"tokens,omitempty"`      // Bearer token，支持 file:、env:、k8s: 引用
+	TLSCertFile string       `yaml:"tlsCertFile,omitempty"` // TLS 证书（可选）
+	TLSKeyFile  string       `yaml:"tlsKeyFile,omitempty"`  // TLS 私钥（可选）
+	EventBuffer int          `yaml:"eventBuffer,omitempty"` // 保留的认证事件数，默认 1000
+}
+
+// AdminToken 管理 API 的 token。name 是持有人，提交、批准访问申请和启用紧急访问时作为操作人，
+// 只写 token 字符串时没有持有人，不能执行这两类操作
+type AdminToken struct {
+	Name  string `yaml:"name,omitempty"`
+	Token Secret `yaml:"token"`
+}
+
+// UnmarshalYAML 同时接受 token 字符串和 {name, token}
+func (t *AdminToken) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*t = AdminToken{}
+		return node.Decode(&t.Token)
+	}
+	type plain AdminToken
+	return node.Decode((*plain)(t))
+}
+
+// validate 检查管理 API 配置
+func (c *AdminConfig) validate() error {
+	if c.Listen == "" {
+		return nil
+	}
+	if len(c.Tokens) == 0 {
+		return fmt.Errorf("tokens are required")
+	}
+	names := map[string]bool{}
+	for i, t := range c.Tokens {
+		if t.Token == "" {
+			return fmt.Errorf("token %d is empty", i+1)
+		}
+		if t.Name == "" {
+			continue
+		}
+		if names[t.Name] {
+			return fmt.Errorf("duplicate token name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tlsCertFile and tlsKeyFile must be set together")
+	}
+	return nil
+}
+
+// principal 返回 Bearer token 的持有人，ok 表示 token 有效。比较时间与 token 内容无关
+func (c *AdminConfig) principal(token string) (name string, ok bool) {
+	if token == "" {
+		return "", false
+	}
+	for _, t := range c.Tokens {
+		if t.Token.Value() != "" && subtle.ConstantTimeCompare([]byte(t.Token.Value()), []byte(token)) == 1 {
+			name, ok = t.Name, true
+		}
+	}
+	return name, ok
+}
+
+// matchToken 检查 token 是否是 tokens 中的一个，比较时间与 token 内容无关
+func matchToken(tokens []Secret, token string) bool {
+	if token == "" {
+		return false
+	}
+	valid := false
+	for _, t := range tokens {
+		if t.Value() != "" && subtle.ConstantTimeCompare([]byte(t.Value()), []byte(token)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// adminResource 管理 API 可以修改的配置列表
+type adminResource struct {
+	section string                                 // 配置中的顶层列表
+	key     string                                 // 条目的名称字段
+	decode  func(data []byte) (interface{}, error) // 严格解析条目，未知字段报错
+	hidden  []string                               // 不是 Secret 类型但同样输出为 ****** 的字段
+}
+
+// adminResources 管理 API 的资源，key 是 URL 中的名称
+var adminResources = map[string]adminResource{
+	"users":    {section: "users", key: "username", decode: decodeStrict[UserConfig], hidden: []string{"passwordHash", "totpSecret"}},
+	"targets":  {section: "targets", key: "name", decode: decodeStrict[TargetConfig]},
+	"clusters": {section: "clusters", key: "name", decode: decodeStrict[ClusterConfig]},
+}
+
+// decodeStrict 解析条目，字段拼写错误时返回错误
+func decodeStrict[T any](data []byte) (interface{}, error) {
+	var v T
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// adminError 返回给管理 API 客户端的错误
+type adminError struct {
+	status  int
+	message string
+}
+
+func (e *adminError) Error() string {
+	return e.message
+}
+
+// errorf 创建 adminError
+func errorf(status int, format string, args ...interface{}) error {
+	return &adminError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// writeAdminError 输出错误，adminError 以外的错误返回 500
+func writeAdminError(w http.ResponseWriter, err error) {
+	var ae *adminError
+	if errors.As(err, &ae) {
+		http.Error(w, ae.message, ae.status)
+		return
+	}
+	log.Printf("[Admin] Internal error: %v", err)
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+// writeJSON 输出 JSON 响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[Admin] Failed to encode response: %v", err)
+	}
+}
+
+// AdminItem 管理 API 返回的资源，Secret 字段输出为 ******
+type AdminItem struct {
+	ResourceVersion string      `json:"resourceVersion"`
+	Spec            interface{} `json:"spec"`
+}
+
+// view 生成资源的输出，保持配置文件中的写法，Secret 字段输出为 ******
+func (res adminResource) view(node *yaml.Node) (AdminItem, error) {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return AdminItem{}, err
+	}
+	var spec interface{}
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		return AdminItem{}, err
+	}
+
+	// 按类型序列化一次，Secret 字段在其中已经被替换
+	typed, err := res.decode(data)
+	if err != nil {
+		return AdminItem{}, err
+	}
+	if data, err = yaml.Marshal(typed); err != nil {
+		return AdminItem{}, err
+	}
+	var mask interface{}
+	if err := yaml.Unmarshal(data, &mask); err != nil {
+		return AdminItem{}, err
+	}
+	spec = redactAs(spec, mask)
+	if m, ok := spec.(map[string]interface{}); ok {
+		for _, key := range res.hidden {
+			if v, ok := m[key]; ok && v != "" {
+				m[key] = redacted
+			}
+		}
+	}
+	return AdminItem{ResourceVersion: resourceVersion(node), Spec: spec}, nil
+}
+
+// redactAs 把 spec 中在 mask 里相同位置为 ****** 的值替换为 ******
+func redactAs(spec, mask interface{}) interface{} {
+	switch v := spec.(type) {
+	case map[string]interface{}:
+		m, _ := mask.(map[string]interface{})
+		for key, value := range v {
+			v[key] = redactAs(value, m[key])
+		}
+	case []interface{}:
+		m, _ := mask.([]interface{})
+		for i, value := range v {
+			var item interface{}
+			if i < len(m) {
+				item = m[i]
+			}
+			v[i] = redactAs(value, item)
+		}
+	default:
+		if mask == redacted {
+			return redacted
+		}
+	}
+	return spec
+}
+
+// adminHandler 返回管理 API 的路由，所有接口都需要 Bearer token
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	for name, res := range adminResources {
+		mux.HandleFunc("GET /api/v1/"+name, s.handleAdminList(res))
+		mux.HandleFunc("POST /api/v1/"+name, s.handleAdminCreate(res))
+		mux.HandleFunc("GET /api/v1/"+name+"/{name}", s.handleAdminGet(res))
+		mux.HandleFunc("PUT /api/v1/"+name+"/{name}", s.handleAdminUpdate(res))
+		mux.HandleFunc("DELETE /api/v1/"+name+"/{name}", s.handleAdminDelete(res))
+	}
+	mux.HandleFunc("GET /api/v1/users/{name}/keys", s.handleAdminListKeys)
+	mux.HandleFunc("POST /api/v1/users/{name}/keys", s.handleAdminAddKey)
+	mux.HandleFunc("DELETE /api/v1/users/{name}/keys/{fingerprint}", s.handleAdminDeleteKey)
+	mux.HandleFunc("POST /api/v1/users/{name}/lock", s.handleAdminLock(true))
+	mux.HandleFunc("POST /api/v1/users/{name}/unlock", s.handleAdminLock(false))
+	mux.HandleFunc("GET /api/v1/events", s.handleAdminEvents(s.events))
+	mux.HandleFunc("GET /api/v1/decisions", s.handleAdminEvents(s.decisions))
+	mux.HandleFunc("GET /api/v1/access-requests", s.handleAdminListAccess)
+	mux.HandleFunc("POST /api/v1/access-requests", s.handleAdminRequestAccess)
+	mux.HandleFunc("POST /api/v1/access-requests/{id}/approve", s.handleAdminDecideAccess(true))
+	mux.HandleFunc("POST /api/v1/access-requests/{id}/deny", s.handleAdminDecideAccess(false))
+	mux.HandleFunc("GET /api/v1/audit", s.handleAdminAudit)
+	mux.HandleFunc("GET /api/v1/breakglass", s.handleAdminBreakGlass)
+	mux.HandleFunc("POST /api/v1/breakglass/enable", s.handleAdminChangeBreakGlass(true))
+	mux.HandleFunc("POST /api/v1/breakglass/disable", s.handleAdminChangeBreakGlass(false))
+	return s.requireAdminToken(mux)
+}
+
+// adminPrincipalKey 请求 context 中保存 token 持有人的 key
+type adminPrincipalKey struct{}
+
+// requireAdminToken 检查 Authorization: Bearer <token>，并把 token 的持有人保存到请求的 context 中
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		name := ""
+		if ok {
+			name, ok = s.currentConfig().Admin.principal(token)
+		}
+		if !ok {
+			log.Printf("[Admin] Unauthorized request - method=%s, path=%s, remoteAddress=%s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sshhook"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminPrincipalKey{}, name)))
+	})
+}
+
+// adminPrincipal 返回调用管理 API 的 token 持有人，token 没有 name 时返回空字符串
+func adminPrincipal(r *http.Request) string {
+	name, _ := r.Context().Value(adminPrincipalKey{}).(string)
+	return name
+}
+
+// loadDocument 从存储读取配置文档
+func (s *Server) loadDocument() (*configDocument, error) {
+	if s.store == nil {
+		return nil, errorf(http.StatusServiceUnavailable, "config store is not available")
+	}
+	data, err := s.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return parseDocument(data)
+}
+
+// updateConfig 读取配置文档并执行修改，新的配置通过检查后写入存储并重新加载。
+// 多个修改依次执行，资源版本在 fn 中检查
+func (s *Server) updateConfig(fn func(doc *configDocument) error) (*configDocument, error) {
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+
+	doc, err := s.loadDocument()
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(doc); err != nil {
+		return nil, err
+	}
+
+	data, err := doc.bytes()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfigData(data, s.store.Path())
+	if err != nil {
+		return nil, errorf(http.StatusUnprocessableEntity, "%v", err)
+	}
+	if err := s.store.Save(data); err != nil {
+		return nil, err
+	}
+	s.Reload(cfg)
+	return doc, nil
+}
+
+// checkVersion 检查 If-Match 中的资源版本，未提供时返回 428，不一致时返回 409
+func checkVersion(r *http.Request, node *yaml.Node) error {
+	want := strings.Trim(r.Header.Get("If-Match"), `"`)
+	if want == "" {
+		return errorf(http.StatusPreconditionRequired, "If-Match header with resourceVersion is required")
+	}
+	if got := resourceVersion(node); got != want {
+		return errorf(http.StatusConflict, "resourceVersion mismatch: current is %s", got)
+	}
+	return nil
+}
+
+// readBody 把 JSON 请求体转换为 YAML 节点
+func readBody(r *http.Request) (*yaml.Node, error) {
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	node := &yaml.Node{}
+	if err := node.Encode(body); err != nil {
+		return nil, errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return node, nil
+}
+
+// checkResource 严格解析新的条目
+func checkResource(res adminResource, node *yaml.Node) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	if _, err := res.decode(data); err != nil {
+		return errorf(http.StatusBadRequest, "invalid %s: %v", strings.TrimSuffix(res.section, "s"), err)
+	}
+	return nil
+}
+
+// respondItem 输出单个资源，ETag 为资源版本
+func respondItem(w http.ResponseWriter, status int, res adminResource, node *yaml.Node) {
+	item, err := res.view(node)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(item.ResourceVersion))
+	writeJSON(w, status, item)
+}
+
+// handleAdminList 列出资源
+func (s *Server) handleAdminList(res adminResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := s.loadDocument()
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		items := []AdminItem{}
+		if list := doc.section(res.section, false); list != nil {
+			for _, node := range list.Content {
+				item, err := res.view(node)
+				if err != nil {
+					writeAdminError(w, err)
+					return
+				}
+				items = append(items, item)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": items})
+	}
+}
+
+// handleAdminGet 查询单个资源
+func (s *Server) handleAdminGet(res adminResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, err := s.loadDocument()
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		node, _ := doc.find(res.section, res.key, r.PathValue("name"))
+		if node == nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		respondItem(w, http.StatusOK, res, node)
+	}
+}
+
+// handleAdminCreate 创建资源，名称已存在时返回 409
+func (s *Server) handleAdminCreate(res adminResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		node, err := readBody(r)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		name := mappingValue(node, res.key)
+		if name == nil || name.Value == "" {
+			writeAdminError(w, errorf(http.StatusBadRequest, "%s is required", res.key))
+			return
+		}
+
+		_, err = s.updateConfig(func(doc *configDocument) error {
+			if existing, _ := doc.find(res.section, res.key, name.Value); existing != nil {
+				return errorf(http.StatusConflict, "%s already exists", name.Value)
+			}
+			if err := restoreRedacted(node, nil, res.key); err != nil {
+				return errorf(http.StatusBadRequest, "%v", err)
+			}
+			if err := checkResource(res, node); err != nil {
+				return err
+			}
+			list := doc.section(res.section, true)
+			list.Content = append(list.Content, node)
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Printf("[Admin] Created %s %s", res.section, name.Value)
+		respondItem(w, http.StatusCreated, res, node)
+	}
+}
+
+// handleAdminUpdate 替换资源，请求体中的 ****** 保持原值
+func (s *Server) handleAdminUpdate(res adminResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		node, err := readBody(r)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		if v := mappingValue(node, res.key); v == nil {
+			setMappingValue(node, res.key, &yaml.Node{Kind: yaml.ScalarNode, Value: name})
+		} else if v.Value != name {
+			writeAdminError(w, errorf(http.StatusBadRequest, "%s cannot be changed", res.key))
+			return
+		}
+
+		_, err = s.updateConfig(func(doc *configDocument) error {
+			old, i := doc.find(res.section, res.key, name)
+			if old == nil {
+				return errorf(http.StatusNotFound, "Not found")
+			}
+			if err := checkVersion(r, old); err != nil {
+				return err
+			}
+			if err := restoreRedacted(node, old, res.key); err != nil {
+				return errorf(http.StatusBadRequest, "%v", err)
+			}
+			if err := checkResource(res, node); err != nil {
+				return err
+			}
+			keepLayout(node, old)
+			doc.section(res.section, false).Content[i] = node
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Printf("[Admin] Updated %s %s", res.section, name)
+		respondItem(w, http.StatusOK, res, node)
+	}
+}
+
+// handleAdminDelete 删除资源，仍被引用的集群和目标在检查配置时报错
+func (s *Server) handleAdminDelete(res adminResource) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		_, err := s.updateConfig(func(doc *configDocument) error {
+			old, i := doc.find(res.section, res.key, name)
+			if old == nil {
+				return errorf(http.StatusNotFound, "Not found")
+			}
+			if err := checkVersion(r, old); err != nil {
+				return err
+			}
+			list := doc.section(res.section, false)
+			list.Content = append(list.Content[:i], list.Content[i+1:]...)
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		log.Printf("[Admin] Deleted %s %s", res.section, name)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AdminKey 用户的公钥
+type AdminKey struct {
+	Fingerprint string `json:"fingerprint"` // SHA256 指纹，删除时使用
+	Key         string `json:"key"`         // authorized_keys 格式的配置
+}
+
+// userKeys 返回用户配置中的公钥和指纹
+func userKeys(user *UserConfig) []AdminKey {
+	keys := []AdminKey{}
+	for _, line := range user.authorizedKeys() {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, AdminKey{Fingerprint: ssh.FingerprintSHA256(pub), Key: line})
+	}
+	return keys
+}
+
+// decodeUser 把用户节点解析为 UserConfig
+func decodeUser(node *yaml.Node) (*UserConfig, error) {
+	var user UserConfig
+	if err := node.Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// handleAdminListKeys 列出用户的公钥
+func (s *Server) handleAdminListKeys(w http.ResponseWriter, r *http.Request) {
+	doc, err := s.loadDocument()
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	node, _ := doc.find("users", "username", r.PathValue("name"))
+	if node == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	user, err := decodeUser(node)
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(resourceVersion(node)))
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": userKeys(user)})
+}
+
+// addUserKey 在用户节点的 publicKeys 中添加公钥，返回指纹
+func addUserKey(node *yaml.Node, line string) (string, error) {
+	line = strings.TrimSpace(line)
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return "", errorf(http.StatusBadRequest, "invalid public key: %v", err)
+	}
+	fingerprint := ssh.FingerprintSHA256(pub)
+
+	user, err := decodeUser(node)
+	if err != nil {
+		return "", err
+	}
+	for _, k := range userKeys(user) {
+		if k.Fingerprint == fingerprint {
+			return "", errorf(http.StatusConflict, "key %s already exists", fingerprint)
+		}
+	}
+	keys := mappingValue(node, "publicKeys")
+	if keys == nil || keys.Kind != yaml.SequenceNode {
+		keys = &yaml.Node{Kind: yaml.SequenceNode}
+		setMappingValue(node, "publicKeys", keys)
+	}
+	keys.Content = append(keys.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: line})
+	return fingerprint, nil
+}
+
+// deleteUserKey 删除用户节点中指纹匹配的公钥，包括 publicKey 和 publicKeys
+func deleteUserKey(node *yaml.Node, fingerprint string) error {
+	matches := func(line string) bool {
+		var user UserConfig
+		user.PublicKey = line
+		keys := userKeys(&user)
+		return len(keys) == 1 && keys[0].Fingerprint == fingerprint
+	}
+
+	found := false
+	if key := mappingValue(node, "publicKey"); key != nil && matches(key.Value) {
+		deleteMappingKey(node, "publicKey")
+		found = true
+	}
+	if keys := mappingValue(node, "publicKeys"); keys != nil && keys.Kind == yaml.SequenceNode {
+		kept := keys.Content[:0]
+		for _, k := range keys.Content {
+			if matches(k.Value) {
+				found = true
+				continue
+			}
+			kept = append(kept, k)
+		}
+		keys.Content = kept
+	}
+	if !found {
+		return errorf(http.StatusNotFound, "key %s not found", fingerprint)
+	}
+	return nil
+}
+
+// handleAdminAddKey 为用户添加公钥，写入 publicKeys
+func (s *Server) handleAdminAddKey(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	var body AdminKey
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeAdminError(w, errorf(http.StatusBadRequest, "invalid request body: %v", err))
+		return
+	}
+
+	var fingerprint string
+	_, err := s.updateConfig(func(doc *configDocument) error {
+		node, _ := doc.find("users", "username", name)
+		if node == nil {
+			return errorf(http.StatusNotFound, "Not found")
+		}
+		if err := checkVersion(r, node); err != nil {
+			return err
+		}
+		var err error
+		fingerprint, err = addUserKey(node, body.Key)
+		return err
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	log.Printf("[Admin] Added key %s to user %s", fingerprint, name)
+	writeJSON(w, http.StatusCreated, AdminKey{Fingerprint: fingerprint, Key: strings.TrimSpace(body.Key)})
+}
+
+// handleAdminDeleteKey 删除用户中指纹匹配的公钥。指纹可能包含 /，需要 URL 编码
+func (s *Server) handleAdminDeleteKey(w http.ResponseWriter, r *http.Request) {
+	name, fingerprint := r.PathValue("name"), r.PathValue("fingerprint")
+	_, err := s.updateConfig(func(doc *configDocument) error {
+		node, _ := doc.find("users", "username", name)
+		if node == nil {
+			return errorf(http.StatusNotFound, "Not found")
+		}
+		if err := checkVersion(r, node); err != nil {
+			return err
+		}
+		return deleteUserKey(node, fingerprint)
+	})
+	if err != nil {
+		writeAdminError(w, err)
+		return
+	}
+	log.Printf("[Admin] Deleted key %s from user %s", fingerprint, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAdminLock 锁定或解锁用户，锁定后认证失败。
+// 紧急锁定不要求 If-Match，提供时仍然检查
+func (s *Server) handleAdminLock(locked bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		res := adminResources["users"]
+		var node *yaml.Node
+		_, err := s.updateConfig(func(doc *configDocument) error {
+			node, _ = doc.find(res.section, res.key, name)
+			if node == nil {
+				return errorf(http.StatusNotFound, "Not found")
+			}
+			if r.Header.Get("If-Match") != "" {
+				if err := checkVersion(r, node); err != nil {
+					return err
+				}
+			}
+			if locked {
+				setMappingValue(node, "locked", &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "true"})
+			} else {
+				deleteMappingKey(node, "locked")
+			}
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		if locked {
+			log.Printf("[Admin] Locked user %s", name)
+		} else {
+			log.Printf("[Admin] Unlocked user %s", name)
+		}
+		respondItem(w, http.StatusOK, res, node)
+	}
+}
+
+// handleAdminEvents 查询最近的认证事件或授权决定，支持 user 和 limit 参数
+func (s *Server) handleAdminEvents(events *authEventLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultEventLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAdminError(w, errorf(http.StatusBadRequest, "invalid limit: %s", v))
+				return
+			}
+			limit = n
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"items": events.list(r.URL.Query().Get("user"), limit)})
+	}
+}
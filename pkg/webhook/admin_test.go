@@ -0,0 +1,412 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.containerssh.io/containerssh/auth"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// adminTestConfig 管理 API 测试使用的配置文件，包含注释以检查写入后保留注释
+const adminTestConfig = `listen: ":8080"
+admin:
+  listen: "127.0.0.1:8081"
+  tokens:
+    - "admin-token"
+    - name: "alice"
+      token: "alice-token"
+clusters:
+  - name: "c1"
+    host: "https://127.0.0.1:6443"
+users:
+  # 运维账号
+  - username: "alice"
+    password: "alice-pass"
+    metadata:
+      KUBERNETES_POD_NAME: "app"
+`
+
+// newAdminTestServer 从临时配置文件创建服务器，返回管理 API 和配置文件路径
+func newAdminTestServer(t *testing.T) (*Server, http.Handler, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	if err := os.WriteFile(path, []byte(adminTestConfig), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server, server.adminHandler(), path
+}
+
+// adminRequest 用 admin-token 调用管理 API，version 不为空时设置 If-Match
+func adminRequest(t *testing.T, handler http.Handler, method, path string, body interface{}, version string) *httptest.ResponseRecorder {
+	t.Helper()
+	return adminRequestAs(t, handler, "admin-token", method, path, body, version)
+}
+
+// adminRequestAs 用指定的 token 调用管理 API
+func adminRequestAs(t *testing.T, handler http.Handler, token, method, path string, body interface{}, version string) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if version != "" {
+		req.Header.Set("If-Match", `"`+version+`"`)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeItem 解析管理 API 返回的单个资源
+func decodeItem(t *testing.T, rec *httptest.ResponseRecorder) AdminItem {
+	t.Helper()
+	var item AdminItem
+	if err := json.Unmarshal(rec.Body.Bytes(), &item); err != nil {
+		t.Fatalf("Failed to decode response %q: %v", rec.Body.String(), err)
+	}
+	return item
+}
+
+// passwordAuth 用密码认证，返回是否成功
+func passwordAuth(t *testing.T, server *Server, username, password string) bool {
+	t.Helper()
+	var req auth.PasswordAuthRequest
+	req.Username = username
+	req.Password = []byte(base64.StdEncoding.EncodeToString([]byte(password)))
+	rec := postJSON(t, server.handlePasswordAuth, req)
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.Success
+}
+
+// TestAdminAPI_Auth 测试管理 API 需要 Bearer token
+func TestAdminAPI_Auth(t *testing.T) {
+	_, handler, _ := newAdminTestServer(t)
+
+	for _, header := range []string{"", "Bearer wrong", "admin-token"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for %q, got %d", header, rec.Code)
+		}
+		if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Error("Expected WWW-Authenticate header")
+		}
+	}
+
+	if rec := adminRequest(t, handler, http.MethodGet, "/api/v1/users", nil, ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rec.Code)
+	}
+}
+
+// TestAdminConfig_Tokens 测试 token 的两种写法和持有人
+func TestAdminConfig_Tokens(t *testing.T) {
+	var c AdminConfig
+	data := "listen: \":8081\"\ntokens:\n  - \"shared\"\n  - name: bob\n    token: \"bob-token\"\n"
+	if err := yaml.Unmarshal([]byte(data), &c); err != nil {
+		t.Fatalf("Failed to parse admin config: %v", err)
+	}
+	if err := c.validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	tests := []struct {
+		token string
+		name  string
+		ok    bool
+	}{
+		{"shared", "", true},
+		{"bob-token", "bob", true},
+		{"bob", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if name, ok := c.principal(tt.token); name != tt.name || ok != tt.ok {
+			t.Errorf("%q: expected (%q, %v), got (%q, %v)", tt.token, tt.name, tt.ok, name, ok)
+		}
+	}
+
+	c.Tokens = append(c.Tokens, AdminToken{Name: "bob", Token: "other"})
+	if err := c.validate(); err == nil {
+		t.Error("Expected error for duplicate token name")
+	}
+	c.Tokens = []AdminToken{{Name: "bob"}}
+	if err := c.validate(); err == nil {
+		t.Error("Expected error for empty token")
+	}
+}
+
+// TestAdminAPI_Users 测试用户的增删改查和资源版本检查
+func TestAdminAPI_Users(t *testing.T) {
+	server, handler, path := newAdminTestServer(t)
+
+	// 查询时不输出密码
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/users/alice", nil, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	item := decodeItem(t, rec)
+	spec := item.Spec.(map[string]interface{})
+	if spec["password"] != redacted {
+		t.Errorf("Expected redacted password, got %v", spec["password"])
+	}
+	if rec.Header().Get("ETag") != `"`+item.ResourceVersion+`"` {
+		t.Errorf("Expected ETag %q, got %q", item.ResourceVersion, rec.Header().Get("ETag"))
+	}
+
+	// 修改需要 If-Match，版本不一致时返回 409
+	spec["metadata"] = map[string]string{"KUBERNETES_POD_NAME": "web"}
+	if rec := adminRequest(t, handler, http.MethodPut, "/api/v1/users/alice", spec, ""); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("Expected status 428, got %d", rec.Code)
+	}
+	if rec := adminRequest(t, handler, http.MethodPut, "/api/v1/users/alice", spec, "stale"); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", rec.Code)
+	}
+	rec = adminRequest(t, handler, http.MethodPut, "/api/v1/users/alice", spec, item.ResourceVersion)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if decodeItem(t, rec).ResourceVersion == item.ResourceVersion {
+		t.Error("Expected resourceVersion to change after update")
+	}
+
+	// ****** 保持原来的密码，注释保留，新的配置立即生效
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, want := range []string{"alice-pass", "# 运维账号", "KUBERNETES_POD_NAME: web"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected config file to contain %q, got:\n%s", want, data)
+		}
+	}
+	// 查询结果原样写回时保持原来的字段顺序，不增加未配置的字段，其他配置保持原文
+	want := strings.Replace(adminTestConfig, "KUBERNETES_POD_NAME: \"app\"", "KUBERNETES_POD_NAME: web", 1)
+	if string(data) != want {
+		t.Errorf("Expected only the modified line to change, got:\n%s", data)
+	}
+	if got := server.currentConfig().GetUser("alice").Metadata["KUBERNETES_POD_NAME"]; got != "web" {
+		t.Errorf("Expected reloaded metadata web, got %s", got)
+	}
+	if !passwordAuth(t, server, "alice", "alice-pass") {
+		t.Error("Expected password to be kept")
+	}
+
+	// 创建用户，重复创建返回 409，未知字段和无效引用被拒绝
+	bob := map[string]interface{}{"username": "bob", "password": "bob-pass"}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", bob, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", bob, ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", rec.Code)
+	}
+	if !passwordAuth(t, server, "bob", "bob-pass") {
+		t.Error("Expected created user to authenticate")
+	}
+	typo := map[string]interface{}{"username": "carol", "pasword": "x"}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", typo, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for unknown field, got %d", rec.Code)
+	}
+	noTarget := map[string]interface{}{"username": "carol", "target": "missing"}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", noTarget, ""); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for invalid config, got %d", rec.Code)
+	}
+	newUser := map[string]interface{}{"username": "carol", "password": redacted}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", newUser, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for redacted password on create, got %d", rec.Code)
+	}
+
+	// passwordHash 同样不输出，原样写回时保持原来的哈希
+	hash, err := HashPassword("dave-pass")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	dave := map[string]interface{}{"username": "dave", "passwordHash": hash}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users", dave, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = adminRequest(t, handler, http.MethodGet, "/api/v1/users/dave", nil, "")
+	item = decodeItem(t, rec)
+	spec = item.Spec.(map[string]interface{})
+	if spec["passwordHash"] != redacted || strings.Contains(rec.Body.String(), hash) {
+		t.Errorf("Expected redacted passwordHash, got %s", rec.Body.String())
+	}
+	spec["metadata"] = map[string]string{"KUBERNETES_POD_NAME": "dave"}
+	if rec := adminRequest(t, handler, http.MethodPut, "/api/v1/users/dave", spec, item.ResourceVersion); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !passwordAuth(t, server, "dave", "dave-pass") {
+		t.Error("Expected passwordHash to be kept")
+	}
+
+	// 删除用户
+	rec = adminRequest(t, handler, http.MethodGet, "/api/v1/users/bob", nil, "")
+	version := decodeItem(t, rec).ResourceVersion
+	if rec := adminRequest(t, handler, http.MethodDelete, "/api/v1/users/bob", nil, version); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rec.Code)
+	}
+	if server.currentConfig().GetUser("bob") != nil {
+		t.Error("Expected bob to be deleted")
+	}
+	if rec := adminRequest(t, handler, http.MethodGet, "/api/v1/users/bob", nil, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+}
+
+// TestAdminAPI_Targets 测试目标和集群使用相同的接口
+func TestAdminAPI_Targets(t *testing.T) {
+	server, handler, _ := newAdminTestServer(t)
+
+	target := map[string]interface{}{"name": "web", "cluster": "c1", "namespace": "prod", "pod": "web-0"}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/targets", target, ""); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if server.currentConfig().GetTarget("web") == nil {
+		t.Error("Expected target to be created")
+	}
+
+	// 仍被目标引用的集群不能删除
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/clusters/c1", nil, "")
+	version := decodeItem(t, rec).ResourceVersion
+	if rec := adminRequest(t, handler, http.MethodDelete, "/api/v1/clusters/c1", nil, version); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", rec.Code)
+	}
+
+	rec = adminRequest(t, handler, http.MethodGet, "/api/v1/clusters", nil, "")
+	var list struct {
+		Items []AdminItem `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("Expected 1 cluster, got %d", len(list.Items))
+	}
+}
+
+// TestAdminAPI_Keys 测试添加、列出和按指纹删除公钥
+func TestAdminAPI_Keys(t *testing.T) {
+	server, handler, _ := newAdminTestServer(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " alice@laptop"
+	fingerprint := ssh.FingerprintSHA256(sshPub)
+
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/users/alice/keys", nil, "")
+	version := strings.Trim(rec.Header().Get("ETag"), `"`)
+	rec = adminRequest(t, handler, http.MethodPost, "/api/v1/users/alice/keys", AdminKey{Key: line}, version)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := server.currentConfig().GetUser("alice").authorizedKeys(); len(got) != 1 || got[0] != line {
+		t.Errorf("Expected key to be added, got %v", got)
+	}
+
+	rec = adminRequest(t, handler, http.MethodGet, "/api/v1/users/alice/keys", nil, "")
+	var keys struct {
+		Items []AdminKey `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &keys); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(keys.Items) != 1 || keys.Items[0].Fingerprint != fingerprint {
+		t.Errorf("Expected key %s, got %+v", fingerprint, keys.Items)
+	}
+	version = strings.Trim(rec.Header().Get("ETag"), `"`)
+
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users/alice/keys", AdminKey{Key: "not a key"}, version); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid key, got %d", rec.Code)
+	}
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users/alice/keys", AdminKey{Key: line}, version); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for duplicate key, got %d", rec.Code)
+	}
+
+	rec = adminRequest(t, handler, http.MethodDelete, "/api/v1/users/alice/keys/"+url.PathEscape(fingerprint), nil, version)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := server.currentConfig().GetUser("alice").authorizedKeys(); len(got) != 0 {
+		t.Errorf("Expected key to be deleted, got %v", got)
+	}
+}
+
+// TestAdminAPI_LockAndEvents 测试锁定用户后认证失败，并能查询认证事件
+func TestAdminAPI_LockAndEvents(t *testing.T) {
+	server, handler, _ := newAdminTestServer(t)
+
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users/alice/lock", nil, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if passwordAuth(t, server, "alice", "alice-pass") {
+		t.Error("Expected locked user to fail authentication")
+	}
+
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/users/alice/unlock", nil, ""); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if !passwordAuth(t, server, "alice", "alice-pass") {
+		t.Error("Expected unlocked user to authenticate")
+	}
+	passwordAuth(t, server, "mallory", "x")
+
+	rec := adminRequest(t, handler, http.MethodGet, "/api/v1/events?user=alice", nil, "")
+	var events struct {
+		Items []AuthEvent `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &events); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(events.Items) != 2 {
+		t.Fatalf("Expected 2 events, got %+v", events.Items)
+	}
+	if !events.Items[0].Success || events.Items[0].Method != "password" {
+		t.Errorf("Expected latest event to be a successful password auth, got %+v", events.Items[0])
+	}
+	if events.Items[1].Success || events.Items[1].Reason != "user locked" {
+		t.Errorf("Expected failed event with reason 'user locked', got %+v", events.Items[1])
+	}
+
+	if rec := adminRequest(t, handler, http.MethodGet, "/api/v1/events?limit=x", nil, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid limit, got %d", rec.Code)
+	}
+}
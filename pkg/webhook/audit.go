@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// 审计事件的类型
+const (
+	auditAccessRequested = "access.requested"
+	auditAccessApproved  = "access.approved"
+	auditAccessDenied    = "access.denied"
+	auditAccessRevoked   = "access.revoked"
+	auditAccessExpired   = "access.expired"
+
+	auditBreakGlassEnabled  = "breakglass.enabled"
+	auditBreakGlassDisabled = "breakglass.disabled"
+	auditBreakGlassExpired  = "breakglass.expired"
+	auditBreakGlassLogin    = "breakglass.login"
+	auditBreakGlassRejected = "breakglass.rejected"
+)
+
+// AuditEvent 临时访问申请和紧急访问的审计记录
+type AuditEvent struct {
+	Time      time.Time  `json:"time"`
+	Action    string     `json:"action"`          // access.* 或 breakglass.*（enabled、disabled、expired、login、rejected）
+	Actor     string     `json:"actor,omitempty"` // 申请人、批准人、拒绝人或启用紧急访问的人，紧急访问登录时为来源地址，过期时为空
+	Username  string     `json:"username,omitempty"`
+	Target    string     `json:"target,omitempty"`
+	GrantID   string     `json:"grantId,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// auditLog 保存最近的审计事件，配置了审计日志文件时同时追加写入
+type auditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+	size   int
+}
+
+// newAuditLog 创建审计日志，size 不大于 0 时使用默认容量
+func newAuditLog(size int) *auditLog {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+	return &auditLog{size: size}
+}
+
+// add 记录事件，file 不为空时以 JSON Lines 格式追加写入，写入失败只输出日志
+func (l *auditLog) add(ev AuditEvent, file string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	log.Printf("[Audit] %s - username=%s, target=%s, grant=%s, actor=%s", ev.Action, ev.Username, ev.Target, ev.GrantID, ev.Actor)
+	l.events = append(l.events, ev)
+	if len(l.events) > l.size {
+		l.events = l.events[len(l.events)-l.size:]
+	}
+	if file == "" {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("[Audit] Failed to encode event: %v", err)
+		return
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("[Audit] Failed to open %s: %v", file, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("[Audit] Failed to write %s: %v", file, err)
+	}
+}
+
+// list 按时间从新到旧返回事件，username 不为空时只返回该用户的事件，limit 不大于 0 时不限制数量
+func (l *auditLog) list(username string, limit int) []AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := []AuditEvent{}
+	for i := len(l.events) - 1; i >= 0; i-- {
+		ev := l.events[i]
+		if username != "" && ev.Username != username {
+			continue
+		}
+		result = append(result, ev)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestAuditLog 测试按时间从新到旧查询、按用户过滤、限制数量，超过容量时丢弃最早的事件
+func TestAuditLog(t *testing.T) {
+	l := newAuditLog(3)
+	for _, ev := range []AuditEvent{
+		{Action: auditAccessRequested, Username: "alice", GrantID: "1"},
+		{Action: auditAccessRequested, Username: "bob", GrantID: "2"},
+		{Action: auditAccessApproved, Username: "alice", GrantID: "1"},
+		{Action: auditAccessExpired, Username: "alice", GrantID: "1"},
+	} {
+		l.add(ev, "")
+	}
+
+	all := l.list("", 0)
+	if len(all) != 3 || all[0].Action != auditAccessExpired || all[2].Username != "bob" {
+		t.Errorf("Expected the 3 latest events, got %+v", all)
+	}
+	if got := l.list("alice", 1); len(got) != 1 || got[0].Action != auditAccessExpired {
+		t.Errorf("Expected latest alice event, got %+v", got)
+	}
+}
+
+// TestAuditLog_File 测试审计事件以 JSON Lines 格式追加写入文件
+func TestAuditLog_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := newAuditLog(0)
+	l.add(AuditEvent{Action: auditAccessRequested, Username: "alice"}, path)
+	l.add(AuditEvent{Action: auditAccessDenied, Username: "alice", Actor: "bob"}, path)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"action":"access.denied"`) || !strings.Contains(lines[1], `"actor":"bob"`) {
+		t.Errorf("Expected 2 JSON lines, got %q", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
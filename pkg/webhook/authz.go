@@ -0,0 +1,186 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.containerssh.io/containerssh/auth"
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// targetSeparator 登录名中用户名和目标的分隔符，如 alice+web
+const targetSeparator = "+"
+
+// metadataTarget 授权通过的目标，由 /authz 写入 metadata，config 接口据此选择目标
+const metadataTarget = "SSHHOOK_TARGET"
+
+// splitLogin 把登录名 <用户名>+<目标> 拆分为用户名和登录时选择的目标，存在同名用户时按普通登录名处理
+func (c *Config) splitLogin(username string) (string, string) {
+	if c.GetUser(username) != nil {
+		return username, ""
+	}
+	i := strings.LastIndex(username, targetSeparator)
+	if i <= 0 || i == len(username)-len(targetSeparator) {
+		return username, ""
+	}
+	return username[:i], username[i+len(targetSeparator):]
+}
+
+// loginUser 根据登录名查找用户，登录名为 <用户名>+<目标> 时同时返回登录时选择的目标
+func (c *Config) loginUser(username string) (*UserConfig, string) {
+	name, target := c.splitLogin(username)
+	user := c.GetUser(name)
+	if user == nil {
+		return nil, ""
+	}
+	return user, target
+}
+
+// allowedTargets 返回用户在登录时可以选择的目标：用户的默认目标、用户和所属组的 targets
+func (c *Config) allowedTargets(user *UserConfig) []string {
+	var targets []string
+	if user.Target != "" {
+		targets = append(targets, user.Target)
+	}
+	targets = append(targets, user.Targets...)
+	for _, name := range user.Groups {
+		if group := c.GetGroup(name); group != nil {
+			targets = append(targets, group.Targets...)
+		}
+	}
+	return targets
+}
+
+// selectableTargets 是否有用户或组允许在登录时选择目标，接受访问申请时总是允许
+func (c *Config) selectableTargets() bool {
+	if c.Access.enabled() || len(c.AccessGrants) > 0 {
+		return true
+	}
+	for i := range c.Users {
+		if len(c.Users[i].Targets) > 0 {
+			return true
+		}
+	}
+	for i := range c.Groups {
+		if len(c.Groups[i].Targets) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeTarget 检查用户能否在登录时选择目标，批准的访问申请在有效期内允许对应的目标
+func (c *Config) authorizeTarget(user *UserConfig, name string, now time.Time) error {
+	if c.GetTarget(name) == nil {
+		return fmt.Errorf("target not found: %s", name)
+	}
+	if !contains(c.allowedTargets(user), name) && c.activeGrant(user.Username, name, now) == nil {
+		return fmt.Errorf("target not allowed: %s", name)
+	}
+	return nil
+}
+
+// handleAuthz 处理授权请求。ContainerSSH 在认证之后、获取配置之前调用，
+// 登录名为 <用户名>+<目标> 时在这里检查一次用户能否访问该目标，结果写入 metadata 供 config 接口使用
+func (s *Server) handleAuthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Printf("[Authz] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req auth.AuthorizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[Authz] Failed to decode request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[Authz] Request received - username=%s, authenticatedUsername=%s, connectionId=%s",
+		req.Username, req.AuthenticatedUsername, req.ConnectionID)
+	// 授权决定按认证通过的用户记录，登录名中的目标记录在 Target 中
+	cfg := s.currentConfig()
+	ev := newAuthEvent("authz", req.ConnectionAuthPendingMetadata)
+	ev.Username = req.AuthenticatedUsername
+	_, ev.Target = cfg.loginUser(req.Username)
+
+	user := cfg.GetUser(req.AuthenticatedUsername)
+	if user == nil {
+		user = cfg.oidcUser(req.AuthenticatedUsername, req.Metadata)
+	}
+	if user == nil {
+		s.denyAuthz(w, ev, "user not found")
+		return
+	}
+	if user.expired(time.Now()) {
+		s.denyAuthz(w, ev, "access expired")
+		return
+	}
+	if user.Locked {
+		s.denyAuthz(w, ev, "user locked")
+		return
+	}
+
+	// 登录名中的用户必须是认证通过的用户
+	name, target := cfg.splitLogin(req.Username)
+	if name != user.Username {
+		s.denyAuthz(w, ev, "login name does not match authenticated user")
+		return
+	}
+	if target != "" && !targetAuthorizedAtLogin(req.Metadata, target) {
+		if err := cfg.authorizeTarget(user, target, time.Now()); err != nil {
+			s.denyAuthz(w, ev, err.Error())
+			return
+		}
+	}
+
+	// 访问策略：按授权的目标检查一次 auth 阶段的策略，公钥类型来自认证时写入的 metadata；
+	// 选择了目标时使用目标的集群，而不是用户 metadata 中的集群
+	policyMD := map[string]string{metadataKeyType: req.Metadata[metadataKeyType].Value}
+	if target == "" {
+		policyMD["KUBERNETES_CLUSTER"] = req.Metadata["KUBERNETES_CLUSTER"].Value
+	}
+	data := newTemplateData(user, req.ConnectionAuthPendingMetadata, user.Username)
+	if reason := cfg.checkPolicies(cfg.newPolicyInput(policyStageAuth, user, data, target, policyMD)); reason != "" {
+		s.denyAuthz(w, ev, reason)
+		return
+	}
+
+	ev.Success = true
+	s.decisions.add(ev)
+	log.Printf("[Authz] ✓ Authorized - username=%s, authenticatedUsername=%s, target=%s",
+		req.Username, user.Username, target)
+
+	// 保留认证时返回的 metadata，加上授权通过的目标
+	resp := auth.ResponseBody{Success: true}
+	resp.AuthenticatedUsername = user.Username
+	md := make(map[string]metadata.Value, len(req.Metadata)+1)
+	for k, v := range req.Metadata {
+		md[k] = v
+	}
+	if target != "" {
+		md[metadataTarget] = metadata.Value{Value: target}
+	}
+	resp.ConnectionAuthPendingMetadata.ConnectionMetadata.Metadata = md
+	s.sendAuthzResponse(w, resp)
+}
+
+// denyAuthz 记录授权失败的原因并拒绝登录
+func (s *Server) denyAuthz(w http.ResponseWriter, ev AuthEvent, reason string) {
+	ev.Reason = reason
+	s.decisions.add(ev)
+	log.Printf("[Authz] ✗ Denied - username=%s, target=%s, connectionId=%s: %s", ev.Username, ev.Target, ev.ConnectionID, reason)
+	s.sendAuthzResponse(w, auth.ResponseBody{Success: false})
+}
+
+// sendAuthzResponse 发送授权响应
+func (s *Server) sendAuthzResponse(w http.ResponseWriter, resp auth.ResponseBody) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("[Authz] Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"go.containerssh.io/containerssh/auth"
+	"go.containerssh.io/containerssh/config"
+	"go.containerssh.io/containerssh/metadata"
+	"gopkg.in/yaml.v3"
+)
+
+// authzTestConfig 用户默认登录 t1，可以选择 t2，所在组可以选择 t3，t4 不允许
+func authzTestConfig() *Config {
+	cfg := simulateTestConfig()
+	cfg.Users[0].Recording = nil
+	for _, name := range []string{"t2", "t3", "t4"} {
+		cfg.Targets = append(cfg.Targets, TargetConfig{
+			Name:      name,
+			Cluster:   "c1",
+			Namespace: "default",
+			Pod:       "pod-" + name,
+		})
+	}
+	cfg.Groups = []GroupConfig{{Name: "ops", Targets: []string{"t3"}}}
+	cfg.Users[0].Groups = []string{"ops"}
+	cfg.Users[0].Targets = []string{"t2"}
+	return cfg
+}
+
+// authzRequest 调用授权接口
+func authzRequest(t *testing.T, server *Server, username, authenticated string) auth.ResponseBody {
+	t.Helper()
+	var req auth.AuthorizationRequest
+	req.Username = username
+	req.AuthenticatedUsername = authenticated
+	req.ConnectionID = "c1"
+	rec := postJSON(t, server.handleAuthz, req)
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// TestConfig_LoginUser 测试从登录名中拆分用户名和目标
+func TestConfig_LoginUser(t *testing.T) {
+	cfg := authzTestConfig()
+	cfg.Users = append(cfg.Users, UserConfig{Username: "a+b", Password: "x"})
+
+	tests := []struct {
+		login  string
+		user   string
+		target string
+	}{
+		{"testuser", "testuser", ""},
+		{"testuser+t2", "testuser", "t2"},
+		{"a+b", "a+b", ""},
+		{"a+b+t2", "a+b", "t2"},
+		{"testuser+", "", ""},
+		{"+t2", "", ""},
+		{"nobody+t2", "", ""},
+	}
+	for _, tt := range tests {
+		user, target := cfg.loginUser(tt.login)
+		name := ""
+		if user != nil {
+			name = user.Username
+		}
+		if name != tt.user || target != tt.target {
+			t.Errorf("%s: expected (%q, %q), got (%q, %q)", tt.login, tt.user, tt.target, name, target)
+		}
+	}
+}
+
+// TestAuthz_Targets 测试登录时选择目标：允许的目标连接对应的 pod，其他目标被拒绝并单独记录
+func TestAuthz_Targets(t *testing.T) {
+	cfg := authzTestConfig()
+	for target, pod := range map[string]string{"t2": "pod-t2", "t3": "pod-t3", "t1": "test-pod"} {
+		result, err := Simulate(cfg, SimulateOptions{Username: "testuser+" + target, Password: "testpass"})
+		if err != nil {
+			t.Fatalf("Failed to simulate: %v", err)
+		}
+		if result.Authorized == nil || !*result.Authorized || result.Config == nil {
+			t.Fatalf("Expected %s to be authorized, got %+v", target, result)
+		}
+		if result.Target != target {
+			t.Errorf("Expected target %s, got %s", target, result.Target)
+		}
+		if result.Config.Kubernetes.Pod.Metadata.Name != pod {
+			t.Errorf("Expected pod %s, got %s", pod, result.Config.Kubernetes.Pod.Metadata.Name)
+		}
+	}
+
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser+t4", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if !result.Authenticated || result.Authorized == nil || *result.Authorized || result.Config != nil {
+		t.Fatalf("Expected t4 to be denied after authentication, got %+v", result)
+	}
+	if result.Reason != "target not allowed: t4" {
+		t.Errorf("Expected reason 'target not allowed: t4', got %q", result.Reason)
+	}
+
+	// 不选择目标时授权步骤直接通过
+	result, err = Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Config == nil || result.Config.Kubernetes.Pod.Metadata.Name != "test-pod" {
+		t.Errorf("Expected default target, got %+v", result)
+	}
+}
+
+// TestAuthz_Decisions 测试授权决定与认证事件分开记录，按认证通过的用户查询
+func TestAuthz_Decisions(t *testing.T) {
+	server, err := NewServer(authzTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if resp := authzRequest(t, server, "testuser+t2", "testuser"); !resp.Success {
+		t.Fatal("Expected t2 to be authorized")
+	}
+	if resp := authzRequest(t, server, "testuser+t2", "testuser"); resp.Metadata[metadataTarget].Value != "t2" {
+		t.Errorf("Expected %s=t2 in metadata, got %v", metadataTarget, resp.Metadata)
+	}
+	if resp := authzRequest(t, server, "testuser+t4", "testuser"); resp.Success {
+		t.Error("Expected t4 to be denied")
+	}
+	// 登录名中的用户与认证通过的用户不一致
+	if resp := authzRequest(t, server, "other+t2", "testuser"); resp.Success {
+		t.Error("Expected mismatched login name to be denied")
+	}
+	if resp := authzRequest(t, server, "testuser+t2", "nobody"); resp.Success {
+		t.Error("Expected unknown user to be denied")
+	}
+
+	decisions := server.decisions.list("testuser", 0)
+	if len(decisions) != 4 {
+		t.Fatalf("Expected 4 decisions for testuser, got %d", len(decisions))
+	}
+	if decisions[0].Reason != "login name does not match authenticated user" {
+		t.Errorf("Expected mismatch reason, got %q", decisions[0].Reason)
+	}
+	if decisions[1].Target != "t4" || decisions[1].Success || decisions[1].Method != "authz" {
+		t.Errorf("Expected denied decision for t4, got %+v", decisions[1])
+	}
+	if events := server.events.list("", 0); len(events) != 0 {
+		t.Errorf("Expected no authentication events, got %d", len(events))
+	}
+}
+
+// TestAuthz_Policy 测试授权步骤按选择的目标检查访问策略
+func TestAuthz_Policy(t *testing.T) {
+	cfg := authzTestConfig()
+	cfg.Clusters = append(cfg.Clusters, ClusterConfig{Name: "c2", Host: "https://c2.invalid:6443", Labels: map[string]string{"env": "prod"}})
+	cfg.Targets[len(cfg.Targets)-2].Cluster = "c2"
+	cfg.Policies = []PolicyConfig{{
+		Name:   "no-prod",
+		Stages: []string{policyStageAuth},
+		Deny:   `"env" in clusterLabels && clusterLabels["env"] == "prod"`,
+	}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	if resp := authzRequest(t, server, "testuser+t2", "testuser"); !resp.Success {
+		t.Error("Expected t2 to be authorized")
+	}
+	if resp := authzRequest(t, server, "testuser+t3", "testuser"); resp.Success {
+		t.Error("Expected t3 on the prod cluster to be denied by policy")
+	}
+	if decision := server.decisions.list("testuser", 1)[0]; decision.Reason != "denied by policy no-prod" {
+		t.Errorf("Expected policy reason, got %q", decision.Reason)
+	}
+}
+
+// TestHandleConfig_TargetRequiresAuthz 测试没有经过授权的目标选择被 config 接口拒绝
+func TestHandleConfig_TargetRequiresAuthz(t *testing.T) {
+	server, err := NewServer(authzTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req config.Request
+	req.Username = "testuser+t2"
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rec.Code)
+	}
+
+	// 授权的目标与登录名不一致
+	req.Metadata = map[string]metadata.Value{metadataTarget: {Value: "t3"}}
+	rec = postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rec.Code)
+	}
+}
+
+// TestValidate_SelectableTargets 测试用户和组的 targets 必须引用已定义的目标
+func TestValidate_SelectableTargets(t *testing.T) {
+	cfg := authzTestConfig()
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	cfg.Users[0].Targets = []string{"missing"}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "target not found: missing") {
+		t.Errorf("Expected missing user target error, got %v", err)
+	}
+
+	cfg = authzTestConfig()
+	cfg.Groups[0].Targets = []string{"missing"}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "target not found: missing") {
+		t.Errorf("Expected missing group target error, got %v", err)
+	}
+}
+
+// TestRenderContainerSSHConfig_Authz 测试允许选择目标时生成授权配置
+func TestRenderContainerSSHConfig_Authz(t *testing.T) {
+	opts := ContainerSSHOptions{HostKeys: []string{"ssh_host_ed25519_key"}, WebhookURL: "http://sshhook:8080"}
+	render := func(cfg *Config) containerSSHConfig {
+		data, err := RenderContainerSSHConfig(cfg, opts)
+		if err != nil {
+			t.Fatalf("Failed to render config: %v", err)
+		}
+		var out containerSSHConfig
+		if err := yaml.Unmarshal(data, &out); err != nil {
+			t.Fatalf("Failed to parse rendered config: %v", err)
+		}
+		return out
+	}
+
+	if out := render(createTestConfig()); out.Auth.Authz != nil {
+		t.Errorf("Expected no authz without selectable targets, got %+v", out.Auth.Authz)
+	}
+	out := render(authzTestConfig())
+	if out.Auth.Authz == nil || out.Auth.Authz.Method != "webhook" || out.Auth.Authz.Webhook.URL != "http://sshhook:8080" {
+		t.Errorf("Expected authz webhook, got %+v", out.Auth.Authz)
+	}
+}
@@ -0,0 +1,347 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultBreakGlassWindow 紧急访问启用后的默认有效时间
+	defaultBreakGlassWindow = time.Hour
+	// breakGlassNotifyTimeout 发送一条通知的超时时间
+	breakGlassNotifyTimeout = 10 * time.Second
+)
+
+// BreakGlassConfig 紧急访问：身份提供方不可用时，breakGlass: true 的用户使用本地的 passwordHash 登录。
+// 这些用户只在 sshhook breakglass enable 之后的 window 内有效，启用、登录、关闭和过期都写入审计日志并发送通知
+type BreakGlassConfig struct {
+	Window   time.Duration `yaml:"window,omitempty"`   // 启用后的有效时间，默认 1h，到期后自动关闭
+	Notify   []Secret      `yaml:"notify,omitempty"`   // 接收通知的 URL，POST JSON，支持 file:、env: 引用
+	AuditLog string        `yaml:"auditLog,omitempty"` // 审计日志文件，默认使用 access.auditLog
+}
+
+// window 返回启用后的有效时间
+func (b *BreakGlassConfig) window() time.Duration {
+	if b.Window == 0 {
+		return defaultBreakGlassWindow
+	}
+	return b.Window
+}
+
+// BreakGlassActivation 紧急访问的启用记录，由管理 API 写入配置的 breakGlassActivation，关闭或过期后被删除
+type BreakGlassActivation struct {
+	EnabledBy string    `yaml:"enabledBy"`
+	EnabledAt time.Time `yaml:"enabledAt"`
+	ExpiresAt time.Time `yaml:"expiresAt"`
+	Reason    string    `yaml:"reason"`
+}
+
+// validateBreakGlass 检查紧急访问的配置和紧急访问用户
+func (c *Config) validateBreakGlass() error {
+	if c.BreakGlass.Window < 0 {
+		return errors.New("breakGlass: window must not be negative")
+	}
+	for _, u := range c.Users {
+		if !u.BreakGlass {
+			continue
+		}
+		// 只接受本地保存的哈希，不依赖 Secret 引用、公钥和第二因素
+		switch {
+		case u.PasswordHash == "":
+			return fmt.Errorf("user %s: break-glass users require passwordHash", u.Username)
+		case u.Password != "":
+			return fmt.Errorf("user %s: break-glass users cannot use password", u.Username)
+		case u.PublicKey != "" || len(u.PublicKeys) > 0:
+			return fmt.Errorf("user %s: break-glass users cannot use public keys", u.Username)
+		case u.TOTPSecret != "":
+			return fmt.Errorf("user %s: break-glass users cannot use totpSecret", u.Username)
+		}
+	}
+	if a := c.BreakGlassActivation; a != nil && !a.ExpiresAt.After(a.EnabledAt) {
+		return errors.New("breakGlassActivation: expiresAt must be after enabledAt")
+	}
+	return nil
+}
+
+// breakGlassActive 紧急访问是否已经启用并且没有过期
+func (c *Config) breakGlassActive(now time.Time) bool {
+	return c.BreakGlassActivation != nil && now.Before(c.BreakGlassActivation.ExpiresAt)
+}
+
+// limitBreakGlassSession 紧急访问用户的 shell 最长运行到紧急访问到期，到期后由监视进程结束
+func (c *Config) limitBreakGlassSession(t *TimeoutsConfig, user *UserConfig, now time.Time) {
+	if !user.BreakGlass || c.BreakGlassActivation == nil {
+		return
+	}
+	remaining := max(c.BreakGlassActivation.ExpiresAt.Sub(now), time.Second)
+	if t.MaxSession == 0 || remaining < t.MaxSession {
+		t.MaxSession = remaining
+	}
+}
+
+// breakGlassUsers 返回紧急访问用户的用户名
+func (c *Config) breakGlassUsers() []string {
+	users := []string{}
+	for _, u := range c.Users {
+		if u.BreakGlass {
+			users = append(users, u.Username)
+		}
+	}
+	return users
+}
+
+// BreakGlassChange 启用或关闭紧急访问的请求，操作人是管理 API token 的持有人
+type BreakGlassChange struct {
+	Reason string `json:"reason"`
+}
+
+// BreakGlassStatus 管理 API 返回的紧急访问状态
+type BreakGlassStatus struct {
+	Enabled   bool       `json:"enabled"`
+	Window    string     `json:"window"`
+	Users     []string   `json:"users"`
+	EnabledBy string     `json:"enabledBy,omitempty"`
+	EnabledAt *time.Time `json:"enabledAt,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// newBreakGlassStatus 生成紧急访问状态的输出
+func newBreakGlassStatus(cfg *Config, now time.Time) BreakGlassStatus {
+	status := BreakGlassStatus{
+		Enabled: cfg.breakGlassActive(now),
+		Window:  cfg.BreakGlass.window().String(),
+		Users:   cfg.breakGlassUsers(),
+	}
+	if a := cfg.BreakGlassActivation; status.Enabled {
+		enabledAt, expiresAt := a.EnabledAt, a.ExpiresAt
+		status.EnabledBy, status.EnabledAt, status.ExpiresAt, status.Reason = a.EnabledBy, &enabledAt, &expiresAt, a.Reason
+	}
+	return status
+}
+
+// breakGlassNotification 发送到 breakGlass.notify 的内容，text 可以直接用于 Slack 等聊天工具的 incoming webhook
+type breakGlassNotification struct {
+	Text  string     `json:"text"`
+	Event AuditEvent `json:"event"`
+}
+
+// auditBreakGlass 记录紧急访问的审计事件并发送通知。simulate 不记录也不发送
+func (s *Server) auditBreakGlass(cfg *Config, ev AuditEvent) {
+	if s.dryRun {
+		return
+	}
+	ev.Time = time.Now()
+	log.Printf("[BreakGlass] ⚠ %s - username=%s, target=%s, actor=%s, reason=%s", ev.Action, ev.Username, ev.Target, ev.Actor, ev.Reason)
+	file := cfg.BreakGlass.AuditLog
+	if file == "" {
+		file = cfg.Access.AuditLog
+	}
+	s.audit.add(ev, file)
+
+	if len(cfg.BreakGlass.Notify) == 0 {
+		return
+	}
+	text := fmt.Sprintf("⚠ sshhook break-glass: %s", ev.Action)
+	for _, field := range []struct{ name, value string }{
+		{"user", ev.Username}, {"target", ev.Target}, {"by", ev.Actor}, {"reason", ev.Reason},
+	} {
+		if field.value != "" {
+			text += fmt.Sprintf(", %s=%s", field.name, field.value)
+		}
+	}
+	if ev.ExpiresAt != nil {
+		text += ", expires=" + ev.ExpiresAt.Format(time.RFC3339)
+	}
+	data, err := json.Marshal(breakGlassNotification{Text: text, Event: ev})
+	if err != nil {
+		log.Printf("[BreakGlass] Failed to encode notification: %v", err)
+		return
+	}
+	for _, u := range cfg.BreakGlass.Notify {
+		go sendBreakGlassNotification(u.Value(), data)
+	}
+}
+
+// sendBreakGlassNotification 发送一条通知，失败只输出日志，日志中不包含 URL
+func sendBreakGlassNotification(url string, data []byte) {
+	client := &http.Client{Timeout: breakGlassNotifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Printf("[BreakGlass] Failed to send notification: %v", errors.Unwrap(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("[BreakGlass] Notification rejected: %s", resp.Status)
+	}
+}
+
+// checkBreakGlass 检查紧急访问用户能否登录，未启用时返回拒绝原因。
+// 密码验证通过之后调用，登录和被拒绝的登录都会记录并通知；target 为登录时选择的目标，为空时使用用户的默认目标
+func (s *Server) checkBreakGlass(cfg *Config, user *UserConfig, target, remoteAddress string) string {
+	if target == "" {
+		target = user.Target
+	}
+	audit := AuditEvent{Actor: remoteAddress, Username: user.Username, Target: target}
+	if !cfg.breakGlassActive(time.Now()) {
+		audit.Action = auditBreakGlassRejected
+		audit.Reason = "break-glass access not enabled"
+		s.auditBreakGlass(cfg, audit)
+		return audit.Reason
+	}
+	audit.Action = auditBreakGlassLogin
+	expires := cfg.BreakGlassActivation.ExpiresAt
+	audit.ExpiresAt = &expires
+	s.auditBreakGlass(cfg, audit)
+	return ""
+}
+
+// handleAdminBreakGlass 查询紧急访问状态
+func (s *Server) handleAdminBreakGlass(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, newBreakGlassStatus(s.currentConfig(), time.Now()))
+}
+
+// handleAdminChangeBreakGlass 启用或关闭紧急访问，操作人是管理 API token 的持有人。
+// 启用需要 reason，已经启用时返回 409，不会延长有效期
+func (s *Server) handleAdminChangeBreakGlass(enable bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body BreakGlassChange
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeAdminError(w, errorf(http.StatusBadRequest, "invalid request body: %v", err))
+			return
+		}
+		actor := adminPrincipal(r)
+		if actor == "" {
+			writeAdminError(w, errorf(http.StatusForbidden, "changing break-glass access requires a named admin token"))
+			return
+		}
+
+		now := time.Now().Truncate(time.Second)
+		var activation BreakGlassActivation
+		_, err := s.updateConfig(func(doc *configDocument) error {
+			cfg := s.currentConfig()
+			root := doc.doc.Content[0]
+			if !enable {
+				if !cfg.breakGlassActive(now) {
+					return errorf(http.StatusConflict, "break-glass access is not enabled")
+				}
+				deleteMappingKey(root, "breakGlassActivation")
+				return nil
+			}
+			if strings.TrimSpace(body.Reason) == "" {
+				return errorf(http.StatusBadRequest, "reason is required")
+			}
+			if len(cfg.breakGlassUsers()) == 0 {
+				return errorf(http.StatusConflict, "no break-glass users configured")
+			}
+			if cfg.breakGlassActive(now) {
+				return errorf(http.StatusConflict, "break-glass access is already enabled until %s",
+					cfg.BreakGlassActivation.ExpiresAt.Format(time.RFC3339))
+			}
+			activation = BreakGlassActivation{
+				EnabledBy: actor,
+				EnabledAt: now,
+				ExpiresAt: now.Add(cfg.BreakGlass.window()),
+				Reason:    body.Reason,
+			}
+			node := &yaml.Node{}
+			if err := node.Encode(&activation); err != nil {
+				return err
+			}
+			setMappingValue(root, "breakGlassActivation", node)
+			return nil
+		})
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+
+		cfg := s.currentConfig()
+		ev := AuditEvent{Action: auditBreakGlassDisabled, Actor: actor, Reason: body.Reason}
+		if enable {
+			ev.Action = auditBreakGlassEnabled
+			ev.ExpiresAt = &activation.ExpiresAt
+			ev.Duration = cfg.BreakGlass.window().String()
+		}
+		s.auditBreakGlass(cfg, ev)
+		writeJSON(w, http.StatusOK, newBreakGlassStatus(cfg, now))
+	}
+}
+
+// expireBreakGlass 紧急访问到期后记录并从配置中删除启用记录。每次启用只记录一次，配置不能写入时保留在配置中
+func (s *Server) expireBreakGlass(now time.Time) {
+	cfg := s.currentConfig()
+	a := cfg.BreakGlassActivation
+	if a == nil || now.Before(a.ExpiresAt) {
+		return
+	}
+
+	s.grantsMu.Lock()
+	if !s.breakGlassExpired.Equal(a.ExpiresAt) {
+		s.breakGlassExpired = a.ExpiresAt
+		expires := a.ExpiresAt
+		s.auditBreakGlass(cfg, AuditEvent{Action: auditBreakGlassExpired, ExpiresAt: &expires, Reason: a.Reason})
+	}
+	s.grantsMu.Unlock()
+
+	if s.store == nil {
+		return
+	}
+	_, err := s.updateConfig(func(doc *configDocument) error {
+		root := doc.doc.Content[0]
+		var current BreakGlassActivation
+		node := mappingValue(root, "breakGlassActivation")
+		// 期间重新启用时保留新的记录
+		if node == nil || node.Decode(&current) != nil || now.Before(current.ExpiresAt) {
+			return nil
+		}
+		deleteMappingKey(root, "breakGlassActivation")
+		return nil
+	})
+	if err != nil {
+		log.Printf("[BreakGlass] Failed to remove expired activation: %v", err)
+	}
+}
+
+// BreakGlassClient 通过管理 API 启用和关闭紧急访问，供 sshhook breakglass 使用
+type BreakGlassClient struct {
+	api *adminUserStore
+}
+
+// NewBreakGlassClient 创建 BreakGlassClient，baseURL 如 http://127.0.0.1:8081
+func NewBreakGlassClient(baseURL, token string) *BreakGlassClient {
+	return &BreakGlassClient{api: NewAdminUserStore(baseURL, token).(*adminUserStore)}
+}
+
+// Status 查询紧急访问状态
+func (c *BreakGlassClient) Status() (*BreakGlassStatus, error) {
+	var status BreakGlassStatus
+	if _, err := c.api.do(http.MethodGet, "/breakglass", "", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Enable 启用紧急访问
+func (c *BreakGlassClient) Enable(change BreakGlassChange) (*BreakGlassStatus, error) {
+	var status BreakGlassStatus
+	if _, err := c.api.do(http.MethodPost, "/breakglass/enable", "", change, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Disable 提前关闭紧急访问
+func (c *BreakGlassClient) Disable(change BreakGlassChange) error {
+	_, err := c.api.do(http.MethodPost, "/breakglass/disable", "", change, nil)
+	return err
+}
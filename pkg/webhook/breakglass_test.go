@@ -0,0 +1,313 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// breakGlassTestConfig 紧急访问测试使用的配置文件，%s 依次为通知 URL、审计日志和 passwordHash
+const breakGlassTestConfig = `listen: ":8080"
+admin:
+  listen: "127.0.0.1:8081"
+  tokens:
+    - "admin-token"
+    - name: "oncall"
+      token: "oncall-token"
+    - name: "lead"
+      token: "lead-token"
+breakGlass:
+  window: 30m
+  notify:
+    - "%s"
+  auditLog: "%s"
+clusters:
+  - name: "c1"
+    host: "https://127.0.0.1:6443"
+targets:
+  - name: "prod"
+    cluster: "c1"
+    namespace: "default"
+    pod: "prod-pod"
+    container: "app"
+users:
+  - username: "alice"
+    password: "alice-pass"
+    target: "prod"
+  - username: "emergency"
+    passwordHash: "%s"
+    target: "prod"
+    breakGlass: true
+`
+
+// newBreakGlassTestServer 创建带紧急访问用户 emergency 的服务器，返回服务器、管理 API、审计日志路径和收到的通知
+func newBreakGlassTestServer(t *testing.T) (*Server, http.Handler, string, <-chan breakGlassNotification) {
+	t.Helper()
+	notifications := make(chan breakGlassNotification, 10)
+	notify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var n breakGlassNotification
+		if err := json.Unmarshal(data, &n); err != nil {
+			t.Errorf("Failed to decode notification %q: %v", data, err)
+		}
+		notifications <- n
+	}))
+	t.Cleanup(notify.Close)
+
+	hash, err := HashPassword("glass-pass")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	dir := t.TempDir()
+	auditPath := filepath.Join(dir, "audit.log")
+	path := filepath.Join(dir, "webhook.yaml")
+	content := breakGlassTestConfig
+	for _, v := range []string{notify.URL, auditPath, hash} {
+		content = strings.Replace(content, "%s", v, 1)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.offline = true
+	return server, server.adminHandler(), auditPath, notifications
+}
+
+// waitNotification 等待下一条通知
+func waitNotification(t *testing.T, notifications <-chan breakGlassNotification) breakGlassNotification {
+	t.Helper()
+	select {
+	case n := <-notifications:
+		return n
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a notification")
+		return breakGlassNotification{}
+	}
+}
+
+// breakGlassConfigRequest 以 emergency 调用 config 接口
+func breakGlassConfigRequest(t *testing.T, server *Server) int {
+	t.Helper()
+	var req config.Request
+	req.Username = "emergency"
+	req.AuthenticatedUsername = "emergency"
+	return postJSON(t, server.handleConfig, req).Code
+}
+
+// TestValidateBreakGlass 测试紧急访问用户只能使用 passwordHash
+func TestValidateBreakGlass(t *testing.T) {
+	tests := []struct {
+		user UserConfig
+		err  string
+	}{
+		{UserConfig{Username: "u", BreakGlass: true}, "break-glass users require passwordHash"},
+		{UserConfig{Username: "u", BreakGlass: true, PasswordHash: "h", Password: "p"}, "break-glass users cannot use password"},
+		{UserConfig{Username: "u", BreakGlass: true, PasswordHash: "h", PublicKeys: []string{"k"}}, "break-glass users cannot use public keys"},
+		{UserConfig{Username: "u", BreakGlass: true, PasswordHash: "h", TOTPSecret: "s"}, "break-glass users cannot use totpSecret"},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Users: []UserConfig{tt.user}}
+		if err := cfg.validateBreakGlass(); err == nil || err.Error() != "user u: "+tt.err {
+			t.Errorf("Expected error %q, got %v", tt.err, err)
+		}
+	}
+
+	cfg := &Config{BreakGlass: BreakGlassConfig{Window: -time.Minute}}
+	if err := cfg.validateBreakGlass(); err == nil {
+		t.Error("Expected error for negative window")
+	}
+}
+
+// TestBreakGlass_EnableAndLogin 测试启用前拒绝登录，启用后在 window 内可以登录，每一步都写入审计日志并发送通知
+func TestBreakGlass_EnableAndLogin(t *testing.T) {
+	server, handler, auditPath, notifications := newBreakGlassTestServer(t)
+
+	if passwordAuth(t, server, "emergency", "glass-pass") {
+		t.Fatal("Expected break-glass login to be rejected before enable")
+	}
+	if n := waitNotification(t, notifications); n.Event.Action != auditBreakGlassRejected || !strings.Contains(n.Text, "user=emergency") {
+		t.Errorf("Expected rejected notification, got %+v", n)
+	}
+	if code := breakGlassConfigRequest(t, server); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 from config before enable, got %d", code)
+	}
+
+	// 操作人是 token 的持有人，没有 name 的 token 不能启用
+	if rec := adminRequest(t, handler, http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{Reason: "IdP outage"}, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for unnamed token, got %d", rec.Code)
+	}
+	if rec := adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{}, ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 without reason, got %d", rec.Code)
+	}
+	rec := adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{Reason: "IdP outage"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var status BreakGlassStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if !status.Enabled || status.EnabledBy != "oncall" || len(status.Users) != 1 || status.Users[0] != "emergency" {
+		t.Fatalf("Expected enabled status, got %+v", status)
+	}
+	if d := time.Until(*status.ExpiresAt); d < 29*time.Minute || d > 30*time.Minute {
+		t.Errorf("Expected break-glass access to expire in 30m, got %s", d)
+	}
+	if n := waitNotification(t, notifications); n.Event.Action != auditBreakGlassEnabled || n.Event.Reason != "IdP outage" {
+		t.Errorf("Expected enabled notification, got %+v", n)
+	}
+	if rec := adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{Reason: "again"}, ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when already enabled, got %d", rec.Code)
+	}
+
+	if passwordAuth(t, server, "emergency", "wrong") {
+		t.Error("Expected wrong password to be rejected")
+	}
+	if !passwordAuth(t, server, "emergency", "glass-pass") {
+		t.Fatal("Expected break-glass login after enable")
+	}
+	if n := waitNotification(t, notifications); n.Event.Action != auditBreakGlassLogin || n.Event.Target != "prod" {
+		t.Errorf("Expected login notification, got %+v", n)
+	}
+	if code := breakGlassConfigRequest(t, server); code != http.StatusOK {
+		t.Errorf("Expected status 200 from config, got %d", code)
+	}
+	// 普通用户不受影响，也不发送通知
+	if !passwordAuth(t, server, "alice", "alice-pass") {
+		t.Error("Expected normal login to be allowed")
+	}
+
+	// 启用记录保存在配置文件中，重启后仍然有效
+	cfg, err := LoadConfig(server.store.Path())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.breakGlassActive(time.Now()) {
+		t.Error("Expected activation in config file")
+	}
+
+	data, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+	want := []string{auditBreakGlassRejected, auditBreakGlassEnabled, auditBreakGlassLogin}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("Expected %d audit log lines, got %q", len(want), data)
+	}
+	for i, line := range lines {
+		var ev AuditEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil || ev.Action != want[i] {
+			t.Errorf("Expected audit event %s, got %q", want[i], line)
+		}
+	}
+	select {
+	case n := <-notifications:
+		t.Errorf("Expected no more notifications, got %+v", n)
+	default:
+	}
+}
+
+// TestBreakGlass_Expire 测试到期后自动关闭：只记录一次，从配置中删除，之后的登录和 config 请求都被拒绝
+func TestBreakGlass_Expire(t *testing.T) {
+	server, handler, _, notifications := newBreakGlassTestServer(t)
+	adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{Reason: "IdP outage"}, "")
+	waitNotification(t, notifications)
+
+	server.expireBreakGlass(time.Now())
+	if !server.currentConfig().breakGlassActive(time.Now()) {
+		t.Fatal("Expected break-glass access to stay enabled before expiry")
+	}
+
+	later := time.Now().Add(31 * time.Minute)
+	server.expireBreakGlass(later)
+	server.expireBreakGlass(later)
+	if n := waitNotification(t, notifications); n.Event.Action != auditBreakGlassExpired {
+		t.Errorf("Expected expired notification, got %+v", n)
+	}
+	var expired int
+	for _, ev := range server.audit.list("", 0) {
+		if ev.Action == auditBreakGlassExpired {
+			expired++
+		}
+	}
+	if expired != 1 {
+		t.Errorf("Expected one expiry audit event, got %d", expired)
+	}
+	if server.currentConfig().BreakGlassActivation != nil {
+		t.Error("Expected activation to be removed from config")
+	}
+	if code := breakGlassConfigRequest(t, server); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 from config after expiry, got %d", code)
+	}
+	if passwordAuth(t, server, "emergency", "glass-pass") {
+		t.Error("Expected break-glass login to be rejected after expiry")
+	}
+}
+
+// TestBreakGlass_Disable 测试提前关闭紧急访问
+func TestBreakGlass_Disable(t *testing.T) {
+	server, handler, _, notifications := newBreakGlassTestServer(t)
+
+	if rec := adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/disable", BreakGlassChange{}, ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 when not enabled, got %d", rec.Code)
+	}
+	adminRequestAs(t, handler, "oncall-token", http.MethodPost, "/api/v1/breakglass/enable", BreakGlassChange{Reason: "IdP outage"}, "")
+	waitNotification(t, notifications)
+
+	rec := adminRequestAs(t, handler, "lead-token", http.MethodPost, "/api/v1/breakglass/disable", BreakGlassChange{Reason: "IdP recovered"}, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if n := waitNotification(t, notifications); n.Event.Action != auditBreakGlassDisabled || n.Event.Actor != "lead" {
+		t.Errorf("Expected disabled notification, got %+v", n)
+	}
+	rec = adminRequest(t, handler, http.MethodGet, "/api/v1/breakglass", nil, "")
+	if !strings.Contains(rec.Body.String(), `"enabled":false`) {
+		t.Errorf("Expected disabled status, got %s", rec.Body.String())
+	}
+	if passwordAuth(t, server, "emergency", "glass-pass") {
+		t.Error("Expected break-glass login to be rejected after disable")
+	}
+}
+
+// TestLimitBreakGlassSession 测试紧急访问用户的会话最长到紧急访问到期为止
+func TestLimitBreakGlassSession(t *testing.T) {
+	now := time.Now()
+	cfg := &Config{BreakGlassActivation: &BreakGlassActivation{EnabledAt: now, ExpiresAt: now.Add(20 * time.Minute)}}
+	glass := &UserConfig{Username: "emergency", BreakGlass: true}
+	tests := []struct {
+		user       *UserConfig
+		maxSession time.Duration
+		now        time.Time
+		want       time.Duration
+	}{
+		{glass, 0, now, 20 * time.Minute},
+		{glass, time.Hour, now, 20 * time.Minute},
+		{glass, 5 * time.Minute, now, 5 * time.Minute},
+		{glass, 0, now.Add(time.Hour), time.Second},
+		{&UserConfig{Username: "alice"}, 0, now, 0},
+	}
+	for _, tt := range tests {
+		timeouts := TimeoutsConfig{MaxSession: tt.maxSession}
+		cfg.limitBreakGlassSession(&timeouts, tt.user, tt.now)
+		if timeouts.MaxSession != tt.want {
+			t.Errorf("%s, maxSession %s: expected %s, got %s", tt.user.Username, tt.maxSession, tt.want, timeouts.MaxSession)
+		}
+	}
+}
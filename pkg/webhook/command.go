@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.containerssh.io/containerssh/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// metadataForcedCommand 公钥中 command= 选项指定的命令写入 metadata 的 key，config 请求中使用
+const metadataForcedCommand = "SSH_FORCED_COMMAND"
+
+// commandAllowEnv 把命令白名单传给容器中检查脚本的环境变量
+const commandAllowEnv = "SSHPROXY_COMMAND_ALLOW"
+
+// commandCheckScript 在容器中检查 SSH_ORIGINAL_COMMAND 是否匹配白名单，
+// 拒绝交互式 shell 和包含换行的命令，匹配时交给 /bin/sh 执行
+const commandCheckScript = `[ -n "$SSH_ORIGINAL_COMMAND" ] || { echo "interactive shell is not allowed" >&2; exit 1; }; ` +
+	`[ "$(printf %s "$SSH_ORIGINAL_COMMAND" | wc -l)" -eq 0 ] && ` +
+	`printf %s "$SSH_ORIGINAL_COMMAND" | grep -Eq "$` + commandAllowEnv + `" && ` +
+	`exec /bin/sh -c "$SSH_ORIGINAL_COMMAND"; ` +
+	`echo "command not allowed" >&2; exit 1`
+
+// authorizedKeys 返回用户配置的所有公钥
+func (u *UserConfig) authorizedKeys() []string {
+	var keys []string
+	if u.PublicKey != "" {
+		keys = append(keys, u.PublicKey)
+	}
+	return append(keys, u.PublicKeys...)
+}
+
+// matchPublicKey 在用户配置的公钥中查找客户端公钥，返回公钥注释和 command= 选项
+// 无法解析的公钥会被跳过
+func matchPublicKey(user *UserConfig, clientKey ssh.PublicKey) (comment, command string, ok bool) {
+	for _, line := range user.authorizedKeys() {
+		key, c, options, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(clientKey.Marshal(), key.Marshal()) {
+			return c, keyCommand(options), true
+		}
+	}
+	return "", "", false
+}
+
+// keyCommand 解析 authorized_keys 中的 command="..." 选项
+func keyCommand(options []string) string {
+	for _, opt := range options {
+		if !strings.HasPrefix(opt, "command=") {
+			continue
+		}
+		value := strings.TrimPrefix(opt, "command=")
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		return strings.ReplaceAll(value, `\"`, `"`)
+	}
+	return ""
+}
+
+// restrictedEnv 受限账户不允许客户端通过 SendEnv 设置的环境变量，
+// 否则客户端可以覆盖白名单或伪造原始命令
+var restrictedEnv = []string{commandAllowEnv, "SSH_ORIGINAL_COMMAND"}
+
+// escapedAlnum 反斜杠加字母或数字，Go 和 grep -E 对这类转义的解释不一致（如 \t、\d、\1）
+var escapedAlnum = regexp.MustCompile(`\\[0-9A-Za-z]`)
+
+// validateCommandPattern 检查白名单表达式。表达式在容器中由 grep -E 匹配，
+// 只接受 Go 和 grep -E 解释一致的 POSIX 扩展正则，拒绝反斜杠加字母或数字的转义
+func validateCommandPattern(pattern string) error {
+	if _, err := regexp.CompilePOSIX(pattern); err != nil {
+		return err
+	}
+	if m := escapedAlnum.FindString(pattern); m != "" {
+		return fmt.Errorf("escape %s is not portable, use a bracket expression instead", m)
+	}
+	return nil
+}
+
+// literalCommands 白名单全部是不含正则元字符的完整命令时返回这些命令
+func literalCommands(allowlist []string) ([]string, bool) {
+	for _, p := range allowlist {
+		if regexp.QuoteMeta(p) != p {
+			return nil, false
+		}
+	}
+	return allowlist, true
+}
+
+// applyCommandPolicy 按强制命令或命令白名单限制会话，返回需要传给容器的环境变量
+// 强制命令优先于白名单；受限的账户不能打开交互式 shell，未显式开启时也不能使用子系统，
+// 也不能通过 SendEnv 设置 restrictedEnv 中的环境变量。
+// 白名单全部是固定命令时交给 ContainerSSH 的命令过滤执行，不依赖容器中的工具；
+// 否则通过强制命令在容器中用 /bin/sh 和 grep -E 检查
+func applyCommandPolicy(sec *config.SecurityConfig, forcedCommand string, allowlist []string) map[string]string {
+	if forcedCommand == "" && len(allowlist) == 0 {
+		return nil
+	}
+
+	sec.Shell.Mode = config.ExecutionPolicyDisable
+	if sec.Subsystem.Mode == config.ExecutionPolicyUnconfigured {
+		sec.Subsystem.Mode = config.ExecutionPolicyDisable
+	}
+	if sec.Env.Mode != config.ExecutionPolicyDisable {
+		sec.Env.Mode = config.ExecutionPolicyFilter
+		sec.Env.Deny = append(sec.Env.Deny, restrictedEnv...)
+	}
+
+	if forcedCommand != "" {
+		sec.ForceCommand = forcedCommand
+		return nil
+	}
+
+	if commands, ok := literalCommands(allowlist); ok {
+		// features 中关闭了 exec 时保持关闭
+		if sec.Command.Mode != config.ExecutionPolicyDisable {
+			sec.Command.Mode = config.ExecutionPolicyFilter
+			sec.Command.Allow = commands
+		}
+		return nil
+	}
+
+	// 每个表达式匹配完整的命令行
+	patterns := make([]string, len(allowlist))
+	for i, p := range allowlist {
+		patterns[i] = "^(" + p + ")$"
+	}
+	sec.ForceCommand = "/bin/sh -c '" + commandCheckScript + "'"
+	return map[string]string{commandAllowEnv: strings.Join(patterns, "|")}
+}
@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.containerssh.io/containerssh/config"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestKey 生成测试用的公钥，返回 authorized_keys 格式
+func newTestKey(t *testing.T) (ssh.PublicKey, string) {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to create public key: %v", err)
+	}
+	return key, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// TestMatchPublicKey 测试在多个公钥中匹配并解析 command= 选项
+func TestMatchPublicKey(t *testing.T) {
+	key1, line1 := newTestKey(t)
+	key2, line2 := newTestKey(t)
+	key3, _ := newTestKey(t)
+
+	user := &UserConfig{
+		PublicKey:  "invalid key",
+		PublicKeys: []string{line1 + " laptop", `command="backup.sh --dir \"/data\"",no-pty ` + line2 + " ci-bot"},
+	}
+
+	comment, command, ok := matchPublicKey(user, key1)
+	if !ok || comment != "laptop" || command != "" {
+		t.Errorf("Unexpected match for key1: %v %q %q", ok, comment, command)
+	}
+	comment, command, ok = matchPublicKey(user, key2)
+	if !ok || comment != "ci-bot" || command != `backup.sh --dir "/data"` {
+		t.Errorf("Unexpected match for key2: %v %q %q", ok, comment, command)
+	}
+	if _, _, ok := matchPublicKey(user, key3); ok {
+		t.Error("Expected unknown key not to match")
+	}
+}
+
+// TestApplyCommandPolicy 测试强制命令和命令白名单
+func TestApplyCommandPolicy(t *testing.T) {
+	var sec config.SecurityConfig
+	if env := applyCommandPolicy(&sec, "", nil); env != nil || sec.ForceCommand != "" {
+		t.Errorf("Expected no restriction, got %+v", sec)
+	}
+
+	sec = config.SecurityConfig{}
+	applyCommandPolicy(&sec, "backup.sh", []string{"ls"})
+	if sec.ForceCommand != "backup.sh" {
+		t.Errorf("Expected forced command, got '%s'", sec.ForceCommand)
+	}
+	if sec.Shell.Mode != config.ExecutionPolicyDisable || sec.Subsystem.Mode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected shell and subsystems to be disabled, got %+v", sec)
+	}
+
+	// 显式开启的子系统保持不变
+	sec = config.SecurityConfig{}
+	sec.Subsystem.Mode = config.ExecutionPolicyEnable
+	env := applyCommandPolicy(&sec, "", []string{"git-upload-pack '.*'", "ls( -l)?"})
+	if sec.Subsystem.Mode != config.ExecutionPolicyEnable {
+		t.Errorf("Expected explicit subsystem mode to be kept, got %s", sec.Subsystem.Mode)
+	}
+	if !strings.HasPrefix(sec.ForceCommand, "/bin/sh -c '") {
+		t.Errorf("Expected check script as forced command, got '%s'", sec.ForceCommand)
+	}
+	if env[commandAllowEnv] != "^(git-upload-pack '.*')$|^(ls( -l)?)$" {
+		t.Errorf("Unexpected pattern: %s", env[commandAllowEnv])
+	}
+
+	// 固定命令交给 ContainerSSH 过滤，不使用容器中的检查脚本
+	sec = config.SecurityConfig{}
+	env = applyCommandPolicy(&sec, "", []string{"uptime", "df -h"})
+	if env != nil || sec.ForceCommand != "" {
+		t.Errorf("Expected no check script for literal commands, got %q %v", sec.ForceCommand, env)
+	}
+	if sec.Command.Mode != config.ExecutionPolicyFilter || !reflect.DeepEqual(sec.Command.Allow, []string{"uptime", "df -h"}) {
+		t.Errorf("Expected command filter, got %+v", sec.Command)
+	}
+
+	// features 中关闭的 exec 不会被白名单重新打开
+	sec = config.SecurityConfig{}
+	sec.Command.Mode = config.ExecutionPolicyDisable
+	applyCommandPolicy(&sec, "", []string{"uptime"})
+	if sec.Command.Mode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected exec to stay disabled, got %s", sec.Command.Mode)
+	}
+}
+
+// envAllowed 按 ContainerSSH 的规则判断客户端能否设置环境变量
+func envAllowed(env config.SecurityEnvConfig, name string) bool {
+	switch env.Mode {
+	case config.ExecutionPolicyDisable:
+		return false
+	case config.ExecutionPolicyFilter:
+		for _, n := range env.Deny {
+			if n == name {
+				return false
+			}
+		}
+		if len(env.Allow) == 0 {
+			return true
+		}
+		for _, n := range env.Allow {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// TestApplyCommandPolicy_ClientEnv 测试客户端不能通过 SendEnv 覆盖白名单或原始命令
+func TestApplyCommandPolicy_ClientEnv(t *testing.T) {
+	for _, tt := range []struct {
+		forced    string
+		allowlist []string
+	}{
+		{"", []string{"ls( -l)?"}},
+		{"", []string{"uptime"}},
+		{"backup.sh", nil},
+	} {
+		var sec config.SecurityConfig
+		applyCommandPolicy(&sec, tt.forced, tt.allowlist)
+		for _, name := range []string{commandAllowEnv, "SSH_ORIGINAL_COMMAND"} {
+			if envAllowed(sec.Env, name) {
+				t.Errorf("Expected client to be denied setting %s for %+v, got %+v", name, tt, sec.Env)
+			}
+		}
+		if !envAllowed(sec.Env, "LANG") {
+			t.Errorf("Expected client to be allowed setting LANG, got %+v", sec.Env)
+		}
+	}
+
+}
+
+// TestValidateCommandPattern 测试白名单表达式的检查
+func TestValidateCommandPattern(t *testing.T) {
+	for pattern, valid := range map[string]bool{
+		"ls( -l)?":                  true,
+		"git-upload-pack '[^']+'":   true,
+		`cat /var/log/app\.log`:     true,
+		`ls \d+`:                    false,
+		`echo\tx`:                   false,
+		`(a)\1`:                     false,
+		"ls (":                      false,
+		"kubectl get [[:alnum:]-]+": true,
+	} {
+		if err := validateCommandPattern(pattern); (err == nil) != valid {
+			t.Errorf("Pattern %q: expected valid=%v, got %v", pattern, valid, err)
+		}
+	}
+}
+
+// TestCommandCheckScript 在本机 shell 中运行检查脚本
+func TestCommandCheckScript(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh not available")
+	}
+	if strings.Contains(commandCheckScript, "'") {
+		t.Fatal("Check script must not contain single quotes")
+	}
+
+	var sec config.SecurityConfig
+	env := applyCommandPolicy(&sec, "", []string{"echo [a-z]+", "true"})
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"echo hello", true},
+		{"true", true},
+		{"", false},
+		{"echo Hello", false},
+		{"echo hello; id", false},
+		{"echo hello\nid", false},
+		{"true && id", false},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("/bin/sh", "-c", commandCheckScript)
+		cmd.Env = append(os.Environ(),
+			"SSH_ORIGINAL_COMMAND="+tt.command,
+			commandAllowEnv+"="+env[commandAllowEnv])
+		out, err := cmd.CombinedOutput()
+		if (err == nil) != tt.allowed {
+			t.Errorf("Command %q: expected allowed=%v, got error=%v, output=%s", tt.command, tt.allowed, err, out)
+		}
+	}
+}
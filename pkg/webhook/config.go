@@ -1,8 +1,14 @@
 package webhook
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path"
+	"regexp"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,43 +24,479 @@ type ClusterConfig struct {
 	ServerName      string `yaml:"serverName"`      // TLS 服务器名称（可选）
 	QPS             int    `yaml:"qps"`             // QPS 限制（可选）
 	Burst           int    `yaml:"burst"`           // Burst 限制（可选）
+
+	Production bool             `yaml:"production,omitempty"` // 生产集群，必须开启录像
+	Recording  *RecordingConfig `yaml:"recording,omitempty"`  // 集群默认的录像配置（可选）
+	Timeouts   *TimeoutsConfig  `yaml:"timeouts,omitempty"`   // 集群默认的会话超时（可选）
+
+	MaxPodSessions int `yaml:"maxPodSessions,omitempty"` // 同一个 pod 的并发连接数上限，0 表示不限制
+
+	Labels map[string]string `yaml:"labels,omitempty"` // 集群标签，如 env: prod，访问策略中为 clusterLabels
 }
 
 // Config webhook 服务配置
 type Config struct {
 	Listen   string          `yaml:"listen"`
 	Clusters []ClusterConfig `yaml:"clusters"` // Kubernetes 集群配置列表
+	Targets  []TargetConfig  `yaml:"targets"`  // 登录目标列表（可选）
+	Groups   []GroupConfig   `yaml:"groups"`   // 用户组列表（可选）
 	Users    []UserConfig    `yaml:"users"`
+
+	// 未指定容器名称时选择容器的策略
+	ContainerSelection ContainerSelectionConfig `yaml:"containerSelection"`
+
+	// 未在集群、目标、组和用户上配置时使用的会话超时
+	Timeouts DefaultTimeoutsConfig `yaml:"timeouts,omitempty"`
+	// 并发连接计数
+	SessionLimits SessionLimitsConfig `yaml:"sessionLimits,omitempty"`
+	// /readyz 就绪检查
+	Readiness ReadinessConfig `yaml:"readiness,omitempty"`
+
+	// 认证前显示的提示（如法律声明），写入生成的 ContainerSSH 配置，修改后需要重新生成
+	Banner string `yaml:"banner,omitempty"`
+
+	// 管理 API，用于维护用户、公钥、目标和集群
+	Admin AdminConfig `yaml:"admin,omitempty"`
+
+	// TOTP 第二因素
+	MFA MFAConfig `yaml:"mfa,omitempty"`
+	// 通过身份提供方的设备授权流程登录
+	OIDC OIDCConfig `yaml:"oidc,omitempty"`
+
+	// 访问策略，认证和返回配置时按顺序检查，任意一条的 deny 表达式为 true 时拒绝
+	Policies []PolicyConfig `yaml:"policies,omitempty"`
+	// 策略中可以引用的开关，如 incident: true，修改后重新加载配置生效
+	PolicyFlags map[string]bool `yaml:"policyFlags,omitempty"`
+
+	// 临时访问申请
+	Access AccessConfig `yaml:"access,omitempty"`
+	// 访问申请，由管理 API 维护
+	AccessGrants []AccessGrant `yaml:"accessGrants,omitempty"`
+
+	// 紧急访问
+	BreakGlass BreakGlassConfig `yaml:"breakGlass,omitempty"`
+	// 紧急访问的启用记录，由管理 API 维护
+	BreakGlassActivation *BreakGlassActivation `yaml:"breakGlassActivation,omitempty"`
+
+	hash     string    // 配置文件内容的 SHA-256，由 /version 返回
+	loadedAt time.Time // 配置加载时间
+	path     string    // 配置文件路径，管理 API 写入该文件
+}
+
+// ContainerSelectionConfig 未指定容器名称时选择容器的策略：
+// 优先使用 kubectl.kubernetes.io/default-container 注解指定的容器，
+// 否则使用第一个不在 sidecar 列表中的容器，init 容器和 ephemeral 容器不会被选中
+type ContainerSelectionConfig struct {
+	Sidecars []string `yaml:"sidecars"` // 需要跳过的 sidecar 容器名称
+}
+
+// defaultSidecars 未配置 sidecar 列表时使用的默认值
+var defaultSidecars = []string{"istio-proxy", "linkerd-proxy", "vault-agent"}
+
+// TargetConfig 登录目标配置，描述用户要进入的 pod 和容器
+// namespace、pod 和 container 支持模板，如 team-{{.Group}} 或 team-${group}，变量见 TemplateData
+type TargetConfig struct {
+	Name          string `yaml:"name"`                 // 目标名称（唯一标识）
+	Cluster       string `yaml:"cluster"`              // 集群名称，对应 clusters 中的 name
+	Namespace     string `yaml:"namespace"`            // Pod 所在的 namespace
+	Pod           string `yaml:"pod"`                  // Pod 名称
+	Container     string `yaml:"container"`            // 容器名称（可选）
+	Production    bool   `yaml:"production,omitempty"` // 生产目标，必须开启录像，组和用户不能关闭
+	SessionConfig `yaml:",inline"`
+
+	// 同一个 pod 的并发连接数上限，覆盖集群上的设置，0 表示沿用集群的设置
+	MaxPodSessions int `yaml:"maxPodSessions,omitempty"`
+
+	// 使用 ephemeral 调试容器登录（可选），适用于没有 shell 的镜像
+	Debug *DebugConfig `yaml:"debug,omitempty"`
+
+	// 按模板为每个连接或会话创建 pod（可选），配置后忽略 namespace、pod 和 container
+	Template *PodTemplateConfig `yaml:"template,omitempty"`
+}
+
+// PodTemplateConfig 按需创建 pod 的模板
+// namespace 和 volumes 中的 claimName 支持模板，{{user}} 会被替换为符合 DNS 规范的用户名
+type PodTemplateConfig struct {
+	Mode            string          `yaml:"mode"`                      // connection（每个连接一个 pod）或 session（每个会话一个 pod），默认 connection
+	Namespace       string          `yaml:"namespace"`                 // namespace 模板，如 ws-{{user}}
+	CreateNamespace bool            `yaml:"createNamespace,omitempty"` // namespace 不存在时自动创建
+	Image           string          `yaml:"image"`                     // 容器镜像
+	Resources       ResourcesConfig `yaml:"resources,omitempty"`       // 资源请求和限制
+	Volumes         []VolumeConfig  `yaml:"volumes,omitempty"`         // 挂载的卷
+	Agent           bool            `yaml:"agent,omitempty"`           // 镜像中包含 containerssh-agent 时开启
+}
+
+// ResourcesConfig 容器资源配置，值使用 Kubernetes 的数量格式，如 500m、1Gi
+type ResourcesConfig struct {
+	Requests map[string]string `yaml:"requests,omitempty"`
+	Limits   map[string]string `yaml:"limits,omitempty"`
+}
+
+// VolumeConfig 挂载到 pod 中的卷，claimName 和 emptyDir 二选一
+type VolumeConfig struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+	ClaimName string `yaml:"claimName,omitempty"` // PVC 名称模板，如 home-{{user}}
+	EmptyDir  bool   `yaml:"emptyDir,omitempty"`  // 使用临时目录
+	ReadOnly  bool   `yaml:"readOnly,omitempty"`
+}
+
+// DebugConfig ephemeral 调试容器配置
+// 调试容器共享目标容器的进程命名空间，同一用户会复用已经在运行的调试容器
+type DebugConfig struct {
+	Image        string        `yaml:"image"`                  // 调试容器镜像，如 busybox
+	Command      []string      `yaml:"command,omitempty"`      // 调试容器的启动命令，默认使用镜像的命令
+	StartTimeout time.Duration `yaml:"startTimeout,omitempty"` // 等待调试容器启动的时间，默认也是最大值 5s
+}
+
+// defaultDebugStartTimeout 等待调试容器启动的默认时间，也是允许的最大值：
+// 调试容器在 config 请求中启动，之后还要探测 shell，总时间不能超过 configTimeout
+const defaultDebugStartTimeout = 5 * time.Second
+
+// GroupConfig 用户组配置，组内用户共享会话配置
+type GroupConfig struct {
+	Name          string `yaml:"name"` // 组名称（唯一标识）
+	SessionConfig `yaml:",inline"`
+
+	// 组内用户在登录时可以选择的目标（登录名 <用户名>+<目标>）
+	Targets []string `yaml:"targets,omitempty"`
+}
+
+// SessionConfig 登录会话配置，可以设置在目标、组和用户上，优先级依次升高
+type SessionConfig struct {
+	Shell     *ShellConfig     `yaml:"shell,omitempty"`     // 登录 shell 配置
+	Features  *FeaturesConfig  `yaml:"features,omitempty"`  // SSH 功能开关
+	Recording *RecordingConfig `yaml:"recording,omitempty"` // 会话录像
+	MOTD      string           `yaml:"motd,omitempty"`      // 登录交互式 shell 后显示的信息，支持模板，变量见 MOTDData
+	Timeouts  *TimeoutsConfig  `yaml:"timeouts,omitempty"`  // 空闲超时和会话最长时间
+	// 单个用户的并发连接数上限，以优先级最高的一层为准，0 表示沿用低优先级的设置
+	MaxSessions int `yaml:"maxSessions,omitempty"`
+}
+
+// ShellConfig 登录 shell 配置
+type ShellConfig struct {
+	Command    []string          `yaml:"command,omitempty"`    // shell 命令，如 ["/bin/bash", "-l"]
+	AutoDetect bool              `yaml:"autoDetect,omitempty"` // 依次探测容器中的 bash、sh、ash
+	WorkDir    string            `yaml:"workDir,omitempty"`    // 登录后的工作目录
+	Env        map[string]string `yaml:"env,omitempty"`        // 登录环境变量
 }
 
 // UserConfig 用户配置
 type UserConfig struct {
-	Username  string            `yaml:"username"`
-	Password  string            `yaml:"password"`
-	PublicKey string            `yaml:"publicKey,omitempty"`
-	Metadata  map[string]string `yaml:"metadata"`
+	Username      string            `yaml:"username"`
+	Password      Secret            `yaml:"password"`               // 支持 file:、env:、k8s: 引用
+	PasswordHash  string            `yaml:"passwordHash,omitempty"` // bcrypt 哈希，由 sshhook user set-password 生成，不能与 password 同时使用
+	PublicKey     string            `yaml:"publicKey,omitempty"`
+	PublicKeys    []string          `yaml:"publicKeys,omitempty"` // 更多公钥，支持 command="..." 选项
+	Groups        []string          `yaml:"groups,omitempty"`     // 所属用户组
+	Target        string            `yaml:"target,omitempty"`     // 登录目标名称，metadata 中的 KUBERNETES_* 会覆盖目标中的值
+	Targets       []string          `yaml:"targets,omitempty"`    // 登录时可以选择的其他目标（登录名 <用户名>+<目标>）
+	Metadata      map[string]string `yaml:"metadata"`             // 支持模板，认证时按连接信息渲染
+	ExpiresAt     time.Time         `yaml:"expiresAt,omitempty"`  // 访问的过期时间（可选），如 2025-12-31T18:00:00+08:00
+	Locked        bool              `yaml:"locked,omitempty"`     // 锁定后认证失败，通过管理 API 锁定和解锁
+	TOTPSecret    string            `yaml:"totpSecret,omitempty"` // 加密的 TOTP 密钥，由 sshhook totp enroll 生成，配置后需要输入验证码
+	BreakGlass    bool              `yaml:"breakGlass,omitempty"` // 紧急访问用户，只能用 passwordHash 登录，只在 sshhook breakglass enable 之后有效
+	SessionConfig `yaml:",inline"`
+
+	// 标记为敏感的 metadata key，ContainerSSH 不会在日志中输出这些值
+	SensitiveMetadata []string `yaml:"sensitiveMetadata,omitempty"`
+	// 通过 ContainerSSH 导出到会话中的环境变量
+	Environment []EnvVarConfig `yaml:"environment,omitempty"`
+	// 由 ContainerSSH 写入容器的文件，如 kube token、git 凭据
+	Files []FileConfig `yaml:"files,omitempty"`
+
+	// 强制执行的命令，与 authorized_keys 的 command= 相同，公钥中的 command= 优先
+	ForcedCommand string `yaml:"forcedCommand,omitempty"`
+	// 允许执行的命令（POSIX 扩展正则，匹配完整的命令行），配置后其他命令和交互式 shell 都会被拒绝
+	CommandAllowlist []string `yaml:"commandAllowlist,omitempty"`
+}
+
+// EnvVarConfig 导出到会话中的环境变量
+type EnvVarConfig struct {
+	Name      string `yaml:"name"`
+	Value     Secret `yaml:"value"`               // 支持 file:、env:、k8s: 引用
+	Sensitive bool   `yaml:"sensitive,omitempty"` // ContainerSSH 不会在日志中输出
 }
 
-// LoadConfig 从文件加载配置
+// FileConfig 写入容器的文件
+type FileConfig struct {
+	Path      string `yaml:"path"`                // 容器中的绝对路径
+	Content   Secret `yaml:"content"`             // 文件内容，支持 file:、env:、k8s: 引用
+	Sensitive bool   `yaml:"sensitive,omitempty"` // ContainerSSH 不会在日志中输出
+}
+
+// envNamePattern 合法的环境变量名称
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Route 用户登录时要进入的 pod 和容器
+type Route struct {
+	Cluster   string
+	Namespace string
+	Pod       string
+	Container string
+	Target    *TargetConfig // 用户配置了登录目标时不为空
+}
+
+// LoadConfig 从文件加载配置，并解析其中的 Secret 引用
 func LoadConfig(filename string) (*Config, error) {
+	config, err := LoadConfigWithoutSecrets(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析 file:、env:、k8s: 引用
+	if err := config.resolveSecrets(context.Background(), &kubeClients{}); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	if err := config.checkTOTPSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadConfigData 解析配置内容并解析 Secret 引用，管理 API 写入前用于检查新的配置
+func loadConfigData(data []byte, filename string) (*Config, error) {
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config.path = filename
+	if err := config.resolveSecrets(context.Background(), &kubeClients{}); err != nil {
+		return nil, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	if err := config.checkTOTPSecrets(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// LoadConfigWithoutSecrets 从文件加载配置，Secret 引用保持原样，
+// 用于生成 ContainerSSH 配置等不需要明文的场景
+func LoadConfigWithoutSecrets(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	config.path = filename
+	return config, nil
+}
 
+// parseConfig 解析配置内容，设置默认值并检查
+func parseConfig(data []byte) (*Config, error) {
 	var config Config
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
+	sum := sha256.Sum256(data)
+	config.hash = hex.EncodeToString(sum[:])
+	config.loadedAt = time.Now()
+
 	// 设置默认值
 	if config.Listen == "" {
 		config.Listen = ":8080"
 	}
+	if config.ContainerSelection.Sidecars == nil {
+		config.ContainerSelection.Sidecars = defaultSidecars
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
 
 	return &config, nil
 }
 
+// validate 检查配置中的引用关系
+func (c *Config) validate() error {
+	if err := c.Timeouts.Default.validate(); err != nil {
+		return fmt.Errorf("timeouts default: %w", err)
+	}
+	if err := c.Timeouts.Production.validate(); err != nil {
+		return fmt.Errorf("timeouts production: %w", err)
+	}
+	if c.SessionLimits.LeaseTTL < 0 {
+		return fmt.Errorf("sessionLimits: leaseTTL must not be negative")
+	}
+	if err := c.Admin.validate(); err != nil {
+		return fmt.Errorf("admin: %w", err)
+	}
+	if err := c.MFA.validate(c.Users); err != nil {
+		return fmt.Errorf("mfa: %w", err)
+	}
+	if err := c.OIDC.validate(c); err != nil {
+		return fmt.Errorf("oidc: %w", err)
+	}
+	if err := c.compilePolicies(); err != nil {
+		return fmt.Errorf("policies: %w", err)
+	}
+	if err := c.validateAccess(); err != nil {
+		return err
+	}
+	if err := c.validateBreakGlass(); err != nil {
+		return err
+	}
+	for i := range c.Clusters {
+		cl := &c.Clusters[i]
+		if err := cl.Timeouts.validateLayer(); err != nil {
+			return fmt.Errorf("cluster %s: %w", cl.Name, err)
+		}
+		if cl.MaxPodSessions < 0 {
+			return fmt.Errorf("cluster %s: maxPodSessions must not be negative", cl.Name)
+		}
+		if cl.Recording != nil {
+			if err := cl.Recording.validateFields(); err != nil {
+				return fmt.Errorf("cluster %s: %w", cl.Name, err)
+			}
+		}
+		if cl.Production {
+			if err := requireRecording(cl, nil); err != nil {
+				return fmt.Errorf("cluster %s: %w", cl.Name, err)
+			}
+		}
+	}
+	for _, t := range c.Targets {
+		if t.Name == "" {
+			return fmt.Errorf("target without name")
+		}
+		if t.Cluster != "" && c.GetCluster(t.Cluster) == nil {
+			return fmt.Errorf("target %s: cluster not found: %s", t.Name, t.Cluster)
+		}
+		if t.Recording != nil {
+			if err := t.Recording.validateFields(); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+		}
+		if t.Production {
+			cluster := c.GetCluster(t.Cluster)
+			if cluster == nil {
+				return fmt.Errorf("target %s: production target requires a cluster", t.Name)
+			}
+			if err := requireRecording(cluster, &t); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+		}
+		for field, value := range map[string]string{"namespace": t.Namespace, "pod": t.Pod, "container": t.Container} {
+			if _, err := renderTemplate(value, sampleTemplateData); err != nil {
+				return fmt.Errorf("target %s: invalid %s template: %w", t.Name, field, err)
+			}
+		}
+		if _, err := renderMOTD(t.MOTD, sampleMOTDData); err != nil {
+			return fmt.Errorf("target %s: invalid motd template: %w", t.Name, err)
+		}
+		if err := t.Timeouts.validateLayer(); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if err := t.Features.validate(); err != nil {
+			return fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		if t.MaxPodSessions < 0 || t.MaxSessions < 0 {
+			return fmt.Errorf("target %s: session limits must not be negative", t.Name)
+		}
+		if t.Debug != nil && t.Debug.Image == "" {
+			return fmt.Errorf("target %s: debug image is required", t.Name)
+		}
+		if t.Debug != nil && (t.Debug.StartTimeout < 0 || t.Debug.StartTimeout > defaultDebugStartTimeout) {
+			return fmt.Errorf("target %s: debug startTimeout must be between 0 and %s", t.Name, defaultDebugStartTimeout)
+		}
+		if t.Template != nil {
+			if t.Debug != nil {
+				return fmt.Errorf("target %s: debug and template cannot be used together", t.Name)
+			}
+			if err := t.Template.validate(); err != nil {
+				return fmt.Errorf("target %s: %w", t.Name, err)
+			}
+		}
+	}
+	for _, g := range c.Groups {
+		if g.Recording != nil {
+			if err := g.Recording.validateFields(); err != nil {
+				return fmt.Errorf("group %s: %w", g.Name, err)
+			}
+		}
+		if _, err := renderMOTD(g.MOTD, sampleMOTDData); err != nil {
+			return fmt.Errorf("group %s: invalid motd template: %w", g.Name, err)
+		}
+		if err := g.Timeouts.validateLayer(); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		if err := g.Features.validate(); err != nil {
+			return fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		if g.MaxSessions < 0 {
+			return fmt.Errorf("group %s: maxSessions must not be negative", g.Name)
+		}
+		for _, t := range g.Targets {
+			if c.GetTarget(t) == nil {
+				return fmt.Errorf("group %s: target not found: %s", g.Name, t)
+			}
+		}
+	}
+	for _, u := range c.Users {
+		if err := u.validatePassword(); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+		if u.Recording != nil {
+			if err := u.Recording.validateFields(); err != nil {
+				return fmt.Errorf("user %s: %w", u.Username, err)
+			}
+		}
+		if u.Target != "" && c.GetTarget(u.Target) == nil {
+			return fmt.Errorf("user %s: target not found: %s", u.Username, u.Target)
+		}
+		for _, t := range u.Targets {
+			if c.GetTarget(t) == nil {
+				return fmt.Errorf("user %s: target not found: %s", u.Username, t)
+			}
+		}
+		for _, g := range u.Groups {
+			if c.GetGroup(g) == nil {
+				return fmt.Errorf("user %s: group not found: %s", u.Username, g)
+			}
+		}
+		for _, env := range u.Environment {
+			if !envNamePattern.MatchString(env.Name) {
+				return fmt.Errorf("user %s: invalid environment variable name: %q", u.Username, env.Name)
+			}
+		}
+		if u.ForcedCommand != "" && len(u.CommandAllowlist) > 0 {
+			return fmt.Errorf("user %s: forcedCommand and commandAllowlist cannot be used together", u.Username)
+		}
+		for _, p := range u.CommandAllowlist {
+			if err := validateCommandPattern(p); err != nil {
+				return fmt.Errorf("user %s: invalid command pattern %q: %w", u.Username, p, err)
+			}
+		}
+		for _, f := range u.Files {
+			if !path.IsAbs(f.Path) {
+				return fmt.Errorf("user %s: file path must be absolute: %q", u.Username, f.Path)
+			}
+		}
+		// 用用户自己的用户名和组渲染一次，提前发现模板错误
+		if _, err := renderMetadata(u.Metadata, userTemplateData(&u)); err != nil {
+			return fmt.Errorf("user %s: invalid %w", u.Username, err)
+		}
+		if _, err := renderMOTD(u.MOTD, sampleMOTDData); err != nil {
+			return fmt.Errorf("user %s: invalid motd template: %w", u.Username, err)
+		}
+		if err := u.Timeouts.validateLayer(); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+		if err := u.Features.validate(); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+		if u.MaxSessions < 0 {
+			return fmt.Errorf("user %s: maxSessions must not be negative", u.Username)
+		}
+	}
+	return nil
+}
+
 // GetUser 根据用户名获取用户配置
 func (c *Config) GetUser(username string) *UserConfig {
 	for i := range c.Users {
@@ -65,6 +507,11 @@ func (c *Config) GetUser(username string) *UserConfig {
 	return nil
 }
 
+// expired 判断用户的访问是否已过期
+func (u *UserConfig) expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !now.Before(u.ExpiresAt)
+}
+
 // GetCluster 根据集群名称获取集群配置
 func (c *Config) GetCluster(name string) *ClusterConfig {
 	for i := range c.Clusters {
@@ -74,3 +521,109 @@ func (c *Config) GetCluster(name string) *ClusterConfig {
 	}
 	return nil
 }
+
+// GetTarget 根据名称获取登录目标配置
+func (c *Config) GetTarget(name string) *TargetConfig {
+	for i := range c.Targets {
+		if c.Targets[i].Name == name {
+			return &c.Targets[i]
+		}
+	}
+	return nil
+}
+
+// GetGroup 根据名称获取用户组配置
+func (c *Config) GetGroup(name string) *GroupConfig {
+	for i := range c.Groups {
+		if c.Groups[i].Name == name {
+			return &c.Groups[i]
+		}
+	}
+	return nil
+}
+
+// ResolveRoute 计算用户登录的 pod 和容器：先取登录目标中的值，再用 metadata 覆盖
+// 目标字段和 metadata 中的模板使用 data 渲染
+func (c *Config) ResolveRoute(user *UserConfig, data *TemplateData) (*Route, error) {
+	route := &Route{}
+	if user.Target != "" {
+		target := c.GetTarget(user.Target)
+		if target == nil {
+			return nil, fmt.Errorf("target not found: %s", user.Target)
+		}
+		route.Target = target
+		route.Cluster = target.Cluster
+
+		var err error
+		if route.Namespace, err = renderTemplate(target.Namespace, data); err != nil {
+			return nil, fmt.Errorf("target %s: failed to render namespace: %w", target.Name, err)
+		}
+		if route.Pod, err = renderTemplate(target.Pod, data); err != nil {
+			return nil, fmt.Errorf("target %s: failed to render pod: %w", target.Name, err)
+		}
+		if route.Container, err = renderTemplate(target.Container, data); err != nil {
+			return nil, fmt.Errorf("target %s: failed to render container: %w", target.Name, err)
+		}
+	}
+
+	md, err := renderMetadata(user.Metadata, data)
+	if err != nil {
+		return nil, err
+	}
+	if v := md["KUBERNETES_CLUSTER"]; v != "" {
+		route.Cluster = v
+	}
+	if v := md["KUBERNETES_POD_NAMESPACE"]; v != "" {
+		route.Namespace = v
+	}
+	if v := md["KUBERNETES_POD_NAME"]; v != "" {
+		route.Pod = v
+	}
+	if v := md["KUBERNETES_CONTAINER_NAME"]; v != "" {
+		route.Container = v
+	}
+	return route, nil
+}
+
+// sessionLayers 返回对用户生效的会话配置，按优先级从低到高排列：目标、用户组、用户
+func (c *Config) sessionLayers(user *UserConfig, target *TargetConfig) []*SessionConfig {
+	var layers []*SessionConfig
+	if target != nil {
+		layers = append(layers, &target.SessionConfig)
+	}
+	for _, name := range user.Groups {
+		if group := c.GetGroup(name); group != nil {
+			layers = append(layers, &group.SessionConfig)
+		}
+	}
+	layers = append(layers, &user.SessionConfig)
+	return layers
+}
+
+// resolveShell 合并各层的 shell 配置：命令、自动探测和工作目录以高优先级为准，环境变量逐个覆盖
+func resolveShell(layers []*SessionConfig) ShellConfig {
+	var shell ShellConfig
+	for _, layer := range layers {
+		if layer.Shell == nil {
+			continue
+		}
+		if len(layer.Shell.Command) > 0 {
+			shell.Command = layer.Shell.Command
+			shell.AutoDetect = false
+		}
+		if layer.Shell.AutoDetect {
+			shell.Command = nil
+			shell.AutoDetect = true
+		}
+		if layer.Shell.WorkDir != "" {
+			shell.WorkDir = layer.Shell.WorkDir
+		}
+		for k, v := range layer.Shell.Env {
+			if shell.Env == nil {
+				shell.Env = make(map[string]string)
+			}
+			shell.Env[k] = v
+		}
+	}
+	return shell
+}
@@ -182,3 +182,225 @@ func TestGetUser_EmptyList(t *testing.T) {
 		t.Errorf("Expected nil for empty user list, got %+v", user)
 	}
 }
+
+// TestLoadConfig_TargetsAndGroups 测试加载登录目标和用户组
+func TestLoadConfig_TargetsAndGroups(t *testing.T) {
+	content := `clusters:
+  - name: "dev-cluster"
+    host: "https://dev:6443"
+targets:
+  - name: "dev-app"
+    cluster: "dev-cluster"
+    namespace: "dev"
+    pod: "app-0"
+    shell:
+      autoDetect: true
+groups:
+  - name: "developers"
+    shell:
+      workDir: "/workspace"
+      env:
+        EDITOR: "vim"
+users:
+  - username: "user1"
+    password: "pass1"
+    target: "dev-app"
+    groups: ["developers"]
+    shell:
+      env:
+        EDITOR: "nano"
+`
+	tmpfile, err := os.CreateTemp("", "webhook-config-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	tmpfile.Close()
+
+	config, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	target := config.GetTarget("dev-app")
+	if target == nil {
+		t.Fatal("Expected to find target dev-app, got nil")
+	}
+	if target.Shell == nil || !target.Shell.AutoDetect {
+		t.Error("Expected target shell autoDetect to be set")
+	}
+
+	user := config.GetUser("user1")
+	shell := resolveShell(config.sessionLayers(user, target))
+	if !shell.AutoDetect {
+		t.Error("Expected autoDetect inherited from target")
+	}
+	if shell.WorkDir != "/workspace" {
+		t.Errorf("Expected workDir '/workspace' from group, got '%s'", shell.WorkDir)
+	}
+	if shell.Env["EDITOR"] != "nano" {
+		t.Errorf("Expected user env to override group env, got '%s'", shell.Env["EDITOR"])
+	}
+}
+
+// TestLoadConfig_UnknownReference 测试引用不存在的目标、用户组以及无效的模板
+func TestLoadConfig_UnknownReference(t *testing.T) {
+	contents := []string{
+		`users:
+  - username: "user1"
+    target: "missing"
+`,
+		`users:
+  - username: "user1"
+    groups: ["missing"]
+`,
+		`targets:
+  - name: "t1"
+    cluster: "missing"
+`,
+		`targets:
+  - name: "t1"
+    namespace: "team-{{.Team}}"
+`,
+		`users:
+  - username: "user1"
+    metadata:
+      KUBERNETES_POD_NAMESPACE: "team-${team}"
+`,
+		`users:
+  - username: "user1"
+    metadata:
+      KUBERNETES_POD_NAMESPACE: "team-{{index .Groups 0}}"
+`,
+		`users:
+  - username: "user1"
+    environment:
+      - name: "GIT-TOKEN"
+        value: "abc"
+`,
+		`users:
+  - username: "user1"
+    files:
+      - path: "relative/token"
+        content: "abc"
+`,
+		`users:
+  - username: "user1"
+    commandAllowlist: ["ls \\d+"]
+`,
+		`users:
+  - username: "user1"
+    forcedCommand: "backup.sh"
+    commandAllowlist: ["ls"]
+`,
+		`targets:
+  - name: "t1"
+    motd: "Welcome to {{.Region}}"
+`,
+		`groups:
+  - name: "g1"
+    timeouts:
+      idle: "-5m"
+`,
+		`groups:
+  - name: "g1"
+    features:
+      agentForwarding: true
+`,
+	}
+
+	for _, content := range contents {
+		tmpfile, err := os.CreateTemp("", "webhook-config-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpfile.Name())
+
+		if _, err := tmpfile.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write temp file: %v", err)
+		}
+		tmpfile.Close()
+
+		if _, err := LoadConfig(tmpfile.Name()); err == nil {
+			t.Errorf("Expected error for config:\n%s", content)
+		}
+	}
+}
+
+// TestResolveRoute 测试登录目标与 metadata 的合并
+func TestResolveRoute(t *testing.T) {
+	config := &Config{
+		Targets: []TargetConfig{
+			{Name: "t1", Cluster: "c1", Namespace: "ns1", Pod: "pod1", Container: "app"},
+		},
+	}
+
+	user := &UserConfig{
+		Username: "user1",
+		Target:   "t1",
+		Metadata: map[string]string{"KUBERNETES_POD_NAME": "pod2"},
+	}
+	route, err := config.ResolveRoute(user, userTemplateData(user))
+	if err != nil {
+		t.Fatalf("Failed to resolve route: %v", err)
+	}
+	if route.Cluster != "c1" || route.Namespace != "ns1" || route.Container != "app" {
+		t.Errorf("Expected values from target, got %+v", route)
+	}
+	if route.Pod != "pod2" {
+		t.Errorf("Expected metadata to override pod, got '%s'", route.Pod)
+	}
+
+	// 没有配置目标时只使用 metadata
+	user = &UserConfig{
+		Username: "user2",
+		Metadata: map[string]string{
+			"KUBERNETES_CLUSTER":       "c2",
+			"KUBERNETES_POD_NAMESPACE": "ns2",
+			"KUBERNETES_POD_NAME":      "pod3",
+		},
+	}
+	route, err = config.ResolveRoute(user, userTemplateData(user))
+	if err != nil {
+		t.Fatalf("Failed to resolve route: %v", err)
+	}
+	if route.Target != nil || route.Cluster != "c2" || route.Pod != "pod3" {
+		t.Errorf("Unexpected route: %+v", route)
+	}
+}
+
+// TestResolveRoute_Template 测试目标字段和 metadata 中的模板
+func TestResolveRoute_Template(t *testing.T) {
+	config := &Config{
+		Targets: []TargetConfig{
+			{Name: "team", Cluster: "c1", Namespace: "team-{{.Group}}", Pod: "shell-${user}"},
+		},
+	}
+
+	user := &UserConfig{
+		Username: "alice",
+		Groups:   []string{"payments"},
+		Target:   "team",
+		Metadata: map[string]string{"KUBERNETES_CONTAINER_NAME": "{{dns .KeyComment}}"},
+	}
+	data := userTemplateData(user)
+	data.KeyComment = "Main"
+	route, err := config.ResolveRoute(user, data)
+	if err != nil {
+		t.Fatalf("Failed to resolve route: %v", err)
+	}
+	if route.Namespace != "team-payments" || route.Pod != "shell-alice" || route.Container != "main" {
+		t.Errorf("Unexpected route: %+v", route)
+	}
+
+	// 没有组时 {{index .Groups 0}} 渲染失败
+	user.Metadata = map[string]string{"KUBERNETES_POD_NAME": "{{index .Groups 0}}"}
+	user.Groups = nil
+	if _, err := config.ResolveRoute(user, userTemplateData(user)); err == nil {
+		t.Error("Expected render error, got nil")
+	}
+}
@@ -0,0 +1,211 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ContainerSSHOptions 生成 ContainerSSH config.yaml 时需要的、webhook.yaml 中没有的参数
+type ContainerSSHOptions struct {
+	WebhookURL string        // ContainerSSH 访问 webhook 的地址（留空时根据 listen 推导）
+	SSHListen  string        // SSH 监听地址
+	HostKeys   []string      // SSH host key 文件列表
+	Timeout    time.Duration // 调用 webhook 的超时时间（可选）
+	LogLevel   string        // 日志级别
+	LogFormat  string        // 日志格式
+}
+
+// containerSSHConfig 对应 ContainerSSH config.yaml 中本项目用到的部分
+type containerSSHConfig struct {
+	SSH          containerSSHSSH          `yaml:"ssh"`
+	Auth         containerSSHAuth         `yaml:"auth"`
+	ConfigServer containerSSHConfigServer `yaml:"configserver"`
+	Backend      string                   `yaml:"backend"`
+	Kubernetes   containerSSHKubernetes   `yaml:"kubernetes"`
+	Log          containerSSHLog          `yaml:"log"`
+}
+
+type containerSSHSSH struct {
+	Listen   string   `yaml:"listen"`
+	HostKeys []string `yaml:"hostkeys"`
+	Banner   string   `yaml:"banner,omitempty"`
+}
+
+type containerSSHAuth struct {
+	URL      string              `yaml:"url"`
+	Password bool                `yaml:"password"`
+	PubKey   bool                `yaml:"pubkey"`
+	Timeout  time.Duration       `yaml:"timeout,omitempty"`
+	Authz    *containerSSHMethod `yaml:"authz,omitempty"`
+
+	KeyboardInteractive *containerSSHMethod `yaml:"keyboardInteractive,omitempty"`
+}
+
+// containerSSHMethod 使用 webhook 的认证步骤：authz 调用 <url>/authz，
+// keyboardInteractive 调用 <url>/keyboard-interactive
+type containerSSHMethod struct {
+	Method  string                   `yaml:"method"`
+	Webhook containerSSHConfigServer `yaml:"webhook"`
+}
+
+type containerSSHConfigServer struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+type containerSSHKubernetes struct {
+	Pod      containerSSHPod      `yaml:"pod"`
+	Timeouts containerSSHTimeouts `yaml:"timeouts"`
+}
+
+type containerSSHPod struct {
+	Mode              string `yaml:"mode"`
+	CreateMissingPods bool   `yaml:"createMissingPods"`
+}
+
+type containerSSHTimeouts struct {
+	CommandStart time.Duration `yaml:"commandStart"`
+	Signal       time.Duration `yaml:"signal"`
+	Window       time.Duration `yaml:"window"`
+}
+
+type containerSSHLog struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// containerSSHHeader 写在生成文件开头的说明
+const containerSSHHeader = `# ContainerSSH 配置文件
+# 由 sshhook render-containerssh-config 根据 webhook.yaml 生成，请勿手工修改
+# 文档：https://containerssh.io/reference/
+
+`
+
+// RenderContainerSSHConfig 根据 webhook 配置生成 ContainerSSH 的 config.yaml
+func RenderContainerSSHConfig(c *Config, opts ContainerSSHOptions) ([]byte, error) {
+	// 只启用实际有用户在使用的认证方式
+	var password, pubkey, mfa bool
+	for i := range c.Users {
+		if c.Users[i].Password != "" || c.Users[i].PasswordHash != "" {
+			password = true
+		}
+		if c.Users[i].mfaRequired() {
+			mfa = true
+		}
+		if len(c.Users[i].authorizedKeys()) > 0 {
+			pubkey = true
+		}
+	}
+	if !password && !pubkey && !c.OIDC.enabled() {
+		return nil, errors.New("no user has a password or public key configured")
+	}
+
+	if len(opts.HostKeys) == 0 {
+		return nil, errors.New("at least one host key is required")
+	}
+
+	webhookURL := opts.WebhookURL
+	if webhookURL == "" {
+		var err error
+		if webhookURL, err = webhookURLFromListen(c.Listen); err != nil {
+			return nil, err
+		}
+	}
+	webhookURL = strings.TrimSuffix(webhookURL, "/")
+
+	sshListen := opts.SSHListen
+	if sshListen == "" {
+		sshListen = "0.0.0.0:2222"
+	}
+	logLevel := opts.LogLevel
+	if logLevel == "" {
+		logLevel = "info"
+	}
+	logFormat := opts.LogFormat
+	if logFormat == "" {
+		logFormat = "text"
+	}
+
+	out := containerSSHConfig{
+		SSH: containerSSHSSH{
+			Listen:   sshListen,
+			HostKeys: opts.HostKeys,
+			// 认证前显示，config 接口在认证后才调用，只能写在静态配置中
+			Banner: c.Banner,
+		},
+		Auth: containerSSHAuth{
+			URL:      webhookURL,
+			Password: password,
+			PubKey:   pubkey,
+			Timeout:  opts.Timeout,
+		},
+		ConfigServer: containerSSHConfigServer{
+			URL:     webhookURL + "/config",
+			Timeout: opts.Timeout,
+		},
+		Backend: "kubernetes",
+		Kubernetes: containerSSHKubernetes{
+			// handleConfig 只连接已存在的 pod
+			Pod: containerSSHPod{
+				Mode:              "persistent",
+				CreateMissingPods: false,
+			},
+			Timeouts: containerSSHTimeouts{
+				CommandStart: 60 * time.Second,
+				Signal:       60 * time.Second,
+				Window:       60 * time.Second,
+			},
+		},
+		Log: containerSSHLog{
+			Level:  logLevel,
+			Format: logFormat,
+		},
+	}
+
+	// 只有允许在登录时选择目标时才需要授权步骤
+	if c.selectableTargets() {
+		out.Auth.Authz = &containerSSHMethod{
+			Method:  "webhook",
+			Webhook: containerSSHConfigServer{URL: webhookURL, Timeout: opts.Timeout},
+		}
+	}
+
+	// 配置了 totpSecret 的用户通过 keyboard-interactive 输入验证码，oidc 的设备授权也使用 keyboard-interactive
+	if mfa || c.OIDC.enabled() {
+		out.Auth.KeyboardInteractive = &containerSSHMethod{
+			Method:  "webhook",
+			Webhook: containerSSHConfigServer{URL: webhookURL, Timeout: opts.Timeout},
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(containerSSHHeader)
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(out); err != nil {
+		return nil, fmt.Errorf("failed to encode containerssh config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode containerssh config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// webhookURLFromListen 根据 webhook 监听地址推导 ContainerSSH 访问 webhook 的地址
+func webhookURLFromListen(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %q: %w", listen, err)
+	}
+	// 监听所有地址时，假定 ContainerSSH 与 webhook 部署在同一台机器
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestRenderContainerSSHConfig 测试根据 webhook 配置生成 ContainerSSH 配置
+func TestRenderContainerSSHConfig(t *testing.T) {
+	config := &Config{
+		Listen: ":8080",
+		Users: []UserConfig{
+			{Username: "user1", Password: "pass1"},
+			{Username: "user2", Password: "pass2"},
+		},
+	}
+
+	data, err := RenderContainerSSHConfig(config, ContainerSSHOptions{
+		HostKeys: []string{"ssh_host_rsa_key"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+
+	var out containerSSHConfig
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to parse rendered config: %v", err)
+	}
+
+	if out.Auth.URL != "http://localhost:8080" {
+		t.Errorf("Expected auth url 'http://localhost:8080', got '%s'", out.Auth.URL)
+	}
+	if out.ConfigServer.URL != "http://localhost:8080/config" {
+		t.Errorf("Expected configserver url 'http://localhost:8080/config', got '%s'", out.ConfigServer.URL)
+	}
+	if !out.Auth.Password {
+		t.Error("Expected password auth to be enabled")
+	}
+	if out.Auth.PubKey {
+		t.Error("Expected pubkey auth to be disabled when no user has a public key")
+	}
+	if out.Kubernetes.Pod.Mode != "persistent" {
+		t.Errorf("Expected pod mode 'persistent', got '%s'", out.Kubernetes.Pod.Mode)
+	}
+	if out.SSH.Listen != "0.0.0.0:2222" {
+		t.Errorf("Expected default ssh listen '0.0.0.0:2222', got '%s'", out.SSH.Listen)
+	}
+	if !strings.Contains(string(data), "commandStart: 1m0s") {
+		t.Errorf("Expected timeouts block in rendered config, got:\n%s", data)
+	}
+}
+
+// TestRenderContainerSSHConfig_PublicKeyOnly 测试只有公钥用户时只启用公钥认证
+func TestRenderContainerSSHConfig_PublicKeyOnly(t *testing.T) {
+	config := &Config{
+		Listen: "10.0.0.1:9090",
+		Users: []UserConfig{
+			{Username: "user1", PublicKey: "ssh-ed25519 AAAA user1@example.com"},
+		},
+	}
+
+	data, err := RenderContainerSSHConfig(config, ContainerSSHOptions{
+		HostKeys:   []string{"ssh_host_ed25519_key"},
+		WebhookURL: "http://sshhook.internal:8080/",
+	})
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+
+	var out containerSSHConfig
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to parse rendered config: %v", err)
+	}
+
+	if out.Auth.Password {
+		t.Error("Expected password auth to be disabled")
+	}
+	if !out.Auth.PubKey {
+		t.Error("Expected pubkey auth to be enabled")
+	}
+	if out.ConfigServer.URL != "http://sshhook.internal:8080/config" {
+		t.Errorf("Expected configserver url from option, got '%s'", out.ConfigServer.URL)
+	}
+}
+
+// TestRenderContainerSSHConfig_NoCredentials 测试没有任何认证方式时报错
+func TestRenderContainerSSHConfig_NoCredentials(t *testing.T) {
+	config := &Config{
+		Listen: ":8080",
+		Users:  []UserConfig{{Username: "user1"}},
+	}
+
+	_, err := RenderContainerSSHConfig(config, ContainerSSHOptions{
+		HostKeys: []string{"ssh_host_rsa_key"},
+	})
+	if err == nil {
+		t.Error("Expected error when no user has credentials, got nil")
+	}
+}
+
+// TestWebhookURLFromListen 测试根据监听地址推导 webhook 地址
+func TestWebhookURLFromListen(t *testing.T) {
+	tests := map[string]string{
+		":8080":          "http://localhost:8080",
+		"0.0.0.0:8080":   "http://localhost:8080",
+		"127.0.0.1:9000": "http://127.0.0.1:9000",
+	}
+	for listen, expected := range tests {
+		got, err := webhookURLFromListen(listen)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", listen, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Expected '%s' for %s, got '%s'", expected, listen, got)
+		}
+	}
+
+	if _, err := webhookURLFromListen("invalid"); err == nil {
+		t.Error("Expected error for invalid listen address, got nil")
+	}
+}
+
+// TestRenderContainerSSHConfig_Banner 测试认证前的 banner 写入 ContainerSSH 配置
+func TestRenderContainerSSHConfig_Banner(t *testing.T) {
+	config := &Config{
+		Listen: ":8080",
+		Banner: "Authorized use only.\n",
+		Users:  []UserConfig{{Username: "user1", Password: "pass1"}},
+	}
+
+	data, err := RenderContainerSSHConfig(config, ContainerSSHOptions{HostKeys: []string{"ssh_host_rsa_key"}})
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+
+	var out containerSSHConfig
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to parse rendered config: %v", err)
+	}
+	if out.SSH.Banner != "Authorized use only.\n" {
+		t.Errorf("Expected banner in ssh config, got '%s'", out.SSH.Banner)
+	}
+}
@@ -0,0 +1,257 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"go.containerssh.io/containerssh/metadata"
+)
+
+const (
+	// metadataIdentity 登录方式，oidc 表示通过身份提供方登录，登录目标已经在登录时检查
+	metadataIdentity = "SSHHOOK_IDENTITY"
+	// metadataGroups 通过身份提供方登录、不在 users 中的用户适用的 sshhook 用户组，逗号分隔
+	metadataGroups = "SSHHOOK_GROUPS"
+
+	identityOIDC = "oidc"
+
+	questionContinue = "continue"
+)
+
+// deviceFlow 等待用户在浏览器中完成授权的连接
+type deviceFlow struct {
+	username string
+	auth     *deviceAuthorization
+	expires  time.Time
+}
+
+// oidcState 身份提供方客户端和进行中的设备授权，只保存在内存中
+type oidcState struct {
+	mu       sync.Mutex
+	provider *oidcProvider
+	flows    map[string]deviceFlow // key 为 connectionID
+}
+
+// newOIDCState 创建 oidcState
+func newOIDCState() *oidcState {
+	return &oidcState{flows: make(map[string]deviceFlow)}
+}
+
+// providerFor 返回配置对应的客户端，重新加载后配置变化时重新创建（不再使用旧的缓存）
+func (o *oidcState) providerFor(config OIDCConfig) *oidcProvider {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.provider == nil || !reflect.DeepEqual(o.provider.config, config) {
+		o.provider = newOIDCProvider(config)
+	}
+	return o.provider
+}
+
+// setFlow 记录连接开始的设备授权，同时清理过期的记录
+func (o *oidcState) setFlow(connectionID string, flow deviceFlow, now time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for id, f := range o.flows {
+		if now.After(f.expires) {
+			delete(o.flows, id)
+		}
+	}
+	o.flows[connectionID] = flow
+}
+
+// getFlow 返回连接进行中的设备授权
+func (o *oidcState) getFlow(connectionID, username string, now time.Time) (deviceFlow, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	f, ok := o.flows[connectionID]
+	if !ok || f.username != username || now.After(f.expires) {
+		return deviceFlow{}, false
+	}
+	return f, true
+}
+
+// clearFlow 删除连接的设备授权
+func (o *oidcState) clearFlow(connectionID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.flows, connectionID)
+}
+
+// oidcUser 通过身份提供方登录、不在 users 中的用户，登录目标和用户组来自认证时写入的 metadata
+func (c *Config) oidcUser(username string, md map[string]metadata.Value) *UserConfig {
+	if md[metadataIdentity].Value != identityOIDC || c.GetUser(username) != nil {
+		return nil
+	}
+	user := &UserConfig{Username: username, Target: md[metadataTarget].Value}
+	if groups := md[metadataGroups].Value; groups != "" {
+		user.Groups = strings.Split(groups, ",")
+	}
+	return user
+}
+
+// targetAuthorizedAtLogin 通过身份提供方登录时目标已经按用户组检查过
+func targetAuthorizedAtLogin(md map[string]metadata.Value, target string) bool {
+	return md[metadataIdentity].Value == identityOIDC && md[metadataTarget].Value == target
+}
+
+// handleDeviceFlow 通过 keyboard-interactive 完成设备授权：第一轮返回授权地址和代码，
+// 用户在浏览器中授权后按回车，webhook 换取并检查 ID token，按用户组决定可以访问的目标
+func (s *Server) handleDeviceFlow(w http.ResponseWriter, r *http.Request, req *KeyboardInteractiveRequest, ev AuthEvent, cfg *Config) {
+	ev.Method = "oidc"
+	reject := func(reason string) {
+		log.Printf("[OIDC] ✗ Authentication failed - username=%s: %s", req.Username, reason)
+		s.rejectAuth(w, ev, reason)
+	}
+	// 紧急访问用户只能用本地的 passwordHash 登录，不经过身份提供方，登录审计和通知由 checkBreakGlass 负责
+	if user, _ := cfg.loginUser(req.Username); user != nil && user.BreakGlass {
+		reject("break-glass users must use password authentication")
+		return
+	}
+	now := time.Now()
+	provider := s.oidc.providerFor(cfg.OIDC)
+
+	flow, ok := s.oidc.getFlow(req.ConnectionID, req.Username, now)
+	if len(req.Answers) == 0 {
+		da, err := provider.startDeviceAuthorization(r.Context())
+		if err != nil {
+			log.Printf("[OIDC] Failed to start device authorization: %v", err)
+			reject("device authorization failed")
+			return
+		}
+		expires := now.Add(time.Duration(da.ExpiresIn) * time.Second)
+		if da.ExpiresIn <= 0 {
+			expires = now.Add(mfaPendingTTL)
+		}
+		s.oidc.setFlow(req.ConnectionID, deviceFlow{username: req.Username, auth: da, expires: expires}, now)
+		log.Printf("[OIDC] Device authorization started - username=%s, connectionId=%s", req.Username, req.ConnectionID)
+		s.sendDeviceFlowQuestion(w, da, false)
+		return
+	}
+	if !ok {
+		reject("device authorization not started or expired")
+		return
+	}
+
+	idToken, err := provider.pollToken(r.Context(), flow.auth.DeviceCode)
+	if errors.Is(err, errAuthorizationPending) {
+		s.sendDeviceFlowQuestion(w, flow.auth, true)
+		return
+	}
+	s.oidc.clearFlow(req.ConnectionID)
+	if err != nil {
+		log.Printf("[OIDC] Device authorization failed for %s: %v", req.Username, err)
+		reject("device authorization failed")
+		return
+	}
+	claims, err := provider.verifyIDToken(r.Context(), idToken, now)
+	if err != nil {
+		log.Printf("[OIDC] Invalid id token for %s: %v", req.Username, err)
+		reject("invalid id token")
+		return
+	}
+
+	name, target := cfg.splitLogin(req.Username)
+	identity, err := cfg.OIDC.identity(claims)
+	if err != nil {
+		log.Printf("[OIDC] Invalid identity for %s: %v", req.Username, err)
+		reject("invalid identity")
+		return
+	}
+	if identity != name {
+		reject("identity does not match login name")
+		return
+	}
+	ev.Target = target
+	targets, groups := cfg.OIDC.mapGroups(claimStrings(claims, cfg.OIDC.groupsClaim()))
+
+	md := make(map[string]string)
+	user := cfg.GetUser(name)
+	if user != nil {
+		// users 中的用户：使用用户自己的配置，身份提供方的用户组可以增加可选的目标
+		if user.expired(now) {
+			reject("access expired")
+			return
+		}
+		if user.Locked {
+			reject("user locked")
+			return
+		}
+		if target != "" && !contains(append(cfg.allowedTargets(user), targets...), target) {
+			reject(fmt.Sprintf("target not allowed: %s", target))
+			return
+		}
+		if md, err = renderMetadata(user.Metadata, newTemplateData(user, req.ConnectionAuthPendingMetadata, user.Username)); err != nil {
+			log.Printf("[OIDC] Failed to render metadata for user %s: %v", name, err)
+			reject("failed to render metadata")
+			return
+		}
+		if md == nil {
+			md = make(map[string]string)
+		}
+	} else {
+		// 不在 users 中的用户：只能访问用户组对应的目标，默认第一个
+		if len(targets) == 0 {
+			reject("no target mapped for groups")
+			return
+		}
+		if target == "" {
+			target = targets[0]
+		} else if !contains(targets, target) {
+			reject(fmt.Sprintf("target not allowed: %s", target))
+			return
+		}
+		if len(groups) > 0 {
+			md[metadataGroups] = strings.Join(groups, ",")
+		}
+	}
+	md[metadataIdentity] = identityOIDC
+	if target != "" {
+		md[metadataTarget] = target
+	}
+	if user == nil {
+		user = cfg.oidcUser(name, toMetadataValues(md))
+	}
+	data := newTemplateData(user, req.ConnectionAuthPendingMetadata, name)
+	if reason := cfg.checkPolicies(cfg.newPolicyInput(policyStageAuth, user, data, target, md)); reason != "" {
+		reject(reason)
+		return
+	}
+
+	log.Printf("[OIDC] ✓ Authentication successful - username=%s, target=%s, groups=%v", name, target, groups)
+	ev.Target = target
+	ev.Success = true
+	s.events.add(ev)
+	s.sendAuthResponse(w, true, name, user, md)
+}
+
+// sendDeviceFlowQuestion 返回授权地址和代码，用户授权后按回车继续
+func (s *Server) sendDeviceFlowQuestion(w http.ResponseWriter, da *deviceAuthorization, pending bool) {
+	instruction := fmt.Sprintf("Open %s and enter the code %s", da.VerificationURI, da.UserCode)
+	if da.VerificationURIComplete != "" {
+		instruction = fmt.Sprintf("Open %s (code %s)", da.VerificationURIComplete, da.UserCode)
+	}
+	question := "Press Enter after approving the login in your browser: "
+	if pending {
+		question = "Login not approved yet, press Enter after approving: "
+	}
+	resp := KeyboardInteractiveResponse{
+		Instruction: instruction,
+		Questions:   []KeyboardInteractiveQuestion{{ID: questionContinue, Question: question, EchoResponse: true}},
+	}
+	writeKeyboardInteractive(w, resp)
+}
+
+// toMetadataValues 把字符串 metadata 转换为 ContainerSSH 的格式
+func toMetadataValues(md map[string]string) map[string]metadata.Value {
+	values := make(map[string]metadata.Value, len(md))
+	for k, v := range md {
+		values[k] = metadata.Value{Value: v}
+	}
+	return values
+}
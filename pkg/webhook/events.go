@@ -0,0 +1,88 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// defaultEventBuffer 默认保留的认证事件数
+const defaultEventBuffer = 1000
+
+// AuthEvent 一次认证的结果
+type AuthEvent struct {
+	Time          time.Time `json:"time"`
+	Username      string    `json:"username"`
+	Method        string    `json:"method"` // password、publickey 或 authz
+	RemoteAddress string    `json:"remoteAddress,omitempty"`
+	ConnectionID  string    `json:"connectionId,omitempty"`
+	Target        string    `json:"target,omitempty"` // 登录时选择的目标
+	Success       bool      `json:"success"`
+	Reason        string    `json:"reason,omitempty"` // 失败原因
+}
+
+// newAuthEvent 根据认证请求生成事件
+func newAuthEvent(method string, md metadata.ConnectionAuthPendingMetadata) AuthEvent {
+	ev := AuthEvent{
+		Time:         time.Now(),
+		Username:     md.Username,
+		Method:       method,
+		ConnectionID: md.ConnectionID,
+	}
+	if md.RemoteAddress.IP != nil {
+		ev.RemoteAddress = md.RemoteAddress.IP.String()
+	}
+	return ev
+}
+
+// authEventLog 保存最近的认证事件，超过容量时覆盖最早的事件
+type authEventLog struct {
+	mu     sync.Mutex
+	events []AuthEvent
+	next   int
+	full   bool
+}
+
+// newAuthEventLog 创建认证事件记录，size 不大于 0 时使用默认容量
+func newAuthEventLog(size int) *authEventLog {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+	return &authEventLog{events: make([]AuthEvent, size)}
+}
+
+// add 记录事件
+func (l *authEventLog) add(ev AuthEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.events[l.next] = ev
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list 按时间从新到旧返回事件，username 不为空时只返回该用户的事件，limit 不大于 0 时不限制数量
+func (l *authEventLog) list(username string, limit int) []AuthEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.events)
+	}
+	result := []AuthEvent{}
+	for i := 1; i <= count; i++ {
+		ev := l.events[(l.next-i+len(l.events))%len(l.events)]
+		if username != "" && ev.Username != username {
+			continue
+		}
+		result = append(result, ev)
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,34 @@
+package webhook
+
+import (
+	"testing"
+)
+
+// TestAuthEventLog 测试认证事件按时间从新到旧返回，超过容量时覆盖最早的事件
+func TestAuthEventLog(t *testing.T) {
+	l := newAuthEventLog(3)
+	for _, name := range []string{"a", "b", "a", "c"} {
+		l.add(AuthEvent{Username: name})
+	}
+
+	got := l.list("", 0)
+	want := []string{"c", "a", "b"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d events, got %d", len(want), len(got))
+	}
+	for i, name := range want {
+		if got[i].Username != name {
+			t.Errorf("Expected event %d to be %s, got %s", i, name, got[i].Username)
+		}
+	}
+
+	if got := l.list("a", 0); len(got) != 1 {
+		t.Errorf("Expected 1 event for user a, got %d", len(got))
+	}
+	if got := l.list("", 2); len(got) != 2 || got[0].Username != "c" {
+		t.Errorf("Expected 2 latest events, got %+v", got)
+	}
+	if got := newAuthEventLog(0).list("", 0); len(got) != 0 {
+		t.Errorf("Expected no events, got %d", len(got))
+	}
+}
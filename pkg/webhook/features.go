@@ -0,0 +1,100 @@
+package webhook
+
+import (
+	"fmt"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// sftpSubsystem SFTP 子系统名称
+const sftpSubsystem = "sftp"
+
+// FeaturesConfig SSH 功能开关，可以设置在目标、组和用户上，优先级依次升高
+// 未设置的项沿用低优先级的配置，全部未设置时使用 ContainerSSH 的默认行为（允许）
+type FeaturesConfig struct {
+	Shell            *bool `yaml:"shell,omitempty"`            // 交互式 shell
+	Exec             *bool `yaml:"exec,omitempty"`             // 执行任意命令，如 ssh host ls
+	SFTP             *bool `yaml:"sftp,omitempty"`             // SFTP 子系统（scp 也依赖 SFTP）
+	Subsystems       *bool `yaml:"subsystems,omitempty"`       // SFTP 以外的子系统
+	LocalForwarding  *bool `yaml:"localForwarding,omitempty"`  // 本地端口和 socket 转发（ssh -L）
+	RemoteForwarding *bool `yaml:"remoteForwarding,omitempty"` // 远程端口和 socket 转发（ssh -R）
+	X11Forwarding    *bool `yaml:"x11Forwarding,omitempty"`    // X11 转发
+	AgentForwarding  *bool `yaml:"agentForwarding,omitempty"`  // SSH agent 转发，ContainerSSH 不支持，只能不设置或设置为 false
+}
+
+// validate 检查功能开关，ContainerSSH 不支持 agent 转发，开启时加载失败而不是静默拒绝
+func (f *FeaturesConfig) validate() error {
+	if f != nil && f.AgentForwarding != nil && *f.AgentForwarding {
+		return fmt.Errorf("agentForwarding is not supported by ContainerSSH")
+	}
+	return nil
+}
+
+// resolveFeatures 合并各层的功能开关，每一项以设置了该项的最高优先级为准
+func resolveFeatures(layers []*SessionConfig) FeaturesConfig {
+	var features FeaturesConfig
+	for _, layer := range layers {
+		f := layer.Features
+		if f == nil {
+			continue
+		}
+		for _, pair := range []struct{ dst, src **bool }{
+			{&features.Shell, &f.Shell},
+			{&features.Exec, &f.Exec},
+			{&features.SFTP, &f.SFTP},
+			{&features.Subsystems, &f.Subsystems},
+			{&features.LocalForwarding, &f.LocalForwarding},
+			{&features.RemoteForwarding, &f.RemoteForwarding},
+			{&features.X11Forwarding, &f.X11Forwarding},
+			{&features.AgentForwarding, &f.AgentForwarding},
+		} {
+			if *pair.src != nil {
+				*pair.dst = *pair.src
+			}
+		}
+	}
+	return features
+}
+
+// executionPolicy 把开关转换为 ContainerSSH 的执行策略，未设置时不配置
+func executionPolicy(enabled *bool) config.SecurityExecutionPolicy {
+	switch {
+	case enabled == nil:
+		return config.ExecutionPolicyUnconfigured
+	case *enabled:
+		return config.ExecutionPolicyEnable
+	default:
+		return config.ExecutionPolicyDisable
+	}
+}
+
+// securityConfig 根据功能开关生成 ContainerSSH 的 security 配置
+// agent 转发 ContainerSSH 本身不支持，不需要配置
+func securityConfig(f FeaturesConfig) config.SecurityConfig {
+	var sec config.SecurityConfig
+	sec.Shell.Mode = executionPolicy(f.Shell)
+	sec.Command.Mode = executionPolicy(f.Exec)
+
+	// SFTP 和其他子系统分开控制时使用 filter 模式
+	sftp, other := executionPolicy(f.SFTP), executionPolicy(f.Subsystems)
+	switch {
+	case sftp == other:
+		sec.Subsystem.Mode = sftp
+	case sftp == config.ExecutionPolicyDisable:
+		sec.Subsystem.Mode = config.ExecutionPolicyFilter
+		sec.Subsystem.Deny = []string{sftpSubsystem}
+	case other == config.ExecutionPolicyDisable:
+		sec.Subsystem.Mode = config.ExecutionPolicyFilter
+		sec.Subsystem.Allow = []string{sftpSubsystem}
+	default:
+		// 一项允许、另一项未设置，未设置时默认也是允许
+		sec.Subsystem.Mode = config.ExecutionPolicyEnable
+	}
+
+	sec.Forwarding.ForwardingMode = executionPolicy(f.LocalForwarding)
+	sec.Forwarding.SocketForwardingMode = executionPolicy(f.LocalForwarding)
+	sec.Forwarding.ReverseForwardingMode = executionPolicy(f.RemoteForwarding)
+	sec.Forwarding.SocketListenMode = executionPolicy(f.RemoteForwarding)
+	sec.Forwarding.X11ForwardingMode = executionPolicy(f.X11Forwarding)
+	return sec
+}
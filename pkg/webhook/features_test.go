@@ -0,0 +1,89 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// boolPtr 返回指向 v 的指针
+func boolPtr(v bool) *bool {
+	return &v
+}
+
+// TestResolveFeatures 测试功能开关按层合并
+func TestResolveFeatures(t *testing.T) {
+	layers := []*SessionConfig{
+		{Features: &FeaturesConfig{Shell: boolPtr(false), Exec: boolPtr(false), X11Forwarding: boolPtr(false)}},
+		{},
+		{Features: &FeaturesConfig{Shell: boolPtr(true), LocalForwarding: boolPtr(false)}},
+	}
+
+	f := resolveFeatures(layers)
+	if f.Shell == nil || !*f.Shell {
+		t.Error("Expected user layer to enable shell")
+	}
+	if f.Exec == nil || *f.Exec {
+		t.Error("Expected target layer to disable exec")
+	}
+	if f.LocalForwarding == nil || *f.LocalForwarding {
+		t.Error("Expected local forwarding to be disabled")
+	}
+	if f.SFTP != nil || f.RemoteForwarding != nil {
+		t.Errorf("Expected unset features to stay nil, got %+v", f)
+	}
+}
+
+// TestSecurityConfig 测试功能开关转换为 ContainerSSH 的 security 配置
+func TestSecurityConfig(t *testing.T) {
+	sec := securityConfig(FeaturesConfig{})
+	if !reflect.DeepEqual(sec, config.SecurityConfig{}) {
+		t.Errorf("Expected empty security config, got %+v", sec)
+	}
+
+	sec = securityConfig(FeaturesConfig{
+		Shell:            boolPtr(true),
+		Exec:             boolPtr(false),
+		LocalForwarding:  boolPtr(false),
+		RemoteForwarding: boolPtr(true),
+		X11Forwarding:    boolPtr(false),
+	})
+	if sec.Shell.Mode != config.ExecutionPolicyEnable || sec.Command.Mode != config.ExecutionPolicyDisable {
+		t.Errorf("Unexpected shell/command modes: %+v", sec)
+	}
+	fwd := sec.Forwarding
+	if fwd.ForwardingMode != config.ExecutionPolicyDisable || fwd.SocketForwardingMode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected local forwarding to be disabled, got %+v", fwd)
+	}
+	if fwd.ReverseForwardingMode != config.ExecutionPolicyEnable || fwd.SocketListenMode != config.ExecutionPolicyEnable {
+		t.Errorf("Expected remote forwarding to be enabled, got %+v", fwd)
+	}
+	if fwd.X11ForwardingMode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected X11 forwarding to be disabled, got %+v", fwd)
+	}
+}
+
+// TestSecurityConfig_Subsystems 测试 SFTP 和其他子系统的组合
+func TestSecurityConfig_Subsystems(t *testing.T) {
+	tests := []struct {
+		sftp, other *bool
+		mode        config.SecurityExecutionPolicy
+		allow, deny []string
+	}{
+		{nil, nil, config.ExecutionPolicyUnconfigured, nil, nil},
+		{boolPtr(false), boolPtr(false), config.ExecutionPolicyDisable, nil, nil},
+		{boolPtr(false), nil, config.ExecutionPolicyFilter, nil, []string{"sftp"}},
+		{boolPtr(true), boolPtr(false), config.ExecutionPolicyFilter, []string{"sftp"}, nil},
+		{nil, boolPtr(false), config.ExecutionPolicyFilter, []string{"sftp"}, nil},
+		{boolPtr(true), nil, config.ExecutionPolicyEnable, nil, nil},
+	}
+	for i, tt := range tests {
+		sec := securityConfig(FeaturesConfig{SFTP: tt.sftp, Subsystems: tt.other})
+		if sec.Subsystem.Mode != tt.mode ||
+			!reflect.DeepEqual(sec.Subsystem.Allow, tt.allow) ||
+			!reflect.DeepEqual(sec.Subsystem.Deny, tt.deny) {
+			t.Errorf("Case %d: unexpected subsystem config %+v", i, sec.Subsystem)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// 构建信息，通过 -ldflags "-X github.com/xjdrew/sshproxy/pkg/webhook.Version=..." 设置
+var (
+	Version   = "dev" // 版本号
+	Commit    = ""    // git commit，未设置时使用 Go 记录的 vcs.revision
+	BuildDate = ""    // 构建时间
+)
+
+// defaultReadinessTimeout 检查集群 API Server 的默认超时时间
+const defaultReadinessTimeout = 3 * time.Second
+
+// ReadinessConfig 就绪检查配置
+type ReadinessConfig struct {
+	CheckClusters bool          `yaml:"checkClusters,omitempty"` // 是否检查所有集群的 API Server 可以访问
+	Timeout       time.Duration `yaml:"timeout,omitempty"`       // 检查集群的超时时间，所有集群并发检查，默认 3s
+}
+
+// clusterProber 检查集群的 API Server 是否可以访问
+type clusterProber interface {
+	// ProbeCluster 访问集群 API Server 的 /readyz
+	ProbeCluster(ctx context.Context, cluster *ClusterConfig) error
+}
+
+// readyzProber 通过 client-go 访问 API Server 的 /readyz
+type readyzProber struct {
+	clients *kubeClients
+}
+
+// ProbeCluster 实现 clusterProber
+func (p *readyzProber) ProbeCluster(ctx context.Context, cluster *ClusterConfig) error {
+	client, err := p.clients.get(cluster)
+	if err != nil {
+		return err
+	}
+	return client.Discovery().RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+}
+
+// VersionInfo /version 接口的响应
+type VersionInfo struct {
+	Version      string     `json:"version"`
+	Commit       string     `json:"commit,omitempty"`
+	BuildDate    string     `json:"buildDate,omitempty"`
+	GoVersion    string     `json:"goVersion"`
+	ConfigHash   string     `json:"configHash,omitempty"`   // 配置文件内容的 SHA-256
+	ConfigLoaded *time.Time `json:"configLoaded,omitempty"` // 配置加载时间
+}
+
+// buildCommit 返回构建时的 git commit
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// handleHealthz 存活检查，进程能够处理请求即返回 200
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz 就绪检查，配置未加载、最近一次重新加载失败或集群不可访问时返回 503
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(r.Context()); err != nil {
+		log.Printf("[Readyz] Not ready: %v", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// ready 检查服务是否可以处理请求
+func (s *Server) ready(ctx context.Context) error {
+	s.mu.RLock()
+	cfg, reloadErr := s.config, s.reloadErr
+	s.mu.RUnlock()
+
+	if cfg == nil {
+		return fmt.Errorf("config not loaded")
+	}
+	if reloadErr != nil {
+		return fmt.Errorf("config reload failed: %w", reloadErr)
+	}
+	if !cfg.Readiness.CheckClusters {
+		return nil
+	}
+
+	timeout := cfg.Readiness.Timeout
+	if timeout == 0 {
+		timeout = defaultReadinessTimeout
+	}
+	// 并发检查所有集群，共用一个超时时间，避免集群较多时探针超时
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	errs := make([]error, len(cfg.Clusters))
+	var wg sync.WaitGroup
+	for i := range cfg.Clusters {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = s.clusterProber.ProbeCluster(ctx, &cfg.Clusters[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("cluster %s is not reachable: %w", cfg.Clusters[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// handleVersion 返回构建信息以及当前配置的哈希和加载时间
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	info := VersionInfo{
+		Version:   Version,
+		Commit:    buildCommit(),
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+	if cfg := s.currentConfig(); cfg != nil && !cfg.loadedAt.IsZero() {
+		info.ConfigHash = cfg.hash
+		info.ConfigLoaded = &cfg.loadedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("[Version] Failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClusterProber 测试用的集群检查，unreachable 中的集群返回错误
+type fakeClusterProber struct {
+	unreachable map[string]bool
+	hang        bool // 为 true 时等待 ctx 结束
+}
+
+func (p *fakeClusterProber) ProbeCluster(ctx context.Context, cluster *ClusterConfig) error {
+	if p.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if p.unreachable[cluster.Name] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+// getStatus 调用 GET 接口并返回状态码
+func getStatus(handler http.HandlerFunc) int {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	return rec.Code
+}
+
+// TestHandleHealthz 测试存活检查
+func TestHandleHealthz(t *testing.T) {
+	server, err := NewServer(createTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if code := getStatus(server.handleHealthz); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+}
+
+// TestHandleReadyz 测试就绪检查
+func TestHandleReadyz(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}, {Name: "c2"}}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	prober := &fakeClusterProber{unreachable: map[string]bool{"c2": true}}
+	server.clusterProber = prober
+
+	// 默认不检查集群
+	if code := getStatus(server.handleReadyz); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+
+	cfg.Readiness.CheckClusters = true
+	if code := getStatus(server.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 with unreachable cluster, got %d", code)
+	}
+	prober.unreachable = nil
+	if code := getStatus(server.handleReadyz); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+
+	// 重新加载失败后未就绪，成功加载后恢复
+	server.ReloadFailed(errors.New("invalid config"))
+	if code := getStatus(server.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 after failed reload, got %d", code)
+	}
+	server.Reload(cfg)
+	if code := getStatus(server.handleReadyz); code != http.StatusOK {
+		t.Errorf("Expected status 200 after reload, got %d", code)
+	}
+
+	server.config = nil
+	if code := getStatus(server.handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without config, got %d", code)
+	}
+}
+
+// TestReadyParallel 测试所有集群共用一个超时时间并发检查
+func TestReadyParallel(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}, {Name: "c2"}, {Name: "c3"}, {Name: "c4"}}
+	cfg.Readiness = ReadinessConfig{CheckClusters: true, Timeout: 200 * time.Millisecond}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.clusterProber = &fakeClusterProber{hang: true}
+
+	start := time.Now()
+	err = server.ready(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cluster c1") {
+		t.Errorf("Expected c1 to be reported unreachable, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*cfg.Readiness.Timeout {
+		t.Errorf("Expected clusters to be probed in parallel, took %v", elapsed)
+	}
+}
+
+// TestHandleVersion 测试构建信息和配置哈希
+func TestHandleVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	content := "users:\n  - username: \"user1\"\n    password: \"pass1\"\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.handleVersion(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	var info VersionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if info.Version != Version || info.GoVersion == "" {
+		t.Errorf("Unexpected build info: %+v", info)
+	}
+	// 配置文件内容的 sha256sum
+	if info.ConfigHash != "94cbf19e2c4b293f6904f828a5177742d6102e134bbcff859f84319b1ff361aa" {
+		t.Errorf("Unexpected config hash: %s", info.ConfigHash)
+	}
+	if info.ConfigLoaded == nil || !info.ConfigLoaded.Equal(cfg.loadedAt) {
+		t.Errorf("Expected config load time %s, got %v", cfg.loadedAt, info.ConfigLoaded)
+	}
+}
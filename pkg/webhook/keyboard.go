@@ -0,0 +1,172 @@
+package webhook
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"go.containerssh.io/containerssh/auth"
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// keyboard-interactive 问题的 ID
+const (
+	questionPassword = "password"
+	questionCode     = "code"
+)
+
+// KeyboardInteractiveQuestion keyboard-interactive 认证中向客户端提出的问题
+type KeyboardInteractiveQuestion struct {
+	ID           string `json:"id"`
+	Question     string `json:"question"`
+	EchoResponse bool   `json:"echoResponse"`
+}
+
+// KeyboardInteractiveRequest keyboard-interactive 接口的请求。第一次请求没有 answers，
+// 接口返回需要回答的问题；之后按问题 ID 带上客户端的回答。
+// 发布的 ContainerSSH 版本没有导出这组类型，JSON 字段由 TestKeyboardInteractive_WireFormat 固定
+type KeyboardInteractiveRequest struct {
+	metadata.ConnectionAuthPendingMetadata `json:",inline"`
+	Answers                                map[string]string `json:"answers,omitempty"`
+}
+
+// KeyboardInteractiveResponse keyboard-interactive 接口的响应，questions 不为空时需要继续回答
+type KeyboardInteractiveResponse struct {
+	auth.ResponseBody `json:",inline"`
+	Instruction       string                        `json:"instruction,omitempty"`
+	Questions         []KeyboardInteractiveQuestion `json:"questions,omitempty"`
+}
+
+// handleKeyboardInteractive 处理 keyboard-interactive 认证，用于 TOTP 第二因素：
+// 连接已经通过密码或公钥认证时只询问验证码，否则同时询问密码和验证码。
+// 配置了 oidc 时，没有 totpSecret 的用户通过设备授权登录
+func (s *Server) handleKeyboardInteractive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		log.Printf("[Keyboard Interactive] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req KeyboardInteractiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Printf("[Keyboard Interactive] Failed to decode request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("[Keyboard Interactive] Request received - username=%s, remoteAddress=%s, connectionId=%s, answers=%d",
+		req.Username, req.RemoteAddress, req.ConnectionID, len(req.Answers))
+	ev := newAuthEvent("keyboard-interactive", req.ConnectionAuthPendingMetadata)
+	reject := func(reason string) {
+		log.Printf("[Keyboard Interactive] ✗ Authentication failed - username=%s: %s", req.Username, reason)
+		s.rejectAuth(w, ev, reason)
+	}
+
+	cfg := s.currentConfig()
+	user, target := cfg.loginUser(req.Username)
+	// 没有配置 TOTP 的用户通过身份提供方登录
+	if cfg.OIDC.enabled() && (user == nil || !user.mfaRequired()) {
+		s.handleDeviceFlow(w, r, &req, ev, cfg)
+		return
+	}
+	if user == nil {
+		reject("user not found")
+		return
+	}
+	now := time.Now()
+	if user.expired(now) {
+		reject("access expired")
+		return
+	}
+	if user.Locked {
+		reject("user locked")
+		return
+	}
+	if !user.mfaRequired() {
+		reject("no second factor configured")
+		return
+	}
+
+	md, firstFactor := s.mfa.getPending(req.ConnectionID, user.Username, now)
+	if !firstFactor && user.Password == "" && user.PasswordHash == "" {
+		reject("public key authentication required before verification code")
+		return
+	}
+
+	// 第一次请求：返回需要回答的问题
+	if len(req.Answers) == 0 {
+		var questions []KeyboardInteractiveQuestion
+		if !firstFactor {
+			questions = append(questions, KeyboardInteractiveQuestion{ID: questionPassword, Question: "Password: "})
+		}
+		questions = append(questions, KeyboardInteractiveQuestion{ID: questionCode, Question: "Verification code: ", EchoResponse: true})
+		writeKeyboardInteractive(w, KeyboardInteractiveResponse{Questions: questions})
+		return
+	}
+
+	if s.mfa.locked(user.Username, now) {
+		reject("too many invalid verification codes")
+		return
+	}
+	if !firstFactor {
+		if !user.checkPassword(req.Answers[questionPassword]) {
+			reject("invalid password")
+			return
+		}
+		data := newTemplateData(user, req.ConnectionAuthPendingMetadata, user.Username)
+		var err error
+		md, err = renderMetadata(user.Metadata, data)
+		if err != nil {
+			log.Printf("[Keyboard Interactive] Failed to render metadata for user %s: %v", req.Username, err)
+			reject("failed to render metadata")
+			return
+		}
+		// 第一因素在密码或公钥认证中通过时已经检查过访问策略
+		if reason := cfg.checkPolicies(cfg.newPolicyInput(policyStageAuth, user, data, target, md)); reason != "" {
+			reject(reason)
+			return
+		}
+	}
+
+	secret, err := cfg.MFA.decryptTOTPSecret(user.Username, user.TOTPSecret)
+	if err != nil {
+		log.Printf("[Keyboard Interactive] Failed to decrypt totpSecret for user %s: %v", user.Username, err)
+		reject("invalid totp secret")
+		return
+	}
+	counter, ok := verifyTOTP(secret, req.Answers[questionCode], now, cfg.MFA.skew())
+	if !ok {
+		if s.mfa.addFailure(user.Username, cfg.MFA.maxAttempts(), cfg.MFA.lockout(), now) {
+			log.Printf("[Keyboard Interactive] Verification code locked for user %s for %v", user.Username, cfg.MFA.lockout())
+		}
+		reject("invalid verification code")
+		return
+	}
+	if !s.mfa.useCounter(user.Username, counter) {
+		reject("verification code already used")
+		return
+	}
+	s.mfa.clearFailures(user.Username)
+	s.mfa.clearPending(req.ConnectionID)
+
+	log.Printf("[Keyboard Interactive] ✓ Authentication successful - username=%s, firstFactor=%v", req.Username, firstFactor)
+	ev.Target = target
+	ev.Success = true
+	s.events.add(ev)
+	s.sendAuthResponse(w, true, user.Username, user, md)
+}
+
+// requireSecondFactor 第一因素通过后记录连接并拒绝本次认证，客户端继续使用 keyboard-interactive 输入验证码
+func (s *Server) requireSecondFactor(w http.ResponseWriter, ev AuthEvent, username string, md map[string]string) {
+	s.mfa.setPending(ev.ConnectionID, username, md, time.Now())
+	s.rejectAuth(w, ev, "second factor required")
+}
+
+// writeKeyboardInteractive 返回需要客户端回答的问题
+func writeKeyboardInteractive(w http.ResponseWriter, resp KeyboardInteractiveResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("[Keyboard Interactive] Failed to encode response: %v", err)
+	}
+}
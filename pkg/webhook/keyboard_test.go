@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go.containerssh.io/containerssh/auth"
+	"gopkg.in/yaml.v3"
+)
+
+// mfaTestServer testuser 配置了密码、公钥和 TOTP 密钥，返回服务器、公钥和 TOTP 密钥
+func mfaTestServer(t *testing.T) (*Server, string, []byte) {
+	t.Helper()
+	cfg := createTestConfig()
+	key, _ := testAuthorizedKey(t, "testuser@laptop")
+	cfg.Users[0].PublicKey = key
+	cfg.MFA.EncryptionKey = "mfa-key"
+	secret := []byte("12345678901234567890")
+	encrypted, err := cfg.MFA.encryptTOTPSecret("testuser", secret)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	cfg.Users[0].TOTPSecret = encrypted
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return server, key, secret
+}
+
+// keyboardInteractive 调用 keyboard-interactive 接口
+func keyboardInteractive(t *testing.T, server *Server, connectionID string, answers map[string]string) KeyboardInteractiveResponse {
+	t.Helper()
+	var req KeyboardInteractiveRequest
+	req.Username = "testuser"
+	req.ConnectionID = connectionID
+	req.Answers = answers
+	rec := postJSON(t, server.handleKeyboardInteractive, req)
+	var resp KeyboardInteractiveResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// questionIDs 返回问题的 ID 列表
+func questionIDs(questions []KeyboardInteractiveQuestion) string {
+	var ids []string
+	for _, q := range questions {
+		ids = append(ids, q.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+// TestKeyboardInteractive_AfterPublicKey 测试公钥认证通过后只需要输入验证码，公钥注释保留在 metadata 中
+func TestKeyboardInteractive_AfterPublicKey(t *testing.T) {
+	server, key, secret := mfaTestServer(t)
+
+	var req auth.PublicKeyAuthRequest
+	req.Username = "testuser"
+	req.ConnectionID = "conn-1"
+	req.PublicKey.PublicKey = key
+	rec := postJSON(t, server.handlePublicKeyAuth, req)
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Success {
+		t.Fatal("Expected public key alone to be insufficient")
+	}
+	if reason := server.lastAuthReason(); reason != "second factor required" {
+		t.Errorf("Expected reason 'second factor required', got %q", reason)
+	}
+
+	questions := keyboardInteractive(t, server, "conn-1", nil)
+	if questions.Success || questionIDs(questions.Questions) != questionCode {
+		t.Fatalf("Expected only the verification code question, got %+v", questions)
+	}
+
+	code := totpCode(secret, totpCounter(time.Now()))
+	result := keyboardInteractive(t, server, "conn-1", map[string]string{questionCode: code})
+	if !result.Success || result.AuthenticatedUsername != "testuser" {
+		t.Fatalf("Expected success, got %+v", result)
+	}
+	if result.Metadata[metadataKeyComment].Value != "testuser@laptop" {
+		t.Errorf("Expected key comment from the first factor, got %v", result.Metadata)
+	}
+
+	// 同一个验证码不能再次使用
+	postJSON(t, server.handlePublicKeyAuth, req)
+	if replay := keyboardInteractive(t, server, "conn-1", map[string]string{questionCode: code}); replay.Success {
+		t.Error("Expected replayed code to be rejected")
+	}
+	if reason := server.lastAuthReason(); reason != "verification code already used" {
+		t.Errorf("Expected replay reason, got %q", reason)
+	}
+}
+
+// TestKeyboardInteractive_Password 测试没有通过第一因素时同时询问密码和验证码
+func TestKeyboardInteractive_Password(t *testing.T) {
+	server, _, secret := mfaTestServer(t)
+
+	questions := keyboardInteractive(t, server, "conn-2", nil)
+	if questionIDs(questions.Questions) != questionPassword+","+questionCode {
+		t.Fatalf("Expected password and code questions, got %+v", questions.Questions)
+	}
+
+	code := totpCode(secret, totpCounter(time.Now()))
+	if resp := keyboardInteractive(t, server, "conn-2", map[string]string{questionPassword: "wrong", questionCode: code}); resp.Success {
+		t.Error("Expected wrong password to be rejected")
+	}
+	if resp := keyboardInteractive(t, server, "conn-2", map[string]string{questionPassword: "testpass", questionCode: "000000"}); resp.Success {
+		t.Error("Expected wrong code to be rejected")
+	}
+	resp := keyboardInteractive(t, server, "conn-2", map[string]string{questionPassword: "testpass", questionCode: code})
+	if !resp.Success || resp.Metadata["KUBERNETES_POD_NAME"].Value != "test-pod" {
+		t.Errorf("Expected success with user metadata, got %+v", resp)
+	}
+
+	// 密码认证通过后同样需要验证码
+	if passwordAuth(t, server, "testuser", "testpass") {
+		t.Error("Expected password alone to be insufficient")
+	}
+}
+
+// TestKeyboardInteractive_Lockout 测试连续输入错误验证码后锁定，锁定期间正确的验证码也被拒绝
+func TestKeyboardInteractive_Lockout(t *testing.T) {
+	server, _, secret := mfaTestServer(t)
+	server.currentConfig().MFA.MaxAttempts = 2
+
+	for i := 0; i < 2; i++ {
+		if resp := keyboardInteractive(t, server, "conn-4", map[string]string{questionPassword: "testpass", questionCode: "000000"}); resp.Success {
+			t.Fatal("Expected wrong code to be rejected")
+		}
+	}
+	code := totpCode(secret, totpCounter(time.Now()))
+	if resp := keyboardInteractive(t, server, "conn-4", map[string]string{questionPassword: "testpass", questionCode: code}); resp.Success {
+		t.Error("Expected locked user to be rejected")
+	}
+	if reason := server.lastAuthReason(); reason != "too many invalid verification codes" {
+		t.Errorf("Expected lockout reason, got %q", reason)
+	}
+}
+
+// TestKeyboardInteractive_WireFormat 测试请求和响应的 JSON 字段与 ContainerSSH 的 webhook 格式一致
+func TestKeyboardInteractive_WireFormat(t *testing.T) {
+	var req KeyboardInteractiveRequest
+	data := `{"connectionId":"c1","remoteAddress":"10.0.0.1:2222","clientVersion":"SSH-2.0-OpenSSH_9.6","username":"alice","answers":{"code":"123456"}}`
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		t.Fatalf("Failed to decode request: %v", err)
+	}
+	if req.ConnectionID != "c1" || req.Username != "alice" || req.Answers[questionCode] != "123456" {
+		t.Errorf("Expected connectionId, username and answers to be decoded, got %+v", req)
+	}
+
+	resp := KeyboardInteractiveResponse{
+		Instruction: "Sign in",
+		Questions:   []KeyboardInteractiveQuestion{{ID: questionCode, Question: "Verification code: ", EchoResponse: true}},
+	}
+	resp.Success = true
+	resp.AuthenticatedUsername = "alice"
+	encoded, err := json.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Failed to encode response: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	for _, key := range []string{"success", "authenticatedUsername", "instruction", "questions"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("Expected field %q in %s", key, encoded)
+		}
+	}
+	if !strings.Contains(string(encoded), `{"id":"code","question":"Verification code: ","echoResponse":true}`) {
+		t.Errorf("Expected question fields id, question and echoResponse, got %s", encoded)
+	}
+}
+
+// TestKeyboardInteractive_WithoutMFA 测试没有配置 totpSecret 的用户不能使用 keyboard-interactive
+func TestKeyboardInteractive_WithoutMFA(t *testing.T) {
+	server, err := NewServer(createTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if resp := keyboardInteractive(t, server, "conn-3", nil); resp.Success || len(resp.Questions) != 0 {
+		t.Errorf("Expected rejection without questions, got %+v", resp)
+	}
+	if !passwordAuth(t, server, "testuser", "testpass") {
+		t.Error("Expected password authentication without MFA")
+	}
+}
+
+// TestRenderContainerSSHConfig_KeyboardInteractive 测试有用户配置 totpSecret 时启用 keyboard-interactive
+func TestRenderContainerSSHConfig_KeyboardInteractive(t *testing.T) {
+	server, _, _ := mfaTestServer(t)
+	data, err := RenderContainerSSHConfig(server.config, ContainerSSHOptions{
+		HostKeys:   []string{"ssh_host_ed25519_key"},
+		WebhookURL: "http://sshhook:8080",
+	})
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+	var out containerSSHConfig
+	if err := yaml.Unmarshal(data, &out); err != nil {
+		t.Fatalf("Failed to parse rendered config: %v", err)
+	}
+	ki := out.Auth.KeyboardInteractive
+	if ki == nil || ki.Method != "webhook" || ki.Webhook.URL != "http://sshhook:8080" {
+		t.Errorf("Expected keyboard-interactive webhook, got %+v", ki)
+	}
+}
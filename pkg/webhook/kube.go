@@ -0,0 +1,458 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// defaultContainerAnnotation kubectl 使用的默认容器注解
+const defaultContainerAnnotation = "kubectl.kubernetes.io/default-container"
+
+// debugPollInterval 等待调试容器启动时查询 pod 的间隔
+var debugPollInterval = time.Second
+
+// shellCandidates 自动探测 shell 时依次尝试的路径
+var shellCandidates = []string{"/bin/bash", "/bin/sh", "/bin/ash"}
+
+// restConfig 根据集群配置构建 client-go 连接配置
+func restConfig(cluster *ClusterConfig) *rest.Config {
+	return &rest.Config{
+		Host:            cluster.Host,
+		BearerTokenFile: cluster.BearerTokenFile,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:     cluster.CACertFile,
+			CertFile:   cluster.CertFile,
+			KeyFile:    cluster.KeyFile,
+			ServerName: cluster.ServerName,
+		},
+		QPS:   float32(cluster.QPS),
+		Burst: cluster.Burst,
+	}
+}
+
+// kubeClients 按集群名称缓存 Kubernetes 客户端
+type kubeClients struct {
+	mu      sync.Mutex
+	clients map[string]kubernetes.Interface
+}
+
+// get 获取集群对应的客户端，不存在时创建
+func (k *kubeClients) get(cluster *ClusterConfig) (kubernetes.Interface, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if client, ok := k.clients[cluster.Name]; ok {
+		return client, nil
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig(cluster))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client for cluster %s: %w", cluster.Name, err)
+	}
+	if k.clients == nil {
+		k.clients = make(map[string]kubernetes.Interface)
+	}
+	k.clients[cluster.Name] = client
+	return client, nil
+}
+
+// reset 清空缓存的客户端，重新加载配置后集群的连接信息可能已经改变
+func (k *kubeClients) reset() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.clients = nil
+}
+
+// shellProber 探测容器中可用的 shell
+type shellProber interface {
+	// ProbeShell 返回 candidates 中第一个可以在容器中执行的 shell
+	ProbeShell(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string, candidates []string) (string, error)
+}
+
+// execProber 通过 exec 到容器中执行 `<shell> -c "exit 0"` 探测 shell
+type execProber struct {
+	clients *kubeClients
+}
+
+// ProbeShell 实现 shellProber
+func (p *execProber) ProbeShell(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string, candidates []string) (string, error) {
+	client, err := p.clients.get(cluster)
+	if err != nil {
+		return "", err
+	}
+
+	var lastErr error
+	for _, shell := range candidates {
+		if lastErr = p.exec(ctx, client, cluster, namespace, pod, container, shell); lastErr == nil {
+			return shell, nil
+		}
+	}
+	return "", fmt.Errorf("no shell found in container: %w", lastErr)
+}
+
+// shellCacheTTL 探测到 shell 的结果缓存时间，同一个容器的镜像不会变化
+const shellCacheTTL = 10 * time.Minute
+
+// shellFailureTTL 探测失败的结果缓存时间，失败可能是临时的网络问题，缓存较短时间
+const shellFailureTTL = 30 * time.Second
+
+// shellCache 按容器缓存 shell 探测结果，避免每次 config 请求都 exec 到容器中
+type shellCache struct {
+	mu      sync.Mutex
+	entries map[string]shellCacheEntry
+}
+
+type shellCacheEntry struct {
+	shell   string
+	err     error
+	expires time.Time
+}
+
+func newShellCache() *shellCache {
+	return &shellCache{entries: make(map[string]shellCacheEntry)}
+}
+
+// get 返回未过期的探测结果
+func (c *shellCache) get(key string, now time.Time) (string, error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		return "", nil, false
+	}
+	return entry.shell, entry.err, true
+}
+
+// set 记录探测结果，同时清理过期的条目
+func (c *shellCache) set(key, shell string, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	ttl := shellCacheTTL
+	if err != nil {
+		ttl = shellFailureTTL
+	}
+	c.entries[key] = shellCacheEntry{shell: shell, err: err, expires: now.Add(ttl)}
+}
+
+// exec 在容器中执行 `<shell> -c "exit 0"`
+func (p *execProber) exec(ctx context.Context, client kubernetes.Interface, cluster *ClusterConfig, namespace, pod, container, shell string) error {
+	_, err := execCommand(ctx, client, cluster, namespace, pod, container, []string{shell, "-c", "exit 0"})
+	return err
+}
+
+// execCommand 在容器中执行命令，返回标准输出
+func execCommand(ctx context.Context, client kubernetes.Interface, cluster *ClusterConfig, namespace, pod, container string, command []string) ([]byte, error) {
+	req := client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(pod).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(restConfig(cluster), "POST", req.URL())
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = executor.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	return stdout.Bytes(), err
+}
+
+// sessionChecker 检查 Kubernetes 中仍然存在的连接，返回连接 ID
+type sessionChecker interface {
+	// PodSessions 返回容器中仍在运行的连接
+	PodSessions(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string) (map[string]bool, error)
+	// WorkspaceSessions 返回 namespace 中仍有工作区 pod 的连接
+	WorkspaceSessions(ctx context.Context, cluster *ClusterConfig, namespace string) (map[string]bool, error)
+}
+
+// kubeSessionChecker 通过 Kubernetes API 检查连接
+type kubeSessionChecker struct {
+	clients *kubeClients
+}
+
+// processCmdlines 输出容器中所有进程的命令行，参数之间以 NUL 分隔
+const processCmdlines = "cat /proc/[0-9]*/cmdline 2>/dev/null; exit 0"
+
+// PodSessions 实现 sessionChecker，在容器进程的命令行中查找连接标记
+func (c *kubeSessionChecker) PodSessions(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string) (map[string]bool, error) {
+	client, err := c.clients.get(cluster)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := client.CoreV1().Pods(namespace).Get(ctx, pod, metav1.GetOptions{}); err != nil {
+		if apierrors.IsNotFound(err) {
+			// pod 已经删除，其中的连接都已结束
+			return map[string]bool{}, nil
+		}
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", namespace, pod, err)
+	}
+	out, err := execCommand(ctx, client, cluster, namespace, pod, container, []string{fallbackShell, "-c", processCmdlines})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processes in pod %s/%s: %w", namespace, pod, err)
+	}
+	live := make(map[string]bool)
+	for _, arg := range bytes.Split(out, []byte{0}) {
+		if id, ok := strings.CutPrefix(string(arg), sessionMarkerPrefix); ok && id != "" {
+			live[id] = true
+		}
+	}
+	return live, nil
+}
+
+// WorkspaceSessions 实现 sessionChecker，查找带有 labelConnection 标签且未在删除中的 pod
+func (c *kubeSessionChecker) WorkspaceSessions(ctx context.Context, cluster *ClusterConfig, namespace string) (map[string]bool, error) {
+	client, err := c.clients.get(cluster)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelConnection})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+	}
+	live := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil {
+			live[pod.Labels[labelConnection]] = true
+		}
+	}
+	return live, nil
+}
+
+// selectContainer 按策略选择 pod 中要进入的容器，只在普通容器中选择
+func selectContainer(pod *v1.Pod, sidecars []string) (string, error) {
+	if name := pod.Annotations[defaultContainerAnnotation]; name != "" {
+		for _, c := range pod.Spec.Containers {
+			if c.Name == name {
+				return name, nil
+			}
+		}
+	}
+
+	for _, c := range pod.Spec.Containers {
+		if !contains(sidecars, c.Name) {
+			return c.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no non-sidecar container in pod %s/%s", pod.Namespace, pod.Name)
+}
+
+// lookupContainer 查询 pod 并选择要进入的容器
+func (k *kubeClients) lookupContainer(ctx context.Context, cluster *ClusterConfig, namespace, name string, sidecars []string) (string, error) {
+	client, err := k.get(cluster)
+	if err != nil {
+		return "", err
+	}
+	pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s: %w", namespace, name, err)
+	}
+	return selectContainer(pod, sidecars)
+}
+
+// contains 判断字符串是否在列表中
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidNameChars 容器名称中不允许出现的字符
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dnsLabel 把名称转换为符合 DNS 规范的形式，可用于容器名称、namespace 和标签
+// 结果最长 50 个字符，预留前缀和后缀的空间
+func dnsLabel(name string) string {
+	label := invalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	label = strings.Trim(label, "-")
+	if len(label) > 50 {
+		label = strings.TrimRight(label[:50], "-")
+	}
+	return label
+}
+
+// debugOwnerEnv 调试容器中记录所属用户的环境变量，复用调试容器时按它判断归属
+// ephemeral 容器没有自己的标签和注解，环境变量会随容器规格保存在 pod 中
+const debugOwnerEnv = "SSHPROXY_DEBUG_USER"
+
+// debugContainerName 根据用户名生成调试容器名称前缀
+// 不同的用户名转换为 DNS 名称后可能相同（如 Bob.Smith 和 bob-smith），所以加上用户名的哈希
+func debugContainerName(username string) string {
+	label := dnsLabel(username)
+	if len(label) > 40 {
+		label = strings.TrimRight(label[:40], "-")
+	}
+	sum := sha256.Sum256([]byte(username))
+	return "debug-" + label + "-" + hex.EncodeToString(sum[:4])
+}
+
+// debugOwner 返回调试容器所属的用户
+func debugOwner(c v1.EphemeralContainer) string {
+	for _, env := range c.Env {
+		if env.Name == debugOwnerEnv {
+			return env.Value
+		}
+	}
+	return ""
+}
+
+// ensureNamespace 确保 namespace 存在，不存在时创建
+func (k *kubeClients) ensureNamespace(ctx context.Context, cluster *ClusterConfig, name string) error {
+	client, err := k.get(cluster)
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get namespace %s: %w", name, err)
+	}
+
+	_, err = client.CoreV1().Namespaces().Create(ctx, &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", name, err)
+	}
+	return nil
+}
+
+// ensureDebugContainer 确保 pod 中有该用户正在运行的调试容器，返回容器名称
+// 已有属于该用户且运行中的调试容器时直接复用，正在启动的继续等待，归属按 debugOwnerEnv 判断而不是容器名称；
+// ephemeral 容器退出后不能删除或重启，所以使用新的序号创建
+func (k *kubeClients) ensureDebugContainer(ctx context.Context, cluster *ClusterConfig, namespace, podName, target, username string, debug *DebugConfig) (string, error) {
+	client, err := k.get(cluster)
+	if err != nil {
+		return "", err
+	}
+	pods := client.CoreV1().Pods(namespace)
+
+	pod, err := pods.Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+	}
+
+	existing := make(map[string]bool)
+	owned := make(map[string]bool)
+	for _, c := range pod.Spec.EphemeralContainers {
+		existing[c.Name] = true
+		if debugOwner(c) == username {
+			owned[c.Name] = true
+		}
+	}
+	timeout := debug.StartTimeout
+	if timeout == 0 {
+		timeout = defaultDebugStartTimeout
+	}
+	starting := ""
+	for _, status := range pod.Status.EphemeralContainerStatuses {
+		if owned[status.Name] && status.State.Running != nil {
+			return status.Name, nil
+		}
+		if owned[status.Name] && status.State.Waiting != nil {
+			starting = status.Name
+		}
+	}
+	// 上一次登录时没有等到启动完成（例如正在拉取镜像）的调试容器继续等待，不再创建新的
+	if starting != "" {
+		if err := waitEphemeralContainer(ctx, client, namespace, podName, starting, timeout); err != nil {
+			return "", err
+		}
+		return starting, nil
+	}
+
+	prefix := debugContainerName(username)
+	name := prefix
+	for i := 2; existing[name]; i++ {
+		name = prefix + "-" + strconv.Itoa(i)
+	}
+
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, v1.EphemeralContainer{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{
+			Name:    name,
+			Image:   debug.Image,
+			Command: debug.Command,
+			Env:     []v1.EnvVar{{Name: debugOwnerEnv, Value: username}},
+			// 保持 stdin 打开，镜像默认的 shell 不会退出
+			Stdin: true,
+			TTY:   true,
+		},
+		TargetContainerName: target,
+	})
+	if _, err := pods.UpdateEphemeralContainers(ctx, podName, pod, metav1.UpdateOptions{}); err != nil {
+		return "", fmt.Errorf("failed to add debug container to pod %s/%s: %w", namespace, podName, err)
+	}
+
+	if err := waitEphemeralContainer(ctx, client, namespace, podName, name, timeout); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// waitEphemeralContainer 等待 ephemeral 容器进入运行状态
+func waitEphemeralContainer(ctx context.Context, client kubernetes.Interface, namespace, podName, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(debugPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod %s/%s: %w", namespace, podName, err)
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			if status.State.Running != nil {
+				return nil
+			}
+			if status.State.Terminated != nil {
+				return fmt.Errorf("debug container %s terminated: %s", name, status.State.Terminated.Reason)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for debug container %s to start", name)
+		case <-ticker.C:
+		}
+	}
+}
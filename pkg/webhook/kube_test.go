@@ -0,0 +1,274 @@
+package webhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestSelectContainer 测试容器选择策略
+func TestSelectContainer(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		containers  []string
+		expected    string
+	}{
+		{"first container", nil, []string{"app", "worker"}, "app"},
+		{"skip sidecar", nil, []string{"istio-proxy", "app"}, "app"},
+		{"annotation", map[string]string{defaultContainerAnnotation: "worker"}, []string{"app", "worker"}, "worker"},
+		{"annotation not found", map[string]string{defaultContainerAnnotation: "missing"}, []string{"istio-proxy", "app"}, "app"},
+	}
+
+	for _, tt := range tests {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Annotations: tt.annotations},
+			Spec: v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "init"}},
+				EphemeralContainers: []v1.EphemeralContainer{
+					{EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger"}},
+				},
+			},
+		}
+		for _, name := range tt.containers {
+			pod.Spec.Containers = append(pod.Spec.Containers, v1.Container{Name: name})
+		}
+
+		got, err := selectContainer(pod, defaultSidecars)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected '%s', got '%s'", tt.name, tt.expected, got)
+		}
+	}
+}
+
+// TestSelectContainer_OnlySidecars 测试只有 sidecar 容器时报错
+func TestSelectContainer_OnlySidecars(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Annotations: map[string]string{defaultContainerAnnotation: "init"},
+		},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "istio-proxy"}},
+		},
+	}
+	if _, err := selectContainer(pod, defaultSidecars); err == nil {
+		t.Error("Expected error when only sidecars exist, got nil")
+	}
+}
+
+// TestDebugContainerName 测试调试容器名称
+func TestDebugContainerName(t *testing.T) {
+	tests := map[string]string{
+		"alice":             "debug-alice-",
+		"Bob.Smith@example": "debug-bob-smith-example-",
+	}
+	for username, prefix := range tests {
+		got := debugContainerName(username)
+		if !strings.HasPrefix(got, prefix) || len(got) != len(prefix)+8 {
+			t.Errorf("Expected '%s<hash>' for %s, got '%s'", prefix, username, got)
+		}
+	}
+
+	// 转换后相同的用户名使用不同的名称
+	if debugContainerName("Bob.Smith") == debugContainerName("bob-smith") {
+		t.Error("Expected different names for Bob.Smith and bob-smith")
+	}
+	if got := debugContainerName(strings.Repeat("a", 100)); len(got) > 63 {
+		t.Errorf("Expected name within 63 characters, got %d", len(got))
+	}
+}
+
+// setDebugPollInterval 缩短等待调试容器的查询间隔，测试结束后恢复
+func setDebugPollInterval(t *testing.T) {
+	old := debugPollInterval
+	debugPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { debugPollInterval = old })
+}
+
+// debugContainer 测试用的调试容器
+type debugContainer struct {
+	name    string
+	owner   string
+	running bool
+	waiting bool // 正在启动，例如正在拉取镜像
+}
+
+// debugTestPod 创建带有调试容器的测试 pod 和客户端缓存
+func debugTestPod(containers []debugContainer) (*kubeClients, *fake.Clientset) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "prod"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+	for _, c := range containers {
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, v1.EphemeralContainer{
+			EphemeralContainerCommon: v1.EphemeralContainerCommon{
+				Name: c.name,
+				Env:  []v1.EnvVar{{Name: debugOwnerEnv, Value: c.owner}},
+			},
+		})
+		state := v1.ContainerState{Terminated: &v1.ContainerStateTerminated{}}
+		if c.running {
+			state = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+		} else if c.waiting {
+			state = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+		}
+		pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses,
+			v1.ContainerStatus{Name: c.name, State: state})
+	}
+	client := fake.NewSimpleClientset(pod)
+	return &kubeClients{clients: map[string]kubernetes.Interface{"c1": client}}, client
+}
+
+// TestEnsureDebugContainer_Reuse 测试复用正在运行的调试容器
+func TestEnsureDebugContainer_Reuse(t *testing.T) {
+	prefix := debugContainerName("alice")
+	kube, _ := debugTestPod([]debugContainer{
+		{name: prefix, owner: "alice"},
+		{name: prefix + "-2", owner: "alice", running: true},
+	})
+
+	name, err := kube.ensureDebugContainer(context.Background(), &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox"})
+	if err != nil {
+		t.Fatalf("Failed to ensure debug container: %v", err)
+	}
+	if name != prefix+"-2" {
+		t.Errorf("Expected to reuse '%s-2', got '%s'", prefix, name)
+	}
+}
+
+// TestEnsureDebugContainer_OtherOwner 测试不复用其他用户的调试容器
+func TestEnsureDebugContainer_OtherOwner(t *testing.T) {
+	setDebugPollInterval(t)
+	// alice-2 的容器名称以 alice 的旧名称为前缀，Alice 转换后与 alice 相同
+	kube, client := debugTestPod([]debugContainer{
+		{name: "debug-alice-2", owner: "alice-2", running: true},
+		{name: debugContainerName("alice"), owner: "Alice", running: true},
+	})
+
+	_, err := kube.ensureDebugContainer(context.Background(), &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox", StartTimeout: 50 * time.Millisecond})
+	if err == nil {
+		t.Fatal("Expected timeout waiting for a new debug container, got nil")
+	}
+
+	pod, err := client.CoreV1().Pods("prod").Get(context.Background(), "app-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get pod: %v", err)
+	}
+	if len(pod.Spec.EphemeralContainers) != 3 {
+		t.Fatalf("Expected a new debug container for alice, got %+v", pod.Spec.EphemeralContainers)
+	}
+	ec := pod.Spec.EphemeralContainers[2]
+	if debugOwner(ec) != "alice" || ec.Name != debugContainerName("alice")+"-2" {
+		t.Errorf("Unexpected debug container: %s owned by %s", ec.Name, debugOwner(ec))
+	}
+}
+
+// TestEnsureDebugContainer_Create 测试创建调试容器并等待启动
+func TestEnsureDebugContainer_Create(t *testing.T) {
+	setDebugPollInterval(t)
+	prefix := debugContainerName("alice")
+	kube, client := debugTestPod([]debugContainer{{name: prefix, owner: "alice"}})
+
+	// 模拟 kubelet 启动调试容器
+	go func() {
+		for i := 0; i < 100; i++ {
+			time.Sleep(10 * time.Millisecond)
+			pod, err := client.CoreV1().Pods("prod").Get(context.Background(), "app-0", metav1.GetOptions{})
+			if err != nil || len(pod.Spec.EphemeralContainers) < 2 {
+				continue
+			}
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, v1.ContainerStatus{
+				Name:  pod.Spec.EphemeralContainers[1].Name,
+				State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+			})
+			client.CoreV1().Pods("prod").UpdateStatus(context.Background(), pod, metav1.UpdateOptions{})
+			return
+		}
+	}()
+
+	name, err := kube.ensureDebugContainer(context.Background(), &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox", StartTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Failed to ensure debug container: %v", err)
+	}
+	if name != prefix+"-2" {
+		t.Errorf("Expected new container '%s-2', got '%s'", prefix, name)
+	}
+
+	pod, err := client.CoreV1().Pods("prod").Get(context.Background(), "app-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get pod: %v", err)
+	}
+	ec := pod.Spec.EphemeralContainers[1]
+	if ec.Image != "busybox" || ec.TargetContainerName != "app" || debugOwner(ec) != "alice" {
+		t.Errorf("Unexpected debug container spec: %+v", ec)
+	}
+}
+
+// TestEnsureDebugContainer_Starting 测试继续等待上次没有启动完成的调试容器，不创建新的
+func TestEnsureDebugContainer_Starting(t *testing.T) {
+	setDebugPollInterval(t)
+	prefix := debugContainerName("alice")
+	kube, client := debugTestPod([]debugContainer{{name: prefix, owner: "alice", waiting: true}})
+
+	if _, err := kube.ensureDebugContainer(context.Background(), &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox", StartTimeout: 50 * time.Millisecond}); err == nil {
+		t.Fatal("Expected timeout error, got nil")
+	}
+	pod, err := client.CoreV1().Pods("prod").Get(context.Background(), "app-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get pod: %v", err)
+	}
+	if len(pod.Spec.EphemeralContainers) != 1 {
+		t.Errorf("Expected no new debug container, got %d", len(pod.Spec.EphemeralContainers))
+	}
+}
+
+// TestEnsureDebugContainer_Timeout 测试调试容器启动超时
+func TestEnsureDebugContainer_Timeout(t *testing.T) {
+	setDebugPollInterval(t)
+	kube, _ := debugTestPod(nil)
+
+	// 总时间由 config 请求的 context 限制
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := kube.ensureDebugContainer(ctx, &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox"}); err == nil {
+		t.Error("Expected context deadline to stop waiting, got nil")
+	}
+
+	_, err := kube.ensureDebugContainer(context.Background(), &ClusterConfig{Name: "c1"},
+		"prod", "app-0", "app", "alice", &DebugConfig{Image: "busybox", StartTimeout: 50 * time.Millisecond})
+	if err == nil {
+		t.Error("Expected timeout error, got nil")
+	}
+}
+
+// TestValidate_DebugStartTimeout 测试调试容器的启动时间不能超过 config 请求的时间
+func TestValidate_DebugStartTimeout(t *testing.T) {
+	cfg := &Config{
+		Clusters: []ClusterConfig{{Name: "c1"}},
+		Targets:  []TargetConfig{{Name: "t1", Cluster: "c1", Debug: &DebugConfig{Image: "busybox", StartTimeout: 3 * time.Second}}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	cfg.Targets[0].Debug.StartTimeout = 60 * time.Second
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected error for startTimeout longer than the config request")
+	}
+}
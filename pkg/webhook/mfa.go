@@ -0,0 +1,361 @@
+package webhook
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod     = 30 * time.Second // TOTP 时间步长
+	totpDigits     = 6                // 验证码位数
+	totpSecretSize = 20               // 随机密钥长度，与 HMAC-SHA1 输出长度相同
+	totpPrefix     = "v1:"            // totpSecret 的格式版本
+
+	defaultMFAIssuer      = "sshhook"
+	defaultMFASkew        = 1
+	defaultMFAMaxAttempts = 5
+	defaultMFALockout     = 15 * time.Minute
+
+	// mfaPendingTTL 第一因素通过后等待输入验证码的时间
+	mfaPendingTTL = 2 * time.Minute
+)
+
+// MFAConfig TOTP 第二因素。配置了 totpSecret 的用户在密码或公钥认证之后还需要输入验证码
+type MFAConfig struct {
+	// 加密 totpSecret 的密钥，支持 file:、env:、k8s: 引用，有用户配置 totpSecret 时必须设置
+	EncryptionKey Secret `yaml:"encryptionKey,omitempty"`
+	// 验证器 App 中显示的发行方，默认 sshhook
+	Issuer string `yaml:"issuer,omitempty"`
+	// 允许的时钟偏差（前后各几个 30 秒步长），默认 1
+	Skew int `yaml:"skew,omitempty"`
+	// 连续输入错误验证码的次数达到该值后暂时锁定用户的验证码认证，默认 5
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// 锁定时间，默认 15m
+	Lockout time.Duration `yaml:"lockout,omitempty"`
+}
+
+// validate 检查 MFA 配置
+func (m *MFAConfig) validate(users []UserConfig) error {
+	if m.Skew < 0 {
+		return errors.New("skew must not be negative")
+	}
+	if m.MaxAttempts < 0 {
+		return errors.New("maxAttempts must not be negative")
+	}
+	if m.Lockout < 0 {
+		return errors.New("lockout must not be negative")
+	}
+	for i := range users {
+		if users[i].TOTPSecret != "" && m.EncryptionKey == "" {
+			return fmt.Errorf("encryptionKey is required by user %s", users[i].Username)
+		}
+	}
+	return nil
+}
+
+// issuer 返回验证器 App 中显示的发行方
+func (m *MFAConfig) issuer() string {
+	if m.Issuer == "" {
+		return defaultMFAIssuer
+	}
+	return m.Issuer
+}
+
+// skew 返回允许的时钟偏差步数
+func (m *MFAConfig) skew() int {
+	if m.Skew == 0 {
+		return defaultMFASkew
+	}
+	return m.Skew
+}
+
+// maxAttempts 返回锁定前允许连续输入错误验证码的次数
+func (m *MFAConfig) maxAttempts() int {
+	if m.MaxAttempts == 0 {
+		return defaultMFAMaxAttempts
+	}
+	return m.MaxAttempts
+}
+
+// lockout 返回验证码错误次数过多后的锁定时间
+func (m *MFAConfig) lockout() time.Duration {
+	if m.Lockout == 0 {
+		return defaultMFALockout
+	}
+	return m.Lockout
+}
+
+// aead 根据 encryptionKey 创建 AES-256-GCM，密钥为 encryptionKey 的 SHA-256
+func (m *MFAConfig) aead() (cipher.AEAD, error) {
+	if m.EncryptionKey == "" {
+		return nil, errors.New("mfa encryptionKey is not configured")
+	}
+	key := sha256.Sum256([]byte(m.EncryptionKey.Value()))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptTOTPSecret 加密 TOTP 密钥，用户名作为附加数据，密文不能复制给其他用户使用
+func (m *MFAConfig) encryptTOTPSecret(username string, secret []byte) (string, error) {
+	aead, err := m.aead()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, secret, []byte(username))
+	return totpPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptTOTPSecret 解密用户的 totpSecret
+func (m *MFAConfig) decryptTOTPSecret(username, value string) ([]byte, error) {
+	if !strings.HasPrefix(value, totpPrefix) {
+		return nil, errors.New("unsupported totpSecret format")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, totpPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid totpSecret: %w", err)
+	}
+	aead, err := m.aead()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("invalid totpSecret: too short")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(username))
+	if err != nil {
+		return nil, errors.New("failed to decrypt totpSecret, wrong encryptionKey?")
+	}
+	return secret, nil
+}
+
+// checkTOTPSecrets 解析 Secret 引用之后检查所有 totpSecret 都能用 encryptionKey 解密
+func (c *Config) checkTOTPSecrets() error {
+	for i := range c.Users {
+		u := &c.Users[i]
+		if u.TOTPSecret == "" {
+			continue
+		}
+		if _, err := c.MFA.decryptTOTPSecret(u.Username, u.TOTPSecret); err != nil {
+			return fmt.Errorf("user %s: %w", u.Username, err)
+		}
+	}
+	return nil
+}
+
+// mfaRequired 用户是否需要第二因素
+func (u *UserConfig) mfaRequired() bool {
+	return u.TOTPSecret != ""
+}
+
+// totpCode 计算时间步 counter 的验证码（RFC 6238，HMAC-SHA1）
+func totpCode(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpCounter 返回 t 所在的时间步
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix() / int64(totpPeriod/time.Second))
+}
+
+// verifyTOTP 在允许的时钟偏差内查找与 code 匹配的时间步
+func verifyTOTP(secret []byte, code string, now time.Time, skew int) (uint64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpCounter(now)
+	for i := -skew; i <= skew; i++ {
+		counter := current + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPEnrollment sshhook totp enroll 生成的 TOTP 密钥
+type TOTPEnrollment struct {
+	Username        string
+	URI             string // otpauth:// 地址，可以生成二维码导入验证器 App
+	EncryptedSecret string // 写入用户 totpSecret 的密文
+
+	secret []byte
+	skew   int
+}
+
+// EnrollTOTP 为用户生成新的 TOTP 密钥，使用配置中的 mfa.encryptionKey 加密
+func EnrollTOTP(cfg *Config, username string) (*TOTPEnrollment, error) {
+	if cfg.GetUser(username) == nil {
+		return nil, fmt.Errorf("user not found: %s", username)
+	}
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encrypted, err := cfg.MFA.encryptTOTPSecret(username, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := cfg.MFA.issuer()
+	params := url.Values{}
+	params.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + username,
+		RawQuery: params.Encode(),
+	}
+	return &TOTPEnrollment{
+		Username:        username,
+		URI:             uri.String(),
+		EncryptedSecret: encrypted,
+		secret:          secret,
+		skew:            cfg.MFA.skew(),
+	}, nil
+}
+
+// Verify 检查验证器 App 生成的验证码，用于确认导入成功
+func (e *TOTPEnrollment) Verify(code string) bool {
+	_, ok := verifyTOTP(e.secret, code, time.Now(), e.skew)
+	return ok
+}
+
+// mfaPending 第一因素通过、等待验证码的连接
+type mfaPending struct {
+	username string
+	metadata map[string]string // 第一因素渲染的 metadata，如公钥注释和 command=
+	expires  time.Time
+}
+
+// mfaFailures 用户连续输入错误验证码的次数
+type mfaFailures struct {
+	count       int
+	lockedUntil time.Time
+}
+
+// mfaState 保存等待第二因素的连接、每个用户最后使用的时间步（防止验证码重放）
+// 和连续输入错误验证码的次数（防止暴力猜测），只保存在内存中
+type mfaState struct {
+	mu          sync.Mutex
+	pending     map[string]mfaPending  // key 为 connectionID
+	lastCounter map[string]uint64      // key 为用户名
+	failures    map[string]mfaFailures // key 为用户名
+}
+
+// newMFAState 创建 mfaState
+func newMFAState() *mfaState {
+	return &mfaState{
+		pending:     make(map[string]mfaPending),
+		lastCounter: make(map[string]uint64),
+		failures:    make(map[string]mfaFailures),
+	}
+}
+
+// locked 返回用户是否因为验证码错误次数过多被锁定，锁定到期后重新计数
+func (m *mfaState) locked(username string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.failures[username]
+	if !ok || f.lockedUntil.IsZero() {
+		return false
+	}
+	if now.Before(f.lockedUntil) {
+		return true
+	}
+	delete(m.failures, username)
+	return false
+}
+
+// addFailure 记录一次错误的验证码，连续错误达到 maxAttempts 次时锁定 lockout 时间，返回是否已锁定
+func (m *mfaState) addFailure(username string, maxAttempts int, lockout time.Duration, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f := m.failures[username]
+	f.count++
+	if f.count >= maxAttempts {
+		f.lockedUntil = now.Add(lockout)
+	}
+	m.failures[username] = f
+	return !f.lockedUntil.IsZero()
+}
+
+// clearFailures 验证码正确后清除错误次数
+func (m *mfaState) clearFailures(username string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, username)
+}
+
+// setPending 记录连接已通过第一因素，同时清理过期的记录
+func (m *mfaState) setPending(connectionID, username string, md map[string]string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, p := range m.pending {
+		if now.After(p.expires) {
+			delete(m.pending, id)
+		}
+	}
+	m.pending[connectionID] = mfaPending{username: username, metadata: md, expires: now.Add(mfaPendingTTL)}
+}
+
+// getPending 返回连接第一因素的 metadata，连接没有以该用户通过第一因素或已过期时返回 false
+func (m *mfaState) getPending(connectionID, username string, now time.Time) (map[string]string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[connectionID]
+	if !ok || p.username != username || now.After(p.expires) {
+		return nil, false
+	}
+	return p.metadata, true
+}
+
+// clearPending 认证完成后删除连接的记录
+func (m *mfaState) clearPending(connectionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, connectionID)
+}
+
+// useCounter 记录用户使用了时间步 counter 的验证码，已经使用过该时间步或更早的时间步时返回 false
+func (m *mfaState) useCounter(username string, counter uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, ok := m.lastCounter[username]; ok && counter <= last {
+		return false
+	}
+	m.lastCounter[username] = counter
+	return true
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestTOTPCode 测试 RFC 6238 附录 B 中 SHA1 的测试向量（取后 6 位）
+func TestTOTPCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(secret, totpCounter(time.Unix(tt.unix, 0))); got != tt.code {
+			t.Errorf("Expected code %s at %d, got %s", tt.code, tt.unix, got)
+		}
+	}
+}
+
+// TestVerifyTOTP 测试允许前后一个时间步的时钟偏差
+func TestVerifyTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	counter := totpCounter(now)
+
+	if got, ok := verifyTOTP(secret, totpCode(secret, counter), now, 1); !ok || got != counter {
+		t.Errorf("Expected current code to match counter %d, got %d, %v", counter, got, ok)
+	}
+	if got, ok := verifyTOTP(secret, " "+totpCode(secret, counter-1)+" ", now, 1); !ok || got != counter-1 {
+		t.Errorf("Expected previous code to match, got %d, %v", got, ok)
+	}
+	if _, ok := verifyTOTP(secret, totpCode(secret, counter+2), now, 1); ok {
+		t.Error("Expected code outside the skew to be rejected")
+	}
+	if _, ok := verifyTOTP(secret, totpCode(secret, counter+2), now, 2); !ok {
+		t.Error("Expected code within skew 2 to match")
+	}
+	if _, ok := verifyTOTP(secret, "", now, 1); ok {
+		t.Error("Expected empty code to be rejected")
+	}
+}
+
+// TestTOTPSecretEncryption 测试加密的 totpSecret 只能用同一个密钥、同一个用户解密
+func TestTOTPSecretEncryption(t *testing.T) {
+	m := MFAConfig{EncryptionKey: "key-1"}
+	encrypted, err := m.encryptTOTPSecret("alice", []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	if !strings.HasPrefix(encrypted, totpPrefix) || strings.Contains(encrypted, "secret") {
+		t.Errorf("Unexpected encrypted value: %s", encrypted)
+	}
+	again, _ := m.encryptTOTPSecret("alice", []byte("secret"))
+	if again == encrypted {
+		t.Error("Expected a random nonce for each encryption")
+	}
+
+	secret, err := m.decryptTOTPSecret("alice", encrypted)
+	if err != nil || string(secret) != "secret" {
+		t.Errorf("Expected to decrypt secret, got %q, %v", secret, err)
+	}
+	if _, err := m.decryptTOTPSecret("bob", encrypted); err == nil {
+		t.Error("Expected error when decrypting another user's secret")
+	}
+	other := MFAConfig{EncryptionKey: "key-2"}
+	if _, err := other.decryptTOTPSecret("alice", encrypted); err == nil {
+		t.Error("Expected error with a different encryption key")
+	}
+	if _, err := (&MFAConfig{}).decryptTOTPSecret("alice", encrypted); err == nil {
+		t.Error("Expected error without encryption key")
+	}
+}
+
+// TestEnrollTOTP 测试生成的 otpauth 地址和密文
+func TestEnrollTOTP(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.MFA = MFAConfig{EncryptionKey: "key", Issuer: "Prod SSH"}
+
+	enrollment, err := EnrollTOTP(cfg, "testuser")
+	if err != nil {
+		t.Fatalf("Failed to enroll: %v", err)
+	}
+	u, err := url.Parse(enrollment.URI)
+	if err != nil {
+		t.Fatalf("Failed to parse URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Prod SSH:testuser" {
+		t.Errorf("Unexpected URI: %s", enrollment.URI)
+	}
+	if q := u.Query(); q.Get("issuer") != "Prod SSH" || len(q.Get("secret")) != 32 || q.Get("digits") != "6" {
+		t.Errorf("Unexpected URI parameters: %v", q)
+	}
+
+	secret, err := cfg.MFA.decryptTOTPSecret("testuser", enrollment.EncryptedSecret)
+	if err != nil {
+		t.Fatalf("Failed to decrypt enrolled secret: %v", err)
+	}
+	if !enrollment.Verify(totpCode(secret, totpCounter(time.Now()))) {
+		t.Error("Expected current code to verify")
+	}
+	if enrollment.Verify(totpCode(secret, totpCounter(time.Now())+5)) {
+		t.Error("Expected code outside the skew to fail")
+	}
+
+	if _, err := EnrollTOTP(cfg, "nobody"); err == nil {
+		t.Error("Expected error for unknown user")
+	}
+	cfg.MFA.EncryptionKey = ""
+	if _, err := EnrollTOTP(cfg, "testuser"); err == nil {
+		t.Error("Expected error without encryption key")
+	}
+}
+
+// TestMFAConfig_Validate 测试配置了 totpSecret 时必须设置 encryptionKey
+func TestMFAConfig_Validate(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Users[0].TOTPSecret = "v1:AAAA"
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "encryptionKey is required") {
+		t.Errorf("Expected encryptionKey error, got %v", err)
+	}
+	cfg.MFA.EncryptionKey = "key"
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected valid config, got %v", err)
+	}
+	cfg.MFA.MaxAttempts = -1
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "maxAttempts") {
+		t.Errorf("Expected maxAttempts error, got %v", err)
+	}
+	cfg.MFA.MaxAttempts = 0
+	// 解析 Secret 引用后检查能否解密
+	if err := cfg.checkTOTPSecrets(); err == nil {
+		t.Error("Expected error for undecryptable totpSecret")
+	}
+}
+
+// TestMFAState 测试等待第二因素的连接过期、验证码重放和错误次数锁定
+func TestMFAState(t *testing.T) {
+	m := newMFAState()
+	now := time.Now()
+	m.setPending("c1", "alice", map[string]string{"k": "v"}, now)
+
+	if md, ok := m.getPending("c1", "alice", now); !ok || md["k"] != "v" {
+		t.Errorf("Expected pending connection, got %v, %v", md, ok)
+	}
+	if _, ok := m.getPending("c1", "bob", now); ok {
+		t.Error("Expected pending connection to belong to alice only")
+	}
+	if _, ok := m.getPending("c1", "alice", now.Add(mfaPendingTTL+time.Second)); ok {
+		t.Error("Expected pending connection to expire")
+	}
+	m.clearPending("c1")
+	if _, ok := m.getPending("c1", "alice", now); ok {
+		t.Error("Expected pending connection to be cleared")
+	}
+
+	if !m.useCounter("alice", 10) {
+		t.Error("Expected first use to succeed")
+	}
+	if m.useCounter("alice", 10) || m.useCounter("alice", 9) {
+		t.Error("Expected reused or older counter to be rejected")
+	}
+	if !m.useCounter("alice", 11) || !m.useCounter("bob", 10) {
+		t.Error("Expected newer counter and other users to succeed")
+	}
+
+	// 连续错误达到次数后锁定，到期后重新计数，验证成功后清除
+	if m.addFailure("alice", 2, time.Minute, now) || m.locked("alice", now) {
+		t.Error("Expected alice not to be locked after one failure")
+	}
+	if !m.addFailure("alice", 2, time.Minute, now) || !m.locked("alice", now) {
+		t.Error("Expected alice to be locked after two failures")
+	}
+	if m.locked("bob", now) {
+		t.Error("Expected lockout to apply to alice only")
+	}
+	if m.locked("alice", now.Add(time.Minute)) {
+		t.Error("Expected lockout to expire")
+	}
+	if m.addFailure("alice", 2, time.Minute, now) {
+		t.Error("Expected failures to be counted again after lockout")
+	}
+	m.clearFailures("alice")
+	if m.addFailure("alice", 2, time.Minute, now) {
+		t.Error("Expected failures to be cleared")
+	}
+}
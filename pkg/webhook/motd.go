@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"bytes"
+	"time"
+)
+
+// motdScript 输出 MOTD 后 exec 到 shell，$0 是 MOTD，$@ 是 shell 命令，避免拼接字符串带来的转义问题
+const motdScript = `printf '%s\n' "$0"; exec "$@"`
+
+// MOTDData 渲染 MOTD 时可用的变量，在 TemplateData 的基础上增加解析后的登录目标
+type MOTDData struct {
+	*TemplateData
+	Target    string // 登录目标名称，直接使用 metadata 时为空
+	Cluster   string // 集群名称
+	Namespace string // Pod 所在的 namespace
+	Pod       string // Pod 名称，模板模式下 pod 由 ContainerSSH 创建，为空
+	Container string // 实际进入的容器，调试模式下是调试容器
+	ExpiresAt string // 访问的过期时间（RFC 3339），未设置时为空
+}
+
+// newMOTDData 根据解析后的登录目标生成 MOTD 变量
+func newMOTDData(data *TemplateData, user *UserConfig, route *Route) *MOTDData {
+	d := &MOTDData{
+		TemplateData: data,
+		Cluster:      route.Cluster,
+		Namespace:    route.Namespace,
+		Pod:          route.Pod,
+		Container:    route.Container,
+	}
+	if route.Target != nil {
+		d.Target = route.Target.Name
+	}
+	if !user.ExpiresAt.IsZero() {
+		d.ExpiresAt = user.ExpiresAt.Format(time.RFC3339)
+	}
+	return d
+}
+
+// resolveMOTD 返回优先级最高的一层中设置的 MOTD
+func resolveMOTD(layers []*SessionConfig) string {
+	var motd string
+	for _, layer := range layers {
+		if layer.MOTD != "" {
+			motd = layer.MOTD
+		}
+	}
+	return motd
+}
+
+// renderMOTD 渲染 MOTD 模板
+func renderMOTD(motd string, data *MOTDData) (string, error) {
+	if !isTemplate(motd) {
+		return motd, nil
+	}
+	tmpl, err := parseTemplate(motd, data.TemplateData)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// sampleMOTDData 检查 MOTD 模板时使用的示例值
+var sampleMOTDData = &MOTDData{
+	TemplateData: sampleTemplateData,
+	Target:       "target",
+	Cluster:      "cluster",
+	Namespace:    "namespace",
+	Pod:          "pod",
+	Container:    "container",
+	ExpiresAt:    "2006-01-02T15:04:05Z",
+}
+
+// motdCommand 在 shell 命令前输出 MOTD，只影响交互式 shell，exec 和 SFTP 不会输出
+func motdCommand(motd string, command []string) []string {
+	if motd == "" {
+		return command
+	}
+	return append([]string{"/bin/sh", "-c", motdScript, motd}, command...)
+}
@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestResolveMOTD 测试 MOTD 以优先级最高的一层为准
+func TestResolveMOTD(t *testing.T) {
+	layers := []*SessionConfig{{MOTD: "target"}, {}, {MOTD: "user"}}
+	if motd := resolveMOTD(layers); motd != "user" {
+		t.Errorf("Expected user motd, got '%s'", motd)
+	}
+	if motd := resolveMOTD(layers[:2]); motd != "target" {
+		t.Errorf("Expected target motd, got '%s'", motd)
+	}
+}
+
+// TestRenderMOTD 测试使用解析后的登录目标渲染 MOTD
+func TestRenderMOTD(t *testing.T) {
+	user := &UserConfig{Username: "alice", Groups: []string{"dev"}, ExpiresAt: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}
+	route := &Route{
+		Target:    &TargetConfig{Name: "t1"},
+		Cluster:   "prod",
+		Namespace: "team-dev",
+		Pod:       "api-0",
+		Container: "api",
+	}
+	data := newMOTDData(userTemplateData(user), user, route)
+
+	motd, err := renderMOTD("${user} -> {{.Target}} {{.Cluster}}/{{.Namespace}}/{{.Pod}}/{{.Container}}{{if .ExpiresAt}} until {{.ExpiresAt}}{{end}}", data)
+	if err != nil {
+		t.Fatalf("Failed to render motd: %v", err)
+	}
+	if motd != "alice -> t1 prod/team-dev/api-0/api until 2030-01-02T03:04:05Z" {
+		t.Errorf("Unexpected motd: '%s'", motd)
+	}
+
+	if _, err := renderMOTD("{{.Unknown}}", sampleMOTDData); err == nil {
+		t.Error("Expected error for unknown field")
+	}
+}
+
+// TestMOTDCommand 测试在 shell 命令前输出 MOTD
+func TestMOTDCommand(t *testing.T) {
+	shell := []string{"/bin/bash"}
+	if cmd := motdCommand("", shell); !reflect.DeepEqual(cmd, shell) {
+		t.Errorf("Expected shell unchanged, got %v", cmd)
+	}
+	want := []string{"/bin/sh", "-c", motdScript, "hello", "/bin/bash"}
+	if cmd := motdCommand("hello", shell); !reflect.DeepEqual(cmd, want) {
+		t.Errorf("Expected %v, got %v", want, cmd)
+	}
+}
+
+// TestUserExpired 测试访问过期时间
+func TestUserExpired(t *testing.T) {
+	now := time.Now()
+	if (&UserConfig{}).expired(now) {
+		t.Error("Expected user without expiresAt never to expire")
+	}
+	if !(&UserConfig{ExpiresAt: now.Add(-time.Minute)}).expired(now) {
+		t.Error("Expected user to be expired")
+	}
+	if (&UserConfig{ExpiresAt: now.Add(time.Minute)}).expired(now) {
+		t.Error("Expected user not to be expired")
+	}
+}
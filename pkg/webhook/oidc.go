@@ -0,0 +1,429 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-jose/go-jose/v4"
+)
+
+const (
+	defaultOIDCUsernameClaim = "sub"
+	defaultOIDCGroupsClaim   = "groups"
+
+	// oidcHTTPTimeout 访问身份提供方的超时时间
+	oidcHTTPTimeout = 10 * time.Second
+	// oidcClockSkew 检查 ID token 的 iat 时允许的时钟偏差
+	oidcClockSkew = time.Minute
+	// jwksRefreshInterval 找不到 kid 时两次获取 JWKS 的最小间隔，避免伪造的 kid 让每次登录都访问身份提供方
+	jwksRefreshInterval = time.Minute
+
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+var defaultOIDCScopes = []string{"openid", "profile", "groups"}
+
+// oidcSigningAlgs ID token 支持的签名算法
+var oidcSigningAlgs = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+}
+
+// OIDCConfig 通过身份提供方的 OAuth2 设备授权流程登录（RFC 8628），在 keyboard-interactive 中显示授权地址
+type OIDCConfig struct {
+	Issuer        string   `yaml:"issuer,omitempty"`        // 身份提供方地址，从 <issuer>/.well-known/openid-configuration 获取接口
+	ClientID      string   `yaml:"clientID,omitempty"`      // ID token 的 aud 必须包含 clientID
+	ClientSecret  Secret   `yaml:"clientSecret,omitempty"`  // 可选，支持 file:、env:、k8s: 引用
+	Scopes        []string `yaml:"scopes,omitempty"`        // 默认 openid profile groups
+	UsernameClaim string   `yaml:"usernameClaim,omitempty"` // 与 SSH 用户名比较的 claim，默认 sub；为 email 时要求 email_verified
+	GroupsClaim   string   `yaml:"groupsClaim,omitempty"`   // 用户组 claim，默认 groups
+
+	// 身份提供方的用户组与登录目标、用户组的对应关系
+	GroupMappings []OIDCGroupMapping `yaml:"groupMappings,omitempty"`
+}
+
+// OIDCGroupMapping 身份提供方中的一个用户组可以访问的目标，以及适用的 sshhook 用户组（会话配置）
+type OIDCGroupMapping struct {
+	Group   string   `yaml:"group"`
+	Targets []string `yaml:"targets,omitempty"`
+	Groups  []string `yaml:"groups,omitempty"`
+}
+
+// enabled 是否启用 OIDC 登录
+func (o *OIDCConfig) enabled() bool {
+	return o.Issuer != ""
+}
+
+// validate 检查 OIDC 配置
+func (o *OIDCConfig) validate(c *Config) error {
+	if !o.enabled() {
+		if o.ClientID != "" || len(o.GroupMappings) > 0 {
+			return errors.New("issuer is required")
+		}
+		return nil
+	}
+	u, err := url.Parse(o.Issuer)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return fmt.Errorf("invalid issuer: %s", o.Issuer)
+	}
+	if o.ClientID == "" {
+		return errors.New("clientID is required")
+	}
+	for _, m := range o.GroupMappings {
+		if m.Group == "" {
+			return errors.New("groupMappings: group is required")
+		}
+		for _, t := range m.Targets {
+			if c.GetTarget(t) == nil {
+				return fmt.Errorf("group mapping %s: target not found: %s", m.Group, t)
+			}
+		}
+		for _, g := range m.Groups {
+			if c.GetGroup(g) == nil {
+				return fmt.Errorf("group mapping %s: group not found: %s", m.Group, g)
+			}
+		}
+	}
+	return nil
+}
+
+// usernameClaim 返回用户名 claim
+func (o *OIDCConfig) usernameClaim() string {
+	if o.UsernameClaim == "" {
+		return defaultOIDCUsernameClaim
+	}
+	return o.UsernameClaim
+}
+
+// identity 返回 ID token 中与 SSH 用户名比较的身份，使用 email 时要求 email_verified 为 true
+func (o *OIDCConfig) identity(claims map[string]interface{}) (string, error) {
+	claim := o.usernameClaim()
+	value, _ := claims[claim].(string)
+	if value == "" {
+		return "", fmt.Errorf("id token has no %s claim", claim)
+	}
+	if claim == "email" {
+		if verified, _ := claims["email_verified"].(bool); !verified {
+			return "", errors.New("email is not verified")
+		}
+	}
+	return value, nil
+}
+
+// groupsClaim 返回用户组 claim
+func (o *OIDCConfig) groupsClaim() string {
+	if o.GroupsClaim == "" {
+		return defaultOIDCGroupsClaim
+	}
+	return o.GroupsClaim
+}
+
+// scopes 返回设备授权请求的 scope
+func (o *OIDCConfig) scopes() []string {
+	if len(o.Scopes) == 0 {
+		return defaultOIDCScopes
+	}
+	return o.Scopes
+}
+
+// mapGroups 根据身份提供方的用户组返回可以访问的目标和适用的 sshhook 用户组，按 groupMappings 的顺序
+func (o *OIDCConfig) mapGroups(groups []string) (targets, sshGroups []string) {
+	for _, m := range o.GroupMappings {
+		if !contains(groups, m.Group) {
+			continue
+		}
+		for _, t := range m.Targets {
+			if !contains(targets, t) {
+				targets = append(targets, t)
+			}
+		}
+		for _, g := range m.Groups {
+			if !contains(sshGroups, g) {
+				sshGroups = append(sshGroups, g)
+			}
+		}
+	}
+	return targets, sshGroups
+}
+
+// oidcDiscovery openid-configuration 中用到的字段
+type oidcDiscovery struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// deviceAuthorization 设备授权接口的响应
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// errAuthorizationPending 用户还没有在浏览器中完成授权
+var errAuthorizationPending = errors.New("authorization pending")
+
+// oidcProvider 访问身份提供方，缓存 openid-configuration 和签名公钥
+type oidcProvider struct {
+	config OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      []jose.JSONWebKey // jwks_uri 中用于签名的公钥
+	fetched   time.Time         // 最近一次获取 JWKS 的时间
+}
+
+// newOIDCProvider 创建 oidcProvider
+func newOIDCProvider(config OIDCConfig) *oidcProvider {
+	return &oidcProvider{config: config, client: &http.Client{Timeout: oidcHTTPTimeout}}
+}
+
+// getJSON 请求 url 并解析 JSON 响应
+func (p *oidcProvider) getJSON(ctx context.Context, u string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// postForm 提交表单，返回状态码和响应内容
+func (p *oidcProvider) postForm(ctx context.Context, u string, form url.Values) (int, []byte, error) {
+	form.Set("client_id", p.config.ClientID)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret.Value())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, body, err
+}
+
+// discover 返回身份提供方的接口地址，第一次调用时获取
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider: %w", err)
+	}
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("issuer mismatch in discovery document: %s", d.Issuer)
+	}
+	if d.DeviceAuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc provider does not support the device authorization flow")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// startDeviceAuthorization 开始设备授权，返回用户需要打开的地址和输入的代码
+func (p *oidcProvider) startDeviceAuthorization(ctx context.Context) (*deviceAuthorization, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := p.postForm(ctx, d.DeviceAuthorizationEndpoint, url.Values{"scope": {strings.Join(p.config.scopes(), " ")}})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("device authorization failed: %s", oauthError(status, body))
+	}
+	var da deviceAuthorization
+	if err := json.Unmarshal(body, &da); err != nil {
+		return nil, fmt.Errorf("invalid device authorization response: %w", err)
+	}
+	if da.DeviceCode == "" || da.VerificationURI == "" {
+		return nil, errors.New("invalid device authorization response: missing device_code or verification_uri")
+	}
+	return &da, nil
+}
+
+// pollToken 用 device_code 换取 ID token，用户还没有授权时返回 errAuthorizationPending
+func (p *oidcProvider) pollToken(ctx context.Context, deviceCode string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	status, body, err := p.postForm(ctx, d.TokenEndpoint, url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {deviceCode},
+	})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(body, &e)
+		if e.Error == "authorization_pending" || e.Error == "slow_down" {
+			return "", errAuthorizationPending
+		}
+		return "", fmt.Errorf("token request failed: %s", oauthError(status, body))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return "", errors.New("token response does not contain an id_token")
+	}
+	return token.IDToken, nil
+}
+
+// oauthError 返回 OAuth2 错误响应中的 error 和 error_description
+func oauthError(status int, body []byte) string {
+	var e struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != "" {
+		if e.Description != "" {
+			return e.Error + ": " + e.Description
+		}
+		return e.Error
+	}
+	return fmt.Sprintf("HTTP %d", status)
+}
+
+// verifyIDToken 通过 go-oidc 检查 ID token 的签名、issuer、audience、exp 和 nbf，
+// 另外检查 iat 不在未来，返回 claims
+func (p *oidcProvider) verifyIDToken(ctx context.Context, raw string, now time.Time) (map[string]interface{}, error) {
+	algs := make([]string, len(oidcSigningAlgs))
+	for i, alg := range oidcSigningAlgs {
+		algs[i] = string(alg)
+	}
+	verifier := oidc.NewVerifier(p.config.Issuer, p, &oidc.Config{
+		ClientID:             p.config.ClientID,
+		SupportedSigningAlgs: algs,
+		Now:                  func() time.Time { return now },
+	})
+	token, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	if token.IssuedAt.After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id token iat is in the future")
+	}
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("malformed id token claims: %w", err)
+	}
+	return claims, nil
+}
+
+// VerifySignature 实现 oidc.KeySet，用 kid 对应的公钥检查签名，返回 payload
+func (p *oidcProvider) VerifySignature(ctx context.Context, raw string) ([]byte, error) {
+	jws, err := jose.ParseSigned(raw, oidcSigningAlgs)
+	if err != nil {
+		return nil, fmt.Errorf("malformed id token: %w", err)
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("id token must have exactly one signature")
+	}
+	keys, err := p.signingKeys(ctx, jws.Signatures[0].Header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		if payload, err := jws.Verify(&keys[i]); err == nil {
+			return payload, nil
+		}
+	}
+	return nil, errors.New("invalid id token signature")
+}
+
+// signingKeys 按 kid 查找签名公钥（没有 kid 时返回所有公钥）。找不到时重新获取 JWKS
+// （身份提供方可能已经轮换密钥），距离上次获取不到 jwksRefreshInterval 时直接返回错误
+func (p *oidcProvider) signingKeys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lookup := func() []jose.JSONWebKey {
+		var keys []jose.JSONWebKey
+		for _, key := range p.keys {
+			if kid == "" || key.KeyID == kid {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	}
+	if keys := lookup(); len(keys) > 0 {
+		return keys, nil
+	}
+	if !p.fetched.IsZero() && time.Since(p.fetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("signing key not found: %s", kid)
+	}
+
+	p.fetched = time.Now()
+	var jwks jose.JSONWebKeySet
+	if err := p.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	p.keys = nil
+	for _, key := range jwks.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			p.keys = append(p.keys, key)
+		}
+	}
+	if keys := lookup(); len(keys) > 0 {
+		return keys, nil
+	}
+	return nil, fmt.Errorf("signing key not found: %s", kid)
+}
+
+// claimStrings 读取字符串或字符串数组类型的 claim
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package webhook
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.containerssh.io/containerssh/auth"
+	"go.containerssh.io/containerssh/config"
+)
+
+// mockOIDCProvider 本地的身份提供方，支持设备授权流程
+type mockOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex
+	approved  bool
+	claims    map[string]interface{}
+	jwksCount int // JWKS 被获取的次数
+}
+
+// newMockOIDCProvider 启动本地身份提供方
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	p := &mockOIDCProvider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                        p.URL,
+			"device_authorization_endpoint": p.URL + "/device",
+			"token_endpoint":                p.URL + "/token",
+			"jwks_uri":                      p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "sshhook" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"device_code":      "device-1",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": p.URL + "/activate",
+			"expires_in":       600,
+			"interval":         5,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if r.FormValue("grant_type") != deviceCodeGrantType || r.FormValue("device_code") != "device-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		if !p.approved {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, p.key, "key-1", p.claims)})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.jwksCount++
+		p.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// approve 模拟用户在浏览器中授权，返回的 ID token 的 sub 为 username，包含 groups
+func (p *mockOIDCProvider) approve(username string, groups ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.approved = true
+	p.claims = p.validClaims()
+	p.claims["sub"] = username
+	p.claims["groups"] = groups
+}
+
+// validClaims 返回有效的 ID token claims
+func (p *mockOIDCProvider) validClaims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": p.URL,
+		"aud": "sshhook",
+		"sub": "user-1",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// sign 生成 RS256 签名的 JWT
+func (p *mockOIDCProvider) sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// oidcTestServer 身份提供方中 sre 组可以访问 t2，并适用 ops 组的会话配置
+func oidcTestServer(t *testing.T, provider *mockOIDCProvider) *Server {
+	t.Helper()
+	cfg := authzTestConfig()
+	cfg.OIDC = OIDCConfig{
+		Issuer:        provider.URL,
+		ClientID:      "sshhook",
+		GroupMappings: []OIDCGroupMapping{{Group: "sre", Targets: []string{"t2"}, Groups: []string{"ops"}}},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Invalid config: %v", err)
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.offline = true
+	return server
+}
+
+// deviceLogin 调用 keyboard-interactive 接口，answer 为 false 时是第一轮请求
+func deviceLogin(t *testing.T, server *Server, username string, answer bool) KeyboardInteractiveResponse {
+	t.Helper()
+	var req KeyboardInteractiveRequest
+	req.Username = username
+	req.ConnectionID = "conn-oidc"
+	if answer {
+		req.Answers = map[string]string{questionContinue: ""}
+	}
+	rec := postJSON(t, server.handleKeyboardInteractive, req)
+	var resp KeyboardInteractiveResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp
+}
+
+// TestOIDCProvider_VerifyIDToken 测试 ID token 的签名、issuer、audience 和有效期检查
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := newOIDCProvider(OIDCConfig{Issuer: mock.URL, ClientID: "sshhook"})
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	now := time.Now()
+
+	claims := mock.validClaims()
+	claims["aud"] = []string{"other", "sshhook"}
+	if _, err := provider.verifyIDToken(t.Context(), mock.sign(t, mock.key, "key-1", claims), now); err != nil {
+		t.Errorf("Expected valid token, got %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token func() string
+	}{
+		{"wrong audience", func() string {
+			c := mock.validClaims()
+			c["aud"] = "other"
+			return mock.sign(t, mock.key, "key-1", c)
+		}},
+		{"wrong issuer", func() string {
+			c := mock.validClaims()
+			c["iss"] = "https://evil.example.com"
+			return mock.sign(t, mock.key, "key-1", c)
+		}},
+		{"expired", func() string {
+			c := mock.validClaims()
+			c["exp"] = now.Add(-time.Hour).Unix()
+			return mock.sign(t, mock.key, "key-1", c)
+		}},
+		{"issued in the future", func() string {
+			c := mock.validClaims()
+			c["iat"] = now.Add(time.Hour).Unix()
+			return mock.sign(t, mock.key, "key-1", c)
+		}},
+		{"wrong signing key", func() string { return mock.sign(t, other, "key-1", mock.validClaims()) }},
+		{"unknown key id", func() string { return mock.sign(t, mock.key, "key-2", mock.validClaims()) }},
+		{"unsigned", func() string {
+			parts := strings.Split(mock.sign(t, mock.key, "key-1", mock.validClaims()), ".")
+			header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`))
+			return header + "." + parts[1] + "."
+		}},
+		{"malformed", func() string { return "not-a-jwt" }},
+	}
+	for _, tt := range tests {
+		if _, err := provider.verifyIDToken(t.Context(), tt.token(), now); err == nil {
+			t.Errorf("%s: expected error, got nil", tt.name)
+		}
+	}
+}
+
+// TestOIDCProvider_JWKSRefresh 测试找不到 kid 时重新获取 JWKS，两次获取之间至少间隔 jwksRefreshInterval
+func TestOIDCProvider_JWKSRefresh(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider := newOIDCProvider(OIDCConfig{Issuer: mock.URL, ClientID: "sshhook"})
+	now := time.Now()
+	jwksCount := func() int {
+		mock.mu.Lock()
+		defer mock.mu.Unlock()
+		return mock.jwksCount
+	}
+
+	if _, err := provider.verifyIDToken(t.Context(), mock.sign(t, mock.key, "key-1", mock.validClaims()), now); err != nil {
+		t.Fatalf("Expected valid token, got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := provider.verifyIDToken(t.Context(), mock.sign(t, mock.key, "key-2", mock.validClaims()), now); err == nil {
+			t.Error("Expected unknown key id to be rejected")
+		}
+	}
+	if n := jwksCount(); n != 1 {
+		t.Errorf("Expected jwks to be fetched once within the refresh interval, got %d", n)
+	}
+
+	provider.fetched = time.Now().Add(-jwksRefreshInterval)
+	provider.verifyIDToken(t.Context(), mock.sign(t, mock.key, "key-2", mock.validClaims()), now)
+	if n := jwksCount(); n != 2 {
+		t.Errorf("Expected jwks to be fetched again after the refresh interval, got %d", n)
+	}
+}
+
+// TestOIDCConfig_Identity 测试默认使用 sub，使用 email 时要求 email_verified
+func TestOIDCConfig_Identity(t *testing.T) {
+	claims := map[string]interface{}{"sub": "carol", "preferred_username": "mallory", "email": "carol@example.com"}
+	var o OIDCConfig
+	if identity, err := o.identity(claims); err != nil || identity != "carol" {
+		t.Errorf("Expected sub by default, got %q, %v", identity, err)
+	}
+
+	o.UsernameClaim = "email"
+	if _, err := o.identity(claims); err == nil {
+		t.Error("Expected unverified email to be rejected")
+	}
+	claims["email_verified"] = true
+	if identity, err := o.identity(claims); err != nil || identity != "carol@example.com" {
+		t.Errorf("Expected verified email, got %q, %v", identity, err)
+	}
+
+	o.UsernameClaim = "missing"
+	if _, err := o.identity(claims); err == nil {
+		t.Error("Expected missing claim to be rejected")
+	}
+}
+
+// TestDeviceFlow_Login 测试不在 users 中的用户通过设备授权登录，按用户组进入 t2
+func TestDeviceFlow_Login(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	server := oidcTestServer(t, mock)
+
+	first := deviceLogin(t, server, "carol", false)
+	if first.Success || !strings.Contains(first.Instruction, "ABCD-EFGH") || !strings.Contains(first.Instruction, mock.URL+"/activate") {
+		t.Fatalf("Expected verification URL and code, got %+v", first)
+	}
+	if len(first.Questions) != 1 || first.Questions[0].ID != questionContinue {
+		t.Fatalf("Expected continue question, got %+v", first.Questions)
+	}
+
+	// 还没有授权时继续询问
+	if pending := deviceLogin(t, server, "carol", true); pending.Success || len(pending.Questions) != 1 {
+		t.Fatalf("Expected pending question, got %+v", pending)
+	}
+
+	mock.approve("carol", "sre", "unrelated")
+	resp := deviceLogin(t, server, "carol", true)
+	if !resp.Success || resp.AuthenticatedUsername != "carol" {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	if resp.Metadata[metadataTarget].Value != "t2" || resp.Metadata[metadataIdentity].Value != identityOIDC || resp.Metadata[metadataGroups].Value != "ops" {
+		t.Errorf("Unexpected metadata: %v", resp.Metadata)
+	}
+	if events := server.events.list("carol", 1); len(events) != 1 || events[0].Method != "oidc" || !events[0].Success {
+		t.Errorf("Expected oidc event, got %+v", events)
+	}
+
+	// 授权步骤和 config 接口使用登录时写入的目标
+	var authzReq auth.AuthorizationRequest
+	authzReq.Username = "carol"
+	authzReq.AuthenticatedUsername = "carol"
+	authzReq.Metadata = resp.Metadata
+	var authzResp auth.ResponseBody
+	json.Unmarshal(postJSON(t, server.handleAuthz, authzReq).Body.Bytes(), &authzResp)
+	if !authzResp.Success {
+		t.Error("Expected authz to allow the oidc user")
+	}
+
+	var req config.Request
+	req.Username = "carol"
+	req.AuthenticatedUsername = "carol"
+	req.Metadata = resp.Metadata
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected config status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var configResp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &configResp); err != nil {
+		t.Fatalf("Failed to decode config response: %v", err)
+	}
+	if configResp.Config.Kubernetes.Pod.Metadata.Name != "pod-t2" {
+		t.Errorf("Expected pod-t2, got %s", configResp.Config.Kubernetes.Pod.Metadata.Name)
+	}
+}
+
+// TestDeviceFlow_Denied 测试身份与登录名不一致、目标不在用户组中时拒绝
+func TestDeviceFlow_Denied(t *testing.T) {
+	tests := []struct {
+		login    string
+		identity string
+		groups   []string
+		reason   string
+	}{
+		{"carol", "mallory", []string{"sre"}, "identity does not match login name"},
+		{"carol+t3", "carol", []string{"sre"}, "target not allowed: t3"},
+		{"carol", "carol", []string{"unrelated"}, "no target mapped for groups"},
+		// users 中的用户：身份提供方的用户组增加可选的目标，其他目标仍然拒绝
+		{"testuser+t4", "testuser", []string{"sre"}, "target not allowed: t4"},
+	}
+	for _, tt := range tests {
+		mock := newMockOIDCProvider(t)
+		server := oidcTestServer(t, mock)
+		deviceLogin(t, server, tt.login, false)
+		mock.approve(tt.identity, tt.groups...)
+		if resp := deviceLogin(t, server, tt.login, true); resp.Success {
+			t.Errorf("%s: expected login to be denied", tt.login)
+		}
+		if reason := server.lastAuthReason(); reason != tt.reason {
+			t.Errorf("%s: expected reason %q, got %q", tt.login, tt.reason, reason)
+		}
+	}
+
+	// 没有开始设备授权时直接回答
+	mock := newMockOIDCProvider(t)
+	server := oidcTestServer(t, mock)
+	if resp := deviceLogin(t, server, "carol", true); resp.Success {
+		t.Error("Expected answer without device authorization to be denied")
+	}
+}
+
+// TestDeviceFlow_ConfigUser 测试 users 中的用户通过身份提供方登录，可以选择用户组对应的目标
+func TestDeviceFlow_ConfigUser(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	server := oidcTestServer(t, mock)
+	server.config.Users[0].Targets = nil
+
+	deviceLogin(t, server, "testuser+t2", false)
+	mock.approve("testuser", "sre")
+	resp := deviceLogin(t, server, "testuser+t2", true)
+	if !resp.Success || resp.AuthenticatedUsername != "testuser" || resp.Metadata[metadataTarget].Value != "t2" {
+		t.Fatalf("Expected testuser to select t2, got %+v", resp)
+	}
+	if resp.Metadata["team"].Value != "ops" {
+		t.Errorf("Expected user metadata, got %v", resp.Metadata)
+	}
+
+	// t2 不在用户自己的 targets 中，授权步骤使用登录时的检查结果
+	var authzReq auth.AuthorizationRequest
+	authzReq.Username = "testuser+t2"
+	authzReq.AuthenticatedUsername = "testuser"
+	authzReq.Metadata = resp.Metadata
+	var authzResp auth.ResponseBody
+	json.Unmarshal(postJSON(t, server.handleAuthz, authzReq).Body.Bytes(), &authzResp)
+	if !authzResp.Success {
+		t.Error("Expected authz to allow the target checked at login")
+	}
+}
+
+// TestDeviceFlow_BreakGlass 测试紧急访问用户不能通过身份提供方登录，紧急访问启用时也不能
+func TestDeviceFlow_BreakGlass(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	server := oidcTestServer(t, mock)
+	server.config.Users[0].BreakGlass = true
+	now := time.Now()
+	server.config.BreakGlassActivation = &BreakGlassActivation{EnabledBy: "oncall", EnabledAt: now, ExpiresAt: now.Add(time.Hour)}
+
+	for _, answered := range []bool{false, true} {
+		mock.approve("testuser", "sre")
+		resp := deviceLogin(t, server, "testuser", answered)
+		if resp.Success || resp.Instruction != "" {
+			t.Errorf("Expected break-glass user to be rejected before device authorization, got %+v", resp)
+		}
+		if reason := server.lastAuthReason(); reason != "break-glass users must use password authentication" {
+			t.Errorf("Unexpected reason: %q", reason)
+		}
+	}
+}
+
+// TestOIDCConfig_Validate 测试 oidc 配置检查
+func TestOIDCConfig_Validate(t *testing.T) {
+	cfg := authzTestConfig()
+	cfg.OIDC = OIDCConfig{Issuer: "https://idp.example.com", ClientID: "sshhook",
+		GroupMappings: []OIDCGroupMapping{{Group: "sre", Targets: []string{"t2"}}}}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+
+	cfg.OIDC.GroupMappings[0].Targets = []string{"missing"}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "target not found: missing") {
+		t.Errorf("Expected missing target error, got %v", err)
+	}
+	cfg.OIDC.GroupMappings = nil
+	cfg.OIDC.ClientID = ""
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "clientID is required") {
+		t.Errorf("Expected clientID error, got %v", err)
+	}
+	cfg.OIDC = OIDCConfig{ClientID: "sshhook"}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "issuer is required") {
+		t.Errorf("Expected issuer error, got %v", err)
+	}
+}
+
+// TestRenderContainerSSHConfig_OIDC 测试启用 oidc 时生成 keyboard-interactive 配置，用户可以没有密码和公钥
+func TestRenderContainerSSHConfig_OIDC(t *testing.T) {
+	cfg := &Config{
+		Listen: ":8080",
+		Users:  []UserConfig{{Username: "user1"}},
+		OIDC:   OIDCConfig{Issuer: "https://idp.example.com", ClientID: "sshhook"},
+	}
+	data, err := RenderContainerSSHConfig(cfg, ContainerSSHOptions{HostKeys: []string{"ssh_host_ed25519_key"}})
+	if err != nil {
+		t.Fatalf("Failed to render config: %v", err)
+	}
+	if !strings.Contains(string(data), "keyboardInteractive:") {
+		t.Errorf("Expected keyboardInteractive in rendered config, got:\n%s", data)
+	}
+}
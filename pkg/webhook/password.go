@@ -0,0 +1,45 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashPassword 生成 passwordHash 使用的 bcrypt 哈希
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password must not be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkPassword 验证密码，配置了 passwordHash 时按 bcrypt 验证；
+// 没有配置密码的用户（如只使用公钥的用户）密码认证总是失败
+func (u *UserConfig) checkPassword(password string) bool {
+	if u.PasswordHash != "" {
+		return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+	}
+	expected := u.Password.Value()
+	return expected != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// validatePassword 检查密码配置
+func (u *UserConfig) validatePassword() error {
+	if u.PasswordHash == "" {
+		return nil
+	}
+	if u.Password != "" {
+		return errors.New("password and passwordHash cannot be used together")
+	}
+	if _, err := bcrypt.Cost([]byte(u.PasswordHash)); err != nil {
+		return fmt.Errorf("invalid passwordHash: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"testing"
+)
+
+// TestCheckPassword 测试明文密码、bcrypt 哈希和未配置密码的用户
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		user     UserConfig
+		password string
+		want     bool
+	}{
+		{"plain", UserConfig{Password: "secret"}, "secret", true},
+		{"plain mismatch", UserConfig{Password: "secret"}, "wrong", false},
+		{"hash", UserConfig{PasswordHash: hash}, "secret", true},
+		{"hash mismatch", UserConfig{PasswordHash: hash}, "wrong", false},
+		{"no password", UserConfig{}, "", false},
+	}
+	for _, tt := range tests {
+		if got := tt.user.checkPassword(tt.password); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := HashPassword(""); err == nil {
+		t.Error("Expected error for empty password")
+	}
+}
+
+// TestValidatePassword 测试 passwordHash 的检查
+func TestValidatePassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := (&UserConfig{PasswordHash: hash}).validatePassword(); err != nil {
+		t.Errorf("Expected valid passwordHash, got %v", err)
+	}
+	if err := (&UserConfig{PasswordHash: "secret"}).validatePassword(); err == nil {
+		t.Error("Expected error for non-bcrypt passwordHash")
+	}
+	if err := (&UserConfig{Password: "secret", PasswordHash: hash}).validatePassword(); err == nil {
+		t.Error("Expected error for password and passwordHash together")
+	}
+}
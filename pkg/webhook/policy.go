@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+)
+
+const (
+	// metadataKeyType 公钥认证时客户端公钥的类型，供 config 请求的策略检查使用
+	metadataKeyType = "SSHHOOK_KEY_TYPE"
+
+	policyStageAuth   = "auth"
+	policyStageConfig = "config"
+)
+
+// PolicyConfig 访问策略，deny 为 CEL 表达式，结果为 true 时拒绝登录。
+// 表达式在加载配置时编译和检查类型，可用的变量见 policyVariables
+type PolicyConfig struct {
+	Name    string   `yaml:"name"`              // 策略名称，拒绝时记录
+	Stages  []string `yaml:"stages,omitempty"`  // 检查的时机：auth（认证）、config（返回配置），默认两者
+	Deny    string   `yaml:"deny"`              // CEL 表达式
+	Message string   `yaml:"message,omitempty"` // 拒绝原因中的说明（可选）
+
+	program cel.Program
+}
+
+// policyVariables 策略表达式中可用的变量
+var policyVariables = []cel.EnvOption{
+	cel.Variable("stage", cel.StringType),                                      // auth 或 config
+	cel.Variable("user", cel.StringType),                                       // 认证后的用户名
+	cel.Variable("groups", cel.ListType(cel.StringType)),                       // 用户所属的组
+	cel.Variable("remoteAddress", cel.StringType),                              // 客户端 IP
+	cel.Variable("now", cel.TimestampType),                                     // 当前时间
+	cel.Variable("target", cel.StringType),                                     // 登录目标，没有目标时为空
+	cel.Variable("cluster", cel.StringType),                                    // 集群名称
+	cel.Variable("clusterLabels", cel.MapType(cel.StringType, cel.StringType)), // 集群的 labels
+	cel.Variable("keyType", cel.StringType),                                    // 公钥类型，如 ssh-ed25519，非公钥认证时为空
+	cel.Variable("flags", cel.MapType(cel.StringType, cel.BoolType)),           // 配置中的 policyFlags
+}
+
+// newPolicyEnv 创建策略表达式的 CEL 环境，除变量外提供 inCIDR(address, cidr) 函数
+func newPolicyEnv() (*cel.Env, error) {
+	opts := append([]cel.EnvOption{}, policyVariables...)
+	opts = append(opts, cel.Function("inCIDR",
+		cel.Overload("inCIDR_string_string", []*cel.Type{cel.StringType, cel.StringType}, cel.BoolType,
+			cel.BinaryBinding(inCIDR))))
+	return cel.NewEnv(opts...)
+}
+
+// inCIDR 检查 IP 是否在网段中，地址为空时返回 false
+func inCIDR(address, cidr ref.Val) ref.Val {
+	_, network, err := net.ParseCIDR(string(cidr.(types.String)))
+	if err != nil {
+		return types.NewErr("inCIDR: %v", err)
+	}
+	ip := net.ParseIP(string(address.(types.String)))
+	return types.Bool(ip != nil && network.Contains(ip))
+}
+
+// compilePolicies 编译策略表达式，表达式必须返回 bool
+func (c *Config) compilePolicies() error {
+	if len(c.Policies) == 0 {
+		return nil
+	}
+	env, err := newPolicyEnv()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := range c.Policies {
+		p := &c.Policies[i]
+		if p.Name == "" {
+			return errors.New("policy without name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate policy: %s", p.Name)
+		}
+		names[p.Name] = true
+		for _, stage := range p.Stages {
+			if stage != policyStageAuth && stage != policyStageConfig {
+				return fmt.Errorf("policy %s: invalid stage: %s", p.Name, stage)
+			}
+		}
+		if p.Deny == "" {
+			return fmt.Errorf("policy %s: deny is required", p.Name)
+		}
+		ast, iss := env.Compile(p.Deny)
+		if iss.Err() != nil {
+			return fmt.Errorf("policy %s: %w", p.Name, iss.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return fmt.Errorf("policy %s: deny must be a bool expression, got %s", p.Name, ast.OutputType())
+		}
+		if p.program, err = env.Program(ast); err != nil {
+			return fmt.Errorf("policy %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo 策略是否在 stage 检查
+func (p *PolicyConfig) appliesTo(stage string) bool {
+	return len(p.Stages) == 0 || contains(p.Stages, stage)
+}
+
+// policyInput 策略表达式的输入
+type policyInput struct {
+	Stage         string
+	User          string
+	Groups        []string
+	RemoteAddress string
+	Time          time.Time
+	Target        string
+	Cluster       string
+	KeyType       string
+}
+
+// newPolicyInput 根据用户、登录目标和认证时写入的 metadata 生成输入，
+// 集群优先使用 metadata 中的 KUBERNETES_CLUSTER，否则使用目标的集群
+func (c *Config) newPolicyInput(stage string, user *UserConfig, data *TemplateData, target string, md map[string]string) *policyInput {
+	if target == "" {
+		target = user.Target
+	}
+	cluster := md["KUBERNETES_CLUSTER"]
+	if t := c.GetTarget(target); cluster == "" && t != nil {
+		cluster = t.Cluster
+	}
+	return &policyInput{
+		Stage:         stage,
+		User:          user.Username,
+		Groups:        user.Groups,
+		RemoteAddress: data.RemoteAddress,
+		Time:          time.Now(),
+		Target:        target,
+		Cluster:       cluster,
+		KeyType:       md[metadataKeyType],
+	}
+}
+
+// checkPolicies 按顺序检查策略，返回拒绝的原因，允许时返回空字符串。
+// 表达式执行出错（如访问不存在的 label）时同样拒绝
+func (c *Config) checkPolicies(in *policyInput) string {
+	if len(c.Policies) == 0 {
+		return ""
+	}
+	labels := map[string]string{}
+	if cl := c.GetCluster(in.Cluster); cl != nil && cl.Labels != nil {
+		labels = cl.Labels
+	}
+	groups := in.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+	flags := c.PolicyFlags
+	if flags == nil {
+		flags = map[string]bool{}
+	}
+	vars := map[string]interface{}{
+		"stage":         in.Stage,
+		"user":          in.User,
+		"groups":        groups,
+		"remoteAddress": in.RemoteAddress,
+		"now":           in.Time,
+		"target":        in.Target,
+		"cluster":       in.Cluster,
+		"clusterLabels": labels,
+		"keyType":       in.KeyType,
+		"flags":         flags,
+	}
+	for i := range c.Policies {
+		p := &c.Policies[i]
+		if !p.appliesTo(in.Stage) {
+			continue
+		}
+		out, _, err := p.program.Eval(vars)
+		if err != nil {
+			log.Printf("[Policy] Failed to evaluate policy %s for user %s: %v", p.Name, in.User, err)
+			return fmt.Sprintf("policy %s failed: %v", p.Name, err)
+		}
+		if deny, ok := out.Value().(bool); !ok || deny {
+			log.Printf("[Policy] Policy %s denied user %s (stage=%s, target=%s, cluster=%s, remoteAddress=%s)",
+				p.Name, in.User, in.Stage, in.Target, in.Cluster, in.RemoteAddress)
+			if p.Message != "" {
+				return fmt.Sprintf("denied by policy %s: %s", p.Name, p.Message)
+			}
+			return fmt.Sprintf("denied by policy %s", p.Name)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.containerssh.io/containerssh/config"
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// policyTestConfig 在 simulateTestConfig 的基础上给集群加上 labels 并配置策略
+func policyTestConfig(t *testing.T, policies ...PolicyConfig) *Config {
+	t.Helper()
+	cfg := simulateTestConfig()
+	cfg.Users[0].Recording = nil
+	cfg.Clusters[0].Labels = map[string]string{"env": "prod"}
+	cfg.Policies = policies
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	return cfg
+}
+
+// TestCompilePolicies_Errors 测试加载时发现策略的错误
+func TestCompilePolicies_Errors(t *testing.T) {
+	tests := []struct {
+		policy PolicyConfig
+		err    string
+	}{
+		{PolicyConfig{Deny: "true"}, "policy without name"},
+		{PolicyConfig{Name: "p"}, "deny is required"},
+		{PolicyConfig{Name: "p", Deny: "true", Stages: []string{"login"}}, "invalid stage: login"},
+		{PolicyConfig{Name: "p", Deny: "team == 'ops'"}, "undeclared reference to 'team'"},
+		{PolicyConfig{Name: "p", Deny: "user + 'x'"}, "deny must be a bool expression"},
+		{PolicyConfig{Name: "p", Deny: "groups == 'ops'"}, "no matching overload"},
+	}
+	for _, tt := range tests {
+		cfg := simulateTestConfig()
+		cfg.Policies = []PolicyConfig{tt.policy}
+		if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: expected error containing %q, got %v", tt.policy.Deny, tt.err, err)
+		}
+	}
+
+	cfg := simulateTestConfig()
+	cfg.Policies = []PolicyConfig{{Name: "p", Deny: "false"}, {Name: "p", Deny: "true"}}
+	if err := cfg.validate(); err == nil || !strings.Contains(err.Error(), "duplicate policy: p") {
+		t.Errorf("Expected duplicate policy error, got %v", err)
+	}
+}
+
+// TestCheckPolicies 测试按用户组、来源地址、开关、集群 labels 和时间检查策略，拒绝时返回命中的策略
+func TestCheckPolicies(t *testing.T) {
+	cfg := policyTestConfig(t,
+		PolicyConfig{
+			Name:    "contractors-dev-only",
+			Deny:    `"contractors" in groups && clusterLabels["env"] != "dev"`,
+			Message: "contractors can only reach dev clusters",
+		},
+		PolicyConfig{
+			Name: "sre-prod-vpn-incident",
+			Deny: `clusterLabels["env"] == "prod" && !("sre" in groups && inCIDR(remoteAddress, "10.8.0.0/16") && "incident" in flags && flags["incident"])`,
+		},
+		PolicyConfig{
+			Name:   "no-weekend-config",
+			Stages: []string{policyStageConfig},
+			Deny:   `now.getDayOfWeek("UTC") == 0`,
+		},
+	)
+	cfg.PolicyFlags = map[string]bool{"incident": true}
+	monday := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+	sunday := time.Date(2025, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		in     policyInput
+		reason string
+	}{
+		{"sre from vpn", policyInput{Stage: policyStageAuth, Groups: []string{"sre"}, RemoteAddress: "10.8.1.2", Cluster: "c1", Time: monday}, ""},
+		{"sre outside vpn", policyInput{Stage: policyStageAuth, Groups: []string{"sre"}, RemoteAddress: "192.168.1.2", Cluster: "c1", Time: monday}, "denied by policy sre-prod-vpn-incident"},
+		{"contractor", policyInput{Stage: policyStageAuth, Groups: []string{"contractors", "sre"}, RemoteAddress: "10.8.1.2", Cluster: "c1", Time: monday},
+			"denied by policy contractors-dev-only: contractors can only reach dev clusters"},
+		{"sunday auth", policyInput{Stage: policyStageAuth, Groups: []string{"sre"}, RemoteAddress: "10.8.1.2", Cluster: "c1", Time: sunday}, ""},
+		{"sunday config", policyInput{Stage: policyStageConfig, Groups: []string{"sre"}, RemoteAddress: "10.8.1.2", Cluster: "c1", Time: sunday}, "denied by policy no-weekend-config"},
+	}
+	for _, tt := range tests {
+		if reason := cfg.checkPolicies(&tt.in); reason != tt.reason {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.reason, reason)
+		}
+	}
+
+	// 事故结束后删除开关
+	cfg.PolicyFlags = nil
+	in := policyInput{Stage: policyStageAuth, Groups: []string{"sre"}, RemoteAddress: "10.8.1.2", Cluster: "c1", Time: monday}
+	if reason := cfg.checkPolicies(&in); reason != "denied by policy sre-prod-vpn-incident" {
+		t.Errorf("Expected denial without incident flag, got %q", reason)
+	}
+}
+
+// TestCheckPolicies_EvalError 测试表达式执行出错时拒绝，如访问集群上没有的 label
+func TestCheckPolicies_EvalError(t *testing.T) {
+	cfg := policyTestConfig(t, PolicyConfig{Name: "team-label", Deny: `clusterLabels["team"] != "ops"`})
+	in := policyInput{Stage: policyStageAuth, Cluster: "c1", Time: time.Now()}
+	if reason := cfg.checkPolicies(&in); !strings.HasPrefix(reason, "policy team-label failed: no such key") {
+		t.Errorf("Expected evaluation failure, got %q", reason)
+	}
+
+	cfg = policyTestConfig(t, PolicyConfig{Name: "team-label", Deny: `"team" in clusterLabels && clusterLabels["team"] != "ops"`})
+	if reason := cfg.checkPolicies(&in); reason != "" {
+		t.Errorf("Expected login to be allowed, got %q", reason)
+	}
+}
+
+// TestPolicy_Auth 测试认证时检查策略，拒绝原因记录在认证事件中
+func TestPolicy_Auth(t *testing.T) {
+	cfg := policyTestConfig(t, PolicyConfig{
+		Name:   "no-rsa",
+		Stages: []string{policyStageAuth},
+		Deny:   `keyType == "ssh-rsa"`,
+	})
+
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser", PublicKey: cfg.Users[0].PublicKey})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Authenticated || result.Reason != "denied by policy no-rsa" {
+		t.Errorf("Expected denial by no-rsa, got %+v", result)
+	}
+
+	result, err = Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if !result.Authenticated {
+		t.Errorf("Expected password login to be allowed, got %+v", result)
+	}
+}
+
+// TestPolicy_Config 测试 config 接口检查策略，公钥类型来自认证时写入的 metadata
+func TestPolicy_Config(t *testing.T) {
+	cfg := policyTestConfig(t, PolicyConfig{
+		Name:   "prod-ed25519",
+		Stages: []string{policyStageConfig},
+		Deny:   `clusterLabels["env"] == "prod" && keyType != "ssh-ed25519"`,
+	})
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.offline = true
+
+	var req config.Request
+	req.Username = "testuser"
+	req.AuthenticatedUsername = "testuser"
+	req.Metadata = map[string]metadata.Value{metadataKeyType: {Value: "ssh-rsa"}}
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "denied by policy prod-ed25519") {
+		t.Errorf("Expected status 403 from policy, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req.Metadata = map[string]metadata.Value{metadataKeyType: {Value: "ssh-ed25519"}}
+	rec = postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// metadataRecordingRetention 录像保留期标签写入 metadata 的 key
+const metadataRecordingRetention = "RECORDING_RETENTION"
+
+// RecordingConfig 会话录像配置，使用 ContainerSSH 的审计日志实现
+// 可以设置在集群、目标、组和用户上，优先级依次升高，未设置的项沿用低优先级的配置
+type RecordingConfig struct {
+	Enable    *bool     `yaml:"enable,omitempty"`    // 是否录像，生产目标上不能关闭
+	Format    string    `yaml:"format,omitempty"`    // asciinema（默认）或 binary
+	Storage   string    `yaml:"storage,omitempty"`   // file（默认）或 s3
+	Directory string    `yaml:"directory,omitempty"` // file：录像目录；s3：上传前的本地暂存目录
+	Retention string    `yaml:"retention,omitempty"` // 保留期标签，如 90d，file 存储时作为子目录
+	S3        *S3Config `yaml:"s3,omitempty"`        // S3 兼容存储
+}
+
+// S3Config S3 兼容存储配置
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint,omitempty"` // 自建存储的地址，如 MinIO
+	Region          string `yaml:"region,omitempty"`
+	Bucket          string `yaml:"bucket"`
+	AccessKey       Secret `yaml:"accessKey"` // 支持 file:、env:、k8s: 引用
+	SecretKey       Secret `yaml:"secretKey"` // 支持 file:、env:、k8s: 引用
+	CACert          string `yaml:"cacert,omitempty"`
+	PathStyleAccess bool   `yaml:"pathStyleAccess,omitempty"`
+}
+
+// merge 用 other 中设置了的项覆盖当前配置
+func (r *RecordingConfig) merge(other *RecordingConfig) {
+	if other == nil {
+		return
+	}
+	if other.Enable != nil {
+		r.Enable = other.Enable
+	}
+	if other.Format != "" {
+		r.Format = other.Format
+	}
+	if other.Storage != "" {
+		r.Storage = other.Storage
+	}
+	if other.Directory != "" {
+		r.Directory = other.Directory
+	}
+	if other.Retention != "" {
+		r.Retention = other.Retention
+	}
+	if other.S3 != nil {
+		r.S3 = other.S3
+	}
+}
+
+// retentionPattern 合法的保留期标签，file 存储时用作子目录名称
+var retentionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// validateFields 检查单层配置中的取值
+func (r *RecordingConfig) validateFields() error {
+	switch config.AuditLogFormat(r.Format) {
+	case "", config.AuditLogFormatAsciinema, config.AuditLogFormatBinary:
+	default:
+		return fmt.Errorf("recording format must be asciinema or binary, got %s", r.Format)
+	}
+	switch config.AuditLogStorage(r.Storage) {
+	case "", config.AuditLogStorageFile, config.AuditLogStorageS3:
+	default:
+		return fmt.Errorf("recording storage must be file or s3, got %s", r.Storage)
+	}
+	if r.Retention != "" && !retentionPattern.MatchString(r.Retention) {
+		return fmt.Errorf("invalid recording retention: %s", r.Retention)
+	}
+	return nil
+}
+
+// validate 检查合并后的录像配置是否完整
+func (r *RecordingConfig) validate() error {
+	if err := r.validateFields(); err != nil {
+		return err
+	}
+	if config.AuditLogStorage(r.Storage) == config.AuditLogStorageS3 && (r.S3 == nil || r.S3.Bucket == "") {
+		return errors.New("recording s3 bucket is required")
+	}
+	if r.Directory == "" {
+		return errors.New("recording directory is required")
+	}
+	return nil
+}
+
+// enabled 判断是否录像
+func (r *RecordingConfig) enabled() bool {
+	return r.Enable != nil && *r.Enable
+}
+
+// production 判断目标是否属于生产环境
+func production(cluster *ClusterConfig, target *TargetConfig) bool {
+	return cluster.Production || (target != nil && target.Production)
+}
+
+// resolveRecording 按集群、目标、组、用户的顺序合并录像配置
+// 生产环境只合并开启录像的设置，组和用户不能关闭录像
+func resolveRecording(cluster *ClusterConfig, target *TargetConfig, layers []*SessionConfig) RecordingConfig {
+	var r RecordingConfig
+	r.merge(cluster.Recording)
+	prod := production(cluster, target)
+	for _, layer := range layers {
+		rec := layer.Recording
+		if prod && rec != nil && rec.Enable != nil && !*rec.Enable {
+			copied := *rec
+			copied.Enable = nil
+			rec = &copied
+		}
+		r.merge(rec)
+	}
+	return r
+}
+
+// requireRecording 检查生产集群或目标自身的配置已经开启了完整的录像
+func requireRecording(cluster *ClusterConfig, target *TargetConfig) error {
+	var layers []*SessionConfig
+	if target != nil {
+		layers = append(layers, &target.SessionConfig)
+	}
+	r := resolveRecording(cluster, target, layers)
+	if !r.enabled() {
+		return errors.New("recording must be enabled for production")
+	}
+	return r.validate()
+}
+
+// auditConfig 生成 ContainerSSH 的审计日志配置，未开启录像时返回空配置
+func (r *RecordingConfig) auditConfig() config.AuditLogConfig {
+	var audit config.AuditLogConfig
+	if !r.enabled() {
+		return audit
+	}
+
+	audit.Enable = true
+	audit.Format = config.AuditLogFormatAsciinema
+	if r.Format != "" {
+		audit.Format = config.AuditLogFormat(r.Format)
+	}
+	audit.Intercept = config.AuditLogInterceptConfig{Stdin: true, Stdout: true, Stderr: true}
+
+	directory := r.Directory
+	if r.Retention != "" {
+		directory = filepath.Join(directory, r.Retention)
+	}
+
+	if config.AuditLogStorage(r.Storage) == config.AuditLogStorageS3 {
+		audit.Storage = config.AuditLogStorageS3
+		audit.S3 = config.AuditLogS3Config{
+			Local:           directory,
+			AccessKey:       r.S3.AccessKey.Value(),
+			SecretKey:       r.S3.SecretKey.Value(),
+			Bucket:          r.S3.Bucket,
+			Region:          r.S3.Region,
+			Endpoint:        r.S3.Endpoint,
+			CACert:          r.S3.CACert,
+			PathStyleAccess: r.S3.PathStyleAccess,
+			Metadata:        config.AuditLogS3Metadata{IP: true, Username: true},
+		}
+		return audit
+	}
+
+	audit.Storage = config.AuditLogStorageFile
+	audit.File.Directory = directory
+	return audit
+}
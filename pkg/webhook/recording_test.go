@@ -0,0 +1,124 @@
+package webhook
+
+import (
+	"testing"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// TestResolveRecording 测试录像配置按集群、目标、组、用户合并
+func TestResolveRecording(t *testing.T) {
+	cluster := &ClusterConfig{Name: "c1", Recording: &RecordingConfig{Enable: boolPtr(true), Directory: "/var/log/sshproxy"}}
+	target := &TargetConfig{Name: "t1", SessionConfig: SessionConfig{Recording: &RecordingConfig{Format: "binary"}}}
+	layers := []*SessionConfig{
+		&target.SessionConfig,
+		{Recording: &RecordingConfig{Retention: "90d"}},
+		{Recording: &RecordingConfig{Enable: boolPtr(false)}},
+	}
+
+	r := resolveRecording(cluster, target, layers)
+	if r.enabled() {
+		t.Error("Expected user layer to disable recording on non-production target")
+	}
+	if r.Format != "binary" || r.Retention != "90d" || r.Directory != "/var/log/sshproxy" {
+		t.Errorf("Unexpected merged recording config: %+v", r)
+	}
+
+	// 生产目标上组和用户不能关闭录像
+	target.Production = true
+	r = resolveRecording(cluster, target, layers)
+	if !r.enabled() {
+		t.Error("Expected recording to stay enabled on production target")
+	}
+	if layers[2].Recording.Enable == nil || *layers[2].Recording.Enable {
+		t.Error("Expected user config not to be modified")
+	}
+}
+
+// TestRecordingAuditConfig 测试录像配置转换为 ContainerSSH 的审计日志配置
+func TestRecordingAuditConfig(t *testing.T) {
+	r := RecordingConfig{}
+	if audit := r.auditConfig(); audit.Enable {
+		t.Errorf("Expected disabled audit log, got %+v", audit)
+	}
+
+	r = RecordingConfig{Enable: boolPtr(true), Directory: "/var/log/sshproxy", Retention: "90d"}
+	audit := r.auditConfig()
+	if !audit.Enable || audit.Format != config.AuditLogFormatAsciinema || audit.Storage != config.AuditLogStorageFile {
+		t.Errorf("Unexpected audit config: %+v", audit)
+	}
+	if audit.File.Directory != "/var/log/sshproxy/90d" {
+		t.Errorf("Expected retention subdirectory, got '%s'", audit.File.Directory)
+	}
+	if !audit.Intercept.Stdin || !audit.Intercept.Stdout || !audit.Intercept.Stderr || audit.Intercept.Passwords {
+		t.Errorf("Unexpected intercept config: %+v", audit.Intercept)
+	}
+
+	r = RecordingConfig{
+		Enable:    boolPtr(true),
+		Format:    "binary",
+		Storage:   "s3",
+		Directory: "/tmp/recordings",
+		S3:        &S3Config{Bucket: "audit", Endpoint: "https://minio:9000", AccessKey: "ak", SecretKey: "sk"},
+	}
+	audit = r.auditConfig()
+	if audit.Format != config.AuditLogFormatBinary || audit.Storage != config.AuditLogStorageS3 {
+		t.Errorf("Unexpected audit config: %+v", audit)
+	}
+	if audit.S3.Bucket != "audit" || audit.S3.Local != "/tmp/recordings" || audit.S3.AccessKey != "ak" || audit.S3.SecretKey != "sk" {
+		t.Errorf("Unexpected s3 config: %+v", audit.S3)
+	}
+	if !audit.S3.Metadata.IP || !audit.S3.Metadata.Username {
+		t.Errorf("Expected s3 metadata to be enabled, got %+v", audit.S3.Metadata)
+	}
+}
+
+// TestRecordingValidate 测试录像配置检查
+func TestRecordingValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		recording RecordingConfig
+		wantErr   bool
+	}{
+		{"file", RecordingConfig{Directory: "/var/log/sshproxy"}, false},
+		{"missing directory", RecordingConfig{}, true},
+		{"invalid format", RecordingConfig{Format: "text", Directory: "/tmp"}, true},
+		{"invalid storage", RecordingConfig{Storage: "gcs", Directory: "/tmp"}, true},
+		{"invalid retention", RecordingConfig{Retention: "../90d", Directory: "/tmp"}, true},
+		{"missing bucket", RecordingConfig{Storage: "s3", Directory: "/tmp"}, true},
+		{"s3", RecordingConfig{Storage: "s3", Directory: "/tmp", S3: &S3Config{Bucket: "audit"}}, false},
+	}
+	for _, tt := range tests {
+		err := tt.recording.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+// TestValidate_ProductionRecording 测试生产目标必须开启录像
+func TestValidate_ProductionRecording(t *testing.T) {
+	cfg := &Config{
+		Clusters: []ClusterConfig{{Name: "c1"}},
+		Targets:  []TargetConfig{{Name: "t1", Cluster: "c1", Production: true}},
+	}
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected error for production target without recording")
+	}
+
+	cfg.Clusters[0].Recording = &RecordingConfig{Enable: boolPtr(true), Directory: "/var/log/sshproxy"}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	cfg.Targets[0].Recording = &RecordingConfig{Enable: boolPtr(false)}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("Expected target opt-out to be ignored in production, got %v", err)
+	}
+
+	cfg.Clusters[0].Production = true
+	cfg.Clusters[0].Recording.Enable = nil
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected error for production cluster without recording")
+	}
+}
@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// secretResolveTimeout 读取 Kubernetes Secret 的超时时间
+const secretResolveTimeout = 10 * time.Second
+
+// redacted 代替明文输出的字符串
+const redacted = "******"
+
+// Secret 包含敏感信息的配置值，支持以下写法：
+//
+//	file:/path/to/file                       读取文件内容，去掉末尾的换行
+//	env:NAME                                 读取环境变量
+//	k8s:<cluster>/<namespace>/<secret>/<key> 读取 clusters 中对应集群的 Secret
+//
+// 其他值按明文处理。引用在加载和重新加载配置时解析，
+// 格式化输出和序列化时只输出 ******，避免明文出现在日志中
+type Secret string
+
+// Value 返回明文
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String 实现 fmt.Stringer，不输出明文
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString 实现 fmt.GoStringer，%#v 也不输出明文
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+// MarshalJSON 序列化时不输出明文
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", s.String())), nil
+}
+
+// MarshalYAML 序列化时不输出明文
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// secretField 配置中的敏感字段
+type secretField struct {
+	name  string // 字段名称，出现在错误信息中
+	value *Secret
+}
+
+// secretFields 返回配置中所有的敏感字段
+func (c *Config) secretFields() []secretField {
+	var fields []secretField
+	recording := func(name string, r *RecordingConfig) {
+		if r != nil && r.S3 != nil {
+			fields = append(fields,
+				secretField{name + ": recording s3 accessKey", &r.S3.AccessKey},
+				secretField{name + ": recording s3 secretKey", &r.S3.SecretKey})
+		}
+	}
+	for i := range c.Admin.Tokens {
+		fields = append(fields, secretField{fmt.Sprintf("admin: token %d", i+1), &c.Admin.Tokens[i].Token})
+	}
+	for i := range c.SessionLimits.Tokens {
+		fields = append(fields, secretField{fmt.Sprintf("sessionLimits: token %d", i+1), &c.SessionLimits.Tokens[i]})
+	}
+	fields = append(fields, secretField{"mfa: encryptionKey", &c.MFA.EncryptionKey})
+	fields = append(fields, secretField{"oidc: clientSecret", &c.OIDC.ClientSecret})
+	for i := range c.BreakGlass.Notify {
+		fields = append(fields, secretField{fmt.Sprintf("breakGlass: notify %d", i+1), &c.BreakGlass.Notify[i]})
+	}
+	for i := range c.Clusters {
+		recording("cluster "+c.Clusters[i].Name, c.Clusters[i].Recording)
+	}
+	for i := range c.Targets {
+		recording("target "+c.Targets[i].Name, c.Targets[i].Recording)
+	}
+	for i := range c.Groups {
+		recording("group "+c.Groups[i].Name, c.Groups[i].Recording)
+	}
+	for i := range c.Users {
+		u := &c.Users[i]
+		fields = append(fields, secretField{fmt.Sprintf("user %s: password", u.Username), &u.Password})
+		recording("user "+u.Username, u.Recording)
+		for j := range u.Environment {
+			env := &u.Environment[j]
+			fields = append(fields, secretField{fmt.Sprintf("user %s: environment %s", u.Username, env.Name), &env.Value})
+		}
+		for j := range u.Files {
+			f := &u.Files[j]
+			fields = append(fields, secretField{fmt.Sprintf("user %s: file %s", u.Username, f.Path), &f.Content})
+		}
+	}
+	return fields
+}
+
+// resolveSecrets 解析配置中所有的 Secret 引用，错误信息只包含字段名和引用，不包含明文
+func (c *Config) resolveSecrets(ctx context.Context, kube *kubeClients) error {
+	for _, f := range c.secretFields() {
+		value, err := c.resolveSecret(ctx, kube, string(*f.value))
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.value = Secret(value)
+	}
+	return nil
+}
+
+// resolveSecret 解析单个 Secret 引用
+func (c *Config) resolveSecret(ctx context.Context, kube *kubeClients, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "file:"):
+		path := strings.TrimPrefix(ref, "file:")
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, "k8s:"):
+		parts := strings.Split(strings.TrimPrefix(ref, "k8s:"), "/")
+		if len(parts) != 4 {
+			return "", fmt.Errorf("invalid secret reference %s, expected k8s:<cluster>/<namespace>/<secret>/<key>", ref)
+		}
+		clusterName, namespace, name, key := parts[0], parts[1], parts[2], parts[3]
+		cluster := c.GetCluster(clusterName)
+		if cluster == nil {
+			return "", fmt.Errorf("cluster not found: %s", clusterName)
+		}
+		client, err := kube.get(cluster)
+		if err != nil {
+			return "", err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, secretResolveTimeout)
+		defer cancel()
+		secret, err := client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+		}
+		data, ok := secret.Data[key]
+		if !ok {
+			return "", fmt.Errorf("key %s not found in secret %s/%s", key, namespace, name)
+		}
+		return string(data), nil
+	}
+	return ref, nil
+}
@@ -0,0 +1,156 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestResolveSecrets 测试解析 file:、env:、k8s: 引用
+func TestResolveSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	t.Setenv("SSHPROXY_TEST_PASSWORD", "from-env")
+
+	config := &Config{
+		Clusters: []ClusterConfig{{Name: "c1"}},
+		Users: []UserConfig{
+			{Username: "file", Password: Secret("file:" + path)},
+			{Username: "env", Password: "env:SSHPROXY_TEST_PASSWORD"},
+			{Username: "k8s", Password: "k8s:c1/auth/sshproxy/bob"},
+			{Username: "plain", Password: "plain-text"},
+			{
+				Username:    "inject",
+				Environment: []EnvVarConfig{{Name: "TOKEN", Value: "env:SSHPROXY_TEST_PASSWORD"}},
+				Files:       []FileConfig{{Path: "/etc/token", Content: Secret("file:" + path)}},
+			},
+		},
+	}
+	kube := &kubeClients{clients: map[string]kubernetes.Interface{
+		"c1": fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "sshproxy", Namespace: "auth"},
+			Data:       map[string][]byte{"bob": []byte("from-secret")},
+		}),
+	}}
+
+	if err := config.resolveSecrets(context.Background(), kube); err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+
+	expected := []string{"from-file", "from-env", "from-secret", "plain-text", ""}
+	for i, u := range config.Users {
+		if u.Password.Value() != expected[i] {
+			t.Errorf("Expected '%s' for user %s, got '%s'", expected[i], u.Username, u.Password.Value())
+		}
+	}
+	inject := config.Users[4]
+	if inject.Environment[0].Value.Value() != "from-env" || inject.Files[0].Content.Value() != "from-file" {
+		t.Errorf("Expected environment and file references to be resolved, got %s %s",
+			inject.Environment[0].Value.Value(), inject.Files[0].Content.Value())
+	}
+}
+
+// TestResolveSecrets_Errors 测试解析失败时错误信息包含字段名
+func TestResolveSecrets_Errors(t *testing.T) {
+	refs := []string{
+		"file:/nonexistent/password",
+		"env:SSHPROXY_TEST_UNSET",
+		"k8s:c1/auth/sshproxy",
+		"k8s:missing/auth/sshproxy/bob",
+		"k8s:c1/auth/sshproxy/missing",
+	}
+	kube := &kubeClients{clients: map[string]kubernetes.Interface{
+		"c1": fake.NewSimpleClientset(&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "sshproxy", Namespace: "auth"},
+			Data:       map[string][]byte{"bob": []byte("from-secret")},
+		}),
+	}}
+
+	for _, ref := range refs {
+		config := &Config{
+			Clusters: []ClusterConfig{{Name: "c1"}},
+			Users:    []UserConfig{{Username: "alice", Password: Secret(ref)}},
+		}
+		err := config.resolveSecrets(context.Background(), kube)
+		if err == nil {
+			t.Errorf("Expected error for %s, got nil", ref)
+			continue
+		}
+		if !strings.Contains(err.Error(), "user alice: password") {
+			t.Errorf("Expected error to name the field, got: %v", err)
+		}
+	}
+}
+
+// TestSecret_Redacted 测试格式化和序列化时不输出明文
+func TestSecret_Redacted(t *testing.T) {
+	user := UserConfig{Username: "alice", Password: "s3cr3t-value"}
+
+	outputs := []string{
+		fmt.Sprintf("%v", user),
+		fmt.Sprintf("%+v", user),
+		fmt.Sprintf("%#v", user),
+		fmt.Sprintf("%s", user.Password),
+	}
+	data, _ := json.Marshal(user)
+	outputs = append(outputs, string(data))
+	data, _ = yaml.Marshal(user)
+	outputs = append(outputs, string(data))
+
+	for _, out := range outputs {
+		if strings.Contains(out, "s3cr3t-value") {
+			t.Errorf("Plaintext found in output: %s", out)
+		}
+	}
+
+	if Secret("").String() != "" {
+		t.Error("Expected empty secret to print as empty string")
+	}
+}
+
+// TestLoadConfig_SecretReference 测试加载配置时解析引用
+func TestLoadConfig_SecretReference(t *testing.T) {
+	t.Setenv("SSHPROXY_TEST_PASSWORD", "from-env")
+	content := `users:
+  - username: "user1"
+    password: "env:SSHPROXY_TEST_PASSWORD"
+`
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Users[0].Password.Value() != "from-env" {
+		t.Errorf("Expected resolved password, got '%s'", config.Users[0].Password.Value())
+	}
+
+	// 不解析引用时保持原样
+	config, err = LoadConfigWithoutSecrets(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if config.Users[0].Password.Value() != "env:SSHPROXY_TEST_PASSWORD" {
+		t.Errorf("Expected unresolved reference, got '%s'", config.Users[0].Password.Value())
+	}
+
+	os.Unsetenv("SSHPROXY_TEST_PASSWORD")
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "user user1: password") {
+		t.Errorf("Expected error naming the field, got: %v", err)
+	}
+}
@@ -1,12 +1,14 @@
 package webhook
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"go.containerssh.io/containerssh/auth"
@@ -14,12 +16,59 @@ import (
 	"go.containerssh.io/containerssh/metadata"
 	"golang.org/x/crypto/ssh"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
+// defaultShell 未配置 shell 时使用的命令
+var defaultShell = []string{"/bin/bash"}
+
+// fallbackShell 模板模式和离线时自动探测使用的 shell，也用于包装 shell 命令
+const fallbackShell = "/bin/sh"
+
+// shellProbeTimeout 自动探测 shell 的超时时间，同时受 configTimeout 限制
+const shellProbeTimeout = 5 * time.Second
+
+// webhookWriteTimeout webhook 写响应的超时时间，handler 必须在此之前返回
+const webhookWriteTimeout = 10 * time.Second
+
+// configTimeout config 接口访问集群的总时间，包括选择容器、启动调试容器、探测 shell 和创建命名空间；
+// 各步骤自己的超时都不能超过它，留出的时间用于写响应
+const configTimeout = 8 * time.Second
+
+// podLookupTimeout 查询 pod 的超时时间
+const podLookupTimeout = 5 * time.Second
+
 // Server webhook HTTP 服务器
 type Server struct {
+	mu         sync.RWMutex
 	config     *Config
 	httpServer *http.Server
+	kube       *kubeClients
+	prober     shellProber
+	shells     *shellCache // shell 探测结果
+	checker    sessionChecker
+	sessions   *sessionTracker
+
+	clusterProber clusterProber // /readyz 检查集群
+	reloadErr     error         // 最近一次重新加载配置的错误，成功加载后清空
+
+	events      *authEventLog // 最近的认证事件，由管理 API 查询
+	decisions   *authEventLog // 最近的授权决定，与认证事件分开保存
+	audit       *auditLog     // 访问申请的审计事件
+	mfa         *mfaState     // 等待第二因素的连接和已使用的验证码
+	oidc        *oidcState    // 身份提供方客户端和进行中的设备授权
+	store       configStore   // 管理 API 读写的配置，配置不是从文件加载时为 nil
+	adminMu     sync.Mutex    // 管理 API 的修改依次执行
+	adminServer *http.Server  // 管理 API，未配置 admin.listen 时为 nil
+
+	grantsMu          sync.Mutex      // 保护 expiredGrants 和 breakGlassExpired
+	expiredGrants     map[string]bool // 已经记录过期的访问申请
+	breakGlassExpired time.Time       // 已经记录过期的紧急访问的到期时间
+	stop              chan struct{}   // 关闭后停止后台任务
+
+	// sshhook simulate 使用：dryRun 时不创建调试容器和命名空间，offline 时不访问集群
+	dryRun  bool
+	offline bool
 }
 
 // AuthResponse 认证响应（使用 ContainerSSH 的 ResponseBody）
@@ -30,19 +79,52 @@ type AuthResponse = auth.ResponseBody
 // NewServer 创建新的 webhook 服务器
 func NewServer(config *Config) (*Server, error) {
 	server := &Server{
-		config: config,
+		config:    config,
+		kube:      &kubeClients{},
+		sessions:  newSessionTracker(),
+		shells:    newShellCache(),
+		events:    newAuthEventLog(config.Admin.EventBuffer),
+		decisions: newAuthEventLog(config.Admin.EventBuffer),
+		audit:     newAuditLog(config.Admin.EventBuffer),
+		mfa:       newMFAState(),
+		oidc:      newOIDCState(),
+
+		expiredGrants: make(map[string]bool),
+		stop:          make(chan struct{}),
 	}
-
-	// 注册路由
-	http.HandleFunc("/config", server.handleConfig)         // Config 接口
-	http.HandleFunc("/password", server.handlePasswordAuth) // 密码认证
-	http.HandleFunc("/pubkey", server.handlePublicKeyAuth)  // 公钥认证
+	if config.path != "" {
+		server.store = &fileStore{path: config.path}
+	}
+	server.prober = &execProber{clients: server.kube}
+	server.checker = &kubeSessionChecker{clients: server.kube}
+	server.clusterProber = &readyzProber{clients: server.kube}
+
+	// 注册路由（每个服务器使用独立的 ServeMux，避免重复创建时冲突）
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", server.handleConfig)                            // Config 接口
+	mux.HandleFunc("/password", server.handlePasswordAuth)                    // 密码认证
+	mux.HandleFunc("/pubkey", server.handlePublicKeyAuth)                     // 公钥认证
+	mux.HandleFunc("/authz", server.handleAuthz)                              // 认证后的授权
+	mux.HandleFunc("/keyboard-interactive", server.handleKeyboardInteractive) // TOTP 第二因素
+	mux.HandleFunc("/session/heartbeat", server.handleSessionHeartbeat)       // 连接租约续期
+	mux.HandleFunc("/session/end", server.handleSessionEnd)                   // 连接结束
+	mux.HandleFunc("/healthz", server.handleHealthz)                          // 存活检查
+	mux.HandleFunc("/readyz", server.handleReadyz)                            // 就绪检查
+	mux.HandleFunc("/version", server.handleVersion)                          // 构建信息和配置版本
 
 	server.httpServer = &http.Server{
 		Addr:         config.Listen,
-		Handler:      nil,
+		Handler:      mux,
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		WriteTimeout: webhookWriteTimeout,
+	}
+	if config.Admin.Listen != "" {
+		server.adminServer = &http.Server{
+			Addr:         config.Admin.Listen,
+			Handler:      server.adminHandler(),
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
 	}
 
 	return server, nil
@@ -55,6 +137,22 @@ func (s *Server) Start() error {
 			log.Fatalf("HTTP server error: %v", err)
 		}
 	}()
+	if s.adminServer != nil {
+		admin := s.currentConfig().Admin
+		go func() {
+			var err error
+			if admin.TLSCertFile != "" {
+				err = s.adminServer.ListenAndServeTLS(admin.TLSCertFile, admin.TLSKeyFile)
+			} else {
+				err = s.adminServer.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server error: %v", err)
+			}
+		}()
+	}
+	go s.sweepExpired(s.stop)
+	go s.watchSessions(s.stop)
 	return nil
 }
 
@@ -62,9 +160,48 @@ func (s *Server) Start() error {
 func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	close(s.stop)
+	if s.adminServer != nil {
+		if err := s.adminServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
 	return s.httpServer.Shutdown(ctx)
 }
 
+// Reload 替换配置并清空缓存的 Kubernetes 客户端，监听地址不会改变
+func (s *Server) Reload(config *Config) {
+	s.mu.Lock()
+	old := s.config
+	s.config = config
+	s.reloadErr = nil
+	s.mu.Unlock()
+
+	if config.Listen != old.Listen {
+		log.Printf("[Reload] Listen address change from %s to %s requires a restart", old.Listen, config.Listen)
+	}
+	if config.Admin.Listen != old.Admin.Listen {
+		log.Printf("[Reload] Admin listen address change from %s to %s requires a restart", old.Admin.Listen, config.Admin.Listen)
+	}
+	s.kube.reset()
+	log.Printf("[Reload] Configuration reloaded - clusters=%d, targets=%d, users=%d",
+		len(config.Clusters), len(config.Targets), len(config.Users))
+}
+
+// ReloadFailed 记录重新加载配置失败，继续使用当前配置，但 /readyz 返回未就绪，直到成功加载
+func (s *Server) ReloadFailed(err error) {
+	s.mu.Lock()
+	s.reloadErr = err
+	s.mu.Unlock()
+}
+
+// currentConfig 返回当前使用的配置
+func (s *Server) currentConfig() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
 // handlePasswordAuth 处理密码认证
 func (s *Server) handlePasswordAuth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -82,6 +219,7 @@ func (s *Server) handlePasswordAuth(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[Password Auth] Request received - username=%s, remoteAddress=%s, connectionId=%s",
 		req.Username, req.RemoteAddress, req.ConnectionID)
+	ev := newAuthEvent("password", req.ConnectionAuthPendingMetadata)
 
 	// 注意：根据 ContainerSSH auth 协议，虽然 Password 字段类型是 []byte，
 	// 但 JSON 中的字段名是 passwordBase64，实际传输的是 Base64 编码的密码
@@ -92,29 +230,66 @@ func (s *Server) handlePasswordAuth(w http.ResponseWriter, r *http.Request) {
 	passwordBytes, err := base64.StdEncoding.DecodeString(passwordBase64)
 	if err != nil {
 		log.Printf("[Password Auth] Failed to decode password for user %s: %v", req.Username, err)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "invalid password encoding")
 		return
 	}
 	password := string(passwordBytes)
 
 	// 查找用户
-	user := s.config.GetUser(req.Username)
+	cfg := s.currentConfig()
+	user, target := cfg.loginUser(req.Username)
 	if user == nil {
 		log.Printf("[Password Auth] User not found: %s", req.Username)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "user not found")
+		return
+	}
+	if user.expired(time.Now()) {
+		log.Printf("[Password Auth] Access expired for user: %s", req.Username)
+		s.rejectAuth(w, ev, "access expired")
+		return
+	}
+	if user.Locked {
+		log.Printf("[Password Auth] User locked: %s", req.Username)
+		s.rejectAuth(w, ev, "user locked")
 		return
 	}
 
 	// 验证密码
-	if user.Password != password {
+	if !user.checkPassword(password) {
 		log.Printf("[Password Auth] Invalid password for user: %s", req.Username)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "invalid password")
 		return
 	}
 
+	// 按连接信息渲染 metadata 模板
+	data := newTemplateData(user, req.ConnectionAuthPendingMetadata, user.Username)
+	md, err := renderMetadata(user.Metadata, data)
+	if err != nil {
+		log.Printf("[Password Auth] Failed to render metadata for user %s: %v", req.Username, err)
+		s.rejectAuth(w, ev, "failed to render metadata")
+		return
+	}
+	if reason := cfg.checkPolicies(cfg.newPolicyInput(policyStageAuth, user, data, target, md)); reason != "" {
+		s.rejectAuth(w, ev, reason)
+		return
+	}
+	if user.BreakGlass {
+		if reason := s.checkBreakGlass(cfg, user, target, ev.RemoteAddress); reason != "" {
+			s.rejectAuth(w, ev, reason)
+			return
+		}
+	}
+
 	log.Printf("[Password Auth] ✓ Authentication successful - username=%s, namespace=%s, pod=%s, container=%s",
-		req.Username, user.Metadata["namespace"], user.Metadata["pod"], user.Metadata["container"])
-	s.sendAuthResponse(w, true, req.Username, user.Metadata)
+		req.Username, md["namespace"], md["pod"], md["container"])
+	ev.Target = target
+	if user.mfaRequired() {
+		s.requireSecondFactor(w, ev, user.Username, md)
+		return
+	}
+	ev.Success = true
+	s.events.add(ev)
+	s.sendAuthResponse(w, true, user.Username, user, md)
 }
 
 // handlePublicKeyAuth 处理公钥认证
@@ -134,19 +309,31 @@ func (s *Server) handlePublicKeyAuth(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("[Public Key Auth] Request received - username=%s, remoteAddress=%s, connectionId=%s",
 		req.Username, req.RemoteAddress, req.ConnectionID)
+	ev := newAuthEvent("publickey", req.ConnectionAuthPendingMetadata)
 
 	// 查找用户
-	user := s.config.GetUser(req.Username)
+	cfg := s.currentConfig()
+	user, target := cfg.loginUser(req.Username)
 	if user == nil {
 		log.Printf("User not found: %s", req.Username)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "user not found")
+		return
+	}
+	if user.expired(time.Now()) {
+		log.Printf("Access expired for user: %s", req.Username)
+		s.rejectAuth(w, ev, "access expired")
+		return
+	}
+	if user.Locked {
+		log.Printf("User locked: %s", req.Username)
+		s.rejectAuth(w, ev, "user locked")
 		return
 	}
 
 	// 如果用户没有配置公钥，拒绝认证
-	if user.PublicKey == "" {
+	if len(user.authorizedKeys()) == 0 {
 		log.Printf("No public key configured for user: %s", req.Username)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "no public key configured")
 		return
 	}
 
@@ -154,27 +341,51 @@ func (s *Server) handlePublicKeyAuth(w http.ResponseWriter, r *http.Request) {
 	clientPubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey.PublicKey))
 	if err != nil {
 		log.Printf("Failed to parse client public key: %v", err)
-		s.sendAuthResponse(w, false, "", nil)
+		s.rejectAuth(w, ev, "invalid public key")
 		return
 	}
 
-	// 解析配置中的公钥（支持 OpenSSH authorized_keys 格式）
-	configPubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(user.PublicKey))
-	if err != nil {
-		log.Printf("Failed to parse config public key: %v", err)
-		s.sendAuthResponse(w, false, "", nil)
+	// 在配置的公钥中查找（支持 OpenSSH authorized_keys 格式）
+	comment, command, ok := matchPublicKey(user, clientPubKey)
+	if !ok {
+		log.Printf("Public key mismatch for user: %s", req.Username)
+		s.rejectAuth(w, ev, "public key mismatch")
 		return
 	}
 
-	// 比较公钥（通过比较 Marshal 后的字节）
-	if !bytes.Equal(clientPubKey.Marshal(), configPubKey.Marshal()) {
-		log.Printf("Public key mismatch for user: %s", req.Username)
-		s.sendAuthResponse(w, false, "", nil)
+	// 按连接信息渲染 metadata 模板，公钥注释、command= 选项和公钥类型写入 metadata 供 config 请求使用
+	data := newTemplateData(user, req.ConnectionAuthPendingMetadata, user.Username)
+	data.KeyComment = comment
+	md, err := renderMetadata(user.Metadata, data)
+	if err != nil {
+		log.Printf("Failed to render metadata for user %s: %v", req.Username, err)
+		s.rejectAuth(w, ev, "failed to render metadata")
+		return
+	}
+	if md == nil {
+		md = make(map[string]string)
+	}
+	if comment != "" {
+		md[metadataKeyComment] = comment
+	}
+	if command != "" {
+		md[metadataForcedCommand] = command
+	}
+	md[metadataKeyType] = clientPubKey.Type()
+	if reason := cfg.checkPolicies(cfg.newPolicyInput(policyStageAuth, user, data, target, md)); reason != "" {
+		s.rejectAuth(w, ev, reason)
 		return
 	}
 
 	log.Printf("Public key auth success: username=%s", req.Username)
-	s.sendAuthResponse(w, true, req.Username, user.Metadata)
+	ev.Target = target
+	if user.mfaRequired() {
+		s.requireSecondFactor(w, ev, user.Username, md)
+		return
+	}
+	ev.Success = true
+	s.events.add(ev)
+	s.sendAuthResponse(w, true, user.Username, user, md)
 }
 
 // 使用 ContainerSSH 官方的 config 类型
@@ -199,32 +410,109 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		req.Username, req.AuthenticatedUsername, req.ConnectionID)
 
 	// 查找用户
-	user := s.config.GetUser(req.AuthenticatedUsername)
+	cfg := s.currentConfig()
+	user := cfg.GetUser(req.AuthenticatedUsername)
+	if user == nil {
+		user = cfg.oidcUser(req.AuthenticatedUsername, req.Metadata)
+	}
 	if user == nil {
 		log.Printf("[Config] User not found: %s", req.AuthenticatedUsername)
 		http.Error(w, "User not found", http.StatusNotFound)
 		return
 	}
+	if user.expired(time.Now()) {
+		log.Printf("[Config] Access expired for user: %s", req.AuthenticatedUsername)
+		http.Error(w, "Access expired", http.StatusForbidden)
+		return
+	}
+	if user.Locked {
+		log.Printf("[Config] User locked: %s", req.AuthenticatedUsername)
+		http.Error(w, "User locked", http.StatusForbidden)
+		return
+	}
+	// 紧急访问可能在认证之后关闭或过期
+	if user.BreakGlass && !cfg.breakGlassActive(time.Now()) {
+		log.Printf("[Config] Break-glass access not enabled for user: %s", req.AuthenticatedUsername)
+		http.Error(w, "Break-glass access not enabled", http.StatusForbidden)
+		return
+	}
+
+	// 登录时选择了目标：使用 /authz 的决定，不再重复检查；没有经过授权时拒绝
+	if _, target := cfg.loginUser(req.Username); target != "" {
+		if req.Metadata[metadataTarget].Value != target {
+			log.Printf("[Config] Target %s was not authorized for user %s", target, req.AuthenticatedUsername)
+			http.Error(w, "Target selection requires authorization", http.StatusForbidden)
+			return
+		}
+		// 访问申请可能在授权之后过期，没有在登录时检查过的目标再检查一次
+		if !targetAuthorizedAtLogin(req.Metadata, target) {
+			if err := cfg.authorizeTarget(user, target, time.Now()); err != nil {
+				log.Printf("[Config] Target %s no longer allowed for user %s: %v", target, req.AuthenticatedUsername, err)
+				http.Error(w, "Target not allowed", http.StatusForbidden)
+				return
+			}
+		}
+		selected := *user
+		selected.Target = target
+		user = &selected
+	}
+
+	// 计算登录目标
+	data := newTemplateData(user, req.ConnectionAuthPendingMetadata, req.AuthenticatedUsername)
+	route, err := cfg.ResolveRoute(user, data)
+	if err != nil {
+		log.Printf("[Config] Failed to resolve target for user %s: %v", req.AuthenticatedUsername, err)
+		http.Error(w, "Failed to resolve target", http.StatusInternalServerError)
+		return
+	}
+
+	// 访问策略：使用解析出的集群，公钥类型来自认证时写入的 metadata
+	in := cfg.newPolicyInput(policyStageConfig, user, data, user.Target, map[string]string{
+		"KUBERNETES_CLUSTER": route.Cluster,
+		metadataKeyType:      req.Metadata[metadataKeyType].Value,
+	})
+	if reason := cfg.checkPolicies(in); reason != "" {
+		log.Printf("[Config] Access denied for user %s: %s", req.AuthenticatedUsername, reason)
+		http.Error(w, "Access denied: "+reason, http.StatusForbidden)
+		return
+	}
 
 	// 获取集群配置
-	clusterName := user.Metadata["KUBERNETES_CLUSTER"]
+	clusterName := route.Cluster
 	if clusterName == "" {
 		log.Printf("[Config] Missing cluster name for user: %s", req.AuthenticatedUsername)
 		http.Error(w, "Missing cluster configuration", http.StatusBadRequest)
 		return
 	}
 
-	cluster := s.config.GetCluster(clusterName)
+	cluster := cfg.GetCluster(clusterName)
 	if cluster == nil {
 		log.Printf("[Config] Cluster not found: %s", clusterName)
 		http.Error(w, "Cluster not found", http.StatusNotFound)
 		return
 	}
 
+	// 录像配置在创建任何资源之前检查
+	recording, ok := sessionRecording(w, user, cluster, route.Target, cfg.sessionLayers(user, route.Target))
+	if !ok {
+		return
+	}
+
+	// 之后访问集群的步骤共用 configTimeout，超时后仍然来得及在 WriteTimeout 之前返回错误
+	ctx, cancel := context.WithTimeout(r.Context(), configTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// 模板模式：由 ContainerSSH 按模板为每个连接或会话创建 pod
+	if route.Target != nil && route.Target.Template != nil {
+		s.handleWorkspaceConfig(w, r, &req, cfg, user, data, route, cluster, recording)
+		return
+	}
+
 	// 构建 Kubernetes 配置
-	podName := user.Metadata["KUBERNETES_POD_NAME"]
-	namespace := user.Metadata["KUBERNETES_POD_NAMESPACE"]
-	containerName := user.Metadata["KUBERNETES_CONTAINER_NAME"]
+	podName := route.Pod
+	namespace := route.Namespace
+	containerName := route.Container
 
 	if podName == "" || namespace == "" {
 		log.Printf("[Config] Missing pod configuration for user: %s", req.AuthenticatedUsername)
@@ -232,33 +520,79 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 先登记租约再访问集群，超过并发限制时不会创建调试容器；之后失败时释放租约
+	timeouts := cfg.resolveTimeouts(cluster, route.Target, cfg.sessionLayers(user, route.Target))
+	cfg.limitBreakGlassSession(&timeouts, user, time.Now())
+	loc := sessionLocation{cluster: clusterName, namespace: namespace, pod: podName, container: containerName}
+	if !s.acquireSession(w, &req, cfg, cluster, route.Target, loc, timeouts, cfg.sessionLayers(user, route.Target)) {
+		return
+	}
+	configured := false
+	defer func() {
+		if !configured {
+			s.sessions.release(req.ConnectionID)
+		}
+	}()
+
+	// 未指定容器时按策略选择，避免进入排在前面的 sidecar 容器
+	if containerName == "" {
+		containerName, err = s.selectContainer(r.Context(), cluster, namespace, podName, cfg.ContainerSelection.Sidecars)
+		if err != nil {
+			log.Printf("[Config] Failed to select container for user %s: %v", req.AuthenticatedUsername, err)
+			http.Error(w, "Failed to select container", http.StatusBadGateway)
+			return
+		}
+		route.Container = containerName
+	}
+
+	// 调试模式下进入共享目标容器进程命名空间的 ephemeral 容器
+	if route.Target != nil && route.Target.Debug != nil {
+		debugName, err := s.ensureDebugContainer(r.Context(), cluster, namespace, podName,
+			containerName, req.AuthenticatedUsername, route.Target.Debug)
+		if err != nil {
+			log.Printf("[Config] Failed to start debug container for user %s: %v", req.AuthenticatedUsername, err)
+			http.Error(w, "Failed to start debug container", http.StatusBadGateway)
+			return
+		}
+		log.Printf("[Config] Using debug container %s targeting %s in pod %s/%s",
+			debugName, containerName, namespace, podName)
+		containerName = debugName
+		route.Container = debugName
+	}
+
 	// 构建 Kubernetes Pod 配置
 	kubeConfig := config.KubernetesConfig{}
 
 	// 设置集群连接信息
-	kubeConfig.Connection.Host = cluster.Host
-	kubeConfig.Connection.CAFile = cluster.CACertFile
-	kubeConfig.Connection.CertFile = cluster.CertFile
-	kubeConfig.Connection.KeyFile = cluster.KeyFile
-	if cluster.BearerTokenFile != "" {
-		kubeConfig.Connection.BearerTokenFile = cluster.BearerTokenFile
-	}
-	if cluster.ServerName != "" {
-		kubeConfig.Connection.ServerName = cluster.ServerName
-	}
-	if cluster.QPS > 0 {
-		kubeConfig.Connection.QPS = float32(cluster.QPS)
-	}
-	if cluster.Burst > 0 {
-		kubeConfig.Connection.Burst = cluster.Burst
-	}
+	kubeConfig.Connection = kubeConnectionConfig(cluster)
 
 	// 设置 Pod 配置
 	kubeConfig.Pod.Metadata.Name = podName
 	kubeConfig.Pod.Metadata.Namespace = namespace
 
-	// 设置 shell 命令（默认使用 /bin/bash）
-	kubeConfig.Pod.ShellCommand = []string{"/bin/bash"}
+	// 设置 shell 命令（按目标、用户组、用户的配置合并，默认使用 /bin/bash）
+	shell := resolveShell(cfg.sessionLayers(user, route.Target))
+	wrap := s.shellWrapper(r.Context(), cluster, route)
+	kubeConfig.Pod.ShellCommand, err = s.shellCommand(r.Context(), cluster, route, shell, wrap)
+	if err != nil {
+		log.Printf("[Config] No shell for user %s: %v", req.AuthenticatedUsername, err)
+		http.Error(w, "No shell found in container", http.StatusBadGateway)
+		return
+	}
+	marker := ""
+	if req.ConnectionID != "" && sessionLimited(cluster, route.Target, true, cfg.sessionLayers(user, route.Target)) {
+		marker = sessionMarker(req.ConnectionID)
+	}
+	kubeConfig.Pod.ShellCommand = timeouts.apply(&shell, kubeConfig.Pod.ShellCommand, marker, wrap)
+	motd, err := renderMOTD(resolveMOTD(cfg.sessionLayers(user, route.Target)), newMOTDData(data, user, route))
+	if err != nil {
+		log.Printf("[Config] Failed to render motd for user %s: %v", req.AuthenticatedUsername, err)
+		http.Error(w, "Invalid motd", http.StatusInternalServerError)
+		return
+	}
+	if motd != "" && wrap("motd") {
+		kubeConfig.Pod.ShellCommand = motdCommand(motd, kubeConfig.Pod.ShellCommand)
+	}
 
 	// 在 persistent 模式下，禁用 ContainerSSH agent
 	kubeConfig.Pod.DisableAgent = true
@@ -273,10 +607,174 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		kubeConfig.Pod.ConsoleContainerNumber = 0
 	}
 
+	loc.container = containerName
+	s.sessions.locate(req.ConnectionID, loc)
+	configured = true
+
+	log.Printf("[Config] ✓ Configuration returned - cluster=%s, namespace=%s, pod=%s, container=%s, shell=%v, idle=%s, maxSession=%s",
+		clusterName, namespace, podName, containerName, kubeConfig.Pod.ShellCommand, timeouts.Idle, timeouts.MaxSession)
+
+	security := sessionSecurity(&req, user, cfg.sessionLayers(user, route.Target), &shell)
+	s.sendConfigResponse(w, &req, kubeConfig, shell, security, recording)
+}
+
+// handleWorkspaceConfig 返回按模板创建 pod 的配置
+func (s *Server) handleWorkspaceConfig(w http.ResponseWriter, r *http.Request, req *config.Request, cfg *Config, user *UserConfig, data *TemplateData, route *Route, cluster *ClusterConfig, recording RecordingConfig) {
+	target := route.Target
+	pod, err := workspacePodConfig(target, data)
+	if err != nil {
+		log.Printf("[Config] Failed to build pod from template %s: %v", target.Name, err)
+		http.Error(w, "Invalid pod template", http.StatusInternalServerError)
+		return
+	}
+
+	// 工作区 pod 由 ContainerSSH 为每个连接创建，只限制用户的并发数；
+	// pod 带有连接 ID 标签，pod 删除后释放租约。租约在创建命名空间之前登记，之后失败时释放
+	timeouts := cfg.resolveTimeouts(cluster, target, cfg.sessionLayers(user, target))
+	cfg.limitBreakGlassSession(&timeouts, user, time.Now())
+	loc := sessionLocation{namespace: pod.Metadata.Namespace}
+	if validation.IsValidLabelValue(req.ConnectionID) == nil && req.ConnectionID != "" {
+		pod.Metadata.Labels[labelConnection] = req.ConnectionID
+		loc.cluster = cluster.Name
+	}
+	if !s.acquireSession(w, req, cfg, cluster, target, loc, timeouts, cfg.sessionLayers(user, target)) {
+		return
+	}
+	configured := false
+	defer func() {
+		if !configured {
+			s.sessions.release(req.ConnectionID)
+		}
+	}()
+
+	if target.Template.CreateNamespace {
+		if err := s.ensureNamespace(r.Context(), cluster, pod.Metadata.Namespace); err != nil {
+			log.Printf("[Config] Failed to prepare namespace for user %s: %v", req.AuthenticatedUsername, err)
+			http.Error(w, "Failed to prepare namespace", http.StatusBadGateway)
+			return
+		}
+	}
+
+	kubeConfig := config.KubernetesConfig{}
+	kubeConfig.Connection = kubeConnectionConfig(cluster)
+	kubeConfig.Pod = pod
+
+	// pod 在连接开始后才创建，无法提前探测 shell
+	route.Namespace = pod.Metadata.Namespace
+	route.Pod = ""
+	route.Container = workspaceContainer
+	shell := resolveShell(cfg.sessionLayers(user, target))
+	wrap := s.shellWrapper(r.Context(), cluster, route)
+	kubeConfig.Pod.ShellCommand, err = s.shellCommand(r.Context(), cluster, route, shell, wrap)
+	if err != nil {
+		log.Printf("[Config] No shell for user %s: %v", req.AuthenticatedUsername, err)
+		http.Error(w, "No shell found in container", http.StatusBadGateway)
+		return
+	}
+	kubeConfig.Pod.ShellCommand = timeouts.apply(&shell, kubeConfig.Pod.ShellCommand, "", wrap)
+	motd, err := renderMOTD(resolveMOTD(cfg.sessionLayers(user, target)), newMOTDData(data, user, route))
+	if err != nil {
+		log.Printf("[Config] Failed to render motd for user %s: %v", req.AuthenticatedUsername, err)
+		http.Error(w, "Invalid motd", http.StatusInternalServerError)
+		return
+	}
+	if motd != "" && wrap("motd") {
+		kubeConfig.Pod.ShellCommand = motdCommand(motd, kubeConfig.Pod.ShellCommand)
+	}
+
+	configured = true
+
+	log.Printf("[Config] ✓ Configuration returned - cluster=%s, namespace=%s, template=%s, mode=%s, image=%s, idle=%s, maxSession=%s",
+		cluster.Name, pod.Metadata.Namespace, target.Name, pod.Mode, target.Template.Image, timeouts.Idle, timeouts.MaxSession)
+
+	security := sessionSecurity(req, user, cfg.sessionLayers(user, target), &shell)
+	s.sendConfigResponse(w, req, kubeConfig, shell, security, recording)
+}
+
+// acquireSession 为连接登记租约，超过并发限制时拒绝登录
+func (s *Server) acquireSession(w http.ResponseWriter, req *config.Request, cfg *Config, cluster *ClusterConfig, target *TargetConfig, loc sessionLocation, timeouts TimeoutsConfig, layers []*SessionConfig) bool {
+	podLimit := 0
+	if loc.pod != "" {
+		podLimit = maxPodSessions(cluster, target)
+	}
+	err := s.sessions.acquire(req.ConnectionID, req.AuthenticatedUsername, loc,
+		resolveMaxSessions(layers), podLimit, cfg.leaseTTL(timeouts), time.Now())
+	if err != nil {
+		log.Printf("[Config] Session limit reached - connectionId=%s: %v", req.ConnectionID, err)
+		http.Error(w, "Too many sessions", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// sessionSecurity 根据功能开关、强制命令和命令白名单生成 security 配置，
+// 检查命令需要的环境变量加入 shell.Env
+func sessionSecurity(req *config.Request, user *UserConfig, layers []*SessionConfig, shell *ShellConfig) config.SecurityConfig {
+	security := securityConfig(resolveFeatures(layers))
+
+	forcedCommand := user.ForcedCommand
+	if v := req.Metadata[metadataForcedCommand].Value; v != "" {
+		forcedCommand = v
+	}
+	for k, v := range applyCommandPolicy(&security, forcedCommand, user.CommandAllowlist) {
+		if shell.Env == nil {
+			shell.Env = make(map[string]string)
+		}
+		shell.Env[k] = v
+	}
+	return security
+}
+
+// sessionRecording 合并录像配置，开启了录像但配置不完整时拒绝登录，不允许不录像的会话
+func sessionRecording(w http.ResponseWriter, user *UserConfig, cluster *ClusterConfig, target *TargetConfig, layers []*SessionConfig) (RecordingConfig, bool) {
+	recording := resolveRecording(cluster, target, layers)
+	if !recording.enabled() {
+		if production(cluster, target) {
+			log.Printf("[Config] Recording is disabled on production cluster %s for user %s", cluster.Name, user.Username)
+			http.Error(w, "Session recording is required", http.StatusInternalServerError)
+			return recording, false
+		}
+		return recording, true
+	}
+	if err := recording.validate(); err != nil {
+		log.Printf("[Config] Invalid recording config for user %s: %v", user.Username, err)
+		http.Error(w, "Invalid recording config", http.StatusInternalServerError)
+		return recording, false
+	}
+	return recording, true
+}
+
+// kubeConnectionConfig 根据集群配置构建 ContainerSSH 的连接配置
+func kubeConnectionConfig(cluster *ClusterConfig) config.KubernetesConnectionConfig {
+	conn := config.KubernetesConnectionConfig{
+		Host:     cluster.Host,
+		CAFile:   cluster.CACertFile,
+		CertFile: cluster.CertFile,
+		KeyFile:  cluster.KeyFile,
+	}
+	if cluster.BearerTokenFile != "" {
+		conn.BearerTokenFile = cluster.BearerTokenFile
+	}
+	if cluster.ServerName != "" {
+		conn.ServerName = cluster.ServerName
+	}
+	if cluster.QPS > 0 {
+		conn.QPS = float32(cluster.QPS)
+	}
+	if cluster.Burst > 0 {
+		conn.Burst = cluster.Burst
+	}
+	return conn
+}
+
+// sendConfigResponse 发送 config 响应
+func (s *Server) sendConfigResponse(w http.ResponseWriter, req *config.Request, kubeConfig config.KubernetesConfig, shell ShellConfig, security config.SecurityConfig, recording RecordingConfig) {
 	// 构建完整的应用配置
 	appConfig := config.AppConfig{
 		Backend:    config.BackendKubernetes,
 		Kubernetes: kubeConfig,
+		Security:   security,
+		Audit:      recording.auditConfig(),
 	}
 
 	// 使用 ContainerSSH 官方的 ResponseBody 结构
@@ -285,8 +783,23 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 		Config:                          appConfig,
 	}
 
-	log.Printf("[Config] ✓ Configuration returned - cluster=%s, namespace=%s, pod=%s, container=%s",
-		clusterName, namespace, podName, containerName)
+	// 登录环境变量通过 metadata 中的 Environment 传给 ContainerSSH
+	if len(shell.Env) > 0 {
+		if resp.Environment == nil {
+			resp.Environment = make(map[string]metadata.Value)
+		}
+		for key, value := range shell.Env {
+			resp.Environment[key] = metadata.Value{Value: value}
+		}
+	}
+
+	// 保留期标签写入 metadata，便于按标签清理录像
+	if recording.enabled() && recording.Retention != "" {
+		if resp.Metadata == nil {
+			resp.Metadata = make(map[string]metadata.Value)
+		}
+		resp.Metadata[metadataRecordingRetention] = metadata.Value{Value: recording.Retention}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
@@ -294,27 +807,157 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// selectContainer 查询 pod 并按策略选择容器。查询失败时返回错误，
+// 不交给 ContainerSSH 使用第一个容器，避免进入 sidecar；离线时不访问集群，返回空字符串
+func (s *Server) selectContainer(ctx context.Context, cluster *ClusterConfig, namespace, podName string, sidecars []string) (string, error) {
+	if s.offline {
+		log.Printf("[Config] Offline, not selecting container for pod %s/%s", namespace, podName)
+		return "", nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, podLookupTimeout)
+	defer cancel()
+
+	name, err := s.kube.lookupContainer(ctx, cluster, namespace, podName, sidecars)
+	if err != nil {
+		return "", fmt.Errorf("pod %s/%s: %w", namespace, podName, err)
+	}
+	log.Printf("[Config] Selected container %s in pod %s/%s", name, namespace, podName)
+	return name, nil
+}
+
+// shellCommand 计算登录 shell 命令
+// 开启自动探测时依次探测 bash、sh、ash，全部失败时返回错误，不猜测容器中的 shell；
+// 配置了工作目录时通过 /bin/sh 切换目录后再 exec 到 shell，容器中没有 /bin/sh 时忽略工作目录
+func (s *Server) shellCommand(ctx context.Context, cluster *ClusterConfig, route *Route, shell ShellConfig, wrap func(feature string) bool) ([]string, error) {
+	command := shell.Command
+	if shell.AutoDetect && route.Pod == "" {
+		// 模板模式下 pod 还不存在
+		command = []string{fallbackShell}
+	} else if shell.AutoDetect && s.offline {
+		log.Printf("[Config] Offline, not probing shell in pod %s/%s, using %s", route.Namespace, route.Pod, fallbackShell)
+		command = []string{fallbackShell}
+	} else if shell.AutoDetect {
+		detected, err := s.probeShell(ctx, cluster, route, shellCandidates)
+		if err != nil {
+			return nil, fmt.Errorf("shell auto-detect failed for pod %s/%s: %w", route.Namespace, route.Pod, err)
+		}
+		command = []string{detected}
+	}
+	if len(command) == 0 {
+		command = defaultShell
+	}
+
+	if shell.WorkDir != "" && wrap("workDir") {
+		// $0 是工作目录，$@ 是 shell 命令，避免拼接字符串带来的转义问题
+		command = append([]string{fallbackShell, "-c", `cd "$0" && exec "$@"`, shell.WorkDir}, command...)
+	}
+	return command, nil
+}
+
+// probeShell 返回 candidates 中第一个可以在容器中执行的 shell，结果按容器缓存
+func (s *Server) probeShell(ctx context.Context, cluster *ClusterConfig, route *Route, candidates []string) (string, error) {
+	key := strings.Join(append([]string{cluster.Name, route.Namespace, route.Pod, route.Container}, candidates...), "/")
+	if shell, err, ok := s.shells.get(key, time.Now()); ok {
+		return shell, err
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+	shell, err := s.prober.ProbeShell(probeCtx, cluster, route.Namespace, route.Pod, route.Container, candidates)
+	// config 请求的总时间用完时失败与容器无关，不缓存
+	if ctx.Err() == nil {
+		s.shells.set(key, shell, err, time.Now())
+	}
+	return shell, err
+}
+
+// shellWrapper 返回检查能否用 /bin/sh 包装 shell 命令的函数，工作目录、最长时间和 MOTD 都依赖 /bin/sh。
+// 第一次调用时探测，不能包装时输出被跳过的功能；模板模式下 pod 还不存在、离线时不访问集群，都按存在处理
+func (s *Server) shellWrapper(ctx context.Context, cluster *ClusterConfig, route *Route) func(feature string) bool {
+	var once sync.Once
+	ok := true
+	return func(feature string) bool {
+		once.Do(func() {
+			if route.Pod == "" || s.offline {
+				return
+			}
+			if _, err := s.probeShell(ctx, cluster, route, []string{fallbackShell}); err != nil {
+				ok = false
+			}
+		})
+		if !ok {
+			log.Printf("[Config] %s not found in pod %s/%s, skipping %s", fallbackShell, route.Namespace, route.Pod, feature)
+		}
+		return ok
+	}
+}
+
+// ensureDebugContainer 启动调试容器，dryRun 时只返回容器名称
+func (s *Server) ensureDebugContainer(ctx context.Context, cluster *ClusterConfig, namespace, podName, target, username string, debug *DebugConfig) (string, error) {
+	if s.dryRun {
+		name := debugContainerName(username)
+		log.Printf("[Config] Dry run, not starting debug container %s in pod %s/%s", name, namespace, podName)
+		return name, nil
+	}
+	return s.kube.ensureDebugContainer(ctx, cluster, namespace, podName, target, username, debug)
+}
+
+// ensureNamespace 创建工作区的命名空间，dryRun 时跳过
+func (s *Server) ensureNamespace(ctx context.Context, cluster *ClusterConfig, namespace string) error {
+	if s.dryRun {
+		log.Printf("[Config] Dry run, not creating namespace %s", namespace)
+		return nil
+	}
+	return s.kube.ensureNamespace(ctx, cluster, namespace)
+}
+
+// rejectAuth 记录认证失败的原因并返回认证失败，原因不会返回给 ContainerSSH
+func (s *Server) rejectAuth(w http.ResponseWriter, ev AuthEvent, reason string) {
+	ev.Reason = reason
+	s.events.add(ev)
+	s.sendAuthResponse(w, false, "", nil, nil)
+}
+
 // sendAuthResponse 发送认证响应
-func (s *Server) sendAuthResponse(w http.ResponseWriter, success bool, username string, userMetadata map[string]string) {
+func (s *Server) sendAuthResponse(w http.ResponseWriter, success bool, username string, user *UserConfig, userMetadata map[string]string) {
 	resp := auth.ResponseBody{
 		Success: success,
 	}
 
-	// 如果认证成功，设置用户名和 metadata
+	// 如果认证成功，设置用户名、metadata、环境变量和文件
 	if success {
 		resp.AuthenticatedUsername = username
 
-		// 转换 metadata 格式为 ContainerSSH 要求的格式
+		// 转换 metadata 格式为 ContainerSSH 要求的格式，sensitiveMetadata 中的 key 不会出现在 ContainerSSH 的日志中
 		if userMetadata != nil {
 			metadataMap := make(map[string]metadata.Value)
 			for key, value := range userMetadata {
 				metadataMap[key] = metadata.Value{
 					Value:     value,
-					Sensitive: false,
+					Sensitive: contains(user.SensitiveMetadata, key),
 				}
 			}
 			resp.ConnectionAuthPendingMetadata.ConnectionMetadata.Metadata = metadataMap
 		}
+
+		if len(user.Environment) > 0 {
+			resp.Environment = make(map[string]metadata.Value)
+			for _, env := range user.Environment {
+				resp.Environment[env.Name] = metadata.Value{
+					Value:     env.Value.Value(),
+					Sensitive: env.Sensitive,
+				}
+			}
+		}
+
+		if len(user.Files) > 0 {
+			resp.Files = make(map[string]metadata.BinaryValue)
+			for _, file := range user.Files {
+				resp.Files[file.Path] = metadata.BinaryValue{
+					Value:     []byte(file.Content.Value()),
+					Sensitive: file.Sensitive,
+				}
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -1,7 +1,28 @@
 package webhook
 
 import (
+"bytes"
+"context"
+"crypto/ed25519"
+"crypto/rand"
+"encoding/base64"
+"encoding/json"
+"errors"
+"net"
+"net/http"
+"net/http/httptest"
+"reflect"
+"strings"
 "testing"
+"time"
+
+"go.containerssh.io/containerssh/auth"
+"go.containerssh.io/containerssh/config"
+"golang.org/x/crypto/ssh"
+v1 "k8s.io/api/core/v1"
+metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+"k8s.io/client-go/kubernetes"
+"k8s.io/client-go/kubernetes/fake"
 )
 
 // 创建测试配置
@@ -87,3 +108,783 @@ if user.Metadata["KUBERNETES_CONTAINER_NAME"] != "test-container" {
 t.Errorf("Expected container 'test-container', got '%s'", user.Metadata["KUBERNETES_CONTAINER_NAME"])
 }
 }
+
+// fakeProber 测试用的 shell 探测器
+type fakeProber struct {
+	shells []string // 容器中存在的 shell
+	calls  int
+}
+
+func (p *fakeProber) ProbeShell(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string, candidates []string) (string, error) {
+	p.calls++
+	for _, c := range candidates {
+		for _, s := range p.shells {
+			if c == s {
+				return c, nil
+			}
+		}
+	}
+	return "", errors.New("no shell found")
+}
+
+// TestShellCommand 测试登录 shell 命令的计算
+func TestShellCommand(t *testing.T) {
+	server, err := NewServer(createTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	prober := &fakeProber{shells: []string{"/bin/sh", "/bin/ash"}}
+	server.prober = prober
+
+	cluster := &ClusterConfig{Name: "c1"}
+	route := &Route{Namespace: "default", Pod: "test-pod"}
+
+	tests := []struct {
+		shell    ShellConfig
+		expected []string
+	}{
+		{ShellConfig{}, []string{"/bin/bash"}},
+		{ShellConfig{Command: []string{"/bin/zsh", "-l"}}, []string{"/bin/zsh", "-l"}},
+		{ShellConfig{AutoDetect: true}, []string{"/bin/sh"}},
+		{ShellConfig{Command: []string{"/bin/ash"}, WorkDir: "/app"},
+			[]string{"/bin/sh", "-c", `cd "$0" && exec "$@"`, "/app", "/bin/ash"}},
+	}
+	wrap := func(string) bool { return true }
+	for _, tt := range tests {
+		got, err := server.shellCommand(context.Background(), cluster, route, tt.shell, wrap)
+		if err != nil {
+			t.Fatalf("Unexpected error for %+v: %v", tt.shell, err)
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Expected %v for %+v, got %v", tt.expected, tt.shell, got)
+		}
+	}
+	if prober.calls != 1 {
+		t.Errorf("Expected prober to be called once, got %d", prober.calls)
+	}
+
+	// 同一个容器的探测结果被缓存
+	if _, err := server.shellCommand(context.Background(), cluster, route, ShellConfig{AutoDetect: true}, wrap); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if prober.calls != 1 {
+		t.Errorf("Expected cached probe result, got %d calls", prober.calls)
+	}
+
+	// 探测失败时返回错误，不猜测 /bin/sh
+	server.prober = &fakeProber{}
+	other := &Route{Namespace: "default", Pod: "distroless"}
+	if got, err := server.shellCommand(context.Background(), cluster, other, ShellConfig{AutoDetect: true}, wrap); err == nil {
+		t.Errorf("Expected error when no shell is found, got %v", got)
+	}
+
+	// 容器中没有 /bin/sh 时不包装工作目录
+	got, err := server.shellCommand(context.Background(), cluster, other, ShellConfig{Command: []string{"/app"}, WorkDir: "/data"},
+		server.shellWrapper(context.Background(), cluster, other))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"/app"}) {
+		t.Errorf("Expected workDir to be skipped without /bin/sh, got %v", got)
+	}
+}
+
+// TestShellCache 测试 shell 探测结果的缓存时间
+func TestShellCache(t *testing.T) {
+	cache := newShellCache()
+	now := time.Now()
+	cache.set("ok", "/bin/sh", nil, now)
+	cache.set("fail", "", errors.New("no shell found"), now)
+
+	if shell, err, ok := cache.get("ok", now.Add(shellFailureTTL)); !ok || err != nil || shell != "/bin/sh" {
+		t.Errorf("Expected cached shell, got %q %v %v", shell, err, ok)
+	}
+	if _, _, ok := cache.get("fail", now.Add(shellFailureTTL)); ok {
+		t.Error("Expected failure to expire after shellFailureTTL")
+	}
+	if _, _, ok := cache.get("ok", now.Add(shellCacheTTL)); ok {
+		t.Error("Expected shell to expire after shellCacheTTL")
+	}
+}
+
+// TestHandleConfig_NoShell 测试自动探测不到 shell 时拒绝返回配置
+func TestHandleConfig_NoShell(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	cfg.Targets = []TargetConfig{{
+		Name:          "t1",
+		Cluster:       "c1",
+		Namespace:     "default",
+		Pod:           "test-pod",
+		Container:     "app",
+		SessionConfig: SessionConfig{Shell: &ShellConfig{AutoDetect: true}},
+	}}
+	cfg.Users[0].Target = "t1"
+	cfg.Users[0].Metadata = nil
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.prober = &fakeProber{}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// postJSON 向 handler 发送 JSON 请求并返回响应
+func postJSON(t *testing.T, handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return rec
+}
+
+// TestHandleConfig_Shell 测试 config 接口返回 shell 命令、环境变量和功能开关
+func TestHandleConfig_Shell(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	cfg.Targets = []TargetConfig{{
+		Name:      "t1",
+		Cluster:   "c1",
+		Namespace: "default",
+		Pod:       "test-pod",
+		SessionConfig: SessionConfig{
+			Shell:    &ShellConfig{AutoDetect: true, Env: map[string]string{"LANG": "C.UTF-8"}},
+			Features: &FeaturesConfig{Exec: boolPtr(false)},
+		},
+	}}
+	cfg.Users[0].Target = "t1"
+	cfg.Users[0].Metadata = nil
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.prober = &fakeProber{shells: []string{"/bin/ash"}}
+	server.kube.clients = map[string]kubernetes.Interface{
+		"c1": fake.NewSimpleClientset(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+		}),
+	}
+
+	var req config.Request
+	req.Username = "testuser"
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Config.Kubernetes.Pod.ShellCommand, []string{"/bin/ash"}) {
+		t.Errorf("Expected detected shell /bin/ash, got %v", resp.Config.Kubernetes.Pod.ShellCommand)
+	}
+	if resp.Config.Kubernetes.Connection.Host != "https://c1:6443" {
+		t.Errorf("Expected cluster host from target, got '%s'", resp.Config.Kubernetes.Connection.Host)
+	}
+	if resp.Environment["LANG"].Value != "C.UTF-8" {
+		t.Errorf("Expected LANG in environment, got %+v", resp.Environment)
+	}
+	if resp.Config.Security.Command.Mode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected exec to be disabled by target features, got %+v", resp.Config.Security.Command)
+	}
+	if resp.Config.Security.Shell.Mode != config.ExecutionPolicyUnconfigured {
+		t.Errorf("Expected shell mode to be unconfigured, got %+v", resp.Config.Security.Shell)
+	}
+}
+
+// TestHandleConfig_ContainerSelection 测试未指定容器时跳过 sidecar 容器
+func TestHandleConfig_ContainerSelection(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	cfg.ContainerSelection.Sidecars = defaultSidecars
+	cfg.Users[0].Metadata = map[string]string{
+		"KUBERNETES_CLUSTER":       "c1",
+		"KUBERNETES_POD_NAMESPACE": "default",
+		"KUBERNETES_POD_NAME":      "test-pod",
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.kube.clients = map[string]kubernetes.Interface{
+		"c1": fake.NewSimpleClientset(&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+			Spec: v1.PodSpec{
+				InitContainers: []v1.Container{{Name: "init"}},
+				Containers:     []v1.Container{{Name: "istio-proxy"}, {Name: "app"}},
+			},
+		}),
+	}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	containers := resp.Config.Kubernetes.Pod.Spec.Containers
+	if len(containers) != 1 || containers[0].Name != "app" {
+		t.Errorf("Expected container 'app', got %+v", containers)
+	}
+}
+
+// TestHandleConfig_ContainerLookupFailure 测试查询 pod 失败时拒绝返回配置，不使用第一个容器
+func TestHandleConfig_ContainerLookupFailure(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	cfg.Users[0].Metadata = map[string]string{
+		"KUBERNETES_CLUSTER":       "c1",
+		"KUBERNETES_POD_NAMESPACE": "default",
+		"KUBERNETES_POD_NAME":      "missing-pod",
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.kube.clients = map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("Expected status 502, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+// TestHandleConfig_Workspace 测试模板目标返回按需创建的 pod 配置
+func TestHandleConfig_Workspace(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	target := createTemplateTarget()
+	target.Template.CreateNamespace = true
+	target.Shell = &ShellConfig{AutoDetect: true}
+	cfg.Targets = []TargetConfig{*target}
+	cfg.Users[0].Target = target.Name
+	cfg.Users[0].Metadata = nil
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	client := fake.NewSimpleClientset()
+	server.kube.clients = map[string]kubernetes.Interface{"c1": client}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	req.ConnectionID = "0123456789abcdef"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	pod := resp.Config.Kubernetes.Pod
+	if pod.Metadata.Namespace != "ws-testuser" {
+		t.Errorf("Expected namespace 'ws-testuser', got '%s'", pod.Metadata.Namespace)
+	}
+	if len(pod.Spec.Containers) != 1 || pod.Spec.Containers[0].Image != "ubuntu:24.04" {
+		t.Errorf("Expected workspace container from template, got %+v", pod.Spec.Containers)
+	}
+	if !reflect.DeepEqual(pod.ShellCommand, []string{fallbackShell}) {
+		t.Errorf("Expected fallback shell before pod exists, got %v", pod.ShellCommand)
+	}
+	if pod.Metadata.Labels[labelConnection] != req.ConnectionID {
+		t.Errorf("Expected connection label on workspace pod, got %v", pod.Metadata.Labels)
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "ws-testuser", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected namespace to be created: %v", err)
+	}
+}
+
+// TestHandleAuth_MetadataTemplate 测试认证响应中的 metadata 按连接信息渲染
+func TestHandleAuth_MetadataTemplate(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Users[0].Groups = []string{"payments"}
+	cfg.Users[0].Metadata = map[string]string{
+		"KUBERNETES_POD_NAMESPACE": "team-{{.Group}}",
+		"source":                   "${remoteAddress}",
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req auth.PasswordAuthRequest
+	req.Username = "testuser"
+	req.RemoteAddress.IP = net.ParseIP("10.0.0.1")
+	req.Password = []byte(base64.StdEncoding.EncodeToString([]byte(cfg.Users[0].Password)))
+	rec := postJSON(t, server.handlePasswordAuth, req)
+
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success {
+		t.Fatal("Expected authentication to succeed")
+	}
+	if resp.Metadata["KUBERNETES_POD_NAMESPACE"].Value != "team-payments" {
+		t.Errorf("Expected rendered namespace, got %+v", resp.Metadata)
+	}
+	if resp.Metadata["source"].Value != "10.0.0.1" {
+		t.Errorf("Expected remote address, got %+v", resp.Metadata)
+	}
+}
+
+// TestHandlePublicKeyAuth_KeyComment 测试公钥注释写入 metadata
+func TestHandlePublicKeyAuth_KeyComment(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+
+	cfg := createTestConfig()
+	cfg.Users[0].PublicKey = authorizedKey + " alice@laptop"
+	cfg.Users[0].Metadata = map[string]string{"KUBERNETES_POD_NAME": "shell-{{dns .KeyComment}}"}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req auth.PublicKeyAuthRequest
+	req.Username = "testuser"
+	req.PublicKey.PublicKey = authorizedKey
+	rec := postJSON(t, server.handlePublicKeyAuth, req)
+
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success {
+		t.Fatal("Expected authentication to succeed")
+	}
+	if resp.Metadata["KUBERNETES_POD_NAME"].Value != "shell-alice-laptop" {
+		t.Errorf("Expected rendered pod name, got %+v", resp.Metadata)
+	}
+	if resp.Metadata[metadataKeyComment].Value != "alice@laptop" {
+		t.Errorf("Expected key comment in metadata, got %+v", resp.Metadata)
+	}
+}
+
+// TestReload 测试重新加载配置
+func TestReload(t *testing.T) {
+	server, err := NewServer(createTestConfig())
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.kube.clients = map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()}
+
+	newConfig := createTestConfig()
+	newConfig.Users[0].Username = "newuser"
+	server.Reload(newConfig)
+
+	if server.currentConfig().GetUser("newuser") == nil {
+		t.Error("Expected new config to be used after reload")
+	}
+	if server.kube.clients != nil {
+		t.Error("Expected cached kubernetes clients to be cleared")
+	}
+}
+
+// TestHandleAuth_EnvironmentAndFiles 测试认证响应中的敏感 metadata、环境变量和文件
+func TestHandleAuth_EnvironmentAndFiles(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Users[0].Metadata["TEAM_TOKEN"] = "abc"
+	cfg.Users[0].SensitiveMetadata = []string{"TEAM_TOKEN"}
+	cfg.Users[0].Environment = []EnvVarConfig{
+		{Name: "GIT_AUTHOR_NAME", Value: "Test User"},
+		{Name: "GITHUB_TOKEN", Value: "ghp_test", Sensitive: true},
+	}
+	cfg.Users[0].Files = []FileConfig{
+		{Path: "/var/run/secrets/kube/token", Content: "token-data", Sensitive: true},
+	}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req auth.PasswordAuthRequest
+	req.Username = "testuser"
+	req.Password = []byte(base64.StdEncoding.EncodeToString([]byte(cfg.Users[0].Password)))
+	rec := postJSON(t, server.handlePasswordAuth, req)
+
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Success {
+		t.Fatal("Expected authentication to succeed")
+	}
+	if !resp.Metadata["TEAM_TOKEN"].Sensitive || resp.Metadata["KUBERNETES_POD_NAME"].Sensitive {
+		t.Errorf("Unexpected sensitive flags: %+v", resp.Metadata)
+	}
+	if v := resp.Environment["GIT_AUTHOR_NAME"]; v.Value != "Test User" || v.Sensitive {
+		t.Errorf("Unexpected GIT_AUTHOR_NAME: %+v", v)
+	}
+	if v := resp.Environment["GITHUB_TOKEN"]; v.Value != "ghp_test" || !v.Sensitive {
+		t.Errorf("Unexpected GITHUB_TOKEN: %+v", v)
+	}
+	file := resp.Files["/var/run/secrets/kube/token"]
+	if string(file.Value) != "token-data" || !file.Sensitive {
+		t.Errorf("Unexpected file: %+v", file)
+	}
+}
+
+// TestHandleConfig_ForcedCommand 测试公钥 command= 选项传递到 config 响应
+func TestHandleConfig_ForcedCommand(t *testing.T) {
+	key, line := newTestKey(t)
+
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}}
+	cfg.Users[0].Metadata["KUBERNETES_CLUSTER"] = "c1"
+	cfg.Users[0].PublicKeys = []string{`command="backup.sh" ` + line}
+	cfg.Users[0].CommandAllowlist = []string{"ls"}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var authReq auth.PublicKeyAuthRequest
+	authReq.Username = "testuser"
+	authReq.PublicKey.PublicKey = string(ssh.MarshalAuthorizedKey(key))
+	rec := postJSON(t, server.handlePublicKeyAuth, authReq)
+
+	var authResp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &authResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !authResp.Success {
+		t.Fatal("Expected authentication to succeed")
+	}
+
+	// ContainerSSH 把认证返回的 metadata 带到 config 请求中
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	req.Metadata = authResp.Metadata
+	rec = postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Config.Security.ForceCommand != "backup.sh" {
+		t.Errorf("Expected key command to take precedence, got '%s'", resp.Config.Security.ForceCommand)
+	}
+	if resp.Config.Security.Shell.Mode != config.ExecutionPolicyDisable {
+		t.Errorf("Expected shell to be disabled, got '%s'", resp.Config.Security.Shell.Mode)
+	}
+}
+
+// TestHandleConfig_Recording 测试 config 响应中的录像配置
+func TestHandleConfig_Recording(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{
+		Name:       "c1",
+		Production: true,
+		Recording:  &RecordingConfig{Enable: boolPtr(true), Directory: "/var/log/sshproxy"},
+	}}
+	cfg.Users[0].Metadata["KUBERNETES_CLUSTER"] = "c1"
+	cfg.Users[0].Recording = &RecordingConfig{Enable: boolPtr(false), Retention: "365d"}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !resp.Config.Audit.Enable {
+		t.Error("Expected recording to stay enabled on production cluster")
+	}
+	if resp.Config.Audit.File.Directory != "/var/log/sshproxy/365d" {
+		t.Errorf("Expected retention subdirectory, got '%s'", resp.Config.Audit.File.Directory)
+	}
+	if resp.Metadata[metadataRecordingRetention].Value != "365d" {
+		t.Errorf("Expected retention metadata, got %+v", resp.Metadata)
+	}
+
+	// 录像配置不完整时拒绝登录
+	cfg.Clusters[0].Recording.Directory = ""
+	rec = postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rec.Code)
+	}
+}
+
+// TestHandleConfig_MOTD 测试 config 响应中的 shell 命令先输出渲染后的 MOTD
+func TestHandleConfig_MOTD(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}}
+	cfg.Targets = []TargetConfig{{
+		Name:          "t1",
+		Cluster:       "c1",
+		Namespace:     "default",
+		Pod:           "test-pod",
+		Container:     "app",
+		SessionConfig: SessionConfig{MOTD: "{{.Cluster}}/{{.Namespace}}/{{.Pod}}/{{.Container}}"},
+	}}
+	cfg.Users[0].Target = "t1"
+	cfg.Users[0].Metadata = nil
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.prober = &fakeProber{shells: []string{"/bin/bash", "/bin/sh"}}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := []string{"/bin/sh", "-c", motdScript, "c1/default/test-pod/app", "/bin/bash"}
+	if !reflect.DeepEqual(resp.Config.Kubernetes.Pod.ShellCommand, want) {
+		t.Errorf("Expected %v, got %v", want, resp.Config.Kubernetes.Pod.ShellCommand)
+	}
+
+	// 容器中没有 /bin/sh 时跳过 MOTD
+	server.prober = &fakeProber{shells: []string{"/bin/bash"}}
+	server.shells = newShellCache()
+	rec = postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	resp = config.ResponseBody{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Config.Kubernetes.Pod.ShellCommand, []string{"/bin/bash"}) {
+		t.Errorf("Expected motd to be skipped without /bin/sh, got %v", resp.Config.Kubernetes.Pod.ShellCommand)
+	}
+}
+
+// TestHandleAuth_Expired 测试访问过期后认证失败
+func TestHandleAuth_Expired(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Users[0].ExpiresAt = time.Now().Add(-time.Hour)
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+
+	var req auth.PasswordAuthRequest
+	req.Username = "testuser"
+	req.Password = []byte(base64.StdEncoding.EncodeToString([]byte(cfg.Users[0].Password)))
+	rec := postJSON(t, server.handlePasswordAuth, req)
+
+	var resp auth.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Success {
+		t.Error("Expected authentication to fail for expired user")
+	}
+
+	var configReq config.Request
+	configReq.AuthenticatedUsername = "testuser"
+	rec = postJSON(t, server.handleConfig, configReq)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", rec.Code)
+	}
+}
+
+// TestHandleConfig_Timeouts 测试生产目标的 config 响应包含默认的空闲超时和会话最长时间
+func TestHandleConfig_Timeouts(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{
+		Name:       "c1",
+		Production: true,
+		Recording:  &RecordingConfig{Enable: boolPtr(true), Directory: "/var/log/sshproxy"},
+	}}
+	cfg.Users[0].Metadata["KUBERNETES_CLUSTER"] = "c1"
+	cfg.Users[0].Timeouts = &TimeoutsConfig{Idle: 5 * time.Minute}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.prober = &fakeProber{shells: []string{"/bin/sh"}}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Environment[idleTimeoutEnv].Value != "300" {
+		t.Errorf("Expected TMOUT=300, got %+v", resp.Environment)
+	}
+	want := []string{"/bin/sh", "-c", watchdogScript, "28800", "-", "/bin/bash"}
+	if !reflect.DeepEqual(resp.Config.Kubernetes.Pod.ShellCommand, want) {
+		t.Errorf("Expected %v, got %v", want, resp.Config.Kubernetes.Pod.ShellCommand)
+	}
+}
+
+// TestHandleConfig_SessionLimitBeforeResources 测试先检查并发限制再创建集群资源，失败时释放租约
+func TestHandleConfig_SessionLimitBeforeResources(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1:6443"}}
+	target := createTemplateTarget()
+	target.Template.CreateNamespace = true
+	cfg.Targets = []TargetConfig{*target}
+	cfg.Users[0].Target = target.Name
+	cfg.Users[0].Metadata = nil
+	cfg.Users[0].MaxSessions = 1
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	client := fake.NewSimpleClientset()
+	server.kube.clients = map[string]kubernetes.Interface{"c1": client}
+	if err := server.sessions.acquire("conn1", "testuser", sessionLocation{}, 1, 0, time.Hour, time.Now()); err != nil {
+		t.Fatalf("Failed to acquire lease: %v", err)
+	}
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	req.ConnectionID = "conn2"
+	if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := client.CoreV1().Namespaces().Get(context.Background(), "ws-testuser", metav1.GetOptions{}); err == nil {
+		t.Error("Expected no namespace to be created over the session limit")
+	}
+
+	// 登记租约之后失败时释放，不占用名额
+	cfg = createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}}
+	cfg.Users[0].Metadata = map[string]string{
+		"KUBERNETES_CLUSTER":       "c1",
+		"KUBERNETES_POD_NAMESPACE": "default",
+		"KUBERNETES_POD_NAME":      "missing-pod",
+	}
+	cfg.Users[0].MaxSessions = 1
+	server, err = NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.kube.clients = map[string]kubernetes.Interface{"c1": fake.NewSimpleClientset()}
+	for _, id := range []string{"conn1", "conn2"} {
+		req.ConnectionID = id
+		if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusBadGateway {
+			t.Errorf("%s: expected status 502, got %d: %s", id, rec.Code, rec.Body.String())
+		}
+	}
+	if len(server.sessions.leases) != 0 {
+		t.Errorf("Expected leases to be released after failures, got %d", len(server.sessions.leases))
+	}
+}
+
+// TestHandleConfig_SessionLimit 测试超过并发连接数时拒绝登录
+func TestHandleConfig_SessionLimit(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", MaxPodSessions: 5}}
+	cfg.Users[0].Metadata["KUBERNETES_CLUSTER"] = "c1"
+	cfg.Users[0].MaxSessions = 1
+	cfg.SessionLimits.Tokens = []Secret{"session-token"}
+
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	server.prober = &fakeProber{shells: []string{"/bin/sh", "/bin/bash"}}
+	checker := &fakeSessionChecker{live: map[string]bool{}}
+	server.checker = checker
+
+	var req config.Request
+	req.AuthenticatedUsername = "testuser"
+	req.ConnectionID = "conn1"
+	rec := postJSON(t, server.handleConfig, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := []string{"/bin/sh", "-c", watchdogScript, "0", sessionMarker("conn1"), "/bin/bash"}
+	if !reflect.DeepEqual(resp.Config.Kubernetes.Pod.ShellCommand, want) {
+		t.Errorf("Expected shell with session marker %v, got %v", want, resp.Config.Kubernetes.Pod.ShellCommand)
+	}
+
+	req.ConnectionID = "conn2"
+	if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", rec.Code)
+	}
+
+	// 第一个连接结束后可以再次登录
+	postSession(t, server.handleSessionEnd, "session-token", sessionRequest{ConnectionID: "conn1"})
+	if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 客户端断开后没有调用 /session/end，检查到连接标记消失后释放
+	checker.live = map[string]bool{"conn2": true}
+	server.checkSessions(context.Background(), time.Now())
+	req.ConnectionID = "conn3"
+	if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 while conn2 is alive, got %d", rec.Code)
+	}
+	checker.live = map[string]bool{}
+	server.checkSessions(context.Background(), time.Now())
+	if rec := postJSON(t, server.handleConfig, req); rec.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after conn2 disconnected, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,328 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultLeaseTTL 未配置 sessionLimits.leaseTTL 且没有会话最长时间时的租约有效期
+const defaultLeaseTTL = time.Hour
+
+// leaseGrace 租约有效期按会话最长时间计算时额外保留的时间，覆盖 SIGKILL 前的等待
+const leaseGrace = 10 * time.Second
+
+// sessionCheckInterval 检查连接是否仍然存在的间隔
+const sessionCheckInterval = time.Minute
+
+// sessionCheckTimeout 检查一个 pod 或 namespace 中连接的超时时间
+const sessionCheckTimeout = 10 * time.Second
+
+// sessionMarkerPrefix 连接标记的前缀，见 sessionMarker
+const sessionMarkerPrefix = "sshproxy-session="
+
+// labelConnection 工作区 pod 上记录 ContainerSSH 连接 ID 的标签
+const labelConnection = "sshproxy/connection"
+
+// SessionLimitsConfig 并发会话计数的配置
+// ContainerSSH 在连接结束时不会通知 webhook，每个连接在 config 请求时登记一个租约，
+// webhook 定期检查 Kubernetes 中的连接：仍然存在的连接续期，检查到过、现在已经不存在的连接释放；
+// 无法检查的连接在租约到期、收到 /session/end 或被 /session/heartbeat 续期之前一直计入并发数
+type SessionLimitsConfig struct {
+	// 租约有效期，默认 1 小时；会话设置了 maxSession 时使用 maxSession
+	LeaseTTL time.Duration `yaml:"leaseTTL,omitempty"`
+	// 调用 /session/heartbeat 和 /session/end 的 Bearer token，未配置时这两个接口不可用
+	Tokens []Secret `yaml:"tokens,omitempty"`
+}
+
+// sessionLocation 连接所在的位置，用于检查连接是否仍然存在，位置未知时 cluster 为空
+type sessionLocation struct {
+	cluster   string
+	namespace string
+	pod       string // 工作区模板模式下为空，pod 由 ContainerSSH 创建并带有 labelConnection 标签
+	container string
+}
+
+// key 返回 pod 的计数 key，工作区模板模式下为空
+func (l sessionLocation) key() string {
+	if l.pod == "" {
+		return ""
+	}
+	return podKey(l.cluster, l.namespace, l.pod)
+}
+
+// sessionLease 一个连接的租约
+type sessionLease struct {
+	user    string
+	loc     sessionLocation
+	ttl     time.Duration
+	expires time.Time
+	seen    bool // 是否在 Kubernetes 中检查到过该连接
+}
+
+// sessionTracker 按 ConnectionID 记录活跃的连接
+type sessionTracker struct {
+	mu     sync.Mutex
+	leases map[string]*sessionLease
+}
+
+// newSessionTracker 创建连接记录
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{leases: make(map[string]*sessionLease)}
+}
+
+// podKey 返回 pod 的计数 key
+func podKey(cluster, namespace, pod string) string {
+	return cluster + "/" + namespace + "/" + pod
+}
+
+// sessionMarker 返回连接标记，作为监视进程的参数保留在容器中，检查连接时在进程的命令行中查找
+func sessionMarker(id string) string {
+	return sessionMarkerPrefix + id
+}
+
+// acquire 为连接登记租约，超过用户或 pod 的并发限制时返回错误，限制为 0 表示不限制。
+// 同一个 ConnectionID 重复登记只会续期，不会重复计数
+func (t *sessionTracker) acquire(id, user string, loc sessionLocation, userLimit, podLimit int, ttl time.Duration, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, lease := range t.leases {
+		if !now.Before(lease.expires) {
+			delete(t.leases, key)
+		}
+	}
+	if lease, ok := t.leases[id]; ok {
+		lease.ttl = ttl
+		lease.expires = now.Add(ttl)
+		return nil
+	}
+
+	pod := loc.key()
+	var userCount, podCount int
+	for _, lease := range t.leases {
+		if lease.user == user {
+			userCount++
+		}
+		if pod != "" && lease.loc.key() == pod {
+			podCount++
+		}
+	}
+	if userLimit > 0 && userCount >= userLimit {
+		return fmt.Errorf("user %s has reached the limit of %d concurrent sessions", user, userLimit)
+	}
+	if podLimit > 0 && podCount >= podLimit {
+		return fmt.Errorf("pod %s has reached the limit of %d concurrent sessions", pod, podLimit)
+	}
+
+	t.leases[id] = &sessionLease{user: user, loc: loc, ttl: ttl, expires: now.Add(ttl)}
+	return nil
+}
+
+// locate 更新租约所在的容器。租约在访问集群之前登记，选择容器和启动调试容器之后才知道连接所在的容器
+func (t *sessionTracker) locate(id string, loc sessionLocation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if lease, ok := t.leases[id]; ok {
+		lease.loc = loc
+	}
+}
+
+// heartbeat 按登记时的有效期为租约续期，租约不存在或已过期时返回 false
+func (t *sessionTracker) heartbeat(id string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lease, ok := t.leases[id]
+	if !ok || !now.Before(lease.expires) {
+		delete(t.leases, id)
+		return false
+	}
+	lease.expires = now.Add(lease.ttl)
+	return true
+}
+
+// release 删除租约，租约不存在时返回 false
+func (t *sessionTracker) release(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.leases[id]
+	delete(t.leases, id)
+	return ok
+}
+
+// locations 按位置分组返回位置已知的连接
+func (t *sessionTracker) locations() map[sessionLocation][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	groups := make(map[sessionLocation][]string)
+	for id, lease := range t.leases {
+		if lease.loc.cluster != "" {
+			groups[lease.loc] = append(groups[lease.loc], id)
+		}
+	}
+	return groups
+}
+
+// update 按检查结果更新连接的租约：仍然存在的连接续期，检查到过、现在已经不存在的连接释放，
+// 从未检查到的连接（如还没有打开 shell 或 pod 还未创建）保持不变，返回释放的连接
+func (t *sessionTracker) update(ids []string, live map[string]bool, now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var released []string
+	for _, id := range ids {
+		lease, ok := t.leases[id]
+		if !ok {
+			continue
+		}
+		switch {
+		case live[id]:
+			lease.seen = true
+			lease.expires = now.Add(lease.ttl)
+		case lease.seen:
+			delete(t.leases, id)
+			released = append(released, id)
+		}
+	}
+	return released
+}
+
+// resolveMaxSessions 返回优先级最高的一层中设置的单个用户的并发会话数
+func resolveMaxSessions(layers []*SessionConfig) int {
+	var max int
+	for _, layer := range layers {
+		if layer.MaxSessions != 0 {
+			max = layer.MaxSessions
+		}
+	}
+	return max
+}
+
+// sessionLimited 是否限制了连接所属用户或 pod 的并发数，限制时才需要检查连接是否结束
+func sessionLimited(cluster *ClusterConfig, target *TargetConfig, pod bool, layers []*SessionConfig) bool {
+	return resolveMaxSessions(layers) > 0 || (pod && maxPodSessions(cluster, target) > 0)
+}
+
+// maxPodSessions 返回同一个 pod 的并发会话数，目标上的设置优先于集群
+func maxPodSessions(cluster *ClusterConfig, target *TargetConfig) int {
+	if target != nil && target.MaxPodSessions != 0 {
+		return target.MaxPodSessions
+	}
+	return cluster.MaxPodSessions
+}
+
+// leaseTTL 计算租约有效期，设置了会话最长时间时连接不会超过该时间
+func (c *Config) leaseTTL(timeouts TimeoutsConfig) time.Duration {
+	if timeouts.MaxSession > 0 {
+		return timeouts.MaxSession + leaseGrace
+	}
+	if c.SessionLimits.LeaseTTL > 0 {
+		return c.SessionLimits.LeaseTTL
+	}
+	return defaultLeaseTTL
+}
+
+// sessionRequest /session/heartbeat 和 /session/end 的请求
+type sessionRequest struct {
+	ConnectionID string `json:"connectionId"`
+}
+
+// decodeSessionRequest 检查 Bearer token 并解析会话请求
+func (s *Server) decodeSessionRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost {
+		log.Printf("[Session] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return "", false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !matchToken(s.currentConfig().SessionLimits.Tokens, token) {
+		log.Printf("[Session] Unauthorized request - path=%s, remoteAddress=%s", r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="sshproxy"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+	var req sessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ConnectionID == "" {
+		log.Printf("[Session] Invalid request: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return "", false
+	}
+	return req.ConnectionID, true
+}
+
+// handleSessionHeartbeat 为连接的租约续期，租约不存在时返回 404
+func (s *Server) handleSessionHeartbeat(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.decodeSessionRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.sessions.heartbeat(id, time.Now()) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessionEnd 连接结束时删除租约
+func (s *Server) handleSessionEnd(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.decodeSessionRequest(w, r)
+	if !ok {
+		return
+	}
+	if s.sessions.release(id) {
+		log.Printf("[Session] Connection ended - connectionId=%s", id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkSessions 检查所有位置已知的连接是否仍然存在。
+// 持久 pod 中在容器进程的命令行中查找连接标记，工作区在 namespace 中查找带有连接标签的 pod；
+// 检查失败时（如容器中没有 /bin/sh 或 cat）保持租约不变，由租约到期释放
+func (s *Server) checkSessions(ctx context.Context, now time.Time) {
+	cfg := s.currentConfig()
+	for loc, ids := range s.sessions.locations() {
+		cluster := cfg.GetCluster(loc.cluster)
+		if cluster == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, sessionCheckTimeout)
+		var live map[string]bool
+		var err error
+		if loc.pod != "" {
+			live, err = s.checker.PodSessions(ctx, cluster, loc.namespace, loc.pod, loc.container)
+		} else {
+			live, err = s.checker.WorkspaceSessions(ctx, cluster, loc.namespace)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("[Session] Failed to check sessions in %s/%s/%s: %v", loc.cluster, loc.namespace, loc.pod, err)
+			continue
+		}
+		for _, id := range s.sessions.update(ids, live, now) {
+			log.Printf("[Session] Connection ended - connectionId=%s", id)
+		}
+	}
+}
+
+// watchSessions 定期检查连接，直到 stop 关闭
+func (s *Server) watchSessions(stop <-chan struct{}) {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.checkSessions(context.Background(), now)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// TestSessionTracker_Limits 测试按用户和 pod 限制并发连接
+func TestSessionTracker_Limits(t *testing.T) {
+	tracker := newSessionTracker()
+	now := time.Now()
+	pod := sessionLocation{cluster: "c1", namespace: "default", pod: "app-0"}
+
+	if err := tracker.acquire("conn1", "alice", pod, 2, 3, time.Hour, now); err != nil {
+		t.Fatalf("Expected first session to be accepted, got %v", err)
+	}
+	// 同一个连接重复登记不计数
+	if err := tracker.acquire("conn1", "alice", pod, 1, 1, time.Hour, now); err != nil {
+		t.Errorf("Expected duplicate connection to be accepted, got %v", err)
+	}
+	if err := tracker.acquire("conn2", "alice", pod, 2, 3, time.Hour, now); err != nil {
+		t.Errorf("Expected second session to be accepted, got %v", err)
+	}
+	if err := tracker.acquire("conn3", "alice", pod, 2, 3, time.Hour, now); err == nil {
+		t.Error("Expected user limit to be reached")
+	}
+	if err := tracker.acquire("conn3", "bob", pod, 2, 3, time.Hour, now); err != nil {
+		t.Errorf("Expected bob to be accepted, got %v", err)
+	}
+	if err := tracker.acquire("conn4", "carol", pod, 0, 3, time.Hour, now); err == nil {
+		t.Error("Expected pod limit to be reached")
+	}
+	if err := tracker.acquire("conn4", "carol", sessionLocation{cluster: "c1", namespace: "default", pod: "app-1"}, 0, 3, time.Hour, now); err != nil {
+		t.Errorf("Expected other pod to be accepted, got %v", err)
+	}
+}
+
+// TestSessionTracker_Expiry 测试租约到期、续期和结束
+func TestSessionTracker_Expiry(t *testing.T) {
+	tracker := newSessionTracker()
+	now := time.Now()
+
+	if err := tracker.acquire("conn1", "alice", sessionLocation{}, 1, 0, time.Minute, now); err != nil {
+		t.Fatalf("Failed to acquire session: %v", err)
+	}
+	if !tracker.heartbeat("conn1", now.Add(50*time.Second)) {
+		t.Fatal("Expected heartbeat to extend the lease")
+	}
+	// 续期后原来的到期时间已经不再生效
+	if err := tracker.acquire("conn2", "alice", sessionLocation{}, 1, 0, time.Minute, now.Add(90*time.Second)); err == nil {
+		t.Error("Expected renewed lease to still count")
+	}
+	// 租约到期后不再计数
+	if err := tracker.acquire("conn2", "alice", sessionLocation{}, 1, 0, time.Minute, now.Add(3*time.Minute)); err != nil {
+		t.Errorf("Expected expired lease to be released, got %v", err)
+	}
+	if tracker.heartbeat("conn1", now.Add(3*time.Minute)) {
+		t.Error("Expected heartbeat for expired lease to fail")
+	}
+
+	if !tracker.release("conn2") {
+		t.Error("Expected release to find the lease")
+	}
+	if tracker.release("conn2") {
+		t.Error("Expected second release to report missing lease")
+	}
+}
+
+// TestLeaseTTL 测试租约有效期
+func TestLeaseTTL(t *testing.T) {
+	cfg := &Config{}
+	if ttl := cfg.leaseTTL(TimeoutsConfig{}); ttl != defaultLeaseTTL {
+		t.Errorf("Expected default lease ttl, got %s", ttl)
+	}
+	cfg.SessionLimits.LeaseTTL = 10 * time.Minute
+	if ttl := cfg.leaseTTL(TimeoutsConfig{}); ttl != 10*time.Minute {
+		t.Errorf("Expected configured lease ttl, got %s", ttl)
+	}
+	if ttl := cfg.leaseTTL(TimeoutsConfig{MaxSession: time.Hour}); ttl != time.Hour+leaseGrace {
+		t.Errorf("Expected lease ttl from max session, got %s", ttl)
+	}
+}
+
+// postSession 带 Bearer token 调用会话接口
+func postSession(t *testing.T, handler http.HandlerFunc, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// TestHandleSession 测试续期和结束接口
+func TestHandleSession(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.SessionLimits.Tokens = []Secret{"session-token"}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	if err := server.sessions.acquire("conn1", "testuser", sessionLocation{}, 0, 0, time.Hour, time.Now()); err != nil {
+		t.Fatalf("Failed to acquire session: %v", err)
+	}
+
+	// 没有 token 或 token 错误时不能结束其他连接
+	for _, token := range []string{"", "wrong"} {
+		rec := postSession(t, server.handleSessionEnd, token, sessionRequest{ConnectionID: "conn1"})
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401 for token %q, got %d", token, rec.Code)
+		}
+	}
+
+	rec := postSession(t, server.handleSessionHeartbeat, "session-token", sessionRequest{ConnectionID: "conn1"})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rec.Code)
+	}
+	rec = postSession(t, server.handleSessionEnd, "session-token", sessionRequest{ConnectionID: "conn1"})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", rec.Code)
+	}
+	rec = postSession(t, server.handleSessionHeartbeat, "session-token", sessionRequest{ConnectionID: "conn1"})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rec.Code)
+	}
+	rec = postSession(t, server.handleSessionEnd, "session-token", sessionRequest{})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+
+	// 未配置 token 时接口不可用
+	cfg.SessionLimits.Tokens = nil
+	rec = postSession(t, server.handleSessionHeartbeat, "session-token", sessionRequest{ConnectionID: "conn1"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without configured tokens, got %d", rec.Code)
+	}
+}
+
+// fakeSessionChecker 测试用的连接检查，返回设置好的连接
+type fakeSessionChecker struct {
+	live map[string]bool
+	err  error
+}
+
+func (c *fakeSessionChecker) PodSessions(ctx context.Context, cluster *ClusterConfig, namespace, pod, container string) (map[string]bool, error) {
+	return c.live, c.err
+}
+
+func (c *fakeSessionChecker) WorkspaceSessions(ctx context.Context, cluster *ClusterConfig, namespace string) (map[string]bool, error) {
+	return c.live, c.err
+}
+
+// TestCheckSessions 测试按 Kubernetes 中的连接续期和释放租约
+func TestCheckSessions(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1"}}
+	server, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	checker := &fakeSessionChecker{live: map[string]bool{"conn1": true}}
+	server.checker = checker
+
+	now := time.Now()
+	loc := sessionLocation{cluster: "c1", namespace: "default", pod: "app-0", container: "app"}
+	for _, id := range []string{"conn1", "conn2"} {
+		if err := server.sessions.acquire(id, "testuser", loc, 0, 0, time.Minute, now); err != nil {
+			t.Fatalf("Failed to acquire session: %v", err)
+		}
+	}
+	// 位置未知的连接不检查
+	if err := server.sessions.acquire("conn3", "testuser", sessionLocation{}, 0, 0, time.Minute, now); err != nil {
+		t.Fatalf("Failed to acquire session: %v", err)
+	}
+
+	// 仍然存在的连接续期，超过原来的有效期也继续计数
+	server.checkSessions(context.Background(), now.Add(50*time.Second))
+	if err := server.sessions.acquire("conn4", "testuser", loc, 1, 0, time.Minute, now.Add(90*time.Second)); err == nil {
+		t.Error("Expected live session to still count after its original ttl")
+	}
+
+	// 检查失败时保持不变
+	checker.err = errors.New("exec failed")
+	server.checkSessions(context.Background(), now.Add(100*time.Second))
+	if _, ok := server.sessions.leases["conn1"]; !ok {
+		t.Error("Expected lease to be kept when check fails")
+	}
+
+	// 检查到过的连接消失后释放，从未检查到的连接等待租约到期
+	server.sessions.acquire("conn2", "testuser", loc, 0, 0, time.Minute, now.Add(100*time.Second))
+	checker.err = nil
+	checker.live = map[string]bool{}
+	server.checkSessions(context.Background(), now.Add(110*time.Second))
+	if _, ok := server.sessions.leases["conn1"]; ok {
+		t.Error("Expected ended session to be released")
+	}
+	if _, ok := server.sessions.leases["conn2"]; !ok {
+		t.Error("Expected never seen session to wait for its lease to expire")
+	}
+}
+
+// TestWorkspaceSessions 测试按 pod 标签查找工作区连接
+func TestWorkspaceSessions(t *testing.T) {
+	deleting := metav1.Now()
+	client := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ws-1", Namespace: "ws", Labels: map[string]string{labelConnection: "conn1"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ws-2", Namespace: "ws", Labels: map[string]string{labelConnection: "conn2"},
+			DeletionTimestamp: &deleting, Finalizers: []string{"test"}}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ws"}},
+	)
+	checker := &kubeSessionChecker{clients: &kubeClients{clients: map[string]kubernetes.Interface{"c1": client}}}
+
+	live, err := checker.WorkspaceSessions(context.Background(), &ClusterConfig{Name: "c1"}, "ws")
+	if err != nil {
+		t.Fatalf("Failed to list sessions: %v", err)
+	}
+	if !reflect.DeepEqual(live, map[string]bool{"conn1": true}) {
+		t.Errorf("Expected only conn1 to be live, got %v", live)
+	}
+}
@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+
+	"go.containerssh.io/containerssh/auth"
+	"go.containerssh.io/containerssh/config"
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// SimulateOptions sshhook simulate 的参数，Password 和 PublicKey 二选一
+type SimulateOptions struct {
+	Username      string
+	Password      string
+	PublicKey     string // authorized_keys 格式
+	Code          string // TOTP 验证码，用户配置了 totpSecret 时需要
+	RemoteAddress string // 客户端 IP，影响 {{.RemoteAddress}} 模板
+	ConnectionID  string
+	Resolve       bool // 访问集群选择容器和探测 shell；默认不访问集群，任何情况下都不会创建资源
+}
+
+// SimulateResult 模拟登录的结果
+type SimulateResult struct {
+	Method        string            `json:"method"` // 使用验证码时为 password+totp 或 publickey+totp
+	Authenticated bool              `json:"authenticated"`
+	Authorized    *bool             `json:"authorized,omitempty"` // 授权步骤的结果，未启用授权步骤时为空
+	Target        string            `json:"target,omitempty"`     // 登录时选择的目标
+	Reason        string            `json:"reason,omitempty"`     // 认证、授权或 config 失败的原因
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Environment   map[string]string `json:"environment,omitempty"`
+	Files         []string          `json:"files,omitempty"`        // 写入容器的文件路径，不输出内容
+	ConfigStatus  int               `json:"configStatus,omitempty"` // config 接口的 HTTP 状态码
+	Config        *config.AppConfig `json:"config,omitempty"`       // 返回给 ContainerSSH 的配置
+}
+
+// Simulate 在进程内依次调用认证、授权（启用时）和 config 接口，与 ContainerSSH 的调用顺序相同。
+// 敏感的 metadata、用户配置的环境变量和 S3 凭据输出为 ******，文件只输出路径
+func Simulate(cfg *Config, opts SimulateOptions) (*SimulateResult, error) {
+	if (opts.Password == "") == (opts.PublicKey == "") {
+		return nil, errors.New("exactly one of password and public key is required")
+	}
+	server, err := NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	server.dryRun = true
+	server.offline = !opts.Resolve
+
+	var pending metadata.ConnectionAuthPendingMetadata
+	pending.Username = opts.Username
+	pending.ConnectionID = opts.ConnectionID
+	if opts.RemoteAddress != "" {
+		ip := net.ParseIP(opts.RemoteAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid remote address: %s", opts.RemoteAddress)
+		}
+		pending.RemoteAddress = metadata.RemoteAddress(net.TCPAddr{IP: ip})
+	}
+
+	// 认证
+	result := &SimulateResult{}
+	var rec *httptest.ResponseRecorder
+	if opts.Password != "" {
+		result.Method = "password"
+		rec = simulateRequest(server.handlePasswordAuth, auth.PasswordAuthRequest{
+			ConnectionAuthPendingMetadata: pending,
+			Password:                      []byte(base64.StdEncoding.EncodeToString([]byte(opts.Password))),
+		})
+	} else {
+		result.Method = "publickey"
+		rec = simulateRequest(server.handlePublicKeyAuth, auth.PublicKeyAuthRequest{
+			ConnectionAuthPendingMetadata: pending,
+			PublicKey:                     auth.PublicKey{PublicKey: strings.TrimSpace(opts.PublicKey)},
+		})
+	}
+	var authResp AuthResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &authResp); err != nil {
+		return nil, fmt.Errorf("failed to decode auth response: %w", err)
+	}
+	// 需要第二因素时与客户端一样继续使用 keyboard-interactive 输入验证码
+	if !authResp.Success && opts.Code != "" && server.lastAuthReason() == "second factor required" {
+		result.Method += "+totp"
+		rec = simulateRequest(server.handleKeyboardInteractive, KeyboardInteractiveRequest{
+			ConnectionAuthPendingMetadata: pending,
+			Answers:                       map[string]string{questionCode: opts.Code},
+		})
+		authResp = AuthResponse{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &authResp); err != nil {
+			return nil, fmt.Errorf("failed to decode keyboard-interactive response: %w", err)
+		}
+	}
+	result.Authenticated = authResp.Success
+	if !authResp.Success {
+		result.Reason = server.lastAuthReason()
+		return result, nil
+	}
+	result.Metadata = simulateValues(authResp.Metadata)
+	result.Environment = simulateSecrets(authResp.Environment)
+	for path := range authResp.Files {
+		result.Files = append(result.Files, path)
+	}
+	sort.Strings(result.Files)
+
+	// ContainerSSH 把认证返回的 metadata 带入授权和 config 请求
+	authenticated := authResp.ConnectionAuthenticatedMetadata
+	authenticated.Username = pending.Username
+	authenticated.ConnectionID = pending.ConnectionID
+	authenticated.RemoteAddress = pending.RemoteAddress
+	_, result.Target = cfg.loginUser(pending.Username)
+
+	if cfg.selectableTargets() {
+		rec = simulateRequest(server.handleAuthz, auth.AuthorizationRequest{ConnectionAuthenticatedMetadata: authenticated})
+		var authzResp AuthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &authzResp); err != nil {
+			return nil, fmt.Errorf("failed to decode authz response: %w", err)
+		}
+		result.Authorized = &authzResp.Success
+		if !authzResp.Success {
+			if decisions := server.decisions.list("", 1); len(decisions) > 0 {
+				result.Reason = decisions[0].Reason
+			}
+			return result, nil
+		}
+		authenticated.Metadata = authzResp.Metadata
+	}
+
+	var req config.Request
+	req.ConnectionAuthenticatedMetadata = authenticated
+	rec = simulateRequest(server.handleConfig, req)
+	result.ConfigStatus = rec.Code
+	if rec.Code != http.StatusOK {
+		result.Reason = strings.TrimSpace(rec.Body.String())
+		return result, nil
+	}
+	var configResp config.ResponseBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &configResp); err != nil {
+		return nil, fmt.Errorf("failed to decode config response: %w", err)
+	}
+	result.Config = &configResp.Config
+	// 录像的 S3 凭据不输出明文
+	s3 := &result.Config.Audit.S3
+	s3.AccessKey = Secret(s3.AccessKey).String()
+	s3.SecretKey = Secret(s3.SecretKey).String()
+	// config 响应中的环境变量（如 TMOUT）和 metadata 与认证返回的合并输出；
+	// config 响应带回了认证返回的环境变量，这些变量保持 ******
+	for k, v := range simulateValues(configResp.Environment) {
+		if _, ok := authResp.Environment[k]; ok {
+			continue
+		}
+		if result.Environment == nil {
+			result.Environment = make(map[string]string)
+		}
+		result.Environment[k] = v
+	}
+	for k, v := range simulateValues(configResp.Metadata) {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]string)
+		}
+		result.Metadata[k] = v
+	}
+	return result, nil
+}
+
+// lastAuthReason 返回最近一次认证失败的原因
+func (s *Server) lastAuthReason() string {
+	if events := s.events.list("", 1); len(events) > 0 {
+		return events[0].Reason
+	}
+	return ""
+}
+
+// simulateRequest 在进程内调用接口
+func simulateRequest(handler http.HandlerFunc, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(data)))
+	return rec
+}
+
+// simulateSecrets 转换认证返回的环境变量。这些值来自用户的 environment，是 Secret 类型，
+// 可能是 file:、env:、k8s: 引用解析后的明文，不论是否标记为 sensitive 都输出为 ******
+func simulateSecrets(values map[string]metadata.Value) map[string]string {
+	result := simulateValues(values)
+	for k := range result {
+		result[k] = redacted
+	}
+	return result
+}
+
+// simulateValues 转换 metadata 值，敏感值输出为 ******
+func simulateValues(values map[string]metadata.Value) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for k, v := range values {
+		if v.Sensitive {
+			result[k] = redacted
+		} else {
+			result[k] = v.Value
+		}
+	}
+	return result
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// simulateTestConfig 目标开启了 shell 探测，集群不可访问
+func simulateTestConfig() *Config {
+	cfg := createTestConfig()
+	cfg.Clusters = []ClusterConfig{{Name: "c1", Host: "https://c1.invalid:6443"}}
+	cfg.Targets = []TargetConfig{{
+		Name:      "t1",
+		Cluster:   "c1",
+		Namespace: "default",
+		Pod:       "test-pod",
+		SessionConfig: SessionConfig{
+			Shell: &ShellConfig{AutoDetect: true},
+		},
+	}}
+	cfg.Users[0].Target = "t1"
+	cfg.Users[0].Metadata = map[string]string{"team": "ops", "token": "s3cr3t"}
+	cfg.Users[0].SensitiveMetadata = []string{"token"}
+	cfg.Users[0].Recording = &RecordingConfig{
+		Enable:    boolPtr(true),
+		Storage:   "s3",
+		Directory: "/var/lib/recordings",
+		S3:        &S3Config{Bucket: "audit", Region: "us-east-1", AccessKey: "AKIA", SecretKey: "s3-secret"},
+	}
+	return cfg
+}
+
+// TestSimulate_Password 测试密码登录的模拟，不访问集群，敏感的 metadata 不输出
+func TestSimulate_Password(t *testing.T) {
+	result, err := Simulate(simulateTestConfig(), SimulateOptions{
+		Username:      "testuser",
+		Password:      "testpass",
+		RemoteAddress: "10.0.0.5",
+		ConnectionID:  "sim",
+	})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if !result.Authenticated || result.Config == nil {
+		t.Fatalf("Expected login to be allowed, got %+v", result)
+	}
+	if result.Metadata["team"] != "ops" || result.Metadata["token"] != redacted {
+		t.Errorf("Expected metadata with redacted token, got %v", result.Metadata)
+	}
+	if !reflect.DeepEqual(result.Config.Kubernetes.Pod.ShellCommand, []string{fallbackShell}) {
+		t.Errorf("Expected fallback shell without cluster access, got %v", result.Config.Kubernetes.Pod.ShellCommand)
+	}
+	if result.Config.Audit.S3.SecretKey != redacted {
+		t.Errorf("Expected redacted S3 secret key, got %q", result.Config.Audit.S3.SecretKey)
+	}
+	if result.Config.Kubernetes.Pod.Metadata.Name != "test-pod" {
+		t.Errorf("Expected pod test-pod, got %s", result.Config.Kubernetes.Pod.Metadata.Name)
+	}
+}
+
+// TestSimulate_Environment 测试用户配置的环境变量不论是否标记为 sensitive 都不输出明文
+func TestSimulate_Environment(t *testing.T) {
+	t.Setenv("SIMULATE_TEST_TOKEN", "env-secret")
+	cfg := simulateTestConfig()
+	cfg.Users[0].Environment = []EnvVarConfig{
+		{Name: "API_TOKEN", Value: "env:SIMULATE_TEST_TOKEN"},
+		{Name: "DB_PASSWORD", Value: "plain-secret", Sensitive: true},
+	}
+	if err := cfg.resolveSecrets(context.Background(), &kubeClients{}); err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if cfg.Users[0].Environment[0].Value.Value() != "env-secret" {
+		t.Fatalf("Expected env: reference to be resolved, got %q", cfg.Users[0].Environment[0].Value.Value())
+	}
+
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Environment["API_TOKEN"] != redacted || result.Environment["DB_PASSWORD"] != redacted {
+		t.Errorf("Expected redacted environment, got %v", result.Environment)
+	}
+	data, _ := json.Marshal(result)
+	if strings.Contains(string(data), "env-secret") || strings.Contains(string(data), "plain-secret") {
+		t.Errorf("Expected no plaintext secrets in output, got %s", data)
+	}
+}
+
+// TestSimulate_Denied 测试认证失败和 config 失败时输出原因
+func TestSimulate_Denied(t *testing.T) {
+	cfg := simulateTestConfig()
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser", Password: "wrong"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Authenticated || result.Reason != "invalid password" {
+		t.Errorf("Expected reason 'invalid password', got %+v", result)
+	}
+
+	result, err = Simulate(cfg, SimulateOptions{Username: "testuser", PublicKey: cfg.Users[0].PublicKey})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if !result.Authenticated || result.Method != "publickey" || result.Config == nil {
+		t.Errorf("Expected public key login to be allowed, got %+v", result)
+	}
+
+	cfg.Users[0].Recording = nil
+	cfg.Targets[0].Production = true
+	result, err = Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Config != nil || result.ConfigStatus != 500 || result.Reason != "Session recording is required" {
+		t.Errorf("Expected config to fail without recording, got %+v", result)
+	}
+
+	if _, err := Simulate(cfg, SimulateOptions{Username: "testuser"}); err == nil {
+		t.Error("Expected error without password and public key")
+	}
+}
+
+// TestSimulate_DebugDryRun 测试调试模式下不创建调试容器
+func TestSimulate_DebugDryRun(t *testing.T) {
+	cfg := simulateTestConfig()
+	cfg.Targets[0].Container = "app"
+	cfg.Targets[0].Debug = &DebugConfig{Image: "busybox"}
+
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Config == nil {
+		t.Fatalf("Expected login to be allowed, got %+v", result)
+	}
+	if got := result.Config.Kubernetes.Pod.Spec.Containers[0].Name; got != debugContainerName("testuser") {
+		t.Errorf("Expected debug container %s, got %s", debugContainerName("testuser"), got)
+	}
+}
+
+// TestSimulate_TOTP 测试配置了 totpSecret 的用户需要 -code
+func TestSimulate_TOTP(t *testing.T) {
+	cfg := simulateTestConfig()
+	cfg.Users[0].Recording = nil
+	cfg.MFA.EncryptionKey = "mfa-key"
+	secret := []byte("12345678901234567890")
+	encrypted, err := cfg.MFA.encryptTOTPSecret("testuser", secret)
+	if err != nil {
+		t.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	cfg.Users[0].TOTPSecret = encrypted
+
+	result, err := Simulate(cfg, SimulateOptions{Username: "testuser", Password: "testpass"})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if result.Authenticated || result.Reason != "second factor required" {
+		t.Errorf("Expected second factor to be required, got %+v", result)
+	}
+
+	result, err = Simulate(cfg, SimulateOptions{
+		Username: "testuser",
+		Password: "testpass",
+		Code:     totpCode(secret, totpCounter(time.Now())),
+	})
+	if err != nil {
+		t.Fatalf("Failed to simulate: %v", err)
+	}
+	if !result.Authenticated || result.Method != "password+totp" || result.Config == nil {
+		t.Errorf("Expected login with verification code, got %+v", result)
+	}
+}
@@ -0,0 +1,507 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configStore 管理 API 读写配置的存储
+type configStore interface {
+	// Path 返回配置文件路径，用于加载写入后的配置
+	Path() string
+	// Load 读取配置内容
+	Load() ([]byte, error)
+	// Save 写入配置内容
+	Save(data []byte) error
+}
+
+// fileStore 把配置写回 webhook 加载的配置文件
+type fileStore struct {
+	path string
+}
+
+// Path 实现 configStore
+func (s *fileStore) Path() string {
+	return s.path
+}
+
+// Load 实现 configStore
+func (s *fileStore) Load() ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+// Save 实现 configStore，先写入同目录下的临时文件再替换，避免写入中断时留下不完整的配置
+func (s *fileStore) Save(data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(s.path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to chmod temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// configDocument 保留注释的配置文档，管理 API 按节点修改，未修改的部分保持原样
+type configDocument struct {
+	doc  yaml.Node
+	data []byte    // 解析时的配置内容
+	base yaml.Node // data 解析得到的文档，不会被修改，用于找出修改的条目
+}
+
+// parseDocument 解析配置内容
+func parseDocument(data []byte) (*configDocument, error) {
+	d := &configDocument{data: data}
+	if err := yaml.Unmarshal(data, &d.doc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if d.doc.Kind == 0 {
+		d.doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if len(d.doc.Content) == 0 || d.doc.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New("config file is not a mapping")
+	}
+	if err := yaml.Unmarshal(data, &d.base); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return d, nil
+}
+
+// bytes 序列化配置文档。只重新生成修改过的顶层配置或列表条目，其余内容按原文复制，
+// 无法按行替换时（如 flow 格式的列表）整个文档由 yaml.v3 重新生成，
+// 此时注释会保留，但空行、注释缩进和行尾注释前的空格等格式不保留
+func (d *configDocument) bytes() ([]byte, error) {
+	if d.base.Kind == yaml.DocumentNode && len(d.base.Content) == 1 {
+		data, err := patchDocument(d.data, d.base.Content[0], d.doc.Content[0])
+		if err == nil {
+			// 修改后的内容必须和文档一致，否则退回重新生成整个文档
+			var check yaml.Node
+			if yaml.Unmarshal(data, &check) == nil && len(check.Content) == 1 && sameNode(check.Content[0], d.doc.Content[0]) {
+				return data, nil
+			}
+		}
+	}
+	return encodeNode(&d.doc, "", "")
+}
+
+// encodeNode 序列化节点，第一行加上 first 前缀，其余非空行加上 rest 前缀
+func encodeNode(node *yaml.Node, first, rest string) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	if first == "" && rest == "" {
+		return buf.Bytes(), nil
+	}
+	var out bytes.Buffer
+	for i, line := range bytes.SplitAfter(buf.Bytes(), []byte("\n")) {
+		switch {
+		case i == 0:
+			out.WriteString(first)
+		case len(bytes.TrimSpace(line)) > 0:
+			out.WriteString(rest)
+		}
+		out.Write(line)
+	}
+	return out.Bytes(), nil
+}
+
+// lineEdit 把原文中 [start, end) 行替换为 text
+type lineEdit struct {
+	start, end int
+	text       []byte
+}
+
+// patchDocument 比较修改前后的顶层 mapping，只替换变化的部分：
+// 列表按条目替换、删除或插入，其他配置整体替换，新增的配置追加到文件末尾
+func patchDocument(data []byte, old, node *yaml.Node) ([]byte, error) {
+	if old.Kind != yaml.MappingNode || old.Style&yaml.FlowStyle != 0 {
+		return nil, errors.New("config is not a block mapping")
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	// 每个顶层配置占用的行，从 key 所在行到下一个 key 之前的最后一行内容
+	index := make(map[string]int)
+	starts := make([]int, 0, len(old.Content)/2+1)
+	for i := 0; i+1 < len(old.Content); i += 2 {
+		key := old.Content[i]
+		if key.Column != 1 {
+			return nil, errors.New("unexpected key position")
+		}
+		index[key.Value] = i
+		starts = append(starts, key.Line-1)
+	}
+	starts = append(starts, len(lines))
+
+	var edits []lineEdit
+	var tail []byte
+	seen := make(map[string]bool)
+	last := -1
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		j, ok := index[key.Value]
+		if !ok {
+			text, err := encodeNode(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{trimComments(key), trimFootComments(value)}}, "", "")
+			if err != nil {
+				return nil, err
+			}
+			tail = append(tail, text...)
+			continue
+		}
+		if j < last {
+			return nil, errors.New("keys reordered")
+		}
+		last = j
+		seen[key.Value] = true
+		if sameNode(old.Content[j+1], value) {
+			continue
+		}
+
+		start := starts[j/2]
+		end := contentEnd(lines, start, starts[j/2+1])
+		if seq, ok := patchSequence(lines, old.Content[j+1], value, end); ok {
+			edits = append(edits, seq...)
+			continue
+		}
+		text, err := encodeNode(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{trimComments(key), trimFootComments(value)}}, "", "")
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, lineEdit{start: start, end: end, text: text})
+	}
+	for i := 0; i+1 < len(old.Content); i += 2 {
+		if !seen[old.Content[i].Value] {
+			start := starts[i/2]
+			edits = append(edits, lineEdit{start: start, end: contentEnd(lines, start, starts[i/2+1])})
+		}
+	}
+	sort.Slice(edits, func(a, b int) bool { return edits[a].start < edits[b].start })
+
+	var out bytes.Buffer
+	pos := 0
+	for _, e := range edits {
+		for ; pos < e.start; pos++ {
+			out.Write(lines[pos])
+		}
+		out.Write(e.text)
+		pos = e.end
+	}
+	for ; pos < len(lines); pos++ {
+		out.Write(lines[pos])
+	}
+	if len(tail) > 0 {
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteByte('\n')
+		}
+		out.Write(tail)
+	}
+	return out.Bytes(), nil
+}
+
+// patchSequence 按条目修改 block 格式的列表：前后相同的条目保持原文，
+// 中间变化的条目重新生成，end 是列表最后一行内容之后的行号
+func patchSequence(lines [][]byte, old, node *yaml.Node, end int) ([]lineEdit, bool) {
+	if old.Kind != yaml.SequenceNode || node.Kind != yaml.SequenceNode || old.Style&yaml.FlowStyle != 0 || len(old.Content) == 0 {
+		return nil, false
+	}
+	// 条目需要和 "- " 在同一行，才能按行替换
+	column := old.Content[0].Column
+	if column < 3 {
+		return nil, false
+	}
+	dash := strings.Repeat(" ", column-3) + "- "
+	starts := make([]int, 0, len(old.Content)+1)
+	for _, item := range old.Content {
+		line := item.Line - 1
+		if item.Column != column || line >= len(lines) || !bytes.HasPrefix(lines[line], []byte(dash)) {
+			return nil, false
+		}
+		starts = append(starts, line)
+	}
+	starts = append(starts, end)
+
+	prefix := 0
+	for prefix < len(old.Content) && prefix < len(node.Content) && sameNode(old.Content[prefix], node.Content[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old.Content)-prefix && suffix < len(node.Content)-prefix &&
+		sameNode(old.Content[len(old.Content)-1-suffix], node.Content[len(node.Content)-1-suffix]) {
+		suffix++
+	}
+
+	var text []byte
+	for _, item := range node.Content[prefix : len(node.Content)-suffix] {
+		data, err := encodeNode(trimComments(item), dash, strings.Repeat(" ", column-1))
+		if err != nil {
+			return nil, false
+		}
+		text = append(text, data...)
+	}
+
+	// 只有插入时放在前一个条目的最后一行内容之后
+	var e lineEdit
+	switch n := len(old.Content) - suffix; {
+	case n > prefix:
+		e = lineEdit{start: starts[prefix], end: contentEnd(lines, starts[prefix], starts[n]), text: text}
+	case prefix > 0:
+		at := contentEnd(lines, starts[prefix-1], starts[prefix])
+		e = lineEdit{start: at, end: at, text: text}
+	default:
+		e = lineEdit{start: starts[0], end: starts[0], text: text}
+	}
+	return []lineEdit{e}, true
+}
+
+// contentEnd 返回 [start, end) 中最后一行内容之后的行号，末尾的空行和注释不计入
+func contentEnd(lines [][]byte, start, end int) int {
+	for end > start+1 {
+		line := bytes.TrimSpace(lines[end-1])
+		if len(line) > 0 && line[0] != '#' {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// trimComments 复制节点并去掉头部和末尾的注释，这些注释在替换的行之外，保留在原文中
+func trimComments(node *yaml.Node) *yaml.Node {
+	n := trimFootComments(node)
+	n.HeadComment = ""
+	if n.Kind == yaml.MappingNode && len(n.Content) > 0 {
+		first := *n.Content[0]
+		first.HeadComment = ""
+		n.Content[0] = &first
+	}
+	return n
+}
+
+// trimFootComments 复制节点并去掉节点及其最后一个子节点上的末尾注释，
+// mapping 最后一项的末尾注释可能在 key 上
+func trimFootComments(node *yaml.Node) *yaml.Node {
+	n := *node
+	n.FootComment = ""
+	if len(n.Content) > 0 {
+		n.Content = append([]*yaml.Node(nil), n.Content...)
+		last := len(n.Content) - 1
+		if n.Kind == yaml.MappingNode && last > 0 {
+			key := *n.Content[last-1]
+			key.FootComment = ""
+			n.Content[last-1] = &key
+		}
+		n.Content[last] = trimFootComments(n.Content[last])
+	}
+	return &n
+}
+
+// sameNode 比较两个节点的内容，不比较注释和引号等格式
+func sameNode(a, b *yaml.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Kind != b.Kind || a.Value != b.Value || a.ShortTag() != b.ShortTag() || len(a.Content) != len(b.Content) {
+		return false
+	}
+	if a.Kind == yaml.AliasNode {
+		return sameNode(a.Alias, b.Alias)
+	}
+	for i := range a.Content {
+		if !sameNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// section 返回顶层列表，如 users，create 为 true 时不存在则创建
+func (d *configDocument) section(name string, create bool) *yaml.Node {
+	root := d.doc.Content[0]
+	if node := mappingValue(root, name); node != nil {
+		if create {
+			if node.Kind != yaml.SequenceNode {
+				*node = yaml.Node{Kind: yaml.SequenceNode}
+			}
+			// users: [] 之类的空列表改为块格式再添加条目
+			node.Style &^= yaml.FlowStyle
+		}
+		return node
+	}
+	if !create {
+		return nil
+	}
+	node := &yaml.Node{Kind: yaml.SequenceNode}
+	root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, node)
+	return node
+}
+
+// find 在顶层列表中按名称查找条目，返回条目和下标
+func (d *configDocument) find(section, key, name string) (*yaml.Node, int) {
+	list := d.section(section, false)
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return nil, -1
+	}
+	for i, item := range list.Content {
+		if v := mappingValue(item, key); v != nil && v.Value == name {
+			return item, i
+		}
+	}
+	return nil, -1
+}
+
+// mappingValue 返回 mapping 节点中 key 对应的值，不存在时返回 nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue 设置 mapping 节点中 key 的值
+func setMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// deleteMappingKey 删除 mapping 节点中的 key
+func deleteMappingKey(node *yaml.Node, key string) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+// resourceVersion 根据条目内容计算资源版本，内容变化后版本随之变化
+func resourceVersion(node *yaml.Node) string {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// restoreRedacted 把新条目中的 ****** 替换为旧条目中相同位置的值，
+// 客户端可以把 GET 得到的资源修改后直接写回，不需要知道 Secret 的明文
+func restoreRedacted(node, old *yaml.Node, path string) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Value != redacted {
+			return nil
+		}
+		if old == nil || old.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s: redacted value without a stored value", path)
+		}
+		*node = *old
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if err := restoreRedacted(node.Content[i+1], mappingValue(old, key), path+"."+key); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			var prev *yaml.Node
+			if old != nil && old.Kind == yaml.SequenceNode && i < len(old.Content) {
+				prev = old.Content[i]
+			}
+			if err := restoreRedacted(item, prev, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keepLayout 按旧条目的顺序排列新条目中的 key，并保留相同 key 上的注释，
+// 整体替换条目后配置文件的变化只包含实际修改的内容
+func keepLayout(node, old *yaml.Node) {
+	if old == nil || node.Kind != old.Kind {
+		return
+	}
+	node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+	switch node.Kind {
+	case yaml.ScalarNode:
+		// 值没有变化时保留原来的引号
+		if node.Value == old.Value {
+			node.Style = old.Style
+		}
+	case yaml.MappingNode:
+		index := make(map[string]int)
+		for i := 0; i+1 < len(old.Content); i += 2 {
+			index[old.Content[i].Value] = i
+		}
+		type pair struct{ key, value *yaml.Node }
+		var known, added []pair
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			p := pair{node.Content[i], node.Content[i+1]}
+			j, ok := index[p.key.Value]
+			if !ok {
+				added = append(added, p)
+				continue
+			}
+			oldKey := old.Content[j]
+			p.key.HeadComment, p.key.LineComment, p.key.FootComment = oldKey.HeadComment, oldKey.LineComment, oldKey.FootComment
+			keepLayout(p.value, old.Content[j+1])
+			known = append(known, p)
+		}
+		sort.SliceStable(known, func(a, b int) bool {
+			return index[known[a].key.Value] < index[known[b].key.Value]
+		})
+		node.Content = node.Content[:0]
+		for _, p := range append(known, added...) {
+			node.Content = append(node.Content, p.key, p.value)
+		}
+	case yaml.SequenceNode:
+		for i := range node.Content {
+			if i < len(old.Content) {
+				keepLayout(node.Content[i], old.Content[i])
+			}
+		}
+	}
+}
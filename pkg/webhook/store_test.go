@@ -0,0 +1,195 @@
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TestFileStore 测试写入配置后保留文件权限，不留下临时文件
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "webhook.yaml")
+	if err := os.WriteFile(path, []byte("listen: \":8080\"\n"), 0640); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	store := &fileStore{path: path}
+	if err := store.Save([]byte("listen: \":9090\"\n")); err != nil {
+		t.Fatalf("Failed to save config: %v", err)
+	}
+	data, err := store.Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if string(data) != "listen: \":9090\"\n" {
+		t.Errorf("Expected saved content, got %q", data)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat config: %v", err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the config file, got %d entries", len(entries))
+	}
+}
+
+// TestConfigDocument 测试修改条目后保留注释，****** 还原为原来的值
+func TestConfigDocument(t *testing.T) {
+	doc, err := parseDocument([]byte(`# webhook 配置
+users:
+  # 运维账号
+  - username: alice
+    password: "file:/etc/sshhook/alice"
+    environment:
+      - name: TOKEN
+        value: "env:TOKEN"
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+
+	old, i := doc.find("users", "username", "alice")
+	if old == nil || i != 0 {
+		t.Fatalf("Expected to find alice at 0, got %v, %d", old, i)
+	}
+	if node, _ := doc.find("users", "username", "bob"); node != nil {
+		t.Error("Expected bob not to be found")
+	}
+
+	updated, err := parseDocument([]byte(`username: alice
+password: "******"
+environment:
+  - name: TOKEN
+    value: "******"
+groups: [ops]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse document: %v", err)
+	}
+	node := updated.doc.Content[0]
+	if err := restoreRedacted(node, old, "alice"); err != nil {
+		t.Fatalf("Failed to restore redacted values: %v", err)
+	}
+	node.HeadComment = old.HeadComment
+	doc.section("users", false).Content[i] = node
+
+	data, err := doc.bytes()
+	if err != nil {
+		t.Fatalf("Failed to encode document: %v", err)
+	}
+	for _, want := range []string{"# webhook 配置", "# 运维账号", "file:/etc/sshhook/alice", "env:TOKEN", "ops"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected document to contain %q, got:\n%s", want, data)
+		}
+	}
+	if resourceVersion(node) == resourceVersion(old) {
+		t.Error("Expected resourceVersion to change")
+	}
+
+	// 新条目中没有可还原的值
+	extra, _ := parseDocument([]byte("password: \"******\"\n"))
+	if err := restoreRedacted(extra.doc.Content[0], nil, "bob"); err == nil {
+		t.Error("Expected error for redacted value without a stored value")
+	}
+}
+
+// patchTestConfig 包含空行、对齐的行尾注释和不缩进的列表，检查写入后只有修改的行变化
+const patchTestConfig = `listen: ":8080"   # 监听地址
+
+users:
+# 运维账号
+- username: alice
+  password: "alice-pass"     # 明文密码
+
+# 开发账号
+- username: bob
+  metadata:
+    KUBERNETES_POD_NAME: "app"
+  # 可选：
+  # groups: [dev]
+
+- username: carol
+  password: "carol-pass"
+
+# 集群
+clusters: []
+`
+
+// TestConfigDocument_Patch 测试只替换修改的配置和条目，其余内容保持原文
+func TestConfigDocument_Patch(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(doc *configDocument)
+		want   string
+	}{
+		{
+			name:   "no change",
+			modify: func(doc *configDocument) {},
+			want:   patchTestConfig,
+		},
+		{
+			name: "replace item",
+			modify: func(doc *configDocument) {
+				node, _ := doc.find("users", "username", "bob")
+				setMappingValue(mappingValue(node, "metadata"), "KUBERNETES_POD_NAME", &yaml.Node{Kind: yaml.ScalarNode, Value: "web"})
+			},
+			want: strings.Replace(patchTestConfig, "- username: bob\n  metadata:\n    KUBERNETES_POD_NAME: \"app\"\n",
+				"- username: bob\n  metadata:\n    KUBERNETES_POD_NAME: web\n", 1),
+		},
+		{
+			name: "delete item",
+			modify: func(doc *configDocument) {
+				list := doc.section("users", false)
+				list.Content = append(list.Content[:2], list.Content[3:]...)
+			},
+			want: strings.Replace(patchTestConfig, "- username: carol\n  password: \"carol-pass\"\n", "", 1),
+		},
+		{
+			name: "append item",
+			modify: func(doc *configDocument) {
+				list := doc.section("users", true)
+				list.Content = append(list.Content, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Value: "username"}, {Kind: yaml.ScalarNode, Value: "dave"},
+				}})
+			},
+			want: strings.Replace(patchTestConfig, "  password: \"carol-pass\"\n", "  password: \"carol-pass\"\n- username: dave\n", 1),
+		},
+		{
+			name: "replace flow list and add key",
+			modify: func(doc *configDocument) {
+				list := doc.section("clusters", true)
+				list.Content = append(list.Content, &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Value: "name"}, {Kind: yaml.ScalarNode, Value: "c1"},
+				}})
+				setMappingValue(doc.doc.Content[0], "mfa", &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Value: "skew"}, {Kind: yaml.ScalarNode, Value: "1"},
+				}})
+			},
+			want: strings.Replace(patchTestConfig, "clusters: []\n", "clusters:\n  - name: c1\nmfa:\n  skew: 1\n", 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := parseDocument([]byte(patchTestConfig))
+			if err != nil {
+				t.Fatalf("Failed to parse document: %v", err)
+			}
+			tt.modify(doc)
+			data, err := doc.bytes()
+			if err != nil {
+				t.Fatalf("Failed to encode document: %v", err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.want, data)
+			}
+		})
+	}
+}
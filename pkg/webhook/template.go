@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// metadataKeyComment 公钥认证时把匹配到的公钥注释写入 metadata 的 key，config 请求中用于渲染模板
+const metadataKeyComment = "SSH_KEY_COMMENT"
+
+// TemplateData 渲染 metadata 和目标字段时可用的变量
+type TemplateData struct {
+	User              string   // 登录时输入的用户名
+	AuthenticatedUser string   // 认证后的用户名
+	RemoteAddress     string   // 客户端 IP
+	ClientVersion     string   // 客户端版本，如 SSH-2.0-OpenSSH_9.6
+	KeyComment        string   // 公钥认证时匹配到的公钥注释
+	Groups            []string // 用户所属的组
+	Group             string   // 第一个用户组
+}
+
+// templateVars ${var} 写法支持的变量及其对应的模板表达式
+var templateVars = map[string]string{
+	"user":              ".User",
+	"authenticatedUser": ".AuthenticatedUser",
+	"remoteAddress":     ".RemoteAddress",
+	"clientVersion":     ".ClientVersion",
+	"keyComment":        ".KeyComment",
+	"group":             ".Group",
+	"groups":            `join .Groups ","`,
+}
+
+// varPattern 匹配 ${var}
+var varPattern = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// templateFuncs 模板中可用的函数
+var templateFuncs = template.FuncMap{
+	"dns":   dnsLabel,
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+}
+
+// isTemplate 判断值中是否包含模板
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{") || strings.Contains(s, "${")
+}
+
+// parseTemplate 解析模板，${var} 会先转换为对应的模板表达式
+func parseTemplate(s string, data *TemplateData) (*template.Template, error) {
+	var unknown string
+	text := varPattern.ReplaceAllStringFunc(s, func(m string) string {
+		name := varPattern.FindStringSubmatch(m)[1]
+		expr, ok := templateVars[name]
+		if !ok {
+			unknown = name
+			return m
+		}
+		return "{{" + expr + "}}"
+	})
+	if unknown != "" {
+		return nil, fmt.Errorf("unknown variable ${%s}", unknown)
+	}
+
+	funcs := template.FuncMap{
+		// {{user}} 返回符合 DNS 规范的用户名，可直接用于 namespace 和资源名称
+		"user": func() string { return dnsLabel(data.AuthenticatedUser) },
+	}
+	return template.New("value").Funcs(templateFuncs).Funcs(funcs).Option("missingkey=error").Parse(text)
+}
+
+// renderTemplate 渲染配置值，不包含模板时直接返回原值
+func renderTemplate(s string, data *TemplateData) (string, error) {
+	if !isTemplate(s) {
+		return s, nil
+	}
+	tmpl, err := parseTemplate(s, data)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// renderMetadata 渲染 metadata 中的所有值
+func renderMetadata(values map[string]string, data *TemplateData) (map[string]string, error) {
+	if values == nil {
+		return nil, nil
+	}
+	rendered := make(map[string]string, len(values))
+	for key, value := range values {
+		v, err := renderTemplate(value, data)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s: %w", key, err)
+		}
+		rendered[key] = v
+	}
+	return rendered, nil
+}
+
+// userTemplateData 返回加载配置时可以确定的变量，连接相关的变量使用示例值，用于检查模板
+func userTemplateData(user *UserConfig) *TemplateData {
+	data := &TemplateData{
+		User:              user.Username,
+		AuthenticatedUser: user.Username,
+		RemoteAddress:     "192.0.2.1",
+		ClientVersion:     "SSH-2.0-OpenSSH",
+		KeyComment:        "user@host",
+		Groups:            user.Groups,
+	}
+	if len(user.Groups) > 0 {
+		data.Group = user.Groups[0]
+	}
+	return data
+}
+
+// sampleTemplateData 检查与具体用户无关的模板（如登录目标）时使用的示例值
+var sampleTemplateData = userTemplateData(&UserConfig{Username: "user", Groups: []string{"group"}})
+
+// newTemplateData 根据 ContainerSSH 请求中的连接信息生成模板变量
+func newTemplateData(user *UserConfig, md metadata.ConnectionAuthPendingMetadata, authenticatedUser string) *TemplateData {
+	data := &TemplateData{
+		User:              md.Username,
+		AuthenticatedUser: authenticatedUser,
+		ClientVersion:     md.ClientVersion,
+		KeyComment:        md.Metadata[metadataKeyComment].Value,
+		Groups:            user.Groups,
+	}
+	// 登录名 <用户名>+<目标> 中的目标不属于用户名，避免出现在 pod 名称等模板结果中
+	if strings.HasPrefix(md.Username, authenticatedUser+targetSeparator) {
+		data.User = authenticatedUser
+	}
+	if md.RemoteAddress.IP != nil {
+		data.RemoteAddress = md.RemoteAddress.IP.String()
+	}
+	if len(user.Groups) > 0 {
+		data.Group = user.Groups[0]
+	}
+	return data
+}
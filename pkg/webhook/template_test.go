@@ -0,0 +1,80 @@
+package webhook
+
+import (
+	"net"
+	"testing"
+
+	"go.containerssh.io/containerssh/metadata"
+)
+
+// TestRenderTemplate 测试模板渲染
+func TestRenderTemplate(t *testing.T) {
+	data := &TemplateData{
+		User:              "Alice",
+		AuthenticatedUser: "Alice.Smith",
+		RemoteAddress:     "10.0.0.1",
+		KeyComment:        "alice@laptop",
+		Groups:            []string{"dev", "ops"},
+		Group:             "dev",
+	}
+
+	tests := map[string]string{
+		"default":                      "default",
+		"team-{{.Group}}":              "team-dev",
+		"team-${group}":                "team-dev",
+		"${user}-${keyComment}":        "Alice-alice@laptop",
+		"ws-{{user}}":                  "ws-alice-smith",
+		"{{dns .User}}":                "alice",
+		`{{join .Groups ","}}`:         "dev,ops",
+		"{{lower .AuthenticatedUser}}": "alice.smith",
+		"${groups}":                    "dev,ops",
+		"{{.RemoteAddress}}":           "10.0.0.1",
+	}
+	for pattern, expected := range tests {
+		got, err := renderTemplate(pattern, data)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", pattern, err)
+			continue
+		}
+		if got != expected {
+			t.Errorf("Expected '%s' for %s, got '%s'", expected, pattern, got)
+		}
+	}
+}
+
+// TestRenderTemplate_Invalid 测试无效的模板
+func TestRenderTemplate_Invalid(t *testing.T) {
+	for _, pattern := range []string{"team-{{.Group", "team-${team}", "{{.Team}}", "{{missing}}"} {
+		if _, err := renderTemplate(pattern, sampleTemplateData); err == nil {
+			t.Errorf("Expected error for %s, got nil", pattern)
+		}
+	}
+}
+
+// TestNewTemplateData 测试从连接信息生成模板变量
+func TestNewTemplateData(t *testing.T) {
+	user := &UserConfig{Username: "alice", Groups: []string{"dev", "ops"}}
+
+	var md metadata.ConnectionAuthPendingMetadata
+	md.Username = "alice"
+	md.ClientVersion = "SSH-2.0-OpenSSH_9.6"
+	md.RemoteAddress.IP = net.ParseIP("10.0.0.1")
+	md.Metadata = map[string]metadata.Value{metadataKeyComment: {Value: "alice@laptop"}}
+
+	data := newTemplateData(user, md, "alice")
+	if data.RemoteAddress != "10.0.0.1" || data.ClientVersion != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("Unexpected connection data: %+v", data)
+	}
+	if data.KeyComment != "alice@laptop" {
+		t.Errorf("Expected key comment from metadata, got '%s'", data.KeyComment)
+	}
+	if data.Group != "dev" || len(data.Groups) != 2 {
+		t.Errorf("Unexpected groups: %+v", data)
+	}
+
+	// 没有远程地址时为空
+	data = newTemplateData(&UserConfig{Username: "bob"}, metadata.ConnectionAuthPendingMetadata{}, "bob")
+	if data.RemoteAddress != "" || data.Group != "" {
+		t.Errorf("Expected empty remote address and group, got %+v", data)
+	}
+}
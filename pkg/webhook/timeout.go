@@ -0,0 +1,118 @@
+package webhook
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// idleTimeoutEnv bash、zsh、ksh 和 busybox ash 在提示符下空闲超过该变量指定的秒数后退出。
+// 用户可以在会话中修改或 unset 该变量，只能防止忘记关闭的 shell
+const idleTimeoutEnv = "TMOUT"
+
+// watchdogScript 后台启动监视进程后 exec 到 shell。$0 是最长时间的秒数（0 表示不限制），
+// $1 是连接标记（见 sessionMarker，不需要时为 -），其余参数是 shell 命令；
+// exec 后 shell 沿用当前进程的 PID，所以子进程中的 $$ 就是 shell。
+// 监视进程每秒检查一次 shell，shell 退出后随之退出；到达最长时间后先发送 SIGHUP，5 秒后仍未退出时发送 SIGKILL。
+// 发送信号前比较 /proc 中的进程启动时间，避免 PID 被复用时结束无关的进程（没有 /proc 时只检查进程是否存在）。
+// 监视进程的命令行保留了连接标记，webhook 据此判断连接是否仍然存在
+const watchdogScript = `st() { read -r s </proc/$$/stat 2>/dev/null || return 0; s=${s##*) }; set -- $s; echo "${20}"; }; ` +
+	`alive() { kill -0 $$ 2>/dev/null && [ "$(st)" = "$t" ]; }; shift; ` +
+	`(t=$(st); i=0; while [ "$0" -eq 0 ] || [ "$i" -lt "$0" ]; do sleep 1; i=$((i+1)); alive || exit 0; done; ` +
+	`kill -HUP $$; sleep 5; alive && kill -KILL $$) </dev/null >/dev/null 2>&1 & exec "$@"`
+
+// defaultProductionTimeouts 生产目标的内置默认超时，可以被 timeouts.production 和各层配置覆盖
+var defaultProductionTimeouts = TimeoutsConfig{
+	Idle:       15 * time.Minute,
+	MaxSession: 8 * time.Hour,
+}
+
+// TimeoutsConfig 会话超时配置，可以设置在集群、目标、组和用户上，优先级依次升高。
+// ContainerSSH 不支持会话超时，由 webhook 通过 TMOUT 和包装 shell 命令在容器中实现，
+// 只对交互式 shell 生效，exec 和 SFTP 会话不受限制
+type TimeoutsConfig struct {
+	Idle       time.Duration `yaml:"idle,omitempty"`       // 空闲超时，通过 TMOUT 环境变量实现
+	MaxSession time.Duration `yaml:"maxSession,omitempty"` // 会话最长时间，到时结束 shell
+}
+
+// DefaultTimeoutsConfig 未在集群、目标、组和用户上配置时使用的超时
+type DefaultTimeoutsConfig struct {
+	Default    TimeoutsConfig `yaml:"default,omitempty"`    // 所有目标的默认值，默认不限制
+	Production TimeoutsConfig `yaml:"production,omitempty"` // 生产目标的默认值，默认空闲 15 分钟、最长 8 小时
+}
+
+// merge 用 other 中设置了的项覆盖当前配置
+func (t *TimeoutsConfig) merge(other *TimeoutsConfig) {
+	if other == nil {
+		return
+	}
+	if other.Idle != 0 {
+		t.Idle = other.Idle
+	}
+	if other.MaxSession != 0 {
+		t.MaxSession = other.MaxSession
+	}
+}
+
+// validate 检查超时的取值，TMOUT 和 sleep 的精度是秒
+func (t *TimeoutsConfig) validate() error {
+	if t.Idle < 0 || (t.Idle > 0 && t.Idle < time.Second) {
+		return fmt.Errorf("idle timeout must be at least 1s, got %s", t.Idle)
+	}
+	if t.MaxSession < 0 || (t.MaxSession > 0 && t.MaxSession < time.Second) {
+		return fmt.Errorf("max session must be at least 1s, got %s", t.MaxSession)
+	}
+	return nil
+}
+
+// validateLayer 检查集群、目标、组或用户上可选的超时配置
+func (t *TimeoutsConfig) validateLayer() error {
+	if t == nil {
+		return nil
+	}
+	return t.validate()
+}
+
+// resolveTimeouts 按默认值、集群、目标、组、用户的顺序合并超时配置
+func (c *Config) resolveTimeouts(cluster *ClusterConfig, target *TargetConfig, layers []*SessionConfig) TimeoutsConfig {
+	t := c.Timeouts.Default
+	if production(cluster, target) {
+		t.merge(&defaultProductionTimeouts)
+		t.merge(&c.Timeouts.Production)
+	}
+	t.merge(cluster.Timeouts)
+	for _, layer := range layers {
+		t.merge(layer.Timeouts)
+	}
+	return t
+}
+
+// seconds 把时间转换为秒数，不足一秒的部分向上取整
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// apply 把超时应用到 shell：空闲超时写入环境变量，最长时间通过 /bin/sh 包装 shell 命令实现。
+// marker 不为空时同样包装 shell 命令，由监视进程保留连接标记；wrap 返回 false 时（容器中没有 /bin/sh）不包装
+func (t *TimeoutsConfig) apply(shell *ShellConfig, command []string, marker string, wrap func(feature string) bool) []string {
+	if t.Idle > 0 {
+		if shell.Env == nil {
+			shell.Env = make(map[string]string)
+		}
+		shell.Env[idleTimeoutEnv] = seconds(t.Idle)
+	}
+	if t.MaxSession == 0 && marker == "" {
+		return command
+	}
+	feature := "maxSession"
+	if t.MaxSession == 0 {
+		feature = "session tracking"
+	}
+	if !wrap(feature) {
+		return command
+	}
+	if marker == "" {
+		marker = "-"
+	}
+	return append([]string{"/bin/sh", "-c", watchdogScript, seconds(t.MaxSession), marker}, command...)
+}
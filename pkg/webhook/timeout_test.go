@@ -0,0 +1,140 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestResolveTimeouts 测试超时按默认值、集群、目标、组、用户合并
+func TestResolveTimeouts(t *testing.T) {
+	cfg := &Config{Timeouts: DefaultTimeoutsConfig{Default: TimeoutsConfig{Idle: time.Hour}}}
+	cluster := &ClusterConfig{Name: "c1", Timeouts: &TimeoutsConfig{MaxSession: 12 * time.Hour}}
+	target := &TargetConfig{Name: "t1"}
+	layers := []*SessionConfig{
+		&target.SessionConfig,
+		{Timeouts: &TimeoutsConfig{Idle: 30 * time.Minute}},
+		{},
+	}
+
+	got := cfg.resolveTimeouts(cluster, target, layers)
+	want := TimeoutsConfig{Idle: 30 * time.Minute, MaxSession: 12 * time.Hour}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	// 生产目标使用更严格的默认值
+	got = cfg.resolveTimeouts(&ClusterConfig{Name: "c1"}, &TargetConfig{Name: "t1", Production: true}, nil)
+	if got != defaultProductionTimeouts {
+		t.Errorf("Expected production defaults %+v, got %+v", defaultProductionTimeouts, got)
+	}
+
+	cfg.Timeouts.Production = TimeoutsConfig{Idle: 5 * time.Minute}
+	got = cfg.resolveTimeouts(&ClusterConfig{Name: "c1", Production: true}, nil, nil)
+	want = TimeoutsConfig{Idle: 5 * time.Minute, MaxSession: defaultProductionTimeouts.MaxSession}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+// TestTimeoutsApply 测试超时转换为环境变量和 shell 命令
+func TestTimeoutsApply(t *testing.T) {
+	wrap := func(string) bool { return true }
+	shell := ShellConfig{}
+	timeouts := TimeoutsConfig{}
+	if cmd := timeouts.apply(&shell, []string{"/bin/bash"}, "", wrap); !reflect.DeepEqual(cmd, []string{"/bin/bash"}) {
+		t.Errorf("Expected shell unchanged, got %v", cmd)
+	}
+	if shell.Env != nil {
+		t.Errorf("Expected no environment, got %v", shell.Env)
+	}
+
+	timeouts = TimeoutsConfig{Idle: 90*time.Second + time.Millisecond, MaxSession: time.Hour}
+	cmd := timeouts.apply(&shell, []string{"/bin/bash"}, "", wrap)
+	if shell.Env[idleTimeoutEnv] != "91" {
+		t.Errorf("Expected TMOUT=91, got '%s'", shell.Env[idleTimeoutEnv])
+	}
+	want := []string{"/bin/sh", "-c", watchdogScript, "3600", "-", "/bin/bash"}
+	if !reflect.DeepEqual(cmd, want) {
+		t.Errorf("Expected %v, got %v", want, cmd)
+	}
+
+	// 容器中没有 /bin/sh 时不限制最长时间
+	noShell := func(string) bool { return false }
+	if cmd := timeouts.apply(&shell, []string{"/bin/bash"}, "", noShell); !reflect.DeepEqual(cmd, []string{"/bin/bash"}) {
+		t.Errorf("Expected shell unchanged without /bin/sh, got %v", cmd)
+	}
+}
+
+// TestWatchdogScript 在本机 shell 中运行监视脚本
+func TestWatchdogScript(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh not available")
+	}
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("/proc not available")
+	}
+
+	// 到达最长时间后结束 shell
+	start := time.Now()
+	err := exec.Command("/bin/sh", "-c", watchdogScript, "1", "-", "sleep", "30").Run()
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Errorf("Expected command to be killed after 1s, got %v after %s", err, time.Since(start))
+	}
+
+	// 监视进程的命令行中保留连接标记，shell 先退出时监视进程随之退出，不会留到最长时间
+	marker := sessionMarker(fmt.Sprintf("test-%d", time.Now().UnixNano()))
+	cmd := exec.Command("/bin/sh", "-c", watchdogScript, "0", marker, "sleep", "1")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !processWithArg(marker) {
+		t.Error("Expected watchdog to keep the session marker")
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Failed to run command: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for processWithArg(marker) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected watchdog to exit after the shell")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// processWithArg 检查是否有参数中包含 arg 的进程，已退出未回收的进程没有参数
+func processWithArg(arg string) bool {
+	paths, _ := filepath.Glob("/proc/[0-9]*/cmdline")
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if err == nil && strings.Contains(string(data), arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestTimeoutsValidate 测试超时取值检查
+func TestTimeoutsValidate(t *testing.T) {
+	tests := []struct {
+		timeouts TimeoutsConfig
+		wantErr  bool
+	}{
+		{TimeoutsConfig{}, false},
+		{TimeoutsConfig{Idle: time.Minute, MaxSession: time.Hour}, false},
+		{TimeoutsConfig{Idle: -time.Minute}, true},
+		{TimeoutsConfig{MaxSession: time.Millisecond}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.timeouts.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.timeouts, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UserStore sshhook user 子命令使用的用户存储，直接编辑配置文件或调用管理 API
+type UserStore interface {
+	// ListUsers 列出所有用户
+	ListUsers() ([]UserSummary, error)
+	// AddUser 添加用户，用户已存在时返回错误
+	AddUser(user NewUser) error
+	// RemoveUser 删除用户
+	RemoveUser(username string) error
+	// SetPasswordHash 设置 passwordHash 并删除明文 password
+	SetPasswordHash(username, hash string) error
+	// SetTOTPSecret 设置加密的 TOTP 密钥，为空时删除，用户不再需要验证码
+	SetTOTPSecret(username, secret string) error
+	// AddKey 添加 authorized_keys 格式的公钥，返回指纹
+	AddKey(username, key string) (string, error)
+	// RemoveKey 按 SHA256 指纹删除公钥
+	RemoveKey(username, fingerprint string) error
+}
+
+// NewUser 添加用户时的参数
+type NewUser struct {
+	Username     string   `yaml:"username" json:"username"`
+	PasswordHash string   `yaml:"passwordHash,omitempty" json:"passwordHash,omitempty"`
+	Target       string   `yaml:"target,omitempty" json:"target,omitempty"`
+	Groups       []string `yaml:"groups,omitempty" json:"groups,omitempty"`
+	PublicKeys   []string `yaml:"publicKeys,omitempty" json:"publicKeys,omitempty"`
+}
+
+// UserSummary 列出用户时输出的信息
+type UserSummary struct {
+	Username  string
+	Target    string
+	Groups    []string
+	Password  string   // hash、plain，未配置密码时为空
+	Keys      []string // 公钥的 SHA256 指纹
+	Locked    bool
+	MFA       bool // 配置了 totpSecret
+	ExpiresAt time.Time
+}
+
+// newUserSummary 根据用户配置生成 UserSummary
+func newUserSummary(u *UserConfig) UserSummary {
+	summary := UserSummary{
+		Username:  u.Username,
+		Target:    u.Target,
+		Groups:    u.Groups,
+		Locked:    u.Locked,
+		MFA:       u.mfaRequired(),
+		ExpiresAt: u.ExpiresAt,
+	}
+	switch {
+	case u.PasswordHash != "":
+		summary.Password = "hash"
+	case u.Password != "":
+		summary.Password = "plain"
+	}
+	for _, k := range userKeys(u) {
+		summary.Keys = append(summary.Keys, k.Fingerprint)
+	}
+	return summary
+}
+
+// fileUserStore 直接编辑配置文件，通过 yaml.Node 修改，只重新生成修改的用户条目，
+// 其他内容保持原文（见 configDocument.bytes）。修改后需要向 sshhook 发送 SIGHUP 才会生效
+type fileUserStore struct {
+	store configStore
+}
+
+// NewFileUserStore 创建编辑配置文件的 UserStore
+func NewFileUserStore(path string) UserStore {
+	return &fileUserStore{store: &fileStore{path: path}}
+}
+
+// update 读取配置文档并执行修改，新的配置通过检查后写回文件，不解析 Secret 引用
+func (s *fileUserStore) update(fn func(doc *configDocument) error) error {
+	data, err := s.store.Load()
+	if err != nil {
+		return err
+	}
+	doc, err := parseDocument(data)
+	if err != nil {
+		return err
+	}
+	if err := fn(doc); err != nil {
+		return err
+	}
+	if data, err = doc.bytes(); err != nil {
+		return err
+	}
+	if _, err := parseConfig(data); err != nil {
+		return err
+	}
+	return s.store.Save(data)
+}
+
+// updateUser 修改用户节点
+func (s *fileUserStore) updateUser(username string, fn func(node *yaml.Node) error) error {
+	return s.update(func(doc *configDocument) error {
+		node, _ := doc.find("users", "username", username)
+		if node == nil {
+			return fmt.Errorf("user not found: %s", username)
+		}
+		return fn(node)
+	})
+}
+
+// ListUsers 实现 UserStore
+func (s *fileUserStore) ListUsers() ([]UserSummary, error) {
+	config, err := LoadConfigWithoutSecrets(s.store.Path())
+	if err != nil {
+		return nil, err
+	}
+	var users []UserSummary
+	for i := range config.Users {
+		users = append(users, newUserSummary(&config.Users[i]))
+	}
+	return users, nil
+}
+
+// AddUser 实现 UserStore
+func (s *fileUserStore) AddUser(user NewUser) error {
+	return s.update(func(doc *configDocument) error {
+		if node, _ := doc.find("users", "username", user.Username); node != nil {
+			return fmt.Errorf("user already exists: %s", user.Username)
+		}
+		node := &yaml.Node{}
+		if err := node.Encode(user); err != nil {
+			return err
+		}
+		list := doc.section("users", true)
+		list.Content = append(list.Content, node)
+		return nil
+	})
+}
+
+// RemoveUser 实现 UserStore
+func (s *fileUserStore) RemoveUser(username string) error {
+	return s.update(func(doc *configDocument) error {
+		node, i := doc.find("users", "username", username)
+		if node == nil {
+			return fmt.Errorf("user not found: %s", username)
+		}
+		list := doc.section("users", false)
+		list.Content = append(list.Content[:i], list.Content[i+1:]...)
+		return nil
+	})
+}
+
+// SetPasswordHash 实现 UserStore
+func (s *fileUserStore) SetPasswordHash(username, hash string) error {
+	return s.updateUser(username, func(node *yaml.Node) error {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Value: hash}
+		// passwordHash 写在原来 password 的位置
+		if mappingValue(node, "password") != nil {
+			deleteMappingKey(node, "passwordHash")
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "password" {
+				node.Content[i].Value = "passwordHash"
+				node.Content[i+1] = value
+				return nil
+			}
+		}
+		setMappingValue(node, "passwordHash", value)
+		return nil
+	})
+}
+
+// SetTOTPSecret 实现 UserStore
+func (s *fileUserStore) SetTOTPSecret(username, secret string) error {
+	return s.updateUser(username, func(node *yaml.Node) error {
+		if secret == "" {
+			deleteMappingKey(node, "totpSecret")
+			return nil
+		}
+		setMappingValue(node, "totpSecret", &yaml.Node{Kind: yaml.ScalarNode, Value: secret})
+		return nil
+	})
+}
+
+// AddKey 实现 UserStore
+func (s *fileUserStore) AddKey(username, key string) (string, error) {
+	var fingerprint string
+	err := s.updateUser(username, func(node *yaml.Node) error {
+		var err error
+		fingerprint, err = addUserKey(node, key)
+		return err
+	})
+	return fingerprint, err
+}
+
+// RemoveKey 实现 UserStore
+func (s *fileUserStore) RemoveKey(username, fingerprint string) error {
+	return s.updateUser(username, func(node *yaml.Node) error {
+		return deleteUserKey(node, fingerprint)
+	})
+}
+
+// adminUserStore 通过管理 API 修改，修改立即生效
+type adminUserStore struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewAdminUserStore 创建调用管理 API 的 UserStore，baseURL 如 http://127.0.0.1:8081
+func NewAdminUserStore(baseURL, token string) UserStore {
+	return &adminUserStore{
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/api/v1",
+		token:   token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do 调用管理 API，version 不为空时设置 If-Match，返回响应中的 ETag
+func (s *adminUserStore) do(method, path, version string, body, out interface{}) (string, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return "", err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.baseURL+path, reader)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if version != "" {
+		req.Header.Set("If-Match", `"`+version+`"`)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return "", fmt.Errorf("%s %s: failed to decode response: %w", method, path, err)
+		}
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`), nil
+}
+
+// userPath 返回用户的 URL 路径
+func userPath(username string) string {
+	return "/users/" + url.PathEscape(username)
+}
+
+// ListUsers 实现 UserStore
+func (s *adminUserStore) ListUsers() ([]UserSummary, error) {
+	var list struct {
+		Items []AdminItem `json:"items"`
+	}
+	if _, err := s.do(http.MethodGet, "/users", "", nil, &list); err != nil {
+		return nil, err
+	}
+	var users []UserSummary
+	for _, item := range list.Items {
+		data, err := json.Marshal(item.Spec)
+		if err != nil {
+			return nil, err
+		}
+		// JSON 是 YAML 的子集，按配置文件的字段名解析
+		var user UserConfig
+		if err := yaml.Unmarshal(data, &user); err != nil {
+			return nil, err
+		}
+		users = append(users, newUserSummary(&user))
+	}
+	return users, nil
+}
+
+// AddUser 实现 UserStore
+func (s *adminUserStore) AddUser(user NewUser) error {
+	_, err := s.do(http.MethodPost, "/users", "", user, nil)
+	return err
+}
+
+// RemoveUser 实现 UserStore
+func (s *adminUserStore) RemoveUser(username string) error {
+	version, err := s.do(http.MethodGet, userPath(username), "", nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(http.MethodDelete, userPath(username), version, nil, nil)
+	return err
+}
+
+// SetPasswordHash 实现 UserStore，其他字段中的 ****** 由服务端还原
+func (s *adminUserStore) SetPasswordHash(username, hash string) error {
+	var item AdminItem
+	version, err := s.do(http.MethodGet, userPath(username), "", nil, &item)
+	if err != nil {
+		return err
+	}
+	spec, ok := item.Spec.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected user spec: %v", item.Spec)
+	}
+	delete(spec, "password")
+	spec["passwordHash"] = hash
+	_, err = s.do(http.MethodPut, userPath(username), version, spec, nil)
+	return err
+}
+
+// SetTOTPSecret 实现 UserStore
+func (s *adminUserStore) SetTOTPSecret(username, secret string) error {
+	var item AdminItem
+	version, err := s.do(http.MethodGet, userPath(username), "", nil, &item)
+	if err != nil {
+		return err
+	}
+	spec, ok := item.Spec.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected user spec: %v", item.Spec)
+	}
+	if secret == "" {
+		delete(spec, "totpSecret")
+	} else {
+		spec["totpSecret"] = secret
+	}
+	_, err = s.do(http.MethodPut, userPath(username), version, spec, nil)
+	return err
+}
+
+// AddKey 实现 UserStore
+func (s *adminUserStore) AddKey(username, key string) (string, error) {
+	version, err := s.do(http.MethodGet, userPath(username)+"/keys", "", nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var added AdminKey
+	if _, err := s.do(http.MethodPost, userPath(username)+"/keys", version, AdminKey{Key: key}, &added); err != nil {
+		return "", err
+	}
+	return added.Fingerprint, nil
+}
+
+// RemoveKey 实现 UserStore
+func (s *adminUserStore) RemoveKey(username, fingerprint string) error {
+	version, err := s.do(http.MethodGet, userPath(username)+"/keys", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	_, err = s.do(http.MethodDelete, userPath(username)+"/keys/"+url.PathEscape(fingerprint), version, nil, nil)
+	return err
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testAuthorizedKey 生成 authorized_keys 格式的公钥和指纹
+func testAuthorizedKey(t *testing.T, comment string) (string, string) {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert key: %v", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment, ssh.FingerprintSHA256(sshPub)
+}
+
+// testUserStore 对 UserStore 执行添加用户、设置密码、添加和删除公钥、删除用户
+func testUserStore(t *testing.T, store UserStore, auth func(username, password string) bool) {
+	t.Helper()
+	key, fingerprint := testAuthorizedKey(t, "bob@laptop")
+
+	if err := store.AddUser(NewUser{Username: "bob", Groups: nil}); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	if err := store.AddUser(NewUser{Username: "bob"}); err == nil {
+		t.Error("Expected error for existing user")
+	}
+	if auth("bob", "") {
+		t.Error("Expected user without password to fail password authentication")
+	}
+
+	hash, err := HashPassword("bob-pass")
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if err := store.SetPasswordHash("bob", hash); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if err := store.SetPasswordHash("alice", hash); err != nil {
+		t.Fatalf("Failed to replace plaintext password: %v", err)
+	}
+	if !auth("alice", "bob-pass") {
+		t.Error("Expected new password for alice")
+	}
+
+	got, err := store.AddKey("bob", key)
+	if err != nil {
+		t.Fatalf("Failed to add key: %v", err)
+	}
+	if got != fingerprint {
+		t.Errorf("Expected fingerprint %s, got %s", fingerprint, got)
+	}
+
+	users, err := store.ListUsers()
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	if len(users) != 2 || users[0].Username != "alice" || users[1].Username != "bob" {
+		t.Fatalf("Expected users alice and bob, got %+v", users)
+	}
+	if users[0].Password != "hash" || users[1].Password != "hash" || len(users[1].Keys) != 1 {
+		t.Errorf("Unexpected user summary: %+v", users)
+	}
+
+	if err := store.RemoveKey("bob", fingerprint); err != nil {
+		t.Fatalf("Failed to remove key: %v", err)
+	}
+	if err := store.RemoveKey("bob", fingerprint); err == nil {
+		t.Error("Expected error for missing key")
+	}
+	if err := store.RemoveUser("bob"); err != nil {
+		t.Fatalf("Failed to remove user: %v", err)
+	}
+	if err := store.RemoveUser("bob"); err == nil {
+		t.Error("Expected error for missing user")
+	}
+}
+
+// TestFileUserStore 测试直接编辑配置文件，保留注释且不写入明文密码
+func TestFileUserStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	if err := os.WriteFile(path, []byte(adminTestConfig), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	testUserStore(t, NewFileUserStore(path), func(username, password string) bool {
+		cfg, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("Failed to load config: %v", err)
+		}
+		user := cfg.GetUser(username)
+		return user != nil && user.checkPassword(password)
+	})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	if !strings.Contains(string(data), "# 运维账号") {
+		t.Errorf("Expected comments to be kept, got:\n%s", data)
+	}
+	if strings.Contains(string(data), "alice-pass") || strings.Contains(string(data), "bob-pass") {
+		t.Errorf("Expected no plaintext password, got:\n%s", data)
+	}
+	// 其他配置保持原来的顺序
+	if strings.Index(string(data), "admin:") > strings.Index(string(data), "clusters:") {
+		t.Errorf("Expected ordering to be kept, got:\n%s", data)
+	}
+}
+
+// TestFileUserStore_RoundTrip 测试示例配置文件写回时只有修改的条目变化
+func TestFileUserStore_RoundTrip(t *testing.T) {
+	orig, err := os.ReadFile("../../webhook.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read example config: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	if err := os.WriteFile(path, orig, 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	store := NewFileUserStore(path).(*fileUserStore)
+	read := func() string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read config: %v", err)
+		}
+		return string(data)
+	}
+
+	// 没有修改时内容不变
+	if err := store.update(func(doc *configDocument) error { return nil }); err != nil {
+		t.Fatalf("Failed to update config: %v", err)
+	}
+	if got := read(); got != string(orig) {
+		t.Errorf("Expected unchanged config, got:\n%s", got)
+	}
+
+	// 添加的用户插入到最后一个用户之后，其余内容不变
+	if err := store.AddUser(NewUser{Username: "golden"}); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	got := read()
+	if strings.Count(got, "  - username: golden\n") != 1 || strings.Replace(got, "  - username: golden\n", "", 1) != string(orig) {
+		t.Errorf("Expected only the new user to be added, got:\n%s", got)
+	}
+	if err := store.RemoveUser("golden"); err != nil {
+		t.Fatalf("Failed to remove user: %v", err)
+	}
+	if got := read(); got != string(orig) {
+		t.Errorf("Expected original config after removing the user, got:\n%s", got)
+	}
+}
+
+// TestAdminUserStore 测试通过管理 API 修改用户
+func TestAdminUserStore(t *testing.T) {
+	server, handler, _ := newAdminTestServer(t)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	testUserStore(t, NewAdminUserStore(ts.URL, "admin-token"), func(username, password string) bool {
+		return passwordAuth(t, server, username, password)
+	})
+
+	if _, err := NewAdminUserStore(ts.URL, "wrong").ListUsers(); err == nil {
+		t.Error("Expected error for invalid token")
+	}
+}
+
+// TestFileUserStore_TOTP 测试写入和删除加密的 TOTP 密钥
+func TestFileUserStore_TOTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook.yaml")
+	if err := os.WriteFile(path, []byte(adminTestConfig), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	store := NewFileUserStore(path)
+	// 没有 mfa.encryptionKey 时不能写入
+	if err := store.SetTOTPSecret("alice", "v1:AAAA"); err == nil {
+		t.Error("Expected error without mfa encryptionKey")
+	}
+
+	if err := os.WriteFile(path, []byte(adminTestConfig+"mfa:\n  encryptionKey: \"mfa-key\"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	enrollment, err := EnrollTOTP(cfg, "alice")
+	if err != nil {
+		t.Fatalf("Failed to enroll: %v", err)
+	}
+	if err := store.SetTOTPSecret("alice", enrollment.EncryptedSecret); err != nil {
+		t.Fatalf("Failed to set totpSecret: %v", err)
+	}
+	if cfg, err = LoadConfig(path); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if !cfg.GetUser("alice").mfaRequired() {
+		t.Error("Expected alice to require MFA")
+	}
+	users, err := store.ListUsers()
+	if err != nil || len(users) != 1 || !users[0].MFA {
+		t.Errorf("Expected MFA in user summary, got %+v, %v", users, err)
+	}
+
+	if err := store.SetTOTPSecret("alice", ""); err != nil {
+		t.Fatalf("Failed to remove totpSecret: %v", err)
+	}
+	if cfg, err = LoadConfig(path); err != nil || cfg.GetUser("alice").mfaRequired() {
+		t.Errorf("Expected alice not to require MFA, got %v", err)
+	}
+}
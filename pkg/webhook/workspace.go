@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+
+	"go.containerssh.io/containerssh/config"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// workspaceContainer 模板 pod 中容器的名称
+const workspaceContainer = "workspace"
+
+// workspaceIdleCommand 不使用 agent 时 pod 的常驻进程，收到退出信号后结束
+var workspaceIdleCommand = []string{"/bin/sh", "-c", "trap 'exit 0' INT TERM; while true; do sleep 1; done"}
+
+// 模板 pod 上的标签
+const (
+	labelUser   = "sshproxy/user"
+	labelTarget = "sshproxy/target"
+)
+
+// validate 检查模板配置，并在加载时解析模板
+func (t *PodTemplateConfig) validate() error {
+	switch config.KubernetesExecutionMode(t.Mode) {
+	case "", config.KubernetesExecutionModeConnection, config.KubernetesExecutionModeSession:
+	default:
+		return fmt.Errorf("template mode must be connection or session, got %s", t.Mode)
+	}
+	if t.Image == "" {
+		return errors.New("template image is required")
+	}
+	if t.Namespace == "" {
+		return errors.New("template namespace is required")
+	}
+	if _, err := renderTemplate(t.Namespace, sampleTemplateData); err != nil {
+		return fmt.Errorf("invalid template namespace: %w", err)
+	}
+	if _, err := t.resources(); err != nil {
+		return err
+	}
+	for _, v := range t.Volumes {
+		if v.Name == "" || v.MountPath == "" {
+			return errors.New("template volume requires name and mountPath")
+		}
+		if (v.ClaimName != "") == v.EmptyDir {
+			return fmt.Errorf("template volume %s requires exactly one of claimName and emptyDir", v.Name)
+		}
+		if _, err := renderTemplate(v.ClaimName, sampleTemplateData); err != nil {
+			return fmt.Errorf("invalid claimName for volume %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// resources 解析资源配置
+func (t *PodTemplateConfig) resources() (v1.ResourceRequirements, error) {
+	var req v1.ResourceRequirements
+	parse := func(values map[string]string) (v1.ResourceList, error) {
+		if len(values) == 0 {
+			return nil, nil
+		}
+		list := make(v1.ResourceList)
+		for name, value := range values {
+			q, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid template resource %s=%s: %w", name, value, err)
+			}
+			list[v1.ResourceName(name)] = q
+		}
+		return list, nil
+	}
+
+	var err error
+	if req.Requests, err = parse(t.Resources.Requests); err != nil {
+		return req, err
+	}
+	if req.Limits, err = parse(t.Resources.Limits); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// workspacePodConfig 根据模板生成 ContainerSSH 的 pod 配置
+func workspacePodConfig(target *TargetConfig, data *TemplateData) (config.KubernetesPodConfig, error) {
+	t := target.Template
+	user := dnsLabel(data.AuthenticatedUser)
+
+	var pod config.KubernetesPodConfig
+	namespace, err := renderTemplate(t.Namespace, data)
+	if err != nil {
+		return pod, fmt.Errorf("failed to render namespace: %w", err)
+	}
+	resources, err := t.resources()
+	if err != nil {
+		return pod, err
+	}
+
+	container := v1.Container{
+		Name:      workspaceContainer,
+		Image:     t.Image,
+		Resources: resources,
+	}
+	for _, v := range t.Volumes {
+		volume := v1.Volume{Name: v.Name}
+		if v.EmptyDir {
+			volume.EmptyDir = &v1.EmptyDirVolumeSource{}
+		} else {
+			claim, err := renderTemplate(v.ClaimName, data)
+			if err != nil {
+				return pod, fmt.Errorf("failed to render claimName for volume %s: %w", v.Name, err)
+			}
+			volume.PersistentVolumeClaim = &v1.PersistentVolumeClaimVolumeSource{ClaimName: claim}
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, v1.VolumeMount{
+			Name:      v.Name,
+			MountPath: v.MountPath,
+			ReadOnly:  v.ReadOnly,
+		})
+	}
+	pod.Spec.Containers = []v1.Container{container}
+
+	pod.Metadata.Namespace = namespace
+	pod.Metadata.GenerateName = "ws-" + user + "-"
+	pod.Metadata.Labels = map[string]string{
+		labelUser:   user,
+		labelTarget: dnsLabel(target.Name),
+	}
+
+	pod.Mode = config.KubernetesExecutionModeConnection
+	if t.Mode != "" {
+		pod.Mode = config.KubernetesExecutionMode(t.Mode)
+	}
+	pod.ConsoleContainerNumber = 0
+	if !t.Agent {
+		pod.DisableAgent = true
+		pod.IdleCommand = workspaceIdleCommand
+	}
+	return pod, nil
+}
@@ -0,0 +1,121 @@
+package webhook
+
+import (
+	"testing"
+
+	"go.containerssh.io/containerssh/config"
+)
+
+// createTemplateTarget 创建测试用的模板目标
+func createTemplateTarget() *TargetConfig {
+	return &TargetConfig{
+		Name:    "Dev",
+		Cluster: "c1",
+		Template: &PodTemplateConfig{
+			Namespace: "ws-{{user}}",
+			Image:     "ubuntu:24.04",
+			Resources: ResourcesConfig{
+				Requests: map[string]string{"cpu": "500m"},
+				Limits:   map[string]string{"memory": "1Gi"},
+			},
+			Volumes: []VolumeConfig{
+				{Name: "home", MountPath: "/home/dev", ClaimName: "home-{{user}}"},
+				{Name: "tmp", MountPath: "/tmp", EmptyDir: true},
+			},
+		},
+	}
+}
+
+// TestPodTemplateValidate 测试模板配置校验
+func TestPodTemplateValidate(t *testing.T) {
+	if err := createTemplateTarget().Template.validate(); err != nil {
+		t.Errorf("Expected valid template, got error: %v", err)
+	}
+
+	tests := map[string]func(*PodTemplateConfig){
+		"invalid mode":      func(p *PodTemplateConfig) { p.Mode = "pod" },
+		"missing image":     func(p *PodTemplateConfig) { p.Image = "" },
+		"missing namespace": func(p *PodTemplateConfig) { p.Namespace = "" },
+		"bad namespace":     func(p *PodTemplateConfig) { p.Namespace = "ws-{{user" },
+		"bad resource":      func(p *PodTemplateConfig) { p.Resources.Limits["memory"] = "lots" },
+		"both sources":      func(p *PodTemplateConfig) { p.Volumes[1].ClaimName = "data" },
+		"no source":         func(p *PodTemplateConfig) { p.Volumes[0].ClaimName = "" },
+		"missing mountPath": func(p *PodTemplateConfig) { p.Volumes[0].MountPath = "" },
+	}
+	for name, modify := range tests {
+		tmpl := createTemplateTarget().Template
+		modify(tmpl)
+		if err := tmpl.validate(); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+// TestWorkspacePodConfig 测试根据模板生成 pod 配置
+func TestWorkspacePodConfig(t *testing.T) {
+	pod, err := workspacePodConfig(createTemplateTarget(), &TemplateData{AuthenticatedUser: "Alice.Smith"})
+	if err != nil {
+		t.Fatalf("Failed to build pod config: %v", err)
+	}
+
+	if pod.Metadata.Namespace != "ws-alice-smith" {
+		t.Errorf("Expected namespace 'ws-alice-smith', got '%s'", pod.Metadata.Namespace)
+	}
+	if pod.Metadata.GenerateName != "ws-alice-smith-" {
+		t.Errorf("Expected generateName 'ws-alice-smith-', got '%s'", pod.Metadata.GenerateName)
+	}
+	if pod.Metadata.Labels[labelUser] != "alice-smith" || pod.Metadata.Labels[labelTarget] != "dev" {
+		t.Errorf("Unexpected labels: %v", pod.Metadata.Labels)
+	}
+	if pod.Mode != config.KubernetesExecutionModeConnection {
+		t.Errorf("Expected connection mode by default, got '%s'", pod.Mode)
+	}
+	if !pod.DisableAgent || len(pod.IdleCommand) == 0 {
+		t.Error("Expected agent to be disabled with an idle command")
+	}
+
+	if len(pod.Spec.Containers) != 1 {
+		t.Fatalf("Expected 1 container, got %d", len(pod.Spec.Containers))
+	}
+	container := pod.Spec.Containers[0]
+	if container.Name != workspaceContainer || container.Image != "ubuntu:24.04" {
+		t.Errorf("Unexpected container: %s %s", container.Name, container.Image)
+	}
+	if cpu := container.Resources.Requests.Cpu(); cpu.String() != "500m" {
+		t.Errorf("Expected cpu request 500m, got %s", cpu.String())
+	}
+	if mem := container.Resources.Limits.Memory(); mem.String() != "1Gi" {
+		t.Errorf("Expected memory limit 1Gi, got %s", mem.String())
+	}
+	if len(container.VolumeMounts) != 2 {
+		t.Fatalf("Expected 2 volume mounts, got %d", len(container.VolumeMounts))
+	}
+
+	if len(pod.Spec.Volumes) != 2 {
+		t.Fatalf("Expected 2 volumes, got %d", len(pod.Spec.Volumes))
+	}
+	if claim := pod.Spec.Volumes[0].PersistentVolumeClaim; claim == nil || claim.ClaimName != "home-alice-smith" {
+		t.Errorf("Expected claim 'home-alice-smith', got %+v", claim)
+	}
+	if pod.Spec.Volumes[1].EmptyDir == nil {
+		t.Error("Expected emptyDir volume")
+	}
+}
+
+// TestWorkspacePodConfig_Session 测试 session 模式和 agent
+func TestWorkspacePodConfig_Session(t *testing.T) {
+	target := createTemplateTarget()
+	target.Template.Mode = "session"
+	target.Template.Agent = true
+
+	pod, err := workspacePodConfig(target, &TemplateData{AuthenticatedUser: "bob"})
+	if err != nil {
+		t.Fatalf("Failed to build pod config: %v", err)
+	}
+	if pod.Mode != config.KubernetesExecutionModeSession {
+		t.Errorf("Expected session mode, got '%s'", pod.Mode)
+	}
+	if pod.DisableAgent || pod.IdleCommand != nil {
+		t.Error("Expected agent to be enabled without an idle command")
+	}
+}